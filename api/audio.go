@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/tags"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Only files with these extensions are served
+var audioContentTypes = map[string]string{
+	".aac":  "audio/aac",
+	".aif":  "audio/aiff",
+	".aiff": "audio/aiff",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mp3":  "audio/mpeg",
+	".mp4":  "audio/mp4",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".wma":  "audio/x-ms-wma",
+}
+
+var errOutsideLibrary = errors.New("File is outside the library folder")
+
+// GET /api/tracks/{id}/audio streams the track's audio file. Range
+// requests are supported, so players can seek (e.g. to the start given
+// in the track's audio_url) without downloading the whole file.
+func (s *Server) audio(w http.ResponseWriter, r *http.Request, trackID int) {
+	if !s.allowMethod(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	path, status, err := s.audioFile(trackID)
+	if err != nil {
+		s.writeError(w, status, err)
+		return
+	}
+
+	if err = serveAudio(w, r, path); err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// Serves the file at path, which must have come from audioFile. Nothing
+// has been written if an error is returned.
+func serveAudio(w http.ResponseWriter, r *http.Request, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(
+		"Content-Type",
+		audioContentTypes[strings.ToLower(filepath.Ext(path))],
+	)
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+	return nil
+}
+
+// Parses a preview start position: "" for the beginning, "middle", or a
+// number of seconds. Returns it in seconds; the middle of a track whose
+// length can't be read is the beginning.
+func (s *Server) audioStart(trackID int, start string) (float64, error) {
+	switch start {
+	case "":
+		return 0, nil
+	case "middle":
+		path, _, err := s.audioFile(trackID)
+		if err != nil {
+			return 0, nil
+		}
+
+		length, err := tags.Duration(path)
+		if err != nil {
+			return 0, nil
+		}
+
+		return math.Round(length.Seconds()*10/2) / 10, nil
+	}
+
+	seconds, err := strconv.ParseFloat(start, 64)
+	if err != nil || seconds < 0 || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf(
+			"Start must be 'middle' or a number of seconds, not '%s'", start,
+		)
+	}
+
+	return seconds, nil
+}
+
+// LibraryRoot, or the folder last scanned by populate-db if unset. ""
+// if neither.
+func (s *Server) libraryRoot() (string, error) {
+	if s.LibraryRoot != "" {
+		return s.LibraryRoot, nil
+	}
+
+	return repo.LoadLibraryRoot(s.db)
+}
+
+// Returns the first of the track's files that can be served, or the
+// status and error for the last that could not
+func (s *Server) audioFile(trackID int) (string, int, error) {
+	root, err := s.libraryRoot()
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+	if root == "" {
+		return "", http.StatusNotFound, errors.New(
+			"No library folder has been scanned",
+		)
+	}
+
+	paths, err := repo.TrackFiles(s.db, trackID)
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	status := http.StatusNotFound
+	err = fmt.Errorf("No audio for track %d", trackID)
+
+	for _, path := range paths {
+		if _, ok := audioContentTypes[strings.ToLower(filepath.Ext(path))]; !ok {
+			status = http.StatusUnsupportedMediaType
+			err = fmt.Errorf("Cannot stream '%s' files", filepath.Ext(path))
+			continue
+		}
+
+		var resolved string
+		resolved, err = libraryPath(root, path)
+		if err == errOutsideLibrary {
+			status = http.StatusForbidden
+			continue
+		}
+		if err != nil {
+			status = http.StatusNotFound
+			continue
+		}
+
+		return resolved, http.StatusOK, nil
+	}
+
+	return "", status, err
+}
+
+// Resolves path, following any symlinks, and checks it is a regular file
+// inside root
+func libraryPath(root string, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", errOutsideLibrary
+	}
+
+	resolvedRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if resolvedRoot, err = filepath.EvalSymlinks(resolvedRoot); err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := track.InFolder(resolvedRoot, resolved); !ok {
+		return "", errOutsideLibrary
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("'%s' is not a file", path)
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestAudio(t *testing.T) {
+	dir := t.TempDir()
+
+	library := filepath.Join(dir, "library")
+	outside := filepath.Join(dir, "outside")
+
+	for _, folder := range []string{library, outside} {
+		if err := os.Mkdir(folder, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// STREAMINFO for 180 seconds: 44.1 kHz, 2 channels, 16 bits,
+	// 7,938,000 samples
+	streamInfo := make([]byte, 34)
+	streamInfo[10], streamInfo[11], streamInfo[12] = 0x0a, 0xc4, 0x42
+	streamInfo[13] = 0xf0
+	binary.BigEndian.PutUint32(streamInfo[14:], 7938000)
+
+	files := map[string]string{
+		filepath.Join(library, "1.mp3"):  "0123456789",
+		filepath.Join(library, "2.flac"): "fLaC\x80\x00\x00\x22" + string(streamInfo),
+		filepath.Join(library, "3.txt"):  "notes",
+		filepath.Join(outside, "4.mp3"):  "secret",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Link inside the library to a file outside it
+	link := filepath.Join(library, "5.mp3")
+	if err := os.Symlink(filepath.Join(outside, "4.mp3"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	db := test_utils.DBSetup()
+
+	for _, path := range []string{
+		filepath.Join(library, "1.mp3"),
+		filepath.Join(library, "2.flac"),
+		filepath.Join(library, "3.txt"),
+		filepath.Join(outside, "4.mp3"),
+		link,
+		filepath.Join(library, "missing.mp3"),
+		library + "/../outside/4.mp3", // Join would clean this
+	} {
+		repo.SaveTracks(db, []track.Track{
+			track.New(track.Track{
+				Title:         filepath.Base(path),
+				PrimaryArtist: track.Artist{Name: "Artist"},
+				Files:         []string{path},
+			}),
+		})
+	}
+
+	// No files
+	repo.SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			Title:         "8",
+			PrimaryArtist: track.Artist{Name: "Artist"},
+		}),
+	})
+
+	s := New(db)
+
+	t.Log("No library scanned")
+
+	request(t, s, "GET", "/api/tracks/1/audio", "", http.StatusNotFound, nil)
+
+	if err := repo.SaveLibraryRoot(db, library); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Whole file")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tracks/1/audio", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Errorf("Expected whole file, got %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "audio/mpeg" {
+		t.Errorf("Expected audio/mpeg, got '%s'", ct)
+	}
+	if rec.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("Expected range support to be advertised")
+	}
+
+	t.Log("Range")
+
+	req := httptest.NewRequest("GET", "/api/tracks/1/audio", nil)
+	req.Header.Set("Range", "bytes=5-")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "56789" {
+		t.Errorf("Expected second half of file, got %d: %s", rec.Code, rec.Body)
+	}
+	if cr := rec.Header().Get("Content-Range"); cr != "bytes 5-9/10" {
+		t.Errorf("Expected Content-Range bytes 5-9/10, got '%s'", cr)
+	}
+
+	t.Log("Content type by format")
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tracks/2/audio", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "audio/flac" {
+		t.Errorf("Expected audio/flac, got '%s'", ct)
+	}
+
+	t.Log("Track details link to audio")
+
+	var details trackResponse
+	request(t, s, "GET", "/api/tracks/1", "", http.StatusOK, &details)
+	if details.AudioURL != "/api/tracks/1/audio" {
+		t.Errorf("Expected audio URL, got '%s'", details.AudioURL)
+	}
+
+	details = trackResponse{}
+	request(t, s, "GET", "/api/tracks/8", "", http.StatusOK, &details)
+	if details.AudioURL != "" {
+		t.Errorf("Expected no audio URL, got '%s'", details.AudioURL)
+	}
+
+	t.Log("Start offset")
+
+	for _, test := range []struct {
+		path     string
+		start    string // Server default
+		expected string
+	}{
+		{"/api/tracks/2?start=middle", "", "/api/tracks/2/audio#t=90"},
+		{"/api/tracks/1?start=12.5", "", "/api/tracks/1/audio#t=12.5"},
+		{"/api/tracks/2", "middle", "/api/tracks/2/audio#t=90"},
+		{"/api/tracks/2?start=0", "middle", "/api/tracks/2/audio"},
+		// Length unknown, so from the beginning
+		{"/api/tracks/1", "middle", "/api/tracks/1/audio"},
+	} {
+		s.AudioStart = test.start
+
+		details = trackResponse{}
+		request(t, s, "GET", test.path, "", http.StatusOK, &details)
+		if details.AudioURL != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.path, test.expected, details.AudioURL)
+		}
+	}
+	s.AudioStart = ""
+
+	request(t, s, "GET", "/api/tracks/1?start=soon", "", http.StatusBadRequest, nil)
+	request(t, s, "GET", "/api/tracks/1?start=-5", "", http.StatusBadRequest, nil)
+
+	t.Log("Refused")
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/api/tracks/3/audio", http.StatusUnsupportedMediaType},
+		{"/api/tracks/4/audio", http.StatusForbidden},
+		{"/api/tracks/5/audio", http.StatusForbidden},
+		{"/api/tracks/6/audio", http.StatusNotFound},
+		{"/api/tracks/7/audio", http.StatusForbidden},
+		{"/api/tracks/8/audio", http.StatusNotFound},
+		{"/api/tracks/99/audio", http.StatusNotFound},
+		{"/api/tracks/x/audio", http.StatusNotFound},
+	} {
+		request(t, s, "GET", test.path, "", test.status, nil)
+	}
+
+	request(t, s, "POST", "/api/tracks/1/audio", "", http.StatusMethodNotAllowed, nil)
+
+	t.Log("Root can be overridden")
+
+	s.LibraryRoot = outside
+	request(t, s, "GET", "/api/tracks/1/audio", "", http.StatusForbidden, nil)
+}
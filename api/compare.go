@@ -0,0 +1,230 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+type contenderJSON struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Detail      string  `json:"detail,omitempty"`
+	Ranking     float64 `json:"ranking"`
+	Comparisons int     `json:"comparisons"`
+}
+
+func newContenderJSON(c repo.Contender) contenderJSON {
+	return contenderJSON{
+		ID:          c.InternalID,
+		Name:        c.Name,
+		Detail:      c.Detail,
+		Ranking:     c.Ranking,
+		Comparisons: c.Comparisons,
+	}
+}
+
+type contendersResponse struct {
+	Entity     repo.Entity     `json:"entity"`
+	Contenders []contenderJSON `json:"contenders"`
+}
+
+// GET /api/next?entity=track&size=2&user=name
+func (s *Server) next(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	entity, err := parseEntity(r.URL.Query().Get("entity"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	size, err := intParam(r, "size")
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if size == 0 {
+		size = 2
+	}
+	if size < 2 || size > repo.MaxRankedContenders {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf(
+			"Size must be 2-%d", repo.MaxRankedContenders,
+		))
+		return
+	}
+
+	group, err := repo.NextGroup(s.db, profile, entity, size)
+	if err == repo.ErrNotEnoughContenders {
+		s.writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := contendersResponse{Entity: entity}
+	for _, c := range group {
+		response.Contenders = append(response.Contenders, newContenderJSON(c))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Either IDs and Preference for a pair, or Order for several put in
+// order, best first
+type comparisonRequest struct {
+	Entity repo.Entity `json:"entity"`
+
+	// 0 = strongest preference for IDs[1], 1 = strongest for IDs[0]
+	IDs        []int    `json:"ids"`
+	Preference *float64 `json:"preference"`
+
+	Order []int `json:"order"`
+}
+
+type comparisonResponse struct {
+	ID int64 `json:"id"`
+	contendersResponse
+}
+
+// POST /api/comparisons?user=name
+func (s *Server) comparisons(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	var req comparisonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entity, err := parseEntity(string(req.Entity))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ids := req.Order
+	if len(req.Order) == 0 {
+		ids = req.IDs
+
+		if len(ids) != 2 || req.Preference == nil {
+			s.writeError(w, http.StatusBadRequest, errors.New(
+				"Need either 'order', or two 'ids' and a 'preference'",
+			))
+			return
+		}
+	}
+
+	// Check up front so a missing contender is a 404 rather than a
+	// failed comparison
+	for _, id := range ids {
+		if _, err = repo.GetContender(
+			s.db, profile, entity, id,
+		); err == sql.ErrNoRows {
+			s.writeError(
+				w, http.StatusNotFound, fmt.Errorf("No %s %d", entity, id),
+			)
+			return
+		} else if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	var comparisonID int64
+	if len(req.Order) > 0 {
+		comparisonID, err = repo.RecordRankedComparison(s.db, profile, entity, ids)
+	} else {
+		comparisonID, err = repo.RecordGradedComparison(
+			s.db, profile, entity, ids[0], ids[1], *req.Preference,
+		)
+	}
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := comparisonResponse{
+		ID:                 comparisonID,
+		contendersResponse: contendersResponse{Entity: entity},
+	}
+
+	for _, id := range ids {
+		c, err := repo.GetContender(s.db, profile, entity, id)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		response.Contenders = append(response.Contenders, newContenderJSON(c))
+	}
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+type undoRequest struct {
+	Entity repo.Entity `json:"entity"`
+}
+
+type undoResponse struct {
+	Undone int64 `json:"undone"`
+}
+
+// POST /api/undo?user=name undoes the user's latest comparison for the
+// entity
+func (s *Server) undo(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	var req undoRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	entity, err := parseEntity(string(req.Entity))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	undone, err := repo.UndoLastComparison(s.db, profile, entity)
+	if err == repo.ErrNothingToUndo {
+		s.writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, undoResponse{Undone: undone})
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func TestComparisons(t *testing.T) {
+	db := setup()
+	s := New(db)
+
+	t.Log("Next pair")
+
+	var next contendersResponse
+	request(t, s, "GET", "/api/next", "", http.StatusOK, &next)
+
+	if next.Entity != "track" || len(next.Contenders) != 2 ||
+		next.Contenders[0].ID == next.Contenders[1].ID {
+		t.Errorf("Expected two different tracks, got %+v", next)
+	}
+
+	request(t, s, "GET", "/api/next?entity=album&size=3", "", http.StatusConflict, nil)
+	request(t, s, "GET", "/api/next?entity=genre", "", http.StatusBadRequest, nil)
+	request(t, s, "GET", "/api/next?size=9", "", http.StatusBadRequest, nil)
+
+	t.Log("Submit a pair")
+
+	var compared comparisonResponse
+	request(
+		t, s, "POST", "/api/comparisons",
+		`{"entity": "track", "ids": [1, 2], "preference": 0.75}`,
+		http.StatusCreated, &compared,
+	)
+
+	expected := []contenderJSON{
+		{ID: 1, Name: "Title 1", Detail: "Artist 1", Ranking: 1016, Comparisons: 1},
+		{ID: 2, Name: "Title 2", Detail: "Artist 2", Ranking: 984, Comparisons: 1},
+	}
+	if compared.ID != 1 || !reflect.DeepEqual(expected, compared.Contenders) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, compared)
+	}
+
+	t.Log("Submit an ordering")
+
+	request(
+		t, s, "POST", "/api/comparisons",
+		`{"entity": "artist", "order": [2, 1]}`,
+		http.StatusCreated, &compared,
+	)
+	if compared.Entity != "artist" || compared.Contenders[0].Ranking != 1016 {
+		t.Errorf("Expected artist 2 to win, got %+v", compared)
+	}
+
+	t.Log("Invalid comparisons")
+
+	for _, body := range []string{
+		`{"ids": [1, 2]}`,
+		`{"ids": [1], "preference": 1}`,
+		`{"ids": [1, 1], "preference": 1}`,
+		`{"ids": [1, 2], "preference": 2}`,
+		`{"order": [1, 2, 1]}`,
+		`not JSON`,
+	} {
+		request(t, s, "POST", "/api/comparisons", body, http.StatusBadRequest, nil)
+	}
+
+	request(
+		t, s, "POST", "/api/comparisons",
+		`{"ids": [1, 99], "preference": 1}`,
+		http.StatusNotFound, nil,
+	)
+	request(t, s, "GET", "/api/comparisons", "", http.StatusMethodNotAllowed, nil)
+
+	t.Log("Undo")
+
+	var undone undoResponse
+	request(t, s, "POST", "/api/undo", "", http.StatusOK, &undone)
+	if undone.Undone != 1 {
+		t.Errorf("Expected comparison 1 to be undone, got %d", undone.Undone)
+	}
+
+	var got groupResponse
+	request(t, s, "GET", "/api/artists/2", "", http.StatusOK, &got)
+	if got.Ranking != 1016 {
+		t.Errorf("Expected artist comparison to be kept, got %+v", got)
+	}
+
+	request(t, s, "POST", "/api/undo", `{"entity": "track"}`, http.StatusConflict, nil)
+	request(t, s, "POST", "/api/undo", `{"entity": "artist"}`, http.StatusOK, nil)
+}
+
+func TestUsers(t *testing.T) {
+	db := setup()
+	s := New(db)
+
+	if _, err := repo.CreateProfile(db, "Sam"); err != nil {
+		t.Fatal(err)
+	}
+
+	request(
+		t, s, "POST", "/api/comparisons?user=sam",
+		`{"entity": "track", "ids": [2, 1], "preference": 1}`,
+		http.StatusCreated, nil,
+	)
+
+	t.Log("Each user sees their own rankings")
+
+	for path, expected := range map[string]contenderJSON{
+		"/api/tracks/2?user=Sam": {ID: 2, Ranking: 1024, Comparisons: 1},
+		"/api/tracks/2":          {ID: 2, Ranking: 1000},
+	} {
+		var got trackResponse
+		request(t, s, "GET", path, "", http.StatusOK, &got)
+
+		if got.Ranking != expected.Ranking || got.Comparisons != expected.Comparisons {
+			t.Errorf("%s: expected %+v, got %+v", path, expected, got)
+		}
+	}
+
+	var ranked rankingsResponse
+	request(t, s, "GET", "/api/rankings?user=sam&min_comparisons=1", "", http.StatusOK, &ranked)
+	if ranked.Total != 2 || ranked.Tracks[0].ID != 2 {
+		t.Errorf("Expected track 2 first of 2, got %+v", ranked)
+	}
+
+	request(t, s, "GET", "/api/rankings?min_comparisons=1", "", http.StatusOK, &ranked)
+	if ranked.Total != 0 {
+		t.Errorf("Expected nothing compared, got %+v", ranked)
+	}
+
+	t.Log("Undo is per user")
+
+	request(t, s, "POST", "/api/undo", "", http.StatusConflict, nil)
+	request(t, s, "POST", "/api/undo?user=sam", "", http.StatusOK, nil)
+
+	t.Log("Unknown user")
+
+	for _, path := range []string{
+		"/api/next?user=nobody",
+		"/api/rankings?user=nobody",
+		"/api/tracks/1?user=nobody",
+		"/api/metrics?user=nobody",
+	} {
+		request(t, s, "GET", path, "", http.StatusNotFound, nil)
+	}
+}
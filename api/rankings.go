@@ -0,0 +1,376 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+type albumJSON struct {
+	ID            int    `json:"id"`
+	MusicBrainzID string `json:"musicbrainz_id,omitempty"`
+	Title         string `json:"title"`
+}
+
+type artistJSON struct {
+	ID            int    `json:"id"`
+	MusicBrainzID string `json:"musicbrainz_id,omitempty"`
+	Name          string `json:"name"`
+}
+
+type trackJSON struct {
+	ID            int    `json:"id"`
+	MusicBrainzID string `json:"musicbrainz_id,omitempty"`
+	Title         string `json:"title"`
+	Genre         string `json:"genre,omitempty"`
+	Year          int    `json:"year,omitempty"`
+
+	Albums        []albumJSON  `json:"albums"`
+	PrimaryArtist artistJSON   `json:"primary_artist"`
+	OtherArtists  []artistJSON `json:"other_artists"`
+
+	Ranking     float64 `json:"ranking"`
+	Comparisons int     `json:"comparisons"`
+
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+
+	// Standard error of the ranking and its 95% confidence interval
+	Deviation  float64 `json:"deviation"`
+	RatingLow  float64 `json:"rating_low"`
+	RatingHigh float64 `json:"rating_high"`
+	Settled    bool    `json:"settled"`
+}
+
+func newTrackJSON(t track.Track) trackJSON {
+	j := trackJSON{
+		ID:            t.InternalID,
+		MusicBrainzID: t.MusicBrainzID,
+		Title:         t.Title,
+		Genre:         t.Genre,
+		Year:          t.Year,
+
+		Albums: []albumJSON{},
+		PrimaryArtist: artistJSON{
+			ID:            t.PrimaryArtist.InternalID,
+			MusicBrainzID: t.PrimaryArtist.MusicBrainzID,
+			Name:          t.PrimaryArtist.Name,
+		},
+		OtherArtists: []artistJSON{},
+
+		Ranking:     t.Ranking,
+		Comparisons: t.Comparisons,
+
+		Wins:      t.Wins,
+		Losses:    t.Losses,
+		Draws:     t.Draws,
+		Deviation: t.Deviation,
+		Settled:   t.Settled,
+	}
+
+	j.RatingLow, j.RatingHigh = elo.Interval(t.Ranking, t.Deviation)
+
+	for _, album := range t.Albums {
+		j.Albums = append(j.Albums, albumJSON{
+			ID:            album.InternalID,
+			MusicBrainzID: album.MusicBrainzID,
+			Title:         album.Title,
+		})
+	}
+
+	for _, artist := range t.OtherArtists {
+		j.OtherArtists = append(j.OtherArtists, artistJSON{
+			ID:            artist.InternalID,
+			MusicBrainzID: artist.MusicBrainzID,
+			Name:          artist.Name,
+		})
+	}
+
+	return j
+}
+
+func newTrackJSONs(tracks []track.Track) []trackJSON {
+	js := []trackJSON{}
+	for _, t := range tracks {
+		js = append(js, newTrackJSON(t))
+	}
+
+	return js
+}
+
+type rankingsResponse struct {
+	Tracks []trackJSON `json:"tracks"`
+	Total  int         `json:"total"`
+}
+
+// GET /api/rankings takes the filters of repo.RankingQuery as snake_case
+// parameters, e.g. ?artist=Low&year_from=1994&order=year&asc=1&limit=20,
+// and the user whose rankings they are
+func (s *Server) rankings(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+
+	q := repo.RankingQuery{
+		Profile: profile,
+		Artist:  params.Get("artist"),
+		Album:   params.Get("album"),
+		Genre:   params.Get("genre"),
+		OrderBy: repo.OrderBy(params.Get("order")),
+	}
+
+	for name, dest := range map[string]*int{
+		"artist_id":       &q.ArtistID,
+		"album_id":        &q.AlbumID,
+		"year_from":       &q.YearFrom,
+		"year_to":         &q.YearTo,
+		"min_comparisons": &q.MinComparisons,
+		"limit":           &q.Limit,
+		"offset":          &q.Offset,
+	} {
+		value, err := intParam(r, name)
+		if err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		*dest = value
+	}
+
+	if asc := params.Get("asc"); asc != "" {
+		ascending, err := strconv.ParseBool(asc)
+		if err != nil {
+			s.writeError(
+				w, http.StatusBadRequest, fmt.Errorf("Invalid asc '%s'", asc),
+			)
+			return
+		}
+
+		q.Ascending = ascending
+	}
+
+	for name, dest := range map[string]*float64{
+		"min_rating": &q.MinRating,
+		"max_rating": &q.MaxRating,
+	} {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+
+		rating, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			s.writeError(
+				w, http.StatusBadRequest, fmt.Errorf("Invalid %s '%s'", name, value),
+			)
+			return
+		}
+
+		*dest = rating
+	}
+
+	if _, ok := map[repo.OrderBy]bool{
+		"":                      true,
+		repo.OrderByRanking:     true,
+		repo.OrderByTitle:       true,
+		repo.OrderByYear:        true,
+		repo.OrderByComparisons: true,
+	}[q.OrderBy]; !ok {
+		s.writeError(
+			w, http.StatusBadRequest, fmt.Errorf("Unknown order '%s'", q.OrderBy),
+		)
+		return
+	}
+
+	page, err := repo.QueryRankings(s.db, q)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rankingsResponse{
+		Tracks: newTrackJSONs(page.Tracks),
+		Total:  page.Total,
+	})
+}
+
+type historyPointJSON struct {
+	SnapshotID int     `json:"snapshot_id"`
+	TakenAt    string  `json:"taken_at"`
+	Ranking    float64 `json:"ranking"`
+	Position   int     `json:"position"`
+}
+
+type trackResponse struct {
+	trackJSON
+	History []historyPointJSON `json:"history"`
+
+	// Set if the track has an audio file. Carries a media fragment
+	// (#t=seconds) if the preview starts part way through, which is also
+	// given as AudioStart.
+	AudioURL   string  `json:"audio_url,omitempty"`
+	AudioStart float64 `json:"audio_start,omitempty"`
+}
+
+// GET /api/tracks/{id}, or /api/tracks/{id}/audio for its audio. Takes
+// an optional start, as for Server.AudioStart (start=0 for the
+// beginning), for where its audio_url should start playing.
+func (s *Server) track(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/audio") {
+		id, err := pathID(r, "/api/tracks/", "/audio")
+		if err != nil {
+			s.writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		s.audio(w, r, id)
+		return
+	}
+
+	if !s.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id, err := pathID(r, "/api/tracks/", "")
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := repo.GetTrack(s.db, profile, id)
+	if err == sql.ErrNoRows {
+		s.writeError(w, http.StatusNotFound, fmt.Errorf("No track %d", id))
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	history, err := repo.TrackHistory(s.db, profile, id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := trackResponse{
+		trackJSON: newTrackJSON(t),
+		History:   []historyPointJSON{},
+	}
+
+	files, err := repo.TrackFiles(s.db, id)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(files) > 0 {
+		start := r.URL.Query().Get("start")
+		if start == "" {
+			start = s.AudioStart
+		}
+
+		if response.AudioStart, err = s.audioStart(id, start); err != nil {
+			s.writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		response.AudioURL = fmt.Sprintf("/api/tracks/%d/audio", id)
+		if response.AudioStart > 0 {
+			response.AudioURL += "#t=" + strconv.FormatFloat(
+				response.AudioStart, 'f', -1, 64,
+			)
+		}
+	}
+
+	for _, point := range history {
+		response.History = append(response.History, historyPointJSON{
+			SnapshotID: point.Snapshot.ID,
+			TakenAt:    point.Snapshot.TakenAt.Format(time.RFC3339),
+			Ranking:    point.Ranking,
+			Position:   point.Position,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+type groupResponse struct {
+	contenderJSON
+	Tracks []trackJSON `json:"tracks"`
+}
+
+// GET /api/albums/{id}
+func (s *Server) album(w http.ResponseWriter, r *http.Request) {
+	s.group(w, r, repo.EntityAlbum, "/api/albums/")
+}
+
+// GET /api/artists/{id}
+func (s *Server) artist(w http.ResponseWriter, r *http.Request) {
+	s.group(w, r, repo.EntityArtist, "/api/artists/")
+}
+
+// An album or artist with its tracks, highest ranked first
+func (s *Server) group(
+	w http.ResponseWriter, r *http.Request, entity repo.Entity, prefix string,
+) {
+	if !s.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id, err := pathID(r, prefix, "")
+	if err != nil {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	c, err := repo.GetContender(s.db, profile, entity, id)
+	if err == sql.ErrNoRows {
+		s.writeError(w, http.StatusNotFound, fmt.Errorf("No %s %d", entity, id))
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	q := repo.RankingQuery{Profile: profile, AlbumID: id}
+	if entity == repo.EntityArtist {
+		q = repo.RankingQuery{Profile: profile, ArtistID: id}
+	}
+
+	page, err := repo.QueryRankings(s.db, q)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, groupResponse{
+		contenderJSON: newContenderJSON(c),
+		Tracks:        newTrackJSONs(page.Tracks),
+	})
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func TestRankings(t *testing.T) {
+	db := setup()
+	s := New(db)
+
+	if _, err := repo.RecordComparison(
+		db, repo.DefaultProfile, repo.EntityTrack, 3, 1, 1,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		expectedIDs   []int
+		expectedTotal int
+	}{
+		{"No filters", "/api/rankings", []int{3, 2, 1}, 3},
+		{"Paginated", "/api/rankings?limit=1&offset=1", []int{2}, 3},
+		{"Artist, including features", "/api/rankings?artist=artist+1", []int{2, 1}, 2},
+		{"Album ID", "/api/rankings?album_id=2", []int{3, 2}, 2},
+		{"Genre and years", "/api/rankings?genre=rock&year_to=1995", []int{1}, 1},
+		{"Compared", "/api/rankings?min_comparisons=1", []int{3, 1}, 2},
+		{"Ordered", "/api/rankings?order=year&asc=true", []int{1, 2, 3}, 3},
+		{"Rating band", "/api/rankings?min_rating=1010&max_rating=1100", []int{3}, 1},
+	}
+
+	for _, test := range tests {
+		t.Log(test.name)
+
+		var got rankingsResponse
+		request(t, s, "GET", test.path, "", http.StatusOK, &got)
+
+		gotIDs := []int{}
+		for _, track := range got.Tracks {
+			gotIDs = append(gotIDs, track.ID)
+		}
+
+		if !reflect.DeepEqual(test.expectedIDs, gotIDs) || got.Total != test.expectedTotal {
+			t.Errorf(
+				"Expected %v of %d, got %v of %d",
+				test.expectedIDs, test.expectedTotal, gotIDs, got.Total,
+			)
+		}
+	}
+
+	for _, path := range []string{
+		"/api/rankings?order=colour",
+		"/api/rankings?limit=ten",
+		"/api/rankings?asc=maybe",
+		"/api/rankings?min_rating=high",
+	} {
+		request(t, s, "GET", path, "", http.StatusBadRequest, nil)
+	}
+}
+
+func TestDetails(t *testing.T) {
+	db := setup()
+	s := New(db)
+
+	if _, err := repo.RecordComparison(
+		db, repo.DefaultProfile, repo.EntityTrack, 2, 1, 1,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.TakeSnapshot(db, repo.DefaultProfile, "Start"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Track")
+
+	var got trackResponse
+	request(t, s, "GET", "/api/tracks/2", "", http.StatusOK, &got)
+
+	expected := trackResponse{
+		trackJSON: trackJSON{
+			ID:            2,
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Genre:         "Pop",
+			Year:          1994,
+			Albums:        []albumJSON{{ID: 2, MusicBrainzID: "AL2", Title: "Album 2"}},
+			PrimaryArtist: artistJSON{ID: 2, Name: "Artist 2"},
+			OtherArtists:  []artistJSON{{ID: 1, Name: "Artist 1"}},
+			Ranking:       1016,
+			Comparisons:   1,
+			Wins:          1,
+			// One even match, too few to settle anything
+			Deviation: elo.Deviation([]float64{0.5}),
+		},
+	}
+	expected.RatingLow, expected.RatingHigh = elo.Interval(
+		expected.Ranking, expected.Deviation,
+	)
+
+	if len(got.History) != 1 || got.History[0].Position != 1 {
+		t.Errorf("Expected one snapshot at position 1, got %+v", got.History)
+	}
+	got.History = nil
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
+
+	t.Log("Album and artist")
+
+	var album groupResponse
+	request(t, s, "GET", "/api/albums/2", "", http.StatusOK, &album)
+
+	if album.Name != "Album 2" || album.Detail != "Artist 2" || len(album.Tracks) != 2 {
+		t.Errorf("Expected Album 2 by Artist 2 with 2 tracks, got %+v", album)
+	}
+
+	var artist groupResponse
+	request(t, s, "GET", "/api/artists/1", "", http.StatusOK, &artist)
+
+	if artist.Name != "Artist 1" || len(artist.Tracks) != 2 {
+		t.Errorf("Expected Artist 1 with 2 tracks, got %+v", artist)
+	}
+
+	t.Log("Not found")
+
+	for _, path := range []string{
+		"/api/tracks/99",
+		"/api/tracks/one",
+		"/api/albums/99",
+		"/api/artists/",
+	} {
+		request(t, s, "GET", path, "", http.StatusNotFound, nil)
+	}
+}
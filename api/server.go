@@ -0,0 +1,226 @@
+// Package api serves the rankings over HTTP as JSON
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+type Server struct {
+	// Audio files are only served from under this folder. Defaults to
+	// the folder last scanned by populate-db.
+	LibraryRoot string
+
+	// Where previews start unless a request says otherwise: "" for the
+	// beginning, "middle", or a number of seconds
+	AudioStart string
+
+	// Credentials for the Subsonic API's default profile. Other profiles
+	// log in with their own names and Subsonic passwords. With no
+	// password here, it refuses every request.
+	SubsonicUser     string
+	SubsonicPassword string
+
+	db      *sql.DB
+	mux     *http.ServeMux
+	started time.Time
+
+	// Updated atomically
+	requests int64
+	errors   int64
+}
+
+// New returns a handler for the API. All routes are under /api/.
+func New(db *sql.DB) *Server {
+	s := &Server{
+		db:      db,
+		mux:     http.NewServeMux(),
+		started: time.Now(),
+	}
+
+	s.mux.HandleFunc("/api/health", s.health)
+	s.mux.HandleFunc("/api/metrics", s.metrics)
+
+	s.mux.HandleFunc("/api/next", s.next)
+	s.mux.HandleFunc("/api/comparisons", s.comparisons)
+	s.mux.HandleFunc("/api/undo", s.undo)
+
+	s.mux.HandleFunc("/api/rankings", s.rankings)
+	s.mux.HandleFunc("/api/tracks/", s.track)
+	s.mux.HandleFunc("/api/albums/", s.album)
+	s.mux.HandleFunc("/api/artists/", s.artist)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&s.requests, 1)
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	if err := s.db.Ping(); err != nil {
+		s.writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+type metricsResponse struct {
+	UptimeSeconds int64 `json:"uptime_seconds"`
+	Requests      int64 `json:"requests"`
+	Errors        int64 `json:"errors"`
+
+	Tracks       int                 `json:"tracks"`
+	RankedTracks int                 `json:"ranked_tracks"`
+	Albums       int                 `json:"albums"`
+	Artists      int                 `json:"artists"`
+	Comparisons  map[repo.Entity]int `json:"comparisons"`
+
+	ImplicitComparisons int `json:"implicit_comparisons"`
+}
+
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	if !s.allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	profile, ok := s.profile(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := repo.GetLibraryStats(s.db, profile)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, metricsResponse{
+		UptimeSeconds: int64(time.Since(s.started).Seconds()),
+		Requests:      atomic.LoadInt64(&s.requests),
+		Errors:        atomic.LoadInt64(&s.errors),
+
+		Tracks:       stats.Tracks,
+		RankedTracks: stats.RankedTracks,
+		Albums:       stats.Albums,
+		Artists:      stats.Artists,
+		Comparisons:  stats.Comparisons,
+
+		ImplicitComparisons: stats.ImplicitComparisons,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println(err)
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server errors are logged as well as returned
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	atomic.AddInt64(&s.errors, 1)
+
+	if status >= 500 {
+		log.Println(err)
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func (s *Server) allowMethod(
+	w http.ResponseWriter, r *http.Request, methods ...string,
+) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	s.writeError(
+		w,
+		http.StatusMethodNotAllowed,
+		errors.New("Method "+r.Method+" not allowed"),
+	)
+
+	return false
+}
+
+// Profile ID from the 'user' parameter, defaulting to the default
+// profile. Writes the error if there's no such user.
+func (s *Server) profile(w http.ResponseWriter, r *http.Request) (int, bool) {
+	profile, err := repo.GetProfile(s.db, r.URL.Query().Get("user"))
+	if errors.Is(err, repo.ErrNoProfile) {
+		s.writeError(w, http.StatusNotFound, err)
+		return 0, false
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return 0, false
+	}
+
+	return profile.ID, true
+}
+
+// Parses the ID from e.g. /api/tracks/12, or /api/tracks/12/audio with
+// suffix "/audio"
+func pathID(r *http.Request, prefix string, suffix string) (int, error) {
+	id, err := strconv.Atoi(
+		strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), suffix),
+	)
+	if err != nil || id < 1 {
+		return 0, errors.New("Invalid ID in " + r.URL.Path)
+	}
+
+	return id, nil
+}
+
+// Entity from the 'entity' parameter, defaulting to tracks
+func parseEntity(value string) (repo.Entity, error) {
+	switch entity := repo.Entity(value); entity {
+	case "":
+		return repo.EntityTrack, nil
+	case repo.EntityTrack, repo.EntityAlbum, repo.EntityArtist:
+		return entity, nil
+	default:
+		return "", errors.New("Unknown entity '" + value + "'")
+	}
+}
+
+// Integer query parameter; missing = 0
+func intParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("Invalid " + name + " '" + value + "'")
+	}
+
+	return i, nil
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestHealthAndMetrics(t *testing.T) {
+	db := setup()
+	s := New(db)
+
+	t.Log("Health")
+
+	var health map[string]string
+	request(t, s, "GET", "/api/health", "", http.StatusOK, &health)
+	if health["status"] != "ok" {
+		t.Errorf("Expected status ok, got %v", health)
+	}
+
+	t.Log("Wrong method")
+
+	request(t, s, "POST", "/api/health", "", http.StatusMethodNotAllowed, nil)
+
+	t.Log("Metrics")
+
+	if _, err := repo.RecordComparison(
+		db, repo.DefaultProfile, repo.EntityTrack, 1, 2, 1,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var metrics metricsResponse
+	request(t, s, "GET", "/api/metrics", "", http.StatusOK, &metrics)
+
+	if metrics.Tracks != 3 || metrics.Albums != 2 || metrics.Artists != 2 {
+		t.Errorf("Expected 3 tracks, 2 albums, 2 artists, got %+v", metrics)
+	}
+	if metrics.RankedTracks != 2 {
+		t.Errorf("Expected 2 ranked tracks, got %d", metrics.RankedTracks)
+	}
+	if metrics.Comparisons[repo.EntityTrack] != 1 ||
+		metrics.Comparisons[repo.EntityAlbum] != 0 {
+		t.Errorf("Expected 1 track comparison, got %v", metrics.Comparisons)
+	}
+
+	// Including this one
+	if metrics.Requests != 3 || metrics.Errors != 1 {
+		t.Errorf(
+			"Expected 3 requests, 1 error, got %d, %d",
+			metrics.Requests, metrics.Errors,
+		)
+	}
+}
+
+// Three tracks on two albums by two artists; track 2 also features
+// Artist 1
+func setup() *sql.DB {
+	db := test_utils.DBSetup()
+
+	repo.SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Genre:         "Rock",
+			Year:          1991,
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Genre:         "Pop",
+			Year:          1994,
+			Albums:        []track.Album{{MusicBrainzID: "AL2", Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+			OtherArtists:  []track.Artist{{Name: "Artist 1"}},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			Genre:         "Rock",
+			Year:          1998,
+			Albums:        []track.Album{{MusicBrainzID: "AL2", Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	return db
+}
+
+// Makes a request, checks the status and decodes the JSON response into
+// response, if given
+func request(
+	t *testing.T,
+	handler http.Handler,
+	method string,
+	path string,
+	body string,
+	expectedStatus int,
+	response interface{},
+) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	if rec.Code != expectedStatus {
+		t.Fatalf(
+			"%s %s: expected status %d, got %d: %s",
+			method, path, expectedStatus, rec.Code, rec.Body,
+		)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: expected JSON, got '%s'", method, path, ct)
+	}
+
+	if response == nil {
+		return
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+}
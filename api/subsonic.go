@@ -0,0 +1,831 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+const (
+	subsonicNamespace = "http://subsonic.org/restapi"
+	subsonicVersion   = "1.16.1"
+
+	// Articles skipped when indexing artists by first letter
+	subsonicIgnoredArticles = "The El La Los Las Le Les"
+)
+
+// Subsonic error codes
+const (
+	subsonicErrGeneric   = 0
+	subsonicErrMissing   = 10
+	subsonicErrWrongAuth = 40
+	subsonicErrNotFound  = 70
+)
+
+type subsonicError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+func (e *subsonicError) Error() string {
+	return e.Message
+}
+
+// Every Subsonic response, successful or not, is one of these. Only the
+// element for the method called is set. Attributes in the XML are plain
+// fields in the JSON, and repeated elements are arrays.
+type subsonicResponse struct {
+	XMLName xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns   string   `xml:"xmlns,attr" json:"-"`
+	Status  string   `xml:"status,attr" json:"status"`
+	Version string   `xml:"version,attr" json:"version"`
+
+	Error        *subsonicError        `xml:"error" json:"error,omitempty"`
+	License      *subsonicLicense      `xml:"license" json:"license,omitempty"`
+	MusicFolders *subsonicMusicFolders `xml:"musicFolders" json:"musicFolders,omitempty"`
+	Indexes      *subsonicIndexes      `xml:"indexes" json:"indexes,omitempty"`
+	Album        *subsonicAlbum        `xml:"album" json:"album,omitempty"`
+	Song         *subsonicChild        `xml:"song" json:"song,omitempty"`
+	Starred      *subsonicStarred      `xml:"starred" json:"starred,omitempty"`
+}
+
+type subsonicLicense struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type subsonicMusicFolders struct {
+	MusicFolder []subsonicMusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type subsonicMusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type subsonicIndexes struct {
+	LastModified    int64           `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string          `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []subsonicIndex `xml:"index" json:"index"`
+}
+
+type subsonicIndex struct {
+	Name   string           `xml:"name,attr" json:"name"`
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+}
+
+type subsonicArtist struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	UserRating int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	Starred    string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type subsonicAlbum struct {
+	ID         string          `xml:"id,attr" json:"id"`
+	Name       string          `xml:"name,attr" json:"name"`
+	Artist     string          `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	SongCount  int             `xml:"songCount,attr" json:"songCount"`
+	UserRating int             `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	Starred    string          `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+	Song       []subsonicChild `xml:"song" json:"song"`
+}
+
+// A song, or an album as a directory (IsDir) in getStarred
+type subsonicChild struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	AlbumID     string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+	UserRating  int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	Starred     string `xml:"starred,attr,omitempty" json:"starred,omitempty"`
+}
+
+type subsonicStarred struct {
+	Artist []subsonicArtist `xml:"artist" json:"artist"`
+	Album  []subsonicChild  `xml:"album" json:"album"`
+	Song   []subsonicChild  `xml:"song" json:"song"`
+}
+
+// Methods are given the profile of the user logged in
+type subsonicMethod func(
+	r *http.Request, profile int, response *subsonicResponse,
+) error
+
+// Subsonic returns a handler for the part of the Subsonic API that
+// Subsonic and Navidrome clients need to browse, play, rate and star
+// tracks. Routes are under /rest/, with or without a .view suffix, and
+// every request must carry a user and their password, as a password or
+// salted token. Each profile logs in with its own name and Subsonic
+// password, and SubsonicUser logs in to the default profile with
+// SubsonicPassword. Ratings and stars of tracks, albums
+// and artists become their priors in the profile, so they feed into its
+// rankings, and rankings shown are the profile's.
+//
+// Audio is streamed as is; format and maxBitRate are ignored.
+func (s *Server) Subsonic() http.Handler {
+	methods := map[string]subsonicMethod{
+		"getAlbum":        s.subsonicGetAlbum,
+		"getIndexes":      s.subsonicGetIndexes,
+		"getLicense":      s.subsonicGetLicense,
+		"getMusicFolders": s.subsonicGetMusicFolders,
+		"getSong":         s.subsonicGetSong,
+		"getStarred":      s.subsonicGetStarred,
+		"ping":            s.subsonicPing,
+		"setRating":       s.subsonicSetRating,
+		"star":            s.subsonicStar,
+		"unstar":          s.subsonicUnstar,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.requests, 1)
+
+		name := strings.TrimSuffix(
+			strings.TrimPrefix(r.URL.Path, "/rest/"), ".view",
+		)
+
+		// Clients may POST parameters as a form
+		if err := r.ParseForm(); err != nil {
+			s.writeSubsonicError(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		method, ok := methods[name]
+		if !ok && name != "stream" {
+			s.writeSubsonicError(w, r, http.StatusNotFound, &subsonicError{
+				Code:    subsonicErrGeneric,
+				Message: "Unknown method '" + name + "'",
+			})
+			return
+		}
+
+		profile, err := s.subsonicAuthenticate(r.Form)
+		if err != nil {
+			s.writeSubsonicError(w, r, http.StatusOK, err)
+			return
+		}
+
+		if name == "stream" {
+			s.subsonicStream(w, r)
+			return
+		}
+
+		var response subsonicResponse
+		if err := method(r, profile, &response); err != nil {
+			s.writeSubsonicError(w, r, http.StatusOK, err)
+			return
+		}
+
+		writeSubsonic(w, r, http.StatusOK, response)
+	})
+}
+
+// Accepts u with either p (plain, or hex after "enc:") or t and s, where
+// t is the MD5 of the password followed by the salt s. Returns the ID of
+// the profile named u, or the default profile's for SubsonicUser.
+func (s *Server) subsonicAuthenticate(form url.Values) (int, error) {
+	wrongAuth := &subsonicError{
+		Code:    subsonicErrWrongAuth,
+		Message: "Wrong username or password",
+	}
+
+	user, err := subsonicParam(form, "u")
+	if err != nil {
+		return 0, err
+	}
+
+	profile, expected, err := s.subsonicUser(user)
+	if err != nil {
+		return 0, err
+	}
+
+	var ok bool
+
+	if token := form.Get("t"); token != "" {
+		salt, err := subsonicParam(form, "s")
+		if err != nil {
+			return 0, err
+		}
+
+		sum := md5.Sum([]byte(expected + salt))
+		ok = subtle.ConstantTimeCompare(
+			[]byte(hex.EncodeToString(sum[:])),
+			[]byte(strings.ToLower(token)),
+		) == 1
+	} else {
+		password, err := subsonicParam(form, "p")
+		if err != nil {
+			return 0, err
+		}
+
+		if strings.HasPrefix(password, "enc:") {
+			decoded, err := hex.DecodeString(strings.TrimPrefix(password, "enc:"))
+			if err != nil {
+				return 0, wrongAuth
+			}
+			password = string(decoded)
+		}
+
+		ok = subtle.ConstantTimeCompare(
+			[]byte(expected), []byte(password),
+		) == 1
+	}
+
+	// With no password set, nobody gets in
+	if !ok || expected == "" || s.SubsonicPassword == "" {
+		return 0, wrongAuth
+	}
+
+	return profile, nil
+}
+
+// The profile a Subsonic user logs in to and its password: the profile
+// with the name and its own password, or for SubsonicUser the default
+// profile and SubsonicPassword. The password is "" for anyone else.
+func (s *Server) subsonicUser(user string) (int, string, error) {
+	profile, err := repo.GetProfile(s.db, user)
+	if errors.Is(err, repo.ErrNoProfile) {
+		if user != s.SubsonicUser {
+			return 0, "", nil
+		}
+
+		return repo.DefaultProfile, s.SubsonicPassword, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	password, err := repo.SubsonicPassword(s.db, profile.ID)
+	return profile.ID, password, err
+}
+
+func (s *Server) subsonicPing(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	return nil
+}
+
+func (s *Server) subsonicGetLicense(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	response.License = &subsonicLicense{Valid: true}
+	return nil
+}
+
+// The whole library is one folder
+func (s *Server) subsonicGetMusicFolders(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	response.MusicFolders = &subsonicMusicFolders{
+		MusicFolder: []subsonicMusicFolder{{ID: 1, Name: "Music"}},
+	}
+	return nil
+}
+
+// Artists by first letter, ignoring articles; "#" for anything that
+// doesn't start with a letter
+func (s *Server) subsonicGetIndexes(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	artists, err := repo.ListContenders(s.db, profile, repo.EntityArtist)
+	if err != nil {
+		return err
+	}
+
+	annotations, err := repo.Annotations(s.db, profile, repo.EntityArtist)
+	if err != nil {
+		return err
+	}
+
+	sortNames := map[string]string{}
+	indexes := map[string][]subsonicArtist{}
+
+	for _, artist := range artists {
+		sortName, index := subsonicIndexName(artist.Name)
+		sortNames[artist.Name] = strings.ToLower(sortName)
+
+		a := annotations[artist.InternalID]
+		indexes[index] = append(indexes[index], subsonicArtist{
+			ID:         subsonicID(repo.EntityArtist, artist.InternalID),
+			Name:       artist.Name,
+			UserRating: a.Rating,
+			Starred:    subsonicTime(a.Starred),
+		})
+	}
+
+	result := &subsonicIndexes{
+		// Always changed, so clients never keep a stale list
+		LastModified:    time.Now().UnixNano() / int64(time.Millisecond),
+		IgnoredArticles: subsonicIgnoredArticles,
+		Index:           []subsonicIndex{},
+	}
+
+	for name, artists := range indexes {
+		sort.SliceStable(artists, func(i, j int) bool {
+			return sortNames[artists[i].Name] < sortNames[artists[j].Name]
+		})
+
+		result.Index = append(result.Index, subsonicIndex{
+			Name:   name,
+			Artist: artists,
+		})
+	}
+
+	sort.Slice(result.Index, func(i, j int) bool {
+		return result.Index[i].Name < result.Index[j].Name
+	})
+
+	response.Indexes = result
+	return nil
+}
+
+// The album's tracks, highest ranked first
+func (s *Server) subsonicGetAlbum(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	id, err := subsonicEntityID(r.Form, "id", repo.EntityAlbum)
+	if err != nil {
+		return err
+	}
+
+	album, err := repo.GetContender(s.db, profile, repo.EntityAlbum, id)
+	if err == sql.ErrNoRows {
+		return subsonicNotFound(repo.EntityAlbum, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	page, err := repo.QueryRankings(
+		s.db, repo.RankingQuery{Profile: profile, AlbumID: id},
+	)
+	if err != nil {
+		return err
+	}
+
+	songs, err := s.subsonicSongs(profile, page.Tracks)
+	if err != nil {
+		return err
+	}
+
+	a, err := repo.GetAnnotation(s.db, profile, repo.EntityAlbum, id)
+	if err != nil {
+		return err
+	}
+
+	response.Album = &subsonicAlbum{
+		ID:         subsonicID(repo.EntityAlbum, id),
+		Name:       album.Name,
+		Artist:     album.Detail,
+		SongCount:  len(songs),
+		UserRating: a.Rating,
+		Starred:    subsonicTime(a.Starred),
+		Song:       songs,
+	}
+	return nil
+}
+
+func (s *Server) subsonicGetSong(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	id, err := subsonicEntityID(r.Form, "id", repo.EntityTrack)
+	if err != nil {
+		return err
+	}
+
+	t, err := repo.GetTrack(s.db, profile, id)
+	if err == sql.ErrNoRows {
+		return subsonicNotFound(repo.EntityTrack, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	songs, err := s.subsonicSongs(profile, []track.Track{t})
+	if err != nil {
+		return err
+	}
+
+	response.Song = &songs[0]
+	return nil
+}
+
+func (s *Server) subsonicStream(w http.ResponseWriter, r *http.Request) {
+	id, err := subsonicEntityID(r.Form, "id", repo.EntityTrack)
+	if err != nil {
+		s.writeSubsonicError(w, r, http.StatusOK, err)
+		return
+	}
+
+	path, status, err := s.audioFile(id)
+	if status == http.StatusNotFound {
+		err = &subsonicError{Code: subsonicErrNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		s.writeSubsonicError(w, r, http.StatusOK, err)
+		return
+	}
+
+	if err = serveAudio(w, r, path); err != nil {
+		s.writeSubsonicError(w, r, http.StatusOK, err)
+	}
+}
+
+// Rates a track, album or artist from 1 to 5; 0 clears the rating
+func (s *Server) subsonicSetRating(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	value, err := subsonicParam(r.Form, "id")
+	if err != nil {
+		return err
+	}
+
+	entity, id, err := parseSubsonicID(value)
+	if err != nil {
+		return err
+	}
+
+	ratingValue, err := subsonicParam(r.Form, "rating")
+	if err != nil {
+		return err
+	}
+
+	rating, err := strconv.Atoi(ratingValue)
+	if err != nil || rating < 0 || rating > 5 {
+		return &subsonicError{
+			Code:    subsonicErrGeneric,
+			Message: "Invalid rating '" + ratingValue + "'",
+		}
+	}
+
+	err = repo.SetRating(s.db, profile, entity, id, rating)
+	if err == sql.ErrNoRows {
+		return subsonicNotFound(entity, id)
+	}
+
+	return err
+}
+
+func (s *Server) subsonicStar(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	return s.subsonicSetStarred(r, profile, true)
+}
+
+func (s *Server) subsonicUnstar(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	return s.subsonicSetStarred(r, profile, false)
+}
+
+// Each of id, albumId and artistId may be given any number of times
+func (s *Server) subsonicSetStarred(
+	r *http.Request, profile int, starred bool,
+) error {
+	var values []string
+	for _, param := range []string{"id", "albumId", "artistId"} {
+		values = append(values, r.Form[param]...)
+	}
+
+	if len(values) == 0 {
+		return &subsonicError{
+			Code:    subsonicErrMissing,
+			Message: "Required parameter is missing: id",
+		}
+	}
+
+	for _, value := range values {
+		entity, id, err := parseSubsonicID(value)
+		if err != nil {
+			return err
+		}
+
+		err = repo.SetStarred(s.db, profile, entity, id, starred)
+		if err == sql.ErrNoRows {
+			return subsonicNotFound(entity, id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Starred artists, albums and tracks, by ID
+func (s *Server) subsonicGetStarred(
+	r *http.Request, profile int, response *subsonicResponse,
+) error {
+	starred := &subsonicStarred{
+		Artist: []subsonicArtist{},
+		Album:  []subsonicChild{},
+		Song:   []subsonicChild{},
+	}
+
+	for _, entity := range []repo.Entity{
+		repo.EntityArtist, repo.EntityAlbum, repo.EntityTrack,
+	} {
+		annotations, err := repo.Annotations(s.db, profile, entity)
+		if err != nil {
+			return err
+		}
+
+		var ids []int
+		for id, a := range annotations {
+			if !a.Starred.IsZero() {
+				ids = append(ids, id)
+			}
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			a := annotations[id]
+
+			switch entity {
+			case repo.EntityArtist, repo.EntityAlbum:
+				c, err := repo.GetContender(s.db, profile, entity, id)
+				if err != nil {
+					return err
+				}
+
+				if entity == repo.EntityArtist {
+					starred.Artist = append(starred.Artist, subsonicArtist{
+						ID:         subsonicID(entity, id),
+						Name:       c.Name,
+						UserRating: a.Rating,
+						Starred:    subsonicTime(a.Starred),
+					})
+					continue
+				}
+
+				starred.Album = append(starred.Album, subsonicChild{
+					ID:         subsonicID(entity, id),
+					IsDir:      true,
+					Title:      c.Name,
+					Album:      c.Name,
+					Artist:     c.Detail,
+					UserRating: a.Rating,
+					Starred:    subsonicTime(a.Starred),
+				})
+			case repo.EntityTrack:
+				t, err := repo.GetTrack(s.db, profile, id)
+				if err != nil {
+					return err
+				}
+
+				songs, err := s.subsonicSongs(profile, []track.Track{t})
+				if err != nil {
+					return err
+				}
+
+				starred.Song = append(starred.Song, songs[0])
+			}
+		}
+	}
+
+	response.Starred = starred
+	return nil
+}
+
+// Songs for tracks, with their first file's details and their ratings
+// and stars in the profile
+func (s *Server) subsonicSongs(
+	profile int, tracks []track.Track,
+) ([]subsonicChild, error) {
+	root, err := s.libraryRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := repo.Annotations(s.db, profile, repo.EntityTrack)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := []subsonicChild{}
+
+	for _, t := range tracks {
+		a := annotations[t.InternalID]
+
+		song := subsonicChild{
+			ID:         subsonicID(repo.EntityTrack, t.InternalID),
+			Title:      t.Title,
+			Artist:     t.PrimaryArtist.Name,
+			Year:       t.Year,
+			Genre:      t.Genre,
+			ArtistID:   subsonicID(repo.EntityArtist, t.PrimaryArtist.InternalID),
+			Type:       "music",
+			UserRating: a.Rating,
+			Starred:    subsonicTime(a.Starred),
+		}
+
+		if len(t.Albums) > 0 && t.Albums[0].InternalID != 0 {
+			song.Album = t.Albums[0].Title
+			song.AlbumID = subsonicID(repo.EntityAlbum, t.Albums[0].InternalID)
+			song.Parent = song.AlbumID
+		}
+
+		files, err := repo.TrackFiles(s.db, t.InternalID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(files) > 0 {
+			ext := strings.ToLower(filepath.Ext(files[0]))
+
+			song.Suffix = strings.TrimPrefix(ext, ".")
+			song.ContentType = audioContentTypes[ext]
+			song.Path = subsonicPath(root, files[0])
+
+			if info, err := os.Stat(files[0]); err == nil {
+				song.Size = info.Size()
+			}
+		}
+
+		songs = append(songs, song)
+	}
+
+	return songs, nil
+}
+
+func writeSubsonic(
+	w http.ResponseWriter, r *http.Request, status int, response subsonicResponse,
+) {
+	response.Xmlns = subsonicNamespace
+	response.Version = subsonicVersion
+	if response.Status == "" {
+		response.Status = "ok"
+	}
+
+	if r.Form.Get("f") == "json" {
+		writeJSON(w, status, map[string]subsonicResponse{
+			"subsonic-response": response,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(status)
+
+	body, err := xml.Marshal(response)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// Subsonic clients expect errors in a successful response, so the status
+// is usually 200. Errors other than subsonicErrors are logged and sent
+// as generic errors.
+func (s *Server) writeSubsonicError(
+	w http.ResponseWriter, r *http.Request, status int, err error,
+) {
+	atomic.AddInt64(&s.errors, 1)
+
+	var subsonicErr *subsonicError
+	if !errors.As(err, &subsonicErr) {
+		log.Println(err)
+		subsonicErr = &subsonicError{Code: subsonicErrGeneric, Message: err.Error()}
+	}
+
+	writeSubsonic(w, r, status, subsonicResponse{
+		Status: "failed",
+		Error:  subsonicErr,
+	})
+}
+
+func subsonicParam(form url.Values, name string) (string, error) {
+	value := form.Get(name)
+	if value == "" {
+		return "", &subsonicError{
+			Code:    subsonicErrMissing,
+			Message: "Required parameter is missing: " + name,
+		}
+	}
+
+	return value, nil
+}
+
+// IDs are prefixed by entity, e.g. "track-12", as Subsonic has one ID
+// space for songs, albums and artists
+func subsonicID(entity repo.Entity, id int) string {
+	return fmt.Sprintf("%s-%d", entity, id)
+}
+
+func parseSubsonicID(value string) (repo.Entity, int, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) == 2 {
+		entity, err := parseEntity(parts[0])
+		id, idErr := strconv.Atoi(parts[1])
+
+		if err == nil && parts[0] != "" && idErr == nil && id > 0 {
+			return entity, id, nil
+		}
+	}
+
+	return "", 0, &subsonicError{
+		Code:    subsonicErrNotFound,
+		Message: "Invalid ID '" + value + "'",
+	}
+}
+
+// The ID of an entity from the param; IDs for anything else are not found
+func subsonicEntityID(
+	form url.Values, param string, entity repo.Entity,
+) (int, error) {
+	value, err := subsonicParam(form, param)
+	if err != nil {
+		return 0, err
+	}
+
+	got, id, err := parseSubsonicID(value)
+	if err != nil {
+		return 0, err
+	}
+	if got != entity {
+		return 0, &subsonicError{
+			Code:    subsonicErrNotFound,
+			Message: "'" + value + "' is not a " + string(entity),
+		}
+	}
+
+	return id, nil
+}
+
+func subsonicNotFound(entity repo.Entity, id int) error {
+	return &subsonicError{
+		Code:    subsonicErrNotFound,
+		Message: fmt.Sprintf("No %s %d", entity, id),
+	}
+}
+
+// "" for the zero time
+func subsonicTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Path relative to the library root, with forward slashes; just the file
+// name if it's elsewhere
+func subsonicPath(root string, path string) string {
+	if rel, ok := track.InFolder(root, path); ok {
+		return filepath.ToSlash(rel)
+	}
+
+	return filepath.Base(path)
+}
+
+// The name to sort an artist by, without any leading article, and its
+// index
+func subsonicIndexName(name string) (string, string) {
+	for _, article := range strings.Fields(subsonicIgnoredArticles) {
+		prefix := article + " "
+		if len(name) > len(prefix) &&
+			strings.EqualFold(name[:len(prefix)], prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+
+	first, _ := utf8.DecodeRuneInString(name)
+	if !unicode.IsLetter(first) {
+		return name, "#"
+	}
+
+	return name, string(unicode.ToUpper(first))
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Query strings as sent by clients, for user admin, the default profile,
+// with password sesame.
+// DSub sends a salted token and asks for JSON; play:Sub sends the
+// password hex-encoded and takes the default XML.
+const (
+	dsubAuth    = "u=admin&t=26719a1196d2a940705a59634eb18eab&s=c19b2d&v=1.2.0&c=DSub&f=json"
+	playSubAuth = "u=admin&p=enc:736573616d65&v=1.13.0&c=play%3ASub"
+)
+
+func TestSubsonic(t *testing.T) {
+	dir := t.TempDir()
+
+	audio := filepath.Join(dir, "The Beatles", "01 Come Together.mp3")
+	if err := os.Mkdir(filepath.Dir(audio), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(audio, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := setup()
+
+	repo.SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			Title:         "Come Together",
+			Albums:        []track.Album{{Title: "Abbey Road"}},
+			PrimaryArtist: track.Artist{Name: "The Beatles"},
+			Files:         []string{audio},
+		}),
+		track.New(track.Track{
+			Title:         "Changes",
+			Albums:        []track.Album{{Title: "Greatest Hits"}},
+			PrimaryArtist: track.Artist{Name: "2Pac"},
+		}),
+	})
+
+	if err := repo.SaveLibraryRoot(db, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(db)
+	s.SubsonicUser = "admin"
+	s.SubsonicPassword = "sesame"
+
+	handler := s.Subsonic()
+
+	t.Log("Ping with token and with password")
+
+	var response subsonicResponse
+	subsonicRequest(t, handler, "GET", "/rest/ping.view?"+dsubAuth, "", http.StatusOK, &response)
+	if response.Status != "ok" || response.Version != subsonicVersion {
+		t.Errorf("Expected ok, got %+v", response)
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/ping?"+playSubAuth, "", http.StatusOK, &response)
+	if response.Status != "ok" {
+		t.Errorf("Expected ok, got %+v", response)
+	}
+
+	t.Log("Refused")
+
+	for _, test := range []struct {
+		path string
+		code int
+	}{
+		{"/rest/ping.view?u=admin&t=00000000000000000000000000000000&s=c19b2d&f=json", subsonicErrWrongAuth},
+		{"/rest/ping.view?u=guest&p=sesame&f=json", subsonicErrWrongAuth},
+		{"/rest/ping.view?u=admin&p=enc:zz&f=json", subsonicErrWrongAuth},
+		{"/rest/ping.view?u=admin&t=26719a1196d2a940705a59634eb18eab&f=json", subsonicErrMissing},
+		{"/rest/ping.view?p=sesame&f=json", subsonicErrMissing},
+		{"/rest/ping.view?u=admin&f=json", subsonicErrMissing},
+		{"/rest/getSong.view?id=track-99&" + dsubAuth, subsonicErrNotFound},
+		{"/rest/getSong.view?id=album-1&" + dsubAuth, subsonicErrNotFound},
+		{"/rest/getSong.view?id=1&" + dsubAuth, subsonicErrNotFound},
+		{"/rest/getSong.view?" + dsubAuth, subsonicErrMissing},
+		{"/rest/getAlbum.view?id=album-99&" + dsubAuth, subsonicErrNotFound},
+		{"/rest/setRating.view?id=track-1&rating=6&" + dsubAuth, subsonicErrGeneric},
+		{"/rest/star.view?" + dsubAuth, subsonicErrMissing},
+		{"/rest/star.view?artistId=artist-99&" + dsubAuth, subsonicErrNotFound},
+		{"/rest/stream.view?id=track-1&" + dsubAuth, subsonicErrNotFound},
+	} {
+		response = subsonicResponse{}
+		subsonicRequest(t, handler, "GET", test.path, "", http.StatusOK, &response)
+
+		if response.Status != "failed" || response.Error == nil ||
+			response.Error.Code != test.code {
+			t.Errorf("%s: expected error %d, got %+v", test.path, test.code, response)
+		}
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getArtistInfo.view?"+dsubAuth, "", http.StatusNotFound, &response)
+
+	t.Log("Browse")
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getMusicFolders.view?"+dsubAuth, "", http.StatusOK, &response)
+	if response.MusicFolders == nil || len(response.MusicFolders.MusicFolder) != 1 {
+		t.Errorf("Expected one music folder, got %+v", response.MusicFolders)
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getIndexes.view?musicFolderId=1&"+dsubAuth, "", http.StatusOK, &response)
+
+	expectedIndexes := []subsonicIndex{
+		{Name: "#", Artist: []subsonicArtist{{ID: "artist-4", Name: "2Pac"}}},
+		{Name: "A", Artist: []subsonicArtist{
+			{ID: "artist-1", Name: "Artist 1"},
+			{ID: "artist-2", Name: "Artist 2"},
+		}},
+		{Name: "B", Artist: []subsonicArtist{{ID: "artist-3", Name: "The Beatles"}}},
+	}
+	if response.Indexes == nil ||
+		!reflect.DeepEqual(expectedIndexes, response.Indexes.Index) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedIndexes, response.Indexes)
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getAlbum.view?id=album-2&"+dsubAuth, "", http.StatusOK, &response)
+
+	if response.Album == nil || response.Album.Name != "Album 2" ||
+		response.Album.SongCount != 2 ||
+		response.Album.Song[0].ID != "track-2" ||
+		response.Album.Song[1].ID != "track-3" {
+		t.Errorf("Expected Album 2 with tracks 2 and 3, got %+v", response.Album)
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getSong.view?id=track-4&"+playSubAuth, "", http.StatusOK, &response)
+
+	expectedSong := &subsonicChild{
+		ID:          "track-4",
+		Parent:      "album-3",
+		Title:       "Come Together",
+		Album:       "Abbey Road",
+		Artist:      "The Beatles",
+		Size:        10,
+		ContentType: "audio/mpeg",
+		Suffix:      "mp3",
+		Path:        "The Beatles/01 Come Together.mp3",
+		AlbumID:     "album-3",
+		ArtistID:    "artist-3",
+		Type:        "music",
+	}
+	if !reflect.DeepEqual(expectedSong, response.Song) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedSong, response.Song)
+	}
+
+	t.Log("Stream")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(
+		"GET", "/rest/stream.view?id=track-4&maxBitRate=320&"+playSubAuth, nil,
+	))
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Errorf("Expected whole file, got %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "audio/mpeg" {
+		t.Errorf("Expected audio/mpeg, got '%s'", ct)
+	}
+
+	t.Log("Rating feeds into the rankings")
+
+	before, err := repo.GetContender(db, repo.DefaultProfile, repo.EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Symfonium POSTs its parameters
+	req := httptest.NewRequest(
+		"POST", "/rest/setRating", strings.NewReader("id=track-2&rating=5&"+dsubAuth),
+	)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"ok"`) {
+		t.Errorf("Expected rating to be set, got %d: %s", rec.Code, rec.Body)
+	}
+
+	after, err := repo.GetContender(db, repo.DefaultProfile, repo.EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Ranking <= before.Ranking {
+		t.Errorf(
+			"Expected 5 stars to raise ranking, went from %v to %v",
+			before.Ranking, after.Ranking,
+		)
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getSong.view?id=track-2&"+dsubAuth, "", http.StatusOK, &response)
+	if response.Song == nil || response.Song.UserRating != 5 {
+		t.Errorf("Expected 5 stars, got %+v", response.Song)
+	}
+
+	t.Log("Star and unstar")
+
+	subsonicRequest(t, handler, "GET",
+		"/rest/star.view?id=track-1&id=track-3&albumId=album-2&artistId=artist-1&"+dsubAuth,
+		"", http.StatusOK, &subsonicResponse{},
+	)
+	subsonicRequest(t, handler, "GET", "/rest/unstar.view?id=track-3&"+dsubAuth, "", http.StatusOK, &subsonicResponse{})
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getStarred.view?"+playSubAuth, "", http.StatusOK, &response)
+
+	starred := response.Starred
+	if starred == nil ||
+		len(starred.Artist) != 1 || starred.Artist[0].ID != "artist-1" ||
+		len(starred.Album) != 1 || starred.Album[0].ID != "album-2" ||
+		!starred.Album[0].IsDir ||
+		len(starred.Song) != 1 || starred.Song[0].ID != "track-1" ||
+		starred.Song[0].Starred == "" {
+		t.Errorf("Expected artist 1, album 2 and track 1 starred, got %+v", starred)
+	}
+
+	var prior float64
+	if err = db.QueryRow(
+		"SELECT prior FROM annotations WHERE entity = 'track' AND entity_id = 1",
+	).Scan(&prior); err != nil {
+		t.Fatal(err)
+	}
+	if prior <= 1000 {
+		t.Errorf("Expected starred track to have a raised prior, got %v", prior)
+	}
+
+	t.Log("Profiles log in with their own names, passwords and ratings")
+
+	sam, err := repo.CreateProfile(db, "Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not with the default profile's password, nor without one of their own
+	for _, password := range []string{"sesame", "open"} {
+		response = subsonicResponse{}
+		subsonicRequest(t, handler, "GET", "/rest/ping.view?u=Sam&f=json&p="+password, "", http.StatusOK, &response)
+		if response.Error == nil || response.Error.Code != subsonicErrWrongAuth {
+			t.Errorf("Password '%s': expected wrong auth, got %+v", password, response)
+		}
+	}
+
+	if err = repo.SetSubsonicPassword(db, sam.ID, "open"); err != nil {
+		t.Fatal(err)
+	}
+	samAuth := "u=Sam&p=open&f=json"
+
+	subsonicRequest(t, handler, "GET",
+		"/rest/setRating.view?id=track-2&rating=2&"+samAuth,
+		"", http.StatusOK, &subsonicResponse{},
+	)
+
+	for _, test := range []struct {
+		auth   string
+		rating int
+	}{
+		{samAuth, 2},
+		{dsubAuth, 5},
+	} {
+		response = subsonicResponse{}
+		subsonicRequest(t, handler, "GET", "/rest/getSong.view?id=track-2&"+test.auth, "", http.StatusOK, &response)
+		if response.Song == nil || response.Song.UserRating != test.rating {
+			t.Errorf("%s: expected %d stars, got %+v", test.auth, test.rating, response.Song)
+		}
+	}
+
+	response = subsonicResponse{}
+	subsonicRequest(t, handler, "GET", "/rest/getStarred.view?"+samAuth, "", http.StatusOK, &response)
+	if response.Starred == nil || len(response.Starred.Song) != 0 {
+		t.Errorf("Expected nothing starred for Sam, got %+v", response.Starred)
+	}
+}
+
+// Makes a Subsonic request, checks the HTTP status and decodes the
+// response, as JSON if f=json was asked for and XML otherwise
+func subsonicRequest(
+	t *testing.T,
+	handler http.Handler,
+	method string,
+	path string,
+	body string,
+	expectedStatus int,
+	response *subsonicResponse,
+) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+	if rec.Code != expectedStatus {
+		t.Fatalf(
+			"%s %s: expected status %d, got %d: %s",
+			method, path, expectedStatus, rec.Code, rec.Body,
+		)
+	}
+
+	if strings.Contains(path, "f=json") {
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: expected JSON, got '%s'", method, path, ct)
+		}
+
+		var wrapped struct {
+			Response *subsonicResponse `json:"subsonic-response"`
+		}
+		wrapped.Response = response
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &wrapped); err != nil {
+			t.Fatalf("%s %s: %s", method, path, err)
+		}
+		return
+	}
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/xml") {
+		t.Errorf("%s %s: expected XML, got '%s'", method, path, ct)
+	}
+	if !strings.Contains(rec.Body.String(), `xmlns="`+subsonicNamespace+`"`) {
+		t.Errorf("%s %s: expected Subsonic namespace in %s", method, path, rec.Body)
+	}
+
+	if err := xml.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatalf("%s %s: %s", method, path, err)
+	}
+}
@@ -0,0 +1,196 @@
+// Package beets reads tracks straight from a beets library.db, whose
+// metadata beets has already matched against MusicBrainz
+package beets
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Prior sources for flexible attributes set by beets plugins
+const (
+	PriorSourceRating = "beets_rating" // mpdstats, 0–1
+	PriorSourcePlays  = "beets_plays"  // mpdstats or lastimport
+)
+
+// Options for reading a library
+type Options struct {
+	// Ignore ratings and play counts
+	NoPriors bool
+}
+
+// Library is what was read from a beets database
+type Library struct {
+	Tracks []track.Track
+
+	// Items beets has no MusicBrainz track ID for, i.e. imported as-is
+	WithoutMBID int
+}
+
+// ReadLibrary reads every item in a beets library. Album artists and
+// composers other than the track artist become secondary artists, as
+// with tags.
+func ReadLibrary(db *sql.DB, opts Options) (Library, error) {
+	flexible := map[int64]map[string]string{}
+
+	if !opts.NoPriors {
+		var err error
+		if flexible, err = readAttributes(db, "rating", "play_count"); err != nil {
+			return Library{}, err
+		}
+	}
+
+	rows, err := db.Query(
+		`SELECT id,
+		        path,
+		        IFNULL(title, ''),
+		        IFNULL(artist, ''),
+		        IFNULL(albumartist, ''),
+		        IFNULL(composer, ''),
+		        IFNULL(album, ''),
+		        IFNULL(genre, ''),
+		        IFNULL(year, 0),
+		        IFNULL(mb_trackid, ''),
+		        IFNULL(mb_albumid, ''),
+		        IFNULL(mb_artistid, ''),
+		        IFNULL(mb_albumartistid, '')
+		   FROM items
+		  ORDER BY id`,
+	)
+	if err != nil {
+		return Library{}, fmt.Errorf("Not a beets library: %w", err)
+	}
+	defer rows.Close()
+
+	library := Library{Tracks: []track.Track{}}
+
+	for rows.Next() {
+		var id int64
+		var path []byte // beets stores paths as bytes
+		var title, artist, albumArtist, composer, album, genre string
+		var year int
+		var trackMBID, albumMBID, artistMBID, albumArtistMBID string
+
+		if err = rows.Scan(
+			&id,
+			&path,
+			&title,
+			&artist,
+			&albumArtist,
+			&composer,
+			&album,
+			&genre,
+			&year,
+			&trackMBID,
+			&albumMBID,
+			&artistMBID,
+			&albumArtistMBID,
+		); err != nil {
+			return Library{}, err
+		}
+
+		artists := []track.Artist{}
+		if albumArtist != "" && albumArtist != artist {
+			artists = append(artists, track.Artist{
+				MusicBrainzID: albumArtistMBID,
+				Name:          albumArtist,
+			})
+		}
+		if composer != "" && composer != artist && composer != albumArtist {
+			artists = append(artists, track.Artist{Name: composer})
+		}
+
+		if trackMBID == "" {
+			library.WithoutMBID++
+		}
+
+		library.Tracks = append(library.Tracks, track.New(track.Track{
+			MusicBrainzID: trackMBID,
+			Title:         title,
+			Genre:         genre,
+			Year:          year,
+			Albums: []track.Album{{
+				MusicBrainzID: albumMBID,
+				Title:         album,
+			}},
+			PrimaryArtist: track.Artist{
+				MusicBrainzID: artistMBID,
+				Name:          artist,
+			},
+			OtherArtists: artists,
+			Files:        []string{string(path)},
+			Prior:        readPrior(flexible[id]),
+		}))
+	}
+
+	return library, rows.Err()
+}
+
+// Flexible attributes with the given keys, by item ID
+func readAttributes(
+	db *sql.DB, keys ...string,
+) (map[int64]map[string]string, error) {
+	attributes := map[int64]map[string]string{}
+
+	for _, key := range keys {
+		if err := readAttribute(db, key, attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	return attributes, nil
+}
+
+func readAttribute(
+	db *sql.DB, key string, attributes map[int64]map[string]string,
+) error {
+	rows, err := db.Query(
+		`SELECT entity_id, value
+		   FROM item_attributes
+		  WHERE key = ?`,
+		key,
+	)
+	if err != nil {
+		return fmt.Errorf("Not a beets library: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var value string
+
+		if err = rows.Scan(&id, &value); err != nil {
+			return err
+		}
+
+		if attributes[id] == nil {
+			attributes[id] = map[string]string{}
+		}
+		attributes[id][key] = value
+	}
+
+	return rows.Err()
+}
+
+// A rating beats a play count. Unparseable values are ignored, as beets
+// doesn't check the types of flexible attributes.
+func readPrior(attributes map[string]string) track.Prior {
+	var prior track.Prior
+
+	plays, err := strconv.Atoi(attributes["play_count"])
+	if err == nil && plays > 0 {
+		prior.Plays = plays
+		prior.Source = PriorSourcePlays
+	}
+
+	rating, err := strconv.ParseFloat(attributes["rating"], 64)
+	if err == nil && rating > 0 && rating <= 1 {
+		prior.Stars = rating * 5
+		prior.Source = PriorSourceRating
+	}
+
+	return prior
+}
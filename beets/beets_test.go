@@ -0,0 +1,146 @@
+package beets
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+
+	_ "modernc.org/sqlite"
+)
+
+// The parts of the beets schema we read, plus a column we don't
+const testSchema = `
+CREATE TABLE items (
+    id INTEGER PRIMARY KEY,
+    path BLOB,
+    album_id INTEGER,
+    title TEXT,
+    artist TEXT,
+    albumartist TEXT,
+    composer TEXT,
+    album TEXT,
+    genre TEXT,
+    year INTEGER,
+    track INTEGER,
+    mb_trackid TEXT,
+    mb_albumid TEXT,
+    mb_artistid TEXT,
+    mb_albumartistid TEXT
+);
+
+CREATE TABLE item_attributes (
+    id INTEGER PRIMARY KEY,
+    entity_id INTEGER,
+    key TEXT,
+    value TEXT,
+    UNIQUE(entity_id, key) ON CONFLICT REPLACE
+);
+
+INSERT INTO items VALUES
+    (1, CAST('/music/Artist 1/01 Title 1.flac' AS BLOB), 1, 'Title 1',
+     'Artist 1', 'Artist 1', '', 'Album 1', 'Rock', 1991, 1,
+     'MB1', 'AL1', 'AR1', 'AR1'),
+    (2, CAST('/music/Compilations/02 Title 2.mp3' AS BLOB), 2, 'Title 2',
+     'Artist 2', 'Various Artists', 'Composer 1', 'Album 2', '', 0, 2,
+     'MB2', 'AL2', 'AR2', 'VA'),
+    (3, '/music/Non-Album/Title 3.mp3', NULL, 'Title 3',
+     'Artist 3', '', NULL, '', NULL, NULL, 0,
+     '', '', '', '');
+
+INSERT INTO item_attributes (entity_id, key, value) VALUES
+    (1, 'rating', '0.8'),
+    (1, 'play_count', '12'),
+    (2, 'play_count', '3'),
+    (3, 'rating', 'lots'),
+    (3, 'mood', 'happy');
+`
+
+func TestReadLibrary(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Each connection would get its own in-memory DB
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(testSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	library, err := ReadLibrary(db, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Library{
+		Tracks: []track.Track{
+			{
+				MusicBrainzID: "MB1",
+				Title:         "Title 1",
+				Genre:         "Rock",
+				Year:          1991,
+				Albums: []track.Album{
+					{MusicBrainzID: "AL1", Title: "Album 1"},
+				},
+				PrimaryArtist: track.Artist{MusicBrainzID: "AR1", Name: "Artist 1"},
+				OtherArtists:  []track.Artist{},
+				Prior: track.Prior{
+					Stars: 4, Plays: 12, Source: PriorSourceRating,
+				},
+				Files: []string{"/music/Artist 1/01 Title 1.flac"},
+			},
+			{
+				MusicBrainzID: "MB2",
+				Title:         "Title 2",
+				Albums: []track.Album{
+					{MusicBrainzID: "AL2", Title: "Album 2"},
+				},
+				PrimaryArtist: track.Artist{MusicBrainzID: "AR2", Name: "Artist 2"},
+				OtherArtists: []track.Artist{
+					{MusicBrainzID: "VA", Name: "Various Artists"},
+					{Name: "Composer 1"},
+				},
+				Prior: track.Prior{Plays: 3, Source: PriorSourcePlays},
+				Files: []string{"/music/Compilations/02 Title 2.mp3"},
+			},
+			{
+				Title:         "Title 3",
+				Albums:        []track.Album{{}},
+				PrimaryArtist: track.Artist{Name: "Artist 3"},
+				OtherArtists:  []track.Artist{},
+				Files:         []string{"/music/Non-Album/Title 3.mp3"},
+			},
+		},
+		WithoutMBID: 1,
+	}
+
+	if !reflect.DeepEqual(expected, library) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, library)
+	}
+
+	t.Log("Without priors")
+
+	library, err = ReadLibrary(db, Options{NoPriors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, got := range library.Tracks {
+		if got.Prior != (track.Prior{}) {
+			t.Errorf("Expected no prior, got %+v", got.Prior)
+		}
+	}
+
+	t.Log("Not a beets library")
+
+	if _, err = db.Exec("DROP TABLE items"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadLibrary(db, Options{}); err == nil {
+		t.Error("Expected error reading DB without items")
+	}
+}
@@ -1,8 +1,13 @@
 // Program to populate sqlite DB with track data, given a music folder
+//
+// Usage:
+//     populate-db [-db <file>] [-no-priors] <folder>
 
 package main
 
 import (
+	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -10,6 +15,8 @@ import (
 	"regexp"
 
 	"github.com/dhowden/tag"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/tags"
 	"github.com/nephila-nacrea/rank-my-music/track"
 
 	_ "modernc.org/sqlite"
@@ -22,7 +29,23 @@ func init() {
 }
 
 func main() {
-	folderPath := os.Args[1]
+	dbFilename := flag.String("db", "ranked_music.sqlt", "sqlite DB file")
+	noPriors := flag.Bool(
+		"no-priors", false,
+		"Don't seed rankings from star ratings and play counts in the tags",
+	)
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: populate-db [-db <file>] [-no-priors] <folder>")
+		os.Exit(2)
+	}
+
+	// Paths are stored absolute so tracks can be played from anywhere
+	folderPath, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	// TODO
 	// 	Handle duplicates
@@ -31,7 +54,7 @@ func main() {
 	var tracks []track.Track
 
 	var filenames []string
-	err := filepath.Walk(
+	err = filepath.Walk(
 		folderPath,
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -84,26 +107,38 @@ func main() {
 			}
 		} else {
 			// Dedupe artist data
-			artists := []string{}
+			artists := []track.Artist{}
 
-			if meta.AlbumArtist() != meta.Artist() {
-				artists = append(artists, meta.AlbumArtist())
+			if meta.AlbumArtist() != "" &&
+				meta.AlbumArtist() != meta.Artist() {
+				artists = append(artists, track.Artist{Name: meta.AlbumArtist()})
 			}
 
-			if meta.Composer() != meta.Artist() &&
+			if meta.Composer() != "" &&
+				meta.Composer() != meta.Artist() &&
 				meta.Composer() != meta.AlbumArtist() {
-				artists = append(artists, meta.Composer())
+				artists = append(artists, track.Artist{Name: meta.Composer()})
+			}
+
+			// Existing ratings give a head start
+			var prior track.Prior
+			if info, err := file.Stat(); err == nil && !*noPriors {
+				prior = tags.ReadPrior(meta, file, info.Size())
 			}
 
 			tracks = append(
 				tracks,
-				track.New(
-					meta.Title(),
-					meta.Album(),
-					meta.Artist(),
-					artists,
-					"", // TODO musicbrainz_id
-				),
+				track.New(track.Track{
+					MusicBrainzID: "", // TODO musicbrainz_id
+					Title:         meta.Title(),
+					Genre:         meta.Genre(),
+					Year:          meta.Year(),
+					Albums:        []track.Album{{Title: meta.Album()}},
+					PrimaryArtist: track.Artist{Name: meta.Artist()},
+					OtherArtists:  artists,
+					Files:         []string{filename},
+					Prior:         prior,
+				}),
 			)
 
 			raw := meta.Raw()
@@ -139,10 +174,24 @@ func main() {
 
 	log.Println("Now for the database!")
 
-	// db, err := sql.Open("sqlite", "file:ranked_music.sqlt")
-	// if err != nil {
-	// 	log.Fatalln(err)
-	// }
+	db, err := sql.Open(
+		"sqlite",
+		"file:"+*dbFilename+"?_pragma=foreign_keys(1)",
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	if _, err = repo.Migrate(db); err != nil {
+		log.Fatalln(err)
+	}
 
-	// repo.SaveTracks(db, tracks)
+	if err = repo.SaveTracks(db, tracks); err != nil {
+		log.Fatalln(err)
+	}
+
+	if err = repo.SaveLibraryRoot(db, folderPath); err != nil {
+		log.Fatalln(err)
+	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/beets"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+// Adds or updates tracks from a beets library. Tracks beets matched to
+// MusicBrainz are updated in place, keeping their rankings, so this can
+// be run again after changes in beets.
+func importBeets(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("beets", flag.ExitOnError)
+
+	noPriors := flags.Bool(
+		"no-priors", false,
+		"Don't seed rankings from ratings and play counts set by beets plugins",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rank beets [-no-priors] <library.db>")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := os.Stat(flags.Arg(0)); err != nil {
+		return err
+	}
+
+	beetsDB, err := sql.Open("sqlite", "file:"+flags.Arg(0)+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer beetsDB.Close()
+
+	library, err := beets.ReadLibrary(beetsDB, beets.Options{NoPriors: *noPriors})
+	if err != nil {
+		return err
+	}
+
+	tracks, err := tracksToSave(db, library.Tracks)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.SaveTracks(db, tracks); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Tracks in beets\t%d\n", len(library.Tracks))
+	fmt.Fprintf(w, "Without MusicBrainz ID\t%d\n", library.WithoutMBID)
+	fmt.Fprintf(
+		w,
+		"Already imported, no MusicBrainz ID\t%d\n",
+		len(library.Tracks)-len(tracks),
+	)
+	fmt.Fprintf(w, "Added or updated\t%d\n", len(tracks))
+
+	return w.Flush()
+}
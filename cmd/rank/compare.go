@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func compare(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+
+	entity := flags.String("entity", "track", "'track', 'album' or 'artist'")
+	rounds := flags.Int("n", 10, "Number of comparisons (0 = until quit)")
+	size := flags.Int(
+		"size", 2,
+		fmt.Sprintf("Number to put in order at once (2-%d)", repo.MaxRankedContenders),
+	)
+	snapshotMode := flags.String(
+		"snapshot", "session",
+		"Snapshot track rankings after each 'session', once a 'day' or 'none'",
+	)
+
+	flags.Parse(args)
+
+	var compared int
+	var err error
+
+	if *size == 2 {
+		compared, err = compareRounds(db, profile.ID, repo.Entity(*entity), *rounds)
+	} else {
+		compared, err = orderRounds(db, profile.ID, repo.Entity(*entity), *rounds, *size)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Snapshots are of track rankings only
+	if compared == 0 || repo.Entity(*entity) != repo.EntityTrack {
+		return nil
+	}
+
+	switch *snapshotMode {
+	case "session":
+		_, err = repo.TakeSnapshot(db, profile.ID, "session")
+	case "day":
+		_, _, err = repo.TakeDailySnapshot(db, profile.ID)
+	case "none":
+	default:
+		err = fmt.Errorf("Unknown snapshot mode '%s'", *snapshotMode)
+	}
+
+	return err
+}
+
+// Returns number of comparisons made
+func compareRounds(
+	db *sql.DB, profile int, entity repo.Entity, rounds int,
+) (int, error) {
+	input := bufio.NewScanner(os.Stdin)
+	compared := 0
+
+	for round := 1; rounds == 0 || round <= rounds; round++ {
+		a, b, err := repo.NextPair(db, profile, entity)
+		if err != nil {
+			return compared, err
+		}
+
+		fmt.Printf("\n[%d] Which do you prefer?\n", round)
+		fmt.Printf("    1) %s\n", describeContender(a))
+		fmt.Printf("    2) %s\n", describeContender(b))
+		fmt.Print("1, 2 (add + if strongly), (d)raw, (s)kip or (q)uit: ")
+
+		if !input.Scan() {
+			return compared, input.Err()
+		}
+
+		// Graded preference for 1, on the default 5-point scale
+		var preference float64
+
+		switch strings.ToLower(strings.TrimSpace(input.Text())) {
+		case "1+":
+			preference = 1
+		case "1":
+			preference = 0.75
+		case "d":
+			preference = 0.5
+		case "2":
+			preference = 0.25
+		case "2+":
+			preference = 0
+		case "s":
+			continue
+		case "q":
+			return compared, nil
+		default:
+			fmt.Println("Didn't understand that, skipping")
+			continue
+		}
+
+		if _, err = repo.RecordGradedComparison(
+			db, profile, entity, a.InternalID, b.InternalID, preference,
+		); err != nil {
+			return compared, err
+		}
+
+		compared++
+	}
+
+	return compared, nil
+}
+
+// Like compareRounds, but for putting several in order at once. Returns
+// number of comparisons made.
+func orderRounds(
+	db *sql.DB, profile int, entity repo.Entity, rounds int, size int,
+) (int, error) {
+	input := bufio.NewScanner(os.Stdin)
+	compared := 0
+
+	for round := 1; rounds == 0 || round <= rounds; round++ {
+		group, err := repo.NextGroup(db, profile, entity, size)
+		if err != nil {
+			return compared, err
+		}
+
+		fmt.Printf("\n[%d] Put these in order, favourite first\n", round)
+		for i, c := range group {
+			fmt.Printf("    %d) %s\n", i+1, describeContender(c))
+		}
+		fmt.Printf("Order (e.g. %s), (s)kip or (q)uit: ", exampleOrder(size))
+
+		if !input.Scan() {
+			return compared, input.Err()
+		}
+
+		answer := strings.ToLower(strings.TrimSpace(input.Text()))
+
+		switch answer {
+		case "s":
+			continue
+		case "q":
+			return compared, nil
+		}
+
+		orderedIDs, ok := parseOrder(answer, group)
+		if !ok {
+			fmt.Println("Didn't understand that, skipping")
+			continue
+		}
+
+		if _, err = repo.RecordRankedComparison(
+			db, profile, entity, orderedIDs,
+		); err != nil {
+			return compared, err
+		}
+
+		compared++
+	}
+
+	return compared, nil
+}
+
+// Parses e.g. "312" into the IDs of the 3rd, 1st and 2nd of group. Every
+// member of group must appear exactly once.
+func parseOrder(answer string, group []repo.Contender) ([]int, bool) {
+	answer = strings.NewReplacer(" ", "", ",", "").Replace(answer)
+	if len(answer) != len(group) {
+		return nil, false
+	}
+
+	seen := map[int]bool{}
+	orderedIDs := []int{}
+
+	for _, r := range answer {
+		i := int(r - '1')
+		if i < 0 || i >= len(group) || seen[i] {
+			return nil, false
+		}
+
+		seen[i] = true
+		orderedIDs = append(orderedIDs, group[i].InternalID)
+	}
+
+	return orderedIDs, true
+}
+
+func exampleOrder(size int) string {
+	example := ""
+	for i := size; i >= 1; i-- {
+		example += fmt.Sprint(i)
+	}
+
+	return example
+}
+
+func describeContender(c repo.Contender) string {
+	description := c.Name
+	if c.Detail != "" {
+		description += " - " + c.Detail
+	}
+
+	return fmt.Sprintf("%s (%.0f)", description, c.Ranking)
+}
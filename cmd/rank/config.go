@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+// Prints the Elo config as JSON, or replaces it with one read from a file
+func config(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+
+	filename := flags.String(
+		"set", "",
+		"JSON file to load the Elo config from. Fields not given keep "+
+			"their current values.",
+	)
+
+	flags.Parse(args)
+
+	config, err := repo.LoadEloConfig(db)
+	if err != nil {
+		return err
+	}
+
+	if *filename != "" {
+		input, err := ioutil.ReadFile(*filename)
+		if err != nil {
+			return err
+		}
+
+		if err = json.Unmarshal(input, &config); err != nil {
+			return err
+		}
+
+		if err = repo.SaveEloConfig(db, config); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(
+			os.Stderr,
+			"Config saved. Run 'rank replay' to apply it to existing rankings.",
+		)
+	}
+
+	output, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+	return nil
+}
+
+func replay(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+
+	entity := flags.String("entity", "track", "'track', 'album' or 'artist'")
+
+	flags.Parse(args)
+
+	return repo.ReplayComparisons(db, repo.Entity(*entity))
+}
+
+// Prints the grade scale as JSON, or replaces it with one read from a file
+func grades(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("grades", flag.ExitOnError)
+
+	filename := flags.String("set", "", "JSON file to load the grade scale from")
+
+	flags.Parse(args)
+
+	scale, err := repo.LoadGradeScale(db)
+	if err != nil {
+		return err
+	}
+
+	if *filename != "" {
+		input, err := ioutil.ReadFile(*filename)
+		if err != nil {
+			return err
+		}
+
+		scale = elo.GradeScale{}
+		if err = json.Unmarshal(input, &scale); err != nil {
+			return err
+		}
+
+		if err = repo.SaveGradeScale(db, scale); err != nil {
+			return err
+		}
+	}
+
+	output, err := json.MarshalIndent(scale, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(output))
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func dump(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ExitOnError)
+
+	output := flags.String("o", "", "File to write to (default: stdout)")
+
+	flags.Parse(args)
+
+	if *output == "" {
+		return repo.Dump(db, os.Stdout)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = repo.Dump(db, file); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func importDump(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+
+	merge := flags.Bool(
+		"merge", false,
+		"Merge into the existing library instead of restoring into an empty DB",
+	)
+	library := flags.String(
+		"library", "",
+		"Folder the music library is in on this machine, to link the "+
+			"dump's audio files under (default: the folder last scanned)",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rank import [-merge] [-library <folder>] <file>")
+		fmt.Fprintln(os.Stderr, "Use '-' to read from stdin")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		r = file
+	}
+
+	if *library != "" {
+		root, err := filepath.Abs(*library)
+		if err != nil {
+			return err
+		}
+		if err = repo.SaveLibraryRoot(db, root); err != nil {
+			return err
+		}
+	}
+
+	mode := repo.ImportRestore
+	if *merge {
+		mode = repo.ImportMerge
+	}
+
+	stats, err := repo.Import(db, r, mode)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "\tAdded\tExisting")
+
+	for _, entity := range []repo.Entity{
+		repo.EntityTrack, repo.EntityAlbum, repo.EntityArtist,
+	} {
+		fmt.Fprintf(
+			w,
+			"%ss\t%d\t%d\n",
+			entity,
+			stats.Added[entity],
+			stats.Matched[entity],
+		)
+	}
+
+	fmt.Fprintf(w, "profiles\t%d\t\n", stats.ProfilesAdded)
+	fmt.Fprintf(w, "audio files\t%d\t%d\n", stats.FilesAdded, stats.FilesSkipped)
+
+	fmt.Fprintf(
+		w,
+		"comparisons\t%d\t%d\n",
+		stats.ComparisonsAdded,
+		stats.ComparisonsSkipped,
+	)
+	fmt.Fprintf(
+		w,
+		"snapshots\t%d\t%d\n",
+		stats.SnapshotsAdded,
+		stats.SnapshotsSkipped,
+	)
+	fmt.Fprintf(
+		w,
+		"listens\t%d\t%d\n",
+		stats.ListensAdded,
+		stats.ListensSkipped,
+	)
+	fmt.Fprintf(
+		w,
+		"annotations\t%d\t%d\n",
+		stats.AnnotationsAdded,
+		stats.AnnotationsSkipped,
+	)
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	root, err := repo.LoadLibraryRoot(db)
+	if err != nil {
+		return err
+	}
+	if root == "" && stats.FilesSkipped > 0 {
+		fmt.Fprintln(
+			os.Stderr,
+			"No library folder is set, so no audio files were linked. "+
+				"Merge the dump again with -library, or scan the library.",
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/export"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func exportRankings(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+
+	format := flags.String("format", "csv", "'csv', 'json' or 'md'")
+	columns := flags.String(
+		"columns", strings.Join(export.DefaultColumns, ","),
+		"Comma-separated columns, from: "+strings.Join(export.ColumnNames(), ", "),
+	)
+	output := flags.String("o", "", "File to write to (default: stdout)")
+
+	q := rankingQueryFlags(flags)
+
+	flags.Parse(args)
+	q.Profile = profile.ID
+
+	page, err := repo.QueryRankings(db, *q)
+	if err != nil {
+		return err
+	}
+
+	rows := []export.Row{}
+	for i, t := range page.Tracks {
+		rows = append(rows, export.Row{Position: q.Offset + i + 1, Track: t})
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	err = export.Write(
+		w, export.Format(*format), strings.Split(*columns, ","), rows,
+	)
+	if err != nil {
+		return err
+	}
+
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		return file.Close()
+	}
+
+	return nil
+}
+
+// Flags for the filters, ordering and paging of a repo.RankingQuery
+func rankingQueryFlags(flags *flag.FlagSet) *repo.RankingQuery {
+	q := &repo.RankingQuery{}
+
+	flags.StringVar(&q.Artist, "artist", "", "Only tracks by this artist")
+	flags.StringVar(&q.Album, "album", "", "Only tracks on this album")
+	flags.StringVar(&q.Genre, "genre", "", "Only tracks in this genre")
+	flags.IntVar(&q.YearFrom, "year-from", 0, "Only tracks from this year on")
+	flags.IntVar(&q.YearTo, "year-to", 0, "Only tracks up to this year")
+	flags.IntVar(
+		&q.MinComparisons, "min-comparisons", 0,
+		"Only tracks compared at least this many times",
+	)
+	flags.Float64Var(&q.MinRating, "min-rating", 0, "Only tracks rated at least this")
+	flags.Float64Var(&q.MaxRating, "max-rating", 0, "Only tracks rated at most this")
+	flags.StringVar(
+		(*string)(&q.OrderBy), "order", string(repo.OrderByRanking),
+		"'ranking', 'title', 'year' or 'comparisons'",
+	)
+	flags.BoolVar(&q.Ascending, "asc", false, "Lowest first")
+	flags.IntVar(&q.Limit, "n", 0, "Number of tracks (0 = all)")
+	flags.IntVar(&q.Offset, "offset", 0, "Number of tracks to skip")
+
+	return q
+}
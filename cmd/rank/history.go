@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func snapshot(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+
+	daily := flags.Bool(
+		"daily", false, "Only take a snapshot if none taken yet today",
+	)
+	label := flags.String("label", "manual", "Label for the snapshot")
+
+	flags.Parse(args)
+
+	if *daily {
+		s, taken, err := repo.TakeDailySnapshot(db, profile.ID)
+		if err != nil {
+			return err
+		}
+
+		if !taken {
+			fmt.Println("Already have a snapshot for today")
+			return nil
+		}
+
+		fmt.Printf("Took snapshot %d\n", s.ID)
+		return nil
+	}
+
+	s, err := repo.TakeSnapshot(db, profile.ID, *label)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Took snapshot %d\n", s.ID)
+	return nil
+}
+
+func snapshots(db *sql.DB, profile repo.Profile, args []string) error {
+	snapshots, err := repo.Snapshots(db, profile.ID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tTaken at (UTC)\tLabel")
+	for _, s := range snapshots {
+		fmt.Fprintf(
+			w, "%d\t%s\t%s\n", s.ID, s.TakenAt.Format("2006-01-02 15:04"), s.Label,
+		)
+	}
+
+	return w.Flush()
+}
+
+func history(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+
+	trackID := flags.Int("track", 0, "Track ID")
+
+	flags.Parse(args)
+
+	points, err := repo.TrackHistory(db, profile.ID, *trackID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Taken at (UTC)\tPosition\tRanking")
+	for _, p := range points {
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%.1f\n",
+			p.Snapshot.TakenAt.Format("2006-01-02 15:04"),
+			p.Position,
+			p.Ranking,
+		)
+	}
+
+	return w.Flush()
+}
+
+func moved(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("moved", flag.ExitOnError)
+
+	from := flags.String(
+		"from", "", "Snapshot ID or date (YYYY-MM-DD) to compare from",
+	)
+	to := flags.String(
+		"to", "", "Snapshot ID or date (YYYY-MM-DD) to compare to "+
+			"(default latest)",
+	)
+	n := flags.Int("n", 10, "Number of risers and fallers to show")
+
+	flags.Parse(args)
+
+	if *from == "" {
+		return fmt.Errorf("-from is required")
+	}
+
+	fromID, err := resolveSnapshot(db, profile.ID, *from)
+	if err != nil {
+		return err
+	}
+
+	toID, err := resolveSnapshot(db, profile.ID, *to)
+	if err != nil {
+		return err
+	}
+
+	risers, fallers, err := repo.Movers(db, profile.ID, fromID, toID, *n)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	for _, section := range []struct {
+		heading   string
+		movements []repo.Movement
+	}{
+		{"Risers", risers},
+		{"Fallers", fallers},
+	} {
+		fmt.Fprintln(w, section.heading)
+		fmt.Fprintln(w, "Track\tArtist\tPosition\tRanking\tChange")
+
+		for _, m := range section.movements {
+			fmt.Fprintf(
+				w,
+				"%s\t%s\t%d -> %d\t%.1f -> %.1f\t%+.1f\n",
+				m.Title,
+				m.PrimaryArtist,
+				m.PositionBefore,
+				m.PositionAfter,
+				m.RankingBefore,
+				m.RankingAfter,
+				m.Change(),
+			)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
+
+// Accepts a snapshot ID, a date (giving the profile's last snapshot of
+// that day) or "" for its latest snapshot
+func resolveSnapshot(db *sql.DB, profile int, arg string) (int, error) {
+	if id, err := strconv.Atoi(arg); err == nil {
+		return id, nil
+	}
+
+	at := time.Now()
+
+	if arg != "" {
+		date, err := time.Parse("2006-01-02", arg)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not a snapshot ID or date", arg)
+		}
+
+		at = date.AddDate(0, 0, 1).Add(-time.Second)
+	}
+
+	s, err := repo.SnapshotAt(db, profile, at)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.ID, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/itunes"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Adds the tracks in an iTunes Library.xml that aren't in the library yet
+func importITunes(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("itunes", flag.ExitOnError)
+
+	mapPath := flags.String(
+		"map-path", "",
+		"Rewrite file paths starting with <from> to start with <to>, as "+
+			"<from>=<to>, e.g. when the library was exported on another machine",
+	)
+	noPriors := flags.Bool(
+		"no-priors", false,
+		"Don't seed rankings from iTunes star ratings and play counts",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(
+			os.Stderr,
+			"Usage: rank itunes [-map-path <from>=<to>] [-no-priors] <Library.xml>",
+		)
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	opts := itunes.Options{NoPriors: *noPriors}
+
+	if *mapPath != "" {
+		parts := strings.SplitN(*mapPath, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Invalid -map-path '%s'", *mapPath)
+		}
+
+		opts.FromPrefix, opts.ToPrefix = parts[0], parts[1]
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	library, err := itunes.ReadLibrary(file, opts)
+	if err != nil {
+		return err
+	}
+
+	tracks, err := tracksToSave(db, library.Tracks)
+	if err != nil {
+		return err
+	}
+
+	if err = repo.SaveTracks(db, tracks); err != nil {
+		return err
+	}
+
+	root, err := repo.LoadLibraryRoot(db)
+	if err != nil {
+		return err
+	}
+	if root == "" && library.MusicFolder != "" {
+		if err = repo.SaveLibraryRoot(db, library.MusicFolder); err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Tracks in library file\t%d\n", len(library.Tracks))
+	fmt.Fprintf(w, "Skipped (not local audio)\t%d\n", library.Skipped)
+	fmt.Fprintf(w, "Already imported\t%d\n", len(library.Tracks)-len(tracks))
+	fmt.Fprintf(w, "Added\t%d\n", len(tracks))
+
+	return w.Flush()
+}
+
+// Tracks without a MusicBrainz ID can't be matched up with ones already
+// saved, so would be added again on every import. Those whose files we
+// already have are left out.
+func tracksToSave(db *sql.DB, tracks []track.Track) ([]track.Track, error) {
+	known, err := repo.KnownFiles(db)
+	if err != nil {
+		return nil, err
+	}
+
+	toSave := []track.Track{}
+	for _, t := range tracks {
+		if t.MusicBrainzID != "" || len(t.Files) == 0 || !known[t.Files[0]] {
+			toSave = append(toSave, t)
+		}
+	}
+
+	return toSave, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func leaderboard(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("leaderboard", flag.ExitOnError)
+
+	by := flags.String("by", "album", "'album' or 'artist'")
+	orderBy := flags.String(
+		"order", string(repo.AggregateBayesian),
+		"'mean', 'median', 'top-k' or 'bayesian'",
+	)
+	topK := flags.Int("k", repo.DefaultTopK, "Number of tracks for top-k mean")
+	priorWeight := flags.Float64(
+		"prior-weight", repo.DefaultPriorWeight,
+		"Number of average tracks added to each entry for the Bayesian score",
+	)
+	minComparisons := flags.Int(
+		"min-comparisons", 1, "Ignore tracks with fewer comparisons",
+	)
+	primaryOnly := flags.Bool(
+		"primary-only", false, "Ignore secondary artists (artist only)",
+	)
+	limit := flags.Int("n", 20, "Number of entries to show (0 = all)")
+
+	flags.Parse(args)
+
+	opts := repo.LeaderboardOptions{
+		Profile:            profile.ID,
+		MinComparisons:     *minComparisons,
+		TopK:               *topK,
+		PriorWeight:        *priorWeight,
+		PrimaryArtistsOnly: *primaryOnly,
+		OrderBy:            repo.Aggregate(*orderBy),
+		Limit:              *limit,
+	}
+
+	var entries []repo.LeaderboardEntry
+	var heading string
+	var err error
+
+	switch *by {
+	case "album":
+		heading = "Album"
+		entries, err = repo.AlbumLeaderboard(db, opts)
+	case "artist":
+		heading = "Artist"
+		entries, err = repo.ArtistLeaderboard(db, opts)
+	default:
+		return fmt.Errorf("Unknown leaderboard '%s'", *by)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "#\t%s\tTracks\tMean\tMedian\tTop %d\tBayesian\n", heading, *topK)
+
+	for i, e := range entries {
+		fmt.Fprintf(
+			w,
+			"%d\t%s\t%d\t%.1f\t%.1f\t%.1f\t%.1f\n",
+			i+1,
+			e.Name,
+			e.RankedTracks,
+			e.Mean,
+			e.Median,
+			e.TopKMean,
+			e.BayesianScore,
+		)
+	}
+
+	return w.Flush()
+}
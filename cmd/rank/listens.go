@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/scrobble"
+)
+
+// Imports the profile's plays from a Last.fm or ListenBrainz export
+func listens(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("listens", flag.ExitOnError)
+
+	format := flags.String(
+		"format", "",
+		"lastfm (CSV) or listenbrainz (JSON) (default: from the file extension)",
+	)
+	noPriors := flags.Bool(
+		"no-priors", false,
+		"Don't seed rankings of unrated tracks from their play counts",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(
+			os.Stderr,
+			"Usage: rank listens [-format lastfm|listenbrainz] [-no-priors] <file>",
+		)
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *format == "" {
+		*format = "listenbrainz"
+		if strings.EqualFold(filepath.Ext(flags.Arg(0)), ".csv") {
+			*format = "lastfm"
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var read []scrobble.Listen
+
+	switch *format {
+	case "lastfm":
+		read, err = scrobble.ReadLastfmCSV(file)
+	case "listenbrainz":
+		read, err = scrobble.ReadListenBrainz(file)
+	default:
+		return fmt.Errorf("Unknown format '%s'", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	stats, err := repo.ImportListens(
+		db, profile.ID, read, *format, !*noPriors,
+	)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Listens\t%d\n", stats.Listens)
+	fmt.Fprintf(w, "Matched by MusicBrainz ID\t%d\n", stats.MatchedByMBID)
+	fmt.Fprintf(w, "Matched by artist and title\t%d\n", stats.MatchedByName)
+	fmt.Fprintf(w, "Unmatched\t%d\n", stats.Unmatched)
+	fmt.Fprintf(w, "New plays\t%d\n", stats.Added)
+	fmt.Fprintf(w, "Priors set\t%d\n", stats.Priors)
+
+	return w.Flush()
+}
@@ -0,0 +1,115 @@
+// Program to query and report on the rankings in the sqlite DB
+//
+// Usage:
+//     rank [-db <file>] [-user <name>] <command> [<args>]
+//
+// Rankings, match history, snapshots, ratings and listens are the given
+// user's, or the default profile's.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	_ "modernc.org/sqlite"
+)
+
+type command func(db *sql.DB, profile repo.Profile, args []string) error
+
+var commands = map[string]command{
+	"beets":       importBeets,
+	"compare":     compare,
+	"config":      config,
+	"consensus":   consensus,
+	"dump":        dump,
+	"export":      exportRankings,
+	"grades":      grades,
+	"history":     history,
+	"import":      importDump,
+	"itunes":      importITunes,
+	"leaderboard": leaderboard,
+	"listens":     listens,
+	"moved":       moved,
+	"mpd":         watchMPD,
+	"musicbrainz": identify,
+	"playlist":    makePlaylist,
+	"priors":      priors,
+	"profiles":    profiles,
+	"replay":      replay,
+	"search":      search,
+	"snapshot":    snapshot,
+	"snapshots":   snapshots,
+	"taste":       compareTaste,
+	"write-tags":  writeTags,
+}
+
+func init() {
+	log.SetFlags(log.Llongfile)
+}
+
+func main() {
+	dbFilename := flag.String("db", "ranked_music.sqlt", "sqlite DB file")
+	user := flag.String("user", "", "Profile to rank as (default: the default profile)")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	db, err := sql.Open(
+		"sqlite",
+		"file:"+*dbFilename+"?_pragma=foreign_keys(1)",
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	if _, err = repo.Migrate(db); err != nil {
+		log.Fatalln(err)
+	}
+
+	profile, err := repo.GetProfile(db, *user)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err = cmd(db, profile, flag.Args()[1:]); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: rank [-db <file>] [-user <name>] <command> [<args>]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "    "+name)
+	}
+
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'rank <command> -h' for command options")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/mpd"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+// Runs until killed, recording tracks MPD skips or plays through as
+// implicit comparisons against their neighbours in the queue.
+// Reconnects if MPD goes away.
+func watchMPD(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("mpd", flag.ExitOnError)
+
+	opts := mpd.DefaultOptions()
+	weights := repo.DefaultPlayWeights()
+
+	addr := flags.String("addr", "localhost:6600", "MPD address")
+	password := flags.String("password", "", "MPD password")
+	musicDir := flags.String(
+		"music-dir", "",
+		"MPD's music_directory (default: folder last scanned)",
+	)
+	flags.Float64Var(
+		&opts.SkipBefore, "skip-before", opts.SkipBefore,
+		"Fraction of a track played before moving on that counts as a skip",
+	)
+	flags.Float64Var(
+		&opts.PlayedAfter, "played-after", opts.PlayedAfter,
+		"Fraction of a track played that counts as playing it through",
+	)
+	flags.Float64Var(
+		&weights.Skipped, "skip-weight", weights.Skipped,
+		"K multiplier for a skipped track losing to its neighbours; 0 = off",
+	)
+	flags.Float64Var(
+		&weights.PlayedThrough, "play-weight", weights.PlayedThrough,
+		"K multiplier for a track played through beating its neighbours; 0 = off",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(
+			os.Stderr,
+			"Usage: rank mpd [-addr <host:port>] [-password <password>] "+
+				"[-music-dir <folder>] [<options>]",
+		)
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *musicDir == "" {
+		var err error
+		if *musicDir, err = repo.LoadLibraryRoot(db); err != nil {
+			return err
+		}
+	}
+	if *musicDir == "" {
+		return errors.New("No library folder has been scanned; give -music-dir")
+	}
+
+	if weights.Skipped < 0 || weights.PlayedThrough < 0 {
+		return errors.New("Weights cannot be negative")
+	}
+
+	watcher, err := mpd.NewWatcher(opts)
+	if err != nil {
+		return err
+	}
+
+	// Only connection errors are worth reconnecting after
+	var recordErr error
+
+	played := func(p mpd.Play) error {
+		var neighbours []string
+		for _, file := range p.Neighbours {
+			neighbours = append(neighbours, filepath.Join(*musicDir, file))
+		}
+
+		recorded, err := repo.RecordPlay(
+			db,
+			profile.ID,
+			filepath.Join(*musicDir, p.File),
+			neighbours,
+			p.Skipped,
+			weights,
+			mpd.Source,
+		)
+		if err != nil {
+			recordErr = err
+			return err
+		}
+
+		outcome := "played"
+		if p.Skipped {
+			outcome = "skipped"
+		}
+		fmt.Printf("%s\t%s\t%d comparisons\n", outcome, p.File, recorded)
+
+		return nil
+	}
+
+	for {
+		client, err := mpd.Dial(*addr, *password)
+		if err == nil {
+			err = watcher.Watch(client, played)
+			client.Close()
+		}
+
+		// e.g. a wrong password; retrying won't help
+		var mpdErr *mpd.Error
+		if recordErr != nil || errors.As(err, &mpdErr) {
+			return err
+		}
+
+		log.Println(err, "- reconnecting in 10s")
+		time.Sleep(10 * time.Second)
+	}
+}
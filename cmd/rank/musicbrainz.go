@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/musicbrainz"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/tags"
+)
+
+// Columns of the proposals file, which is reviewed by hand before being
+// applied. Only track_id and mbid are read back.
+var proposalColumns = []string{
+	"track_id", "score", "mbid",
+	"title", "artist", "album",
+	"mb_title", "mb_artist", "mb_release",
+}
+
+// Identifies tracks with no MusicBrainz ID from a local MusicBrainz dump,
+// in three steps: load the dump into a lookup store, write the proposed
+// matches to review, then apply the ones kept
+func identify(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("musicbrainz", flag.ExitOnError)
+
+	storePath := flags.String(
+		"store", "musicbrainz.sqlt", "sqlite file to keep MusicBrainz recordings in",
+	)
+	load := flags.String(
+		"load", "", "Add the recordings in this dump to the store",
+	)
+	format := flags.String(
+		"format", "",
+		"Format of the -load dump: json (one recording per line) or tsv "+
+			"(default from the file extension)",
+	)
+	minScore := flags.Float64(
+		"min-score", 0.7, "Only propose matches scoring at least this (0–1)",
+	)
+	apply := flags.String(
+		"apply", "", "Set MusicBrainz IDs from this reviewed proposals file",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, "  rank musicbrainz [-store <file>] -load <dump> [-format json|tsv]")
+		fmt.Fprintln(os.Stderr, "  rank musicbrainz [-store <file>] [-min-score <score>] > proposals.tsv")
+		fmt.Fprintln(os.Stderr, "  rank musicbrainz -apply proposals.tsv")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || (*load != "" && *apply != "") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *apply != "" {
+		return applyProposals(db, *apply)
+	}
+
+	store, err := musicbrainz.Open(*storePath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *load != "" {
+		return loadDump(store, *load, *format)
+	}
+
+	recordings, err := store.Recordings()
+	if err != nil {
+		return err
+	}
+	if recordings == 0 {
+		return fmt.Errorf("No recordings in %s; add some with -load", *storePath)
+	}
+
+	return proposeMatches(db, store, *minScore)
+}
+
+func loadDump(store *musicbrainz.Store, path string, format string) error {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".jsonl", ".ndjson":
+			format = "json"
+		case ".tsv", ".txt":
+			format = "tsv"
+		}
+	}
+
+	var read musicbrainz.Reader
+	switch format {
+	case "json":
+		read = musicbrainz.ReadJSON
+	case "tsv":
+		read = musicbrainz.ReadTSV
+	default:
+		return fmt.Errorf("Unknown dump format '%s'; give -format json or tsv", format)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	added, err := store.Load(bufio.NewReader(file), read)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	total, err := store.Recordings()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Added\t%d\n", added)
+	fmt.Fprintf(w, "In store\t%d\n", total)
+
+	return w.Flush()
+}
+
+// Writes the best match for each unidentified track as TSV on stdout
+func proposeMatches(db *sql.DB, store *musicbrainz.Store, minScore float64) error {
+	page, err := repo.QueryRankings(db, repo.RankingQuery{Unidentified: true})
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprintln(out, strings.Join(proposalColumns, "\t"))
+
+	proposed := 0
+
+	for _, t := range page.Tracks {
+		q := musicbrainz.Query{Title: t.Title, Artist: t.PrimaryArtist.Name}
+		if len(t.Albums) > 0 {
+			q.Album = t.Albums[0].Title
+		}
+
+		files, err := repo.TrackFiles(db, t.InternalID)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			// Unreadable lengths only make for weaker matches
+			q.Length, _ = tags.Duration(files[0])
+		}
+
+		matches, err := store.Match(q, 1)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 || matches[0].Score < minScore {
+			continue
+		}
+
+		m := matches[0]
+		fields := []string{
+			strconv.Itoa(t.InternalID),
+			strconv.FormatFloat(m.Score, 'f', 2, 64),
+			m.ID,
+			q.Title, q.Artist, q.Album,
+			m.Title, m.Artist, m.Release,
+		}
+		for i, field := range fields {
+			fields[i] = strings.ReplaceAll(field, "\t", " ")
+		}
+
+		fmt.Fprintln(out, strings.Join(fields, "\t"))
+		proposed++
+	}
+
+	if err = out.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"Proposed matches for %d of %d unidentified tracks\n",
+		proposed, len(page.Tracks),
+	)
+
+	return nil
+}
+
+// Sets the MusicBrainz IDs in a proposals file. Lines that can't be
+// applied, e.g. as the ID is already in use, are reported and skipped.
+func applyProposals(db *sql.DB, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return fmt.Errorf("%s: no header row", path)
+	}
+
+	columns := map[string]int{}
+	for i, name := range strings.Split(scanner.Text(), "\t") {
+		columns[name] = i
+	}
+
+	trackCol, ok1 := columns["track_id"]
+	mbidCol, ok2 := columns["mbid"]
+	if !ok1 || !ok2 {
+		return fmt.Errorf("%s: need track_id and mbid columns", path)
+	}
+
+	applied, failed := 0, 0
+
+	for line := 2; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		fields := strings.Split(scanner.Text(), "\t")
+		if trackCol >= len(fields) || mbidCol >= len(fields) {
+			return fmt.Errorf("%s:%d: missing columns", path, line)
+		}
+
+		trackID, err := strconv.Atoi(strings.TrimSpace(fields[trackCol]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid track ID '%s'", path, line, fields[trackCol])
+		}
+
+		err = repo.SetTrackMBID(db, trackID, strings.TrimSpace(fields[mbidCol]))
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("No track %d", trackID)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", path, line, err)
+			failed++
+			continue
+		}
+
+		applied++
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Applied\t%d\n", applied)
+	fmt.Fprintf(w, "Failed\t%d\n", failed)
+
+	return w.Flush()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/playlist"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func makePlaylist(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("playlist", flag.ExitOnError)
+
+	format := flags.String(
+		"format", "",
+		"'m3u8' or 'xspf' (default: from the -o extension, else m3u8)",
+	)
+	output := flags.String("o", "", "File to write to (default: stdout)")
+	title := flags.String("title", "", "Playlist title")
+	paths := flags.String(
+		"paths", "absolute",
+		"'absolute' or 'relative' (to the playlist's directory)",
+	)
+	mix := flags.Bool(
+		"mix", false,
+		"Pick -n matching tracks at random, favouring higher rated ones",
+	)
+	seed := flags.Int64("seed", 0, "Random seed for -mix (default: time)")
+
+	q := rankingQueryFlags(flags)
+
+	flags.Parse(args)
+	q.Profile = profile.ID
+
+	if *format == "" {
+		*format = string(playlist.FormatM3U8)
+		if strings.EqualFold(filepath.Ext(*output), ".xspf") {
+			*format = string(playlist.FormatXSPF)
+		}
+	}
+
+	opts := playlist.Options{Title: *title}
+
+	switch *paths {
+	case "absolute":
+	case "relative":
+		dir, err := filepath.Abs(filepath.Dir(*output))
+		if err != nil {
+			return err
+		}
+
+		opts.RelativeTo = dir
+	default:
+		return fmt.Errorf("Unknown paths '%s'", *paths)
+	}
+
+	n := q.Limit
+	if *mix {
+		q.Limit = 0
+	}
+
+	page, err := repo.QueryRankings(db, *q)
+	if err != nil {
+		return err
+	}
+
+	tracks := page.Tracks
+
+	if *mix {
+		config, err := repo.LoadEloConfig(db)
+		if err != nil {
+			return err
+		}
+
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+
+		tracks = playlist.Mix(
+			tracks, n, config.StartingRating, rand.New(rand.NewSource(*seed)),
+		)
+	}
+
+	entries := []playlist.Entry{}
+	missing := 0
+
+	for _, t := range tracks {
+		files, err := repo.TrackFiles(db, t.InternalID)
+		if err != nil {
+			return err
+		}
+
+		if len(files) == 0 {
+			missing++
+			continue
+		}
+
+		entries = append(entries, playlist.Entry{Track: t, Path: files[0]})
+	}
+
+	if missing > 0 {
+		fmt.Fprintf(
+			os.Stderr,
+			"Skipped %d tracks with no known file; rerun populate-db to find them\n",
+			missing,
+		)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	if err = playlist.Write(w, playlist.Format(*format), entries, opts); err != nil {
+		return err
+	}
+
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		return file.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+// Lists where track priors in the profile came from, or drops some of
+// them
+func priors(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("priors", flag.ExitOnError)
+
+	clear := flags.String(
+		"clear", "",
+		"Comma-separated sources to drop priors from and replay, or 'all'",
+	)
+
+	flags.Parse(args)
+
+	if *clear != "" {
+		var sources []string
+		if *clear != "all" {
+			sources = strings.Split(*clear, ",")
+		}
+
+		cleared, err := repo.ClearPriors(db, profile.ID, sources...)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Cleared %d priors and replayed track comparisons\n", cleared)
+		return nil
+	}
+
+	sources, err := repo.PriorSources(db, profile.ID)
+	if err != nil {
+		return err
+	}
+
+	if len(sources) == 0 {
+		fmt.Println("No tracks have priors")
+		return nil
+	}
+
+	names := []string{}
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Source\tTracks")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\n", name, sources[name])
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func profiles(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("profiles", flag.ExitOnError)
+
+	add := flags.String("add", "", "Name of a profile to add")
+	remove := flags.String(
+		"delete", "", "Name of a profile to delete, with its rankings and history",
+	)
+	password := flags.String(
+		"subsonic-password", "",
+		"Password Subsonic clients log in to the -user profile with; "+
+			"empty to stop them",
+	)
+
+	flags.Parse(args)
+
+	setPassword := false
+	flags.Visit(func(f *flag.Flag) {
+		setPassword = setPassword || f.Name == "subsonic-password"
+	})
+
+	if setPassword {
+		if err := repo.SetSubsonicPassword(db, profile.ID, *password); err != nil {
+			return err
+		}
+
+		fmt.Printf("Set the Subsonic password of profile '%s'\n", profile.Name)
+		return nil
+	}
+
+	if *add != "" {
+		p, err := repo.CreateProfile(db, *add)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Added profile %d, '%s'\n", p.ID, p.Name)
+		return nil
+	}
+
+	if *remove != "" {
+		p, err := repo.GetProfile(db, *remove)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.DeleteProfile(db, p.ID); err != nil {
+			return err
+		}
+
+		fmt.Printf("Deleted profile '%s'\n", p.Name)
+		return nil
+	}
+
+	profiles, err := repo.Profiles(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tName\tRanked tracks\tComparisons")
+	for _, p := range profiles {
+		stats, err := repo.GetLibraryStats(db, p.ID)
+		if err != nil {
+			return err
+		}
+
+		comparisons := 0
+		for _, n := range stats.Comparisons {
+			comparisons += n
+		}
+
+		fmt.Fprintf(
+			w, "%d\t%s\t%d\t%d\n", p.ID, p.Name, stats.RankedTracks, comparisons,
+		)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func search(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+
+	entity := flags.String(
+		"entity", "", "'track', 'album' or 'artist' (default: all)",
+	)
+	limit := flags.Int("n", 20, "Number of results to show (0 = all)")
+	rebuild := flags.Bool(
+		"rebuild", false, "Rebuild the search index before searching",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rank search [<flags>] <words>")
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if *rebuild {
+		if err := repo.RebuildSearchIndex(db); err != nil {
+			return err
+		}
+	}
+
+	if flags.NArg() == 0 {
+		if *rebuild {
+			return nil
+		}
+
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var only []repo.Entity
+	if *entity != "" {
+		only = append(only, repo.Entity(*entity))
+	}
+
+	results, err := repo.Search(
+		db, profile.ID, strings.Join(flags.Args(), " "), *limit, only...,
+	)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No matches")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Type\tID\tName\tBy\tRanking\tComparisons")
+
+	for _, r := range results {
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%s\t%s\t%.1f\t%d\n",
+			r.Entity,
+			r.InternalID,
+			r.Name,
+			r.Detail,
+			r.Ranking,
+			r.Comparisons,
+		)
+	}
+
+	return w.Flush()
+}
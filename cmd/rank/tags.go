@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/tags"
+)
+
+func writeTags(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("write-tags", flag.ExitOnError)
+
+	dryRun := flags.Bool(
+		"dry-run", false, "Show what would be written without changing any files",
+	)
+	backupDir := flags.String(
+		"backup-dir", "tag-backups",
+		"Folder to copy each file into before changing it. Files backed "+
+			"up by an earlier run keep that backup, of the file before "+
+			"any ratings were written.",
+	)
+	noBackup := flags.Bool("no-backup", false, "Don't back files up")
+	minComparisons := flags.Int(
+		"min-comparisons", 1, "Only rate tracks compared at least this many times",
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rank write-tags [<flags>]")
+		fmt.Fprintln(os.Stderr, "Writes 1–5 star ratings into the tags of tracks' audio files")
+		fmt.Fprintln(os.Stderr, "(MP3, FLAC and MP4). The starting rating is 3 stars and each")
+		fmt.Fprintf(
+			os.Stderr, "%d points above or below it a star more or less.\n",
+			elo.PriorStarPoints,
+		)
+		flags.PrintDefaults()
+	}
+
+	flags.Parse(args)
+
+	if *minComparisons < 1 {
+		*minComparisons = 1
+	}
+
+	opts := tags.Options{DryRun: *dryRun}
+
+	if !*noBackup {
+		opts.BackupDir = *backupDir
+
+		root, err := repo.LoadLibraryRoot(db)
+		if err != nil {
+			return err
+		}
+		opts.LibraryRoot = root
+	}
+
+	config, err := repo.LoadEloConfig(db)
+	if err != nil {
+		return err
+	}
+
+	page, err := repo.QueryRankings(
+		db,
+		repo.RankingQuery{Profile: profile.ID, MinComparisons: *minComparisons},
+	)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "Stars\tTitle\tFile\tResult")
+
+	failed := 0
+
+	for _, t := range page.Tracks {
+		stars := tags.Stars(t.Ranking, config.StartingRating)
+
+		files, err := repo.TrackFiles(db, t.InternalID)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range files {
+			result, err := tags.WriteRating(path, stars, opts)
+
+			status := "Written"
+			switch {
+			case err != nil:
+				status = err.Error()
+				failed++
+			case *dryRun:
+				status = "Would write " + result.Format
+			case result.BackedUpBefore:
+				status = "Written, original already backed up in " + result.Backup
+			case result.Backup != "":
+				status = "Written, backed up to " + result.Backup
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", stars, t.Title, path, status)
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be updated", failed)
+	}
+
+	return nil
+}
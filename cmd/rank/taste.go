@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nephila-nacrea/rank-my-music/repo"
+)
+
+func compareTaste(db *sql.DB, profile repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("taste", flag.ExitOnError)
+
+	with := flags.String(
+		"with", "", "User to compare with, listing where you disagree "+
+			"(default: compare every pair of users)",
+	)
+	minComparisons := flags.Int(
+		"min-comparisons", 1, "Ignore tracks with fewer comparisons",
+	)
+	n := flags.Int("n", 10, "Number of disagreements to show (0 = all)")
+
+	flags.Parse(args)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	if *with == "" {
+		profiles, err := repo.Profiles(db)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, "User\tUser\tTracks in common\tSpearman\tKendall")
+
+		for i, a := range profiles {
+			for _, b := range profiles[i+1:] {
+				match, err := repo.CompareTaste(db, a.ID, b.ID, *minComparisons)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(
+					w, "%s\t%s\t%d\t%.2f\t%.2f\n",
+					a.Name, b.Name, match.Common, match.Spearman, match.Kendall,
+				)
+			}
+		}
+
+		return w.Flush()
+	}
+
+	other, err := repo.GetProfile(db, *with)
+	if err != nil {
+		return err
+	}
+
+	match, err := repo.CompareTaste(db, profile.ID, other.ID, *minComparisons)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"%d tracks in common; Spearman %.2f, Kendall %.2f\n\n",
+		match.Common, match.Spearman, match.Kendall,
+	)
+
+	disagreements, err := repo.Disagreements(
+		db, profile.ID, other.ID, *minComparisons, *n,
+	)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Track\tArtist\t%s\t%s\n", profile.Name, other.Name)
+	for _, d := range disagreements {
+		fmt.Fprintf(
+			w, "%s\t%s\t%d (%.1f)\t%d (%.1f)\n",
+			d.Title, d.PrimaryArtist, d.PositionA, d.RankingA, d.PositionB,
+			d.RankingB,
+		)
+	}
+
+	return w.Flush()
+}
+
+func consensus(db *sql.DB, _ repo.Profile, args []string) error {
+	flags := flag.NewFlagSet("consensus", flag.ExitOnError)
+
+	users := flags.String(
+		"users", "", "Comma-separated users to combine (default: everyone)",
+	)
+	method := flags.String(
+		"method", string(repo.ConsensusBorda),
+		"'borda', 'kemeny' (both only use tracks everyone has ranked) or "+
+			"'bradley-terry' (pools everyone's comparisons)",
+	)
+	minComparisons := flags.Int(
+		"min-comparisons", 1, "Ignore tracks with fewer comparisons",
+	)
+	limit := flags.Int("n", 20, "Number of tracks to show (0 = all)")
+
+	flags.Parse(args)
+
+	opts := repo.ConsensusOptions{
+		Method:         repo.ConsensusMethod(*method),
+		MinComparisons: *minComparisons,
+		Limit:          *limit,
+	}
+
+	if *users != "" {
+		for _, name := range strings.Split(*users, ",") {
+			p, err := repo.GetProfile(db, strings.TrimSpace(name))
+			if err != nil {
+				return err
+			}
+
+			opts.Profiles = append(opts.Profiles, p.ID)
+		}
+	}
+
+	entries, err := repo.ConsensusRanking(db, opts)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "#\tTrack\tArtist\tScore\tRanked by")
+	for i, e := range entries {
+		fmt.Fprintf(
+			w, "%d\t%s\t%s\t%.1f\t%d\n",
+			i+1, e.Title, e.PrimaryArtist, e.Score, e.Profiles,
+		)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,95 @@
+// Program to serve the rankings in the sqlite DB as a JSON API, with a
+// web page for voting at /. With a Subsonic password, Subsonic clients
+// can browse, play, rate and star tracks under /rest/.
+//
+// Usage:
+//     server [-db <file>] [-addr <host:port>] [-library <folder>]
+//            [-start middle|<seconds>]
+//            [-subsonic-user <name>] [-subsonic-password <password>]
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nephila-nacrea/rank-my-music/api"
+	"github.com/nephila-nacrea/rank-my-music/repo"
+	"github.com/nephila-nacrea/rank-my-music/web"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+}
+
+func main() {
+	dbFilename := flag.String("db", "ranked_music.sqlt", "sqlite DB file")
+	addr := flag.String("addr", "localhost:8080", "Address to listen on")
+	library := flag.String(
+		"library", "",
+		"Only serve audio files under this folder (default: folder last scanned)",
+	)
+
+	start := flag.String(
+		"start", "",
+		"Where audio previews start: 'middle' or a number of seconds "+
+			"(default: the beginning)",
+	)
+
+	subsonicUser := flag.String(
+		"subsonic-user", "admin",
+		"Subsonic user name for the default profile; other profiles log in "+
+			"with their own names and passwords, set with 'rank profiles'",
+	)
+	subsonicPassword := flag.String(
+		"subsonic-password", "",
+		"Subsonic password for -subsonic-user (default: Subsonic API off)",
+	)
+
+	flag.Parse()
+
+	if *start != "" && *start != "middle" {
+		if _, err := strconv.ParseFloat(*start, 64); err != nil {
+			log.Fatalf("-start must be 'middle' or a number of seconds")
+		}
+	}
+
+	db, err := sql.Open(
+		"sqlite",
+		"file:"+*dbFilename+"?_pragma=foreign_keys(1)",
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	// SQLite allows one writer at a time; serialise rather than fail
+	// with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err = repo.Migrate(db); err != nil {
+		log.Fatalln(err)
+	}
+
+	apiServer := api.New(db)
+	apiServer.LibraryRoot = *library
+	apiServer.AudioStart = *start
+	apiServer.SubsonicUser = *subsonicUser
+	apiServer.SubsonicPassword = *subsonicPassword
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", apiServer)
+	if *subsonicPassword != "" {
+		mux.Handle("/rest/", apiServer.Subsonic())
+	}
+	mux.Handle("/", web.Handler())
+
+	log.Printf("Listening on http://%s/\n", *addr)
+
+	log.Fatalln(http.ListenAndServe(*addr, mux))
+}
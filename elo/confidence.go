@@ -0,0 +1,47 @@
+package elo
+
+import "math"
+
+// Number of comparisons at which Confidence reaches 0.5
+const ConfidenceComparisons = 10
+
+// Rating deviation before any comparisons, as in Glicko
+const InitialDeviation = 350
+
+// Deviations either side of a rating covered by its 95% interval
+const intervalDeviations = 1.96
+
+// Converts rating differences to the natural log of the odds
+var q = math.Ln10 / 400
+
+// Confidence is a rough measure, from 0 to 1, of how settled a rating is
+// after the given number of comparisons: 0 for none, 0.5 at
+// ConfidenceComparisons, approaching 1 as comparisons accumulate
+func Confidence(comparisons int) float64 {
+	if comparisons <= 0 {
+		return 0
+	}
+
+	return float64(comparisons) / float64(comparisons+ConfidenceComparisons)
+}
+
+// Deviation estimates the standard error of a rating, as Glicko does,
+// from the expected score of each game behind it. It starts at
+// InitialDeviation and shrinks with every game, most for evenly matched
+// ones, as a foregone result says little about either player.
+func Deviation(expectedScores []float64) float64 {
+	information := 1.0 / (InitialDeviation * InitialDeviation)
+
+	for _, e := range expectedScores {
+		information += q * q * e * (1 - e)
+	}
+
+	return 1 / math.Sqrt(information)
+}
+
+// Interval is the 95% confidence interval of a rating with the given
+// deviation
+func Interval(rating float64, deviation float64) (float64, float64) {
+	return rating - intervalDeviations*deviation,
+		rating + intervalDeviations*deviation
+}
@@ -0,0 +1,127 @@
+package elo
+
+import (
+	"errors"
+	"math"
+)
+
+const DefaultStartingRating = 1000
+
+// Config controls how ratings move. The zero value is not useful; start
+// from DefaultConfig.
+type Config struct {
+	StartingRating float64 `json:"starting_rating"`
+
+	// K used for the first ProvisionalComparisons comparisons, so new
+	// entries find their level quickly
+	ProvisionalK           float64 `json:"provisional_k"`
+	ProvisionalComparisons int     `json:"provisional_comparisons"`
+
+	// After the provisional period K decays from K towards MinK as
+	// comparisons accumulate:
+	//     K / (1 + KDecay * comparisons since provisional period)
+	// KDecay = 0 keeps K constant; MinK = 0 lets it decay without limit.
+	K      float64 `json:"k"`
+	KDecay float64 `json:"k_decay"`
+	MinK   float64 `json:"min_k"`
+
+	// Well-established favourites move more slowly. Ratings at or above
+	// HighRating use at most HighRatingK. HighRatingK = 0 disables this.
+	HighRating  float64 `json:"high_rating"`
+	HighRatingK float64 `json:"high_rating_k"`
+
+	// Ratings are clamped to [Floor, Ceiling]. 0 = no floor/ceiling.
+	Floor   float64 `json:"floor"`
+	Ceiling float64 `json:"ceiling"`
+}
+
+// DefaultConfig is plain Elo with a constant K, i.e. what
+// CalculateNewRankings does
+func DefaultConfig() Config {
+	return Config{
+		StartingRating: DefaultStartingRating,
+		ProvisionalK:   K,
+		K:              K,
+	}
+}
+
+// KFactor returns the K to use for a player with the given number of
+// previous comparisons and current rating
+func (c Config) KFactor(comparisons int, rating float64) float64 {
+	if comparisons < c.ProvisionalComparisons {
+		return c.ProvisionalK
+	}
+
+	k := c.K / (1 + c.KDecay*float64(comparisons-c.ProvisionalComparisons))
+
+	if c.HighRatingK > 0 && rating >= c.HighRating {
+		k = math.Min(k, c.HighRatingK)
+	}
+
+	return math.Max(k, c.MinK)
+}
+
+// CalculateNewRankings is CalculateNewRankings with this config's
+// K-factor schedule and limits. Each player's K depends on their own
+// comparison count and rating.
+func (c Config) CalculateNewRankings(eloA Elo, eloB Elo) (float64, float64) {
+	return c.CalculateNewRankingsWithMultiplier(eloA, eloB, 1)
+}
+
+// CalculateNewRankingsWithMultiplier scales both players' K by
+// multiplier, e.g. for a margin of victory. See Outcome.
+func (c Config) CalculateNewRankingsWithMultiplier(
+	eloA Elo, eloB Elo, multiplier float64,
+) (float64, float64) {
+	newRankA := eloA.CurrentRanking +
+		multiplier*c.KFactor(eloA.Comparisons, eloA.CurrentRanking)*
+			(eloA.Score-ExpectedScore(
+				eloA.CurrentRanking, eloB.CurrentRanking,
+			))
+
+	newRankB := eloB.CurrentRanking +
+		multiplier*c.KFactor(eloB.Comparisons, eloB.CurrentRanking)*
+			(eloB.Score-ExpectedScore(
+				eloB.CurrentRanking, eloA.CurrentRanking,
+			))
+
+	return c.Clamp(newRankA), c.Clamp(newRankB)
+}
+
+// Clamp limits a rating to the configured floor and ceiling
+func (c Config) Clamp(rating float64) float64 {
+	if c.Floor != 0 && rating < c.Floor {
+		return c.Floor
+	}
+	if c.Ceiling != 0 && rating > c.Ceiling {
+		return c.Ceiling
+	}
+
+	return rating
+}
+
+func (c Config) Validate() error {
+	if c.K <= 0 || c.ProvisionalK <= 0 {
+		return errors.New("K must be positive")
+	}
+	if c.MinK < 0 || c.HighRatingK < 0 || c.KDecay < 0 {
+		return errors.New("MinK, HighRatingK and KDecay cannot be negative")
+	}
+	if c.MinK > c.K {
+		return errors.New("MinK cannot be above K")
+	}
+	if c.HighRatingK > 0 && c.HighRatingK < c.MinK {
+		return errors.New("HighRatingK cannot be below MinK")
+	}
+	if c.ProvisionalComparisons < 0 {
+		return errors.New("ProvisionalComparisons cannot be negative")
+	}
+	if c.Floor != 0 && c.Ceiling != 0 && c.Floor >= c.Ceiling {
+		return errors.New("Floor must be below Ceiling")
+	}
+	if c.Clamp(c.StartingRating) != c.StartingRating {
+		return errors.New("StartingRating must be between Floor and Ceiling")
+	}
+
+	return nil
+}
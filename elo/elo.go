@@ -9,9 +9,13 @@ const K = 32
 
 type Elo struct {
 	CurrentRanking float64
-	Score          float64 // 0 = loss, 0.5 = draw, 1 = win
+	Score          float64 // 0 = loss, 0.5 = draw, 1 = win. See also Outcome.
+
+	// Previous comparisons. Only used by Config.CalculateNewRankings.
+	Comparisons int
 }
 
+// CalculateNewRankings uses a constant K. See Config for more control.
 func CalculateNewRankings(eloA Elo, eloB Elo) (float64, float64) {
 	newRankA := eloA.CurrentRanking +
 		K*(eloA.Score-ExpectedScore(
@@ -0,0 +1,335 @@
+package elo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDefaultConfigMatchesCalculateNewRankings(t *testing.T) {
+	a := Elo{CurrentRanking: 1200, Score: 0, Comparisons: 3}
+	b := Elo{CurrentRanking: 1000, Score: 1, Comparisons: 100}
+
+	expectedA, expectedB := CalculateNewRankings(a, b)
+	gotA, gotB := DefaultConfig().CalculateNewRankings(a, b)
+
+	if expectedA != gotA || expectedB != gotB {
+		t.Errorf(
+			"Expected (%v, %v), got (%v, %v)",
+			expectedA, expectedB, gotA, gotB,
+		)
+	}
+}
+
+func TestKFactor(t *testing.T) {
+	c := Config{
+		ProvisionalK:           64,
+		ProvisionalComparisons: 5,
+		K:                      32,
+		KDecay:                 0.1,
+		MinK:                   10,
+		HighRating:             1500,
+		HighRatingK:            12,
+	}
+
+	tests := []struct {
+		name        string
+		comparisons int
+		rating      float64
+		expected    float64
+	}{
+		{"Provisional", 0, 1000, 64},
+		{"Last provisional", 4, 1000, 64},
+		{"First established", 5, 1000, 32},
+		{"Decayed", 15, 1000, 16},
+		{"Decayed to minimum", 1000, 1000, 10},
+		{"High rating", 5, 1500, 12},
+		{"Provisional ignores high rating", 0, 2000, 64},
+	}
+
+	for _, test := range tests {
+		if got := c.KFactor(test.comparisons, test.rating); got != test.expected {
+			t.Errorf("%s: expected K %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestConfigCalculateNewRankings(t *testing.T) {
+	c := DefaultConfig()
+	c.ProvisionalK = 64
+	c.ProvisionalComparisons = 1
+
+	t.Log("Each side uses its own K")
+
+	newA, newB := c.CalculateNewRankings(
+		Elo{CurrentRanking: 1000, Score: 1, Comparisons: 0},
+		Elo{CurrentRanking: 1000, Score: 0, Comparisons: 1},
+	)
+	if newA != 1032 || newB != 984 {
+		t.Errorf("Expected (1032, 984), got (%v, %v)", newA, newB)
+	}
+
+	t.Log("Floor and ceiling")
+
+	c.Floor = 990
+	c.Ceiling = 1020
+
+	newA, newB = c.CalculateNewRankings(
+		Elo{CurrentRanking: 1000, Score: 1, Comparisons: 0},
+		Elo{CurrentRanking: 1000, Score: 0, Comparisons: 1},
+	)
+	if newA != 1020 || newB != 990 {
+		t.Errorf("Expected (1020, 990), got (%v, %v)", newA, newB)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Log("Lowering K and adding decay takes effect from the defaults")
+
+	c := DefaultConfig()
+	c.K = 16
+	c.KDecay = 1
+
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.KFactor(1, 1000); got != 8 {
+		t.Errorf("Expected K 8, got %v", got)
+	}
+
+	for name, invalid := range map[string]Config{
+		"MinK above K": {
+			StartingRating: 1000, ProvisionalK: 32, K: 16, MinK: 20,
+		},
+		"HighRatingK below MinK": {
+			StartingRating: 1000, ProvisionalK: 32, K: 32, MinK: 10,
+			HighRating: 1500, HighRatingK: 5,
+		},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestExpectedScore(t *testing.T) {
+	if got := ExpectedScore(1000, 1000); got != 0.5 {
+		t.Errorf("Expected 0.5 for equal ratings, got %v", got)
+	}
+
+	// 400 points = 10:1 odds
+	if got := ExpectedScore(1400, 1000); math.Abs(got-10.0/11) > 1e-12 {
+		t.Errorf("Expected 10/11, got %v", got)
+	}
+}
+
+func TestGradeScale(t *testing.T) {
+	g := DefaultGradeScale()
+
+	if err := g.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Grades")
+
+	for i, expected := range []Outcome{
+		{0, 1.5}, {0, 1}, {0.5, 1}, {1, 1}, {1, 1.5},
+	} {
+		got, err := g.Grade(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("Grade %d: expected %+v, got %+v", i, expected, got)
+		}
+	}
+
+	if _, err := g.Grade(5); err == nil {
+		t.Error("Expected error for grade out of range")
+	}
+
+	t.Log("Slider positions match grades and interpolate between them")
+
+	for _, test := range []struct {
+		preference float64
+		expected   Outcome
+	}{
+		{0, Outcome{0, 1.5}},
+		{0.25, Outcome{0, 1}},
+		{0.375, Outcome{0.25, 1}},
+		{0.875, Outcome{1, 1.25}},
+		{1, Outcome{1, 1.5}},
+	} {
+		got, err := g.Preference(test.preference)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.expected {
+			t.Errorf(
+				"Preference %v: expected %+v, got %+v",
+				test.preference, test.expected, got,
+			)
+		}
+	}
+
+	if _, err := g.Preference(1.1); err == nil {
+		t.Error("Expected error for preference out of range")
+	}
+
+	t.Log("Invalid scales")
+
+	for _, invalid := range []GradeScale{
+		{Points: []Outcome{{1, 1}}},
+		{Points: []Outcome{{0, 1}, {1.5, 1}}},
+		{Points: []Outcome{{0, 1}, {1, 0}}},
+		{Points: []Outcome{{1, 1}, {0, 1}}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestMultiplier(t *testing.T) {
+	newA, newB := DefaultConfig().CalculateNewRankingsWithMultiplier(
+		Elo{CurrentRanking: 1000, Score: 1},
+		Elo{CurrentRanking: 1000, Score: 0},
+		1.5,
+	)
+	if newA != 1024 || newB != 976 {
+		t.Errorf("Expected (1024, 976), got (%v, %v)", newA, newB)
+	}
+}
+
+func TestCalculatePlacedRankings(t *testing.T) {
+	c := DefaultConfig()
+
+	t.Log("Two players is plain Elo")
+
+	players := []Elo{
+		{CurrentRanking: 1100, Comparisons: 3},
+		{CurrentRanking: 1000, Comparisons: 7},
+	}
+
+	got, err := c.CalculatePlacedRankings(players, []int{2, 1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	players[0].Score = 0
+	players[1].Score = 1
+	expectedA, expectedB := c.CalculateNewRankings(players[0], players[1])
+
+	if got[0] != expectedA || got[1] != expectedB {
+		t.Errorf(
+			"Expected (%v, %v), got (%v, %v)",
+			expectedA, expectedB, got[0], got[1],
+		)
+	}
+
+	t.Log("Equal ratings, three players")
+
+	got, err = c.CalculatePlacedRankings(
+		[]Elo{{CurrentRanking: 1000}, {CurrentRanking: 1000}, {CurrentRanking: 1000}},
+		[]int{3, 1, 2},
+		1,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Winner: K * ((1 - 0.5) + (1 - 0.5)) / 2 = 16
+	expected := []float64{984, 1016, 1000}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, got)
+			break
+		}
+	}
+
+	t.Log("Shared places are draws")
+
+	got, err = c.CalculatePlacedRankings(
+		[]Elo{{CurrentRanking: 1000}, {CurrentRanking: 1000}, {CurrentRanking: 1000}},
+		[]int{1, 1, 3},
+		1,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = []float64{1008, 1008, 984}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, got)
+			break
+		}
+	}
+
+	t.Log("Mismatched input")
+
+	if _, err = c.CalculatePlacedRankings(players, []int{1}, 1); err == nil {
+		t.Error("Expected error for mismatched places")
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	for comparisons, expected := range map[int]float64{
+		0:                         0,
+		ConfidenceComparisons:     0.5,
+		3 * ConfidenceComparisons: 0.75,
+	} {
+		if got := Confidence(comparisons); got != expected {
+			t.Errorf("%d comparisons: expected %v, got %v", comparisons, expected, got)
+		}
+	}
+}
+
+func TestDeviation(t *testing.T) {
+	if got := Deviation(nil); got != InitialDeviation {
+		t.Errorf("Expected %v with no games, got %v", InitialDeviation, got)
+	}
+
+	even := make([]float64, 100)
+	lopsided := make([]float64, 100)
+	for i := range even {
+		even[i] = 0.5
+		lopsided[i] = 0.99
+	}
+
+	// 1 / sqrt(1/350^2 + 100 * (ln 10/400)^2 / 4)
+	if got := Deviation(even); math.Abs(got-34.57) > 0.01 {
+		t.Errorf("Expected about 34.57 after 100 even games, got %v", got)
+	}
+	if Deviation(lopsided) <= Deviation(even) {
+		t.Error("Expected lopsided games to say less than even ones")
+	}
+
+	low, high := Interval(1000, 50)
+	if low != 902 || high != 1098 {
+		t.Errorf("Expected 902-1098, got %v-%v", low, high)
+	}
+}
+
+func TestPriorRating(t *testing.T) {
+	for _, test := range []struct {
+		stars    float64
+		plays    int
+		expected float64
+	}{
+		{0, 0, 1000},
+		{3, 0, 1000},
+		{5, 100, 1200},
+		{1, 0, 800},
+		{2.5, 0, 950},
+		{0, 1, 1025},
+		{0, 3, 1050},
+		{0, 1000, 1100},
+	} {
+		if got := PriorRating(1000, test.stars, test.plays); got != test.expected {
+			t.Errorf(
+				"%v stars, %d plays: expected %v, got %v",
+				test.stars, test.plays, test.expected, got,
+			)
+		}
+	}
+}
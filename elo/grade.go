@@ -0,0 +1,97 @@
+package elo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Outcome is the result of a graded comparison from A's point of view
+type Outcome struct {
+	// 0 = loss, 0.5 = draw, 1 = win; fractions allowed
+	ScoreA float64 `json:"score_a"`
+
+	// Scales K for both sides, so a decisive preference can move
+	// ratings further than a marginal one (margin of victory)
+	Multiplier float64 `json:"multiplier"`
+}
+
+// GradeScale maps how strongly A is preferred to an Outcome. Points run
+// from the strongest preference for B to the strongest preference for A,
+// evenly spaced over [0, 1], so a 0-1 slider and an n-point scale are
+// interchangeable: grade i of n is slider position i/(n-1).
+//
+// Fractional scores (e.g. 0.75 for a weak win) and multipliers can be
+// mixed freely.
+type GradeScale struct {
+	Points []Outcome `json:"points"`
+}
+
+// DefaultGradeScale is a 5-point scale where a weak preference counts
+// as a normal win and a strong one moves ratings half as much again
+func DefaultGradeScale() GradeScale {
+	return GradeScale{
+		Points: []Outcome{
+			{ScoreA: 0, Multiplier: 1.5}, // Strongly prefer B
+			{ScoreA: 0, Multiplier: 1},   // Prefer B
+			{ScoreA: 0.5, Multiplier: 1}, // No preference
+			{ScoreA: 1, Multiplier: 1},   // Prefer A
+			{ScoreA: 1, Multiplier: 1.5}, // Strongly prefer A
+		},
+	}
+}
+
+func (g GradeScale) Validate() error {
+	if len(g.Points) < 2 {
+		return errors.New("Grade scale needs at least two points")
+	}
+
+	for i, p := range g.Points {
+		if p.ScoreA < 0 || p.ScoreA > 1 {
+			return fmt.Errorf("Grade %d: score %v out of range", i, p.ScoreA)
+		}
+		if p.Multiplier <= 0 {
+			return fmt.Errorf("Grade %d: multiplier must be positive", i)
+		}
+		if i > 0 && p.ScoreA < g.Points[i-1].ScoreA {
+			return fmt.Errorf("Grade %d: scores must not decrease", i)
+		}
+	}
+
+	return nil
+}
+
+// Grade returns the outcome for grade i (0 = strongest preference for B)
+func (g GradeScale) Grade(i int) (Outcome, error) {
+	if i < 0 || i >= len(g.Points) {
+		return Outcome{}, fmt.Errorf(
+			"Grade %d out of range 0-%d", i, len(g.Points)-1,
+		)
+	}
+
+	return g.Points[i], nil
+}
+
+// Preference returns the outcome for a slider position p in [0, 1], where
+// 0 = strongest preference for B and 1 = strongest preference for A.
+// Positions between points are interpolated linearly.
+func (g GradeScale) Preference(p float64) (Outcome, error) {
+	if p < 0 || p > 1 || math.IsNaN(p) {
+		return Outcome{}, fmt.Errorf("Preference %v out of range", p)
+	}
+
+	position := p * float64(len(g.Points)-1)
+
+	lower := int(math.Floor(position))
+	if lower == len(g.Points)-1 {
+		return g.Points[lower], nil
+	}
+
+	fraction := position - float64(lower)
+	from, to := g.Points[lower], g.Points[lower+1]
+
+	return Outcome{
+		ScoreA:     from.ScoreA + fraction*(to.ScoreA-from.ScoreA),
+		Multiplier: from.Multiplier + fraction*(to.Multiplier-from.Multiplier),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package elo
+
+import "fmt"
+
+// CalculatePlacedRankings updates the ratings of several players put in
+// order at once, e.g. "rank these four songs". places[i] is players[i]'s
+// place, 1 = best; equal places are draws.
+//
+// The ordering is decomposed into every pairwise result (each player
+// beats everyone placed below them), all scored against the ratings from
+// before the update. Each player's K is divided by the number of
+// opponents, so one ordering moves a rating about as far as a single
+// comparison would. Players' Score fields are ignored.
+func (c Config) CalculatePlacedRankings(
+	players []Elo, places []int, multiplier float64,
+) ([]float64, error) {
+	if len(players) < 2 {
+		return nil, fmt.Errorf("Need at least 2 players, got %d", len(players))
+	}
+	if len(places) != len(players) {
+		return nil, fmt.Errorf(
+			"Got %d places for %d players", len(places), len(players),
+		)
+	}
+
+	opponents := float64(len(players) - 1)
+	newRankings := make([]float64, len(players))
+
+	for i, player := range players {
+		k := multiplier * c.KFactor(player.Comparisons, player.CurrentRanking)
+
+		var change float64
+		for j, opponent := range players {
+			if i == j {
+				continue
+			}
+
+			change += PairwiseScore(places[i], places[j]) - ExpectedScore(
+				player.CurrentRanking, opponent.CurrentRanking,
+			)
+		}
+
+		newRankings[i] = c.Clamp(player.CurrentRanking + k*change/opponents)
+	}
+
+	return newRankings, nil
+}
+
+// PairwiseScore is the score of the player in placeSelf against the
+// player in placeOpponent (1 = best place)
+func PairwiseScore(placeSelf int, placeOpponent int) float64 {
+	switch {
+	case placeSelf < placeOpponent:
+		return 1
+	case placeSelf > placeOpponent:
+		return 0
+	}
+
+	return 0.5
+}
@@ -0,0 +1,27 @@
+package elo
+
+import "math"
+
+// Rating points per star above or below 3 stars
+const PriorStarPoints = 100
+
+// Most rating points a play count can add. Plays say less than stars, so
+// they count for less.
+const PriorPlayPoints = 100
+
+// PriorRating is the starting rating for a track given what its tags
+// say: a star rating from 0 to 5 (0 = not rated) and a play count. A
+// 3-star track starts at start, with each star either side worth
+// PriorStarPoints. Without stars, plays add 25 points per doubling, up
+// to PriorPlayPoints.
+func PriorRating(start float64, stars float64, plays int) float64 {
+	if stars > 0 {
+		return start + (math.Min(stars, 5)-3)*PriorStarPoints
+	}
+
+	if plays > 0 {
+		return start + math.Min(PriorPlayPoints, 25*math.Log2(1+float64(plays)))
+	}
+
+	return start
+}
@@ -0,0 +1,384 @@
+// Package export writes ranked tracks out as CSV, JSON or Markdown
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+type Format string
+
+const (
+	FormatCSV      Format = "csv"
+	FormatJSON     Format = "json"
+	FormatMarkdown Format = "md"
+)
+
+// Row is one exported track. Position is its place in the export, from 1.
+type Row struct {
+	Position int
+	Track    track.Track
+}
+
+type column struct {
+	// Also the JSON key
+	name   string
+	header string
+
+	// JSON value; formatted as text for CSV and Markdown
+	value func(r Row) interface{}
+	text  func(r Row) string
+}
+
+var columns = map[string]column{
+	"position": {
+		header: "#",
+		value:  func(r Row) interface{} { return r.Position },
+	},
+	"id": {
+		header: "ID",
+		value:  func(r Row) interface{} { return r.Track.InternalID },
+	},
+	"mbid": {
+		header: "MusicBrainz ID",
+		value:  func(r Row) interface{} { return r.Track.MusicBrainzID },
+	},
+	"title": {
+		header: "Title",
+		value:  func(r Row) interface{} { return r.Track.Title },
+	},
+	"artist": {
+		header: "Artist",
+		value:  func(r Row) interface{} { return r.Track.PrimaryArtist.Name },
+	},
+	"artists": {
+		header: "Artists",
+		value:  func(r Row) interface{} { return artistNames(r.Track) },
+		text: func(r Row) string {
+			return strings.Join(artistNames(r.Track), "; ")
+		},
+	},
+	"artist_mbids": {
+		header: "Artist MusicBrainz IDs",
+		value:  func(r Row) interface{} { return artistMBIDs(r.Track) },
+		text: func(r Row) string {
+			return strings.Join(artistMBIDs(r.Track), "; ")
+		},
+	},
+	"albums": {
+		header: "Albums",
+		value:  func(r Row) interface{} { return albumTitles(r.Track) },
+		text: func(r Row) string {
+			return strings.Join(albumTitles(r.Track), "; ")
+		},
+	},
+	"album_mbids": {
+		header: "Album MusicBrainz IDs",
+		value:  func(r Row) interface{} { return albumMBIDs(r.Track) },
+		text: func(r Row) string {
+			return strings.Join(albumMBIDs(r.Track), "; ")
+		},
+	},
+	"genre": {
+		header: "Genre",
+		value:  func(r Row) interface{} { return r.Track.Genre },
+	},
+	"year": {
+		header: "Year",
+		value:  func(r Row) interface{} { return r.Track.Year },
+		text: func(r Row) string {
+			if r.Track.Year == 0 {
+				return ""
+			}
+			return strconv.Itoa(r.Track.Year)
+		},
+	},
+	"rating": {
+		header: "Rating",
+		value:  func(r Row) interface{} { return r.Track.Ranking },
+		text: func(r Row) string {
+			return strconv.FormatFloat(r.Track.Ranking, 'f', 1, 64)
+		},
+	},
+	"comparisons": {
+		header: "Comparisons",
+		value:  func(r Row) interface{} { return r.Track.Comparisons },
+	},
+	"wins": {
+		header: "Wins",
+		value:  func(r Row) interface{} { return r.Track.Wins },
+	},
+	"losses": {
+		header: "Losses",
+		value:  func(r Row) interface{} { return r.Track.Losses },
+	},
+	"draws": {
+		header: "Draws",
+		value:  func(r Row) interface{} { return r.Track.Draws },
+	},
+	"deviation": {
+		header: "Deviation",
+		value:  func(r Row) interface{} { return r.Track.Deviation },
+		text: func(r Row) string {
+			return strconv.FormatFloat(r.Track.Deviation, 'f', 1, 64)
+		},
+	},
+	"rating_low": {
+		header: "Rating (low)",
+		value:  func(r Row) interface{} { return ratingLow(r.Track) },
+		text: func(r Row) string {
+			return strconv.FormatFloat(ratingLow(r.Track), 'f', 1, 64)
+		},
+	},
+	"rating_high": {
+		header: "Rating (high)",
+		value:  func(r Row) interface{} { return ratingHigh(r.Track) },
+		text: func(r Row) string {
+			return strconv.FormatFloat(ratingHigh(r.Track), 'f', 1, 64)
+		},
+	},
+	"settled": {
+		header: "Settled",
+		value:  func(r Row) interface{} { return r.Track.Settled },
+	},
+	"plays": {
+		header: "Plays",
+		value:  func(r Row) interface{} { return r.Track.Plays },
+	},
+	"last_played": {
+		header: "Last played",
+		value: func(r Row) interface{} {
+			if r.Track.LastPlayed.IsZero() {
+				return nil
+			}
+			return r.Track.LastPlayed.Format(time.RFC3339)
+		},
+		text: func(r Row) string {
+			if r.Track.LastPlayed.IsZero() {
+				return ""
+			}
+			return r.Track.LastPlayed.Format(time.RFC3339)
+		},
+	},
+	"confidence": {
+		header: "Confidence",
+		value: func(r Row) interface{} {
+			return elo.Confidence(r.Track.Comparisons)
+		},
+		text: func(r Row) string {
+			return strconv.FormatFloat(
+				elo.Confidence(r.Track.Comparisons), 'f', 2, 64,
+			)
+		},
+	},
+}
+
+// Columns exported when none are chosen
+var DefaultColumns = []string{
+	"position",
+	"title",
+	"artists",
+	"albums",
+	"rating",
+	"comparisons",
+	"confidence",
+}
+
+// ColumnNames lists every column that can be exported
+func ColumnNames() []string {
+	return []string{
+		"position", "id", "mbid", "title", "artist", "artists",
+		"artist_mbids", "albums", "album_mbids", "genre", "year",
+		"rating", "comparisons", "wins", "losses", "draws", "deviation",
+		"rating_low", "rating_high", "settled", "plays", "last_played",
+		"confidence",
+	}
+}
+
+// Write exports rows in the given format with the named columns, in
+// order. Nil columns means DefaultColumns.
+func Write(w io.Writer, format Format, columnNames []string, rows []Row) error {
+	if columnNames == nil {
+		columnNames = DefaultColumns
+	}
+
+	cols := []column{}
+	for _, name := range columnNames {
+		col, ok := columns[name]
+		if !ok {
+			return fmt.Errorf(
+				"Unknown column '%s'; choose from %s",
+				name, strings.Join(ColumnNames(), ", "),
+			)
+		}
+
+		col.name = name
+		cols = append(cols, col)
+	}
+
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, cols, rows)
+	case FormatJSON:
+		return writeJSON(w, cols, rows)
+	case FormatMarkdown:
+		return writeMarkdown(w, cols, rows)
+	default:
+		return fmt.Errorf("Unknown format '%s'", format)
+	}
+}
+
+func writeCSV(w io.Writer, cols []column, rows []Row) error {
+	out := csv.NewWriter(w)
+
+	record := make([]string, len(cols))
+	for i, col := range cols {
+		record[i] = col.name
+	}
+	if err := out.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, col := range cols {
+			record[i] = col.format(row)
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+func writeJSON(w io.Writer, cols []column, rows []Row) error {
+	objects := []map[string]interface{}{}
+
+	for _, row := range rows {
+		object := map[string]interface{}{}
+		for _, col := range cols {
+			object[col.name] = col.value(row)
+		}
+
+		objects = append(objects, object)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(objects)
+}
+
+func writeMarkdown(w io.Writer, cols []column, rows []Row) error {
+	cells := make([]string, len(cols))
+
+	for i, col := range cols {
+		cells[i] = markdownEscape(col.header)
+	}
+	if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+		return err
+	}
+
+	for i, col := range cols {
+		cells[i] = "---"
+		if col.numeric() {
+			cells[i] = "---:"
+		}
+	}
+	if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, col := range cols {
+			cells[i] = markdownEscape(col.format(row))
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (col column) format(r Row) string {
+	if col.text != nil {
+		return col.text(r)
+	}
+
+	return fmt.Sprint(col.value(r))
+}
+
+// Right-aligned in Markdown
+func (col column) numeric() bool {
+	switch col.value(Row{}).(type) {
+	case int, float64:
+		return true
+	}
+
+	return false
+}
+
+func markdownEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		"|", `\|`,
+		"\n", " ",
+	).Replace(s)
+}
+
+// Bounds of the track's 95% confidence interval
+func ratingLow(t track.Track) float64 {
+	low, _ := elo.Interval(t.Ranking, t.Deviation)
+	return low
+}
+
+func ratingHigh(t track.Track) float64 {
+	_, high := elo.Interval(t.Ranking, t.Deviation)
+	return high
+}
+
+func artistNames(t track.Track) []string {
+	names := []string{t.PrimaryArtist.Name}
+	for _, artist := range t.OtherArtists {
+		names = append(names, artist.Name)
+	}
+
+	return names
+}
+
+func artistMBIDs(t track.Track) []string {
+	mbids := []string{t.PrimaryArtist.MusicBrainzID}
+	for _, artist := range t.OtherArtists {
+		mbids = append(mbids, artist.MusicBrainzID)
+	}
+
+	return mbids
+}
+
+func albumTitles(t track.Track) []string {
+	titles := []string{}
+	for _, album := range t.Albums {
+		titles = append(titles, album.Title)
+	}
+
+	return titles
+}
+
+func albumMBIDs(t track.Track) []string {
+	mbids := []string{}
+	for _, album := range t.Albums {
+		mbids = append(mbids, album.MusicBrainzID)
+	}
+
+	return mbids
+}
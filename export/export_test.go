@@ -0,0 +1,172 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+var rows = []Row{
+	{
+		Position: 1,
+		Track: track.Track{
+			InternalID:    2,
+			MusicBrainzID: "MB2",
+			Title:         "Title, with comma",
+			Year:          1994,
+			Albums: []track.Album{
+				{MusicBrainzID: "AL1", Title: "Album 1"},
+				{MusicBrainzID: "AL2", Title: "Album | 2"},
+			},
+			PrimaryArtist: track.Artist{MusicBrainzID: "AR1", Name: "Artist 1"},
+			OtherArtists:  []track.Artist{{Name: "Artist 2"}},
+			Ranking:       1016.25,
+			Comparisons:   10,
+			Wins:          6,
+			Losses:        3,
+			Draws:         1,
+			Deviation:     50,
+			Settled:       true,
+			Plays:         3,
+			LastPlayed:    time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		},
+	},
+	{
+		Position: 2,
+		Track: track.Track{
+			InternalID:    1,
+			Title:         "Title 1",
+			Albums:        []track.Album{},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Ranking:       984,
+		},
+	},
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(&buf, FormatCSV, nil, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `position,title,artists,albums,rating,comparisons,confidence
+1,"Title, with comma",Artist 1; Artist 2,Album 1; Album | 2,1016.2,10,0.50
+2,Title 1,Artist 1,,984.0,0,0.00
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestResultColumns(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(
+		&buf,
+		FormatCSV,
+		[]string{
+			"position", "wins", "losses", "draws", "deviation", "rating_low",
+			"rating_high", "settled",
+		},
+		rows,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `position,wins,losses,draws,deviation,rating_low,rating_high,settled
+1,6,3,1,50.0,918.2,1114.2,true
+2,0,0,0,0.0,984.0,984.0,false
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(
+		&buf, FormatMarkdown, []string{"position", "title", "albums", "year"}, rows,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `| # | Title | Albums | Year |
+| ---: | --- | --- | ---: |
+| 1 | Title, with comma | Album 1; Album \| 2 | 1994 |
+| 2 | Title 1 |  |  |
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(
+		&buf,
+		FormatJSON,
+		[]string{
+			"id", "mbid", "artist_mbids", "album_mbids", "rating", "plays",
+			"last_played", "confidence",
+		},
+		rows,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{{
+		"id":           2.0,
+		"mbid":         "MB2",
+		"artist_mbids": []interface{}{"AR1", ""},
+		"album_mbids":  []interface{}{"AL1", "AL2"},
+		"rating":       1016.25,
+		"plays":        3.0,
+		"last_played":  "2021-03-04T05:06:07Z",
+		"confidence":   0.5,
+	}, {
+		"id":           1.0,
+		"mbid":         "",
+		"artist_mbids": []interface{}{""},
+		"album_mbids":  []interface{}{},
+		"rating":       984.0,
+		"plays":        0.0,
+		"last_played":  nil,
+		"confidence":   0.0,
+	}}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
+
+	t.Log("No rows is an empty array")
+
+	buf.Reset()
+	if err := Write(&buf, FormatJSON, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("Expected [], got %s", buf.String())
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(&buf, "xml", nil, rows); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+	if err := Write(&buf, FormatCSV, []string{"title", "colour"}, rows); err == nil {
+		t.Error("Expected error for unknown column")
+	}
+}
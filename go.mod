@@ -1,6 +1,6 @@
 module github.com/nephila-nacrea/rank-my-music
 
-go 1.14
+go 1.16
 
 require (
 	github.com/dhowden/tag v0.0.0-20201120070457-d52dcb253c63
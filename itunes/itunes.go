@@ -0,0 +1,330 @@
+// Package itunes reads tracks from the Library.xml that iTunes and Apple
+// Music export (File > Library > Export Library)
+package itunes
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Prior sources for ratings and play counts from the library
+const (
+	PriorSourceRating = "itunes_rating"
+	PriorSourcePlays  = "itunes_plays"
+)
+
+// Options for turning library entries into tracks
+type Options struct {
+	// Replaces the start of every file path, e.g. when the library was
+	// exported on another machine. Both use forward slashes.
+	FromPrefix string
+	ToPrefix   string
+
+	// Ignore ratings and play counts
+	NoPriors bool
+}
+
+// Library is what was read from a Library.xml
+type Library struct {
+	Tracks []track.Track
+
+	// Where iTunes keeps its media, as a local path; "" if not given
+	MusicFolder string
+
+	// Entries that aren't local audio files: podcasts, videos, and
+	// streamed or cloud-only tracks
+	Skipped int
+}
+
+// Kinds of entry that aren't music
+var skippedFlags = []string{
+	"Podcast", "Movie", "TV Show", "Music Video", "Has Video", "Audiobook",
+}
+
+var windowsDrive = regexp.MustCompile(`^/[A-Za-z]:/`)
+
+// ReadLibrary reads the tracks in an iTunes Library.xml. Star ratings set
+// by hand (not ones iTunes computed from the album rating) and play
+// counts become track priors, as with ratings in tags.
+func ReadLibrary(r io.Reader, opts Options) (Library, error) {
+	decoder := xml.NewDecoder(r)
+
+	root, err := readRoot(decoder)
+	if err != nil {
+		return Library{}, err
+	}
+
+	plist, ok := root.(map[string]interface{})
+	if !ok {
+		return Library{}, errors.New("Library is not a plist dictionary")
+	}
+
+	entries, ok := plist["Tracks"].(map[string]interface{})
+	if !ok {
+		return Library{}, errors.New("Library has no Tracks dictionary")
+	}
+
+	// Keep the library's own order, which dictionaries lose
+	ids := make([]int, 0, len(entries))
+	for key := range entries {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return Library{}, fmt.Errorf("Invalid track ID '%s'", key)
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	library := Library{Tracks: []track.Track{}}
+
+	if folder, ok := plist["Music Folder"].(string); ok && folder != "" {
+		if library.MusicFolder, err = locationPath(folder, opts); err != nil {
+			return Library{}, err
+		}
+		library.MusicFolder = filepath.Clean(library.MusicFolder)
+	}
+
+	for _, id := range ids {
+		entry, ok := entries[strconv.Itoa(id)].(map[string]interface{})
+		if !ok {
+			return Library{}, fmt.Errorf("Track %d is not a dictionary", id)
+		}
+
+		t, ok, err := readTrack(entry, opts)
+		if err != nil {
+			return Library{}, fmt.Errorf("Track %d: %w", id, err)
+		}
+		if !ok {
+			library.Skipped++
+			continue
+		}
+
+		library.Tracks = append(library.Tracks, t)
+	}
+
+	return library, nil
+}
+
+func readTrack(entry map[string]interface{}, opts Options) (track.Track, bool, error) {
+	str := func(key string) string {
+		s, _ := entry[key].(string)
+		return strings.TrimSpace(s)
+	}
+	integer := func(key string) int {
+		n, _ := entry[key].(int64)
+		return int(n)
+	}
+	flag := func(key string) bool {
+		b, _ := entry[key].(bool)
+		return b
+	}
+
+	for _, key := range skippedFlags {
+		if flag(key) {
+			return track.Track{}, false, nil
+		}
+	}
+
+	location := str("Location")
+	if location == "" || !strings.HasPrefix(location, "file:") {
+		return track.Track{}, false, nil
+	}
+
+	path, err := locationPath(location, opts)
+	if err != nil {
+		return track.Track{}, false, err
+	}
+
+	artist := str("Artist")
+	albumArtist := str("Album Artist")
+	composer := str("Composer")
+
+	// Dedupe artist data, as for tags
+	artists := []track.Artist{}
+	if albumArtist != "" && albumArtist != artist {
+		artists = append(artists, track.Artist{Name: albumArtist})
+	}
+	if composer != "" && composer != artist && composer != albumArtist {
+		artists = append(artists, track.Artist{Name: composer})
+	}
+
+	var prior track.Prior
+	if !opts.NoPriors {
+		prior = readPrior(
+			integer("Rating"), flag("Rating Computed"), integer("Play Count"),
+		)
+	}
+
+	return track.New(track.Track{
+		Title:         str("Name"),
+		Genre:         str("Genre"),
+		Year:          integer("Year"),
+		Albums:        []track.Album{{Title: str("Album")}},
+		PrimaryArtist: track.Artist{Name: artist},
+		OtherArtists:  artists,
+		Files:         []string{path},
+		Prior:         prior,
+	}), true, nil
+}
+
+// Ratings are 0–100, 20 per star
+func readPrior(rating int, computed bool, plays int) track.Prior {
+	prior := track.Prior{Plays: plays}
+
+	switch {
+	case rating > 0 && !computed:
+		prior.Stars = float64(rating) / 20
+		prior.Source = PriorSourceRating
+	case plays > 0:
+		prior.Source = PriorSourcePlays
+	default:
+		return track.Prior{}
+	}
+
+	return prior
+}
+
+// Turns a file:// URL into a local path. Windows libraries have URLs like
+// file://localhost/C:/Music/..., and the drive letter is kept.
+func locationPath(location string, opts Options) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("Invalid location '%s'", location)
+	}
+
+	path := u.Path
+	if windowsDrive.MatchString(path) {
+		path = path[1:]
+	}
+
+	if opts.FromPrefix != "" && strings.HasPrefix(path, opts.FromPrefix) {
+		path = opts.ToPrefix + strings.TrimPrefix(path, opts.FromPrefix)
+	}
+
+	return filepath.FromSlash(path), nil
+}
+
+// Reads the value inside <plist>
+func readRoot(decoder *xml.Decoder) (interface{}, error) {
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, errors.New("Library has no plist")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local == "plist" {
+			continue
+		}
+
+		return readValue(decoder, start)
+	}
+}
+
+// Reads a plist value whose start element has just been read
+func readValue(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := map[string]interface{}{}
+		key := ""
+		haveKey := false
+
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := token.(type) {
+			case xml.EndElement:
+				return dict, nil
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err = decoder.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					haveKey = true
+					continue
+				}
+
+				if !haveKey {
+					return nil, fmt.Errorf("Plist dict value <%s> has no key", t.Name.Local)
+				}
+
+				value, err := readValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+
+				dict[key] = value
+				haveKey = false
+			}
+		}
+	case "array":
+		array := []interface{}{}
+
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := token.(type) {
+			case xml.EndElement:
+				return array, nil
+			case xml.StartElement:
+				value, err := readValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+
+				array = append(array, value)
+			}
+		}
+	case "true", "false":
+		if err := decoder.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := decoder.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "string", "date":
+		return text, nil
+	case "integer":
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid plist integer '%s'", text)
+		}
+		return n, nil
+	case "real":
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid plist real '%s'", text)
+		}
+		return f, nil
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+
+	return nil, fmt.Errorf("Unknown plist element <%s>", start.Name.Local)
+}
@@ -0,0 +1,167 @@
+package itunes
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+const testLibrary = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Major Version</key><integer>1</integer>
+	<key>Date</key><date>2021-03-02T10:15:00Z</date>
+	<key>Music Folder</key><string>file://localhost/Users/me/Music/iTunes/iTunes%20Media/</string>
+	<key>Tracks</key>
+	<dict>
+		<key>205</key>
+		<dict>
+			<key>Track ID</key><integer>205</integer>
+			<key>Name</key><string>Title 2</string>
+			<key>Artist</key><string>Artist 1</string>
+			<key>Album Artist</key><string>Artist 2</string>
+			<key>Composer</key><string>Artist 1</string>
+			<key>Album</key><string>Album 1</string>
+			<key>Rating</key><integer>60</integer>
+			<key>Rating Computed</key><true/>
+			<key>Play Count</key><integer>7</integer>
+			<key>Location</key><string>file://localhost/C:/Music/Artist%201/02%20Title%202.m4a</string>
+		</dict>
+		<key>41</key>
+		<dict>
+			<key>Track ID</key><integer>41</integer>
+			<key>Name</key><string>Title 1 &amp; more</string>
+			<key>Artist</key><string>Artist 1</string>
+			<key>Album</key><string>Album 1</string>
+			<key>Genre</key><string>Rock</string>
+			<key>Year</key><integer>1991</integer>
+			<key>Rating</key><integer>80</integer>
+			<key>Play Count</key><integer>3</integer>
+			<key>Artwork Count</key><integer>1</integer>
+			<key>Persistent ID</key><string>0123456789ABCDEF</string>
+			<key>Compilation</key><false/>
+			<key>Location</key><string>file://localhost/Users/me/Music/iTunes/iTunes%20Media/Music/Artist%201/01%20Title%201.mp3</string>
+		</dict>
+		<key>300</key>
+		<dict>
+			<key>Name</key><string>Episode 1</string>
+			<key>Podcast</key><true/>
+			<key>Location</key><string>file://localhost/Users/me/Music/Podcasts/1.mp3</string>
+		</dict>
+		<key>301</key>
+		<dict>
+			<key>Name</key><string>Streamed</string>
+			<key>Track Type</key><string>Remote</string>
+			<key>Play Count</key><integer>12</integer>
+		</dict>
+		<key>302</key>
+		<dict>
+			<key>Name</key><string>Not played</string>
+			<key>Artist</key><string>Artist 3</string>
+			<key>Location</key><string>file://localhost/Users/me/Elsewhere/3.flac</string>
+		</dict>
+	</dict>
+	<key>Playlists</key>
+	<array>
+		<dict>
+			<key>Name</key><string>Library</string>
+			<key>Playlist Items</key>
+			<array>
+				<dict><key>Track ID</key><integer>41</integer></dict>
+			</array>
+		</dict>
+	</array>
+	<key>Artwork</key><data>
+		AAEC
+	</data>
+</dict>
+</plist>
+`
+
+func TestReadLibrary(t *testing.T) {
+	library, err := ReadLibrary(strings.NewReader(testLibrary), Options{
+		FromPrefix: "/Users/me/Music/iTunes/iTunes Media/",
+		ToPrefix:   "/music/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Library{
+		Tracks: []track.Track{
+			{
+				Title:         "Title 1 & more",
+				Genre:         "Rock",
+				Year:          1991,
+				Albums:        []track.Album{{Title: "Album 1"}},
+				PrimaryArtist: track.Artist{Name: "Artist 1"},
+				OtherArtists:  []track.Artist{},
+				Prior: track.Prior{
+					Stars: 4, Plays: 3, Source: PriorSourceRating,
+				},
+				Files: []string{
+					filepath.FromSlash("/music/Music/Artist 1/01 Title 1.mp3"),
+				},
+			},
+			{
+				Title:         "Title 2",
+				Albums:        []track.Album{{Title: "Album 1"}},
+				PrimaryArtist: track.Artist{Name: "Artist 1"},
+				OtherArtists:  []track.Artist{{Name: "Artist 2"}},
+				Prior:         track.Prior{Plays: 7, Source: PriorSourcePlays},
+				Files: []string{
+					filepath.FromSlash("C:/Music/Artist 1/02 Title 2.m4a"),
+				},
+			},
+			{
+				Title:         "Not played",
+				Albums:        []track.Album{{}},
+				PrimaryArtist: track.Artist{Name: "Artist 3"},
+				OtherArtists:  []track.Artist{},
+				Files: []string{
+					filepath.FromSlash("/Users/me/Elsewhere/3.flac"),
+				},
+			},
+		},
+		MusicFolder: filepath.FromSlash("/music"),
+		Skipped:     2,
+	}
+
+	if !reflect.DeepEqual(expected, library) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, library)
+	}
+
+	t.Log("Without priors")
+
+	library, err = ReadLibrary(
+		strings.NewReader(testLibrary), Options{NoPriors: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, got := range library.Tracks {
+		if got.Prior != (track.Prior{}) {
+			t.Errorf("Expected no prior, got %+v", got.Prior)
+		}
+	}
+
+	t.Log("Invalid libraries")
+
+	for _, xml := range []string{
+		"",
+		"<plist><array></array></plist>",
+		"<plist><dict><key>Tracks</key><string>none</string></dict></plist>",
+		"<plist><dict><key>Tracks</key><dict><key>1</key><dict>" +
+			"<key>Year</key><integer>soon</integer></dict></dict></dict></plist>",
+		"<plist><dict><key>Tracks</key><dict>",
+	} {
+		if _, err := ReadLibrary(strings.NewReader(xml), Options{}); err == nil {
+			t.Errorf("Expected error reading %s", xml)
+		}
+	}
+}
@@ -60,13 +60,15 @@ func main() {
 
 			tracks = append(
 				tracks,
-				track.New(
-					meta.Title(),
-					meta.Album(),
-					[]string{
-						meta.Artist(), meta.AlbumArtist(), meta.Composer(),
+				track.New(track.Track{
+					Title:         meta.Title(),
+					Albums:        []track.Album{{Title: meta.Album()}},
+					PrimaryArtist: track.Artist{Name: meta.Artist()},
+					OtherArtists: []track.Artist{
+						{Name: meta.AlbumArtist()}, {Name: meta.Composer()},
 					},
-				),
+					Ranking: elo.DefaultStartingRating,
+				}),
 			)
 		}
 	}
@@ -0,0 +1,283 @@
+// Package mpd talks to a Music Player Daemon over its text protocol, and
+// watches it to tell which tracks were skipped and which played through
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is an ACK from the server, e.g.
+// ACK [50@0] {playlistinfo} Bad song index
+type Error struct {
+	Code    int
+	Command string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("MPD error %d from %s: %s", e.Code, e.Command, e.Message)
+}
+
+// Client is a connection to MPD. It is not safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// Protocol version announced by the server
+	Version string
+}
+
+// Status is the player state from the status command
+type Status struct {
+	State    string // "play", "pause" or "stop"
+	SongID   int    // 0 if there is no current song
+	Pos      int    // Position of the current song in the queue
+	Elapsed  float64
+	Duration float64 // Seconds; 0 if unknown
+}
+
+// Song is a song in the queue
+type Song struct {
+	File     string // Relative to MPD's music directory
+	ID       int
+	Pos      int
+	Duration float64 // Seconds; 0 if unknown
+}
+
+// Dial connects to MPD at addr (host:port), sending password if it
+// isn't ""
+func Dial(addr string, password string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, r: bufio.NewReader(conn)}
+
+	greeting, err := c.r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "OK MPD ") {
+		conn.Close()
+		return nil, fmt.Errorf("Not an MPD server: %q", greeting)
+	}
+	c.Version = strings.TrimSpace(strings.TrimPrefix(greeting, "OK MPD "))
+
+	if password != "" {
+		if _, err = c.command("password", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Idle blocks until one of the subsystems (e.g. "player") changes, and
+// returns those that did
+func (c *Client) Idle(subsystems ...string) ([]string, error) {
+	fields, err := c.command("idle", subsystems...)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for _, f := range fields {
+		if f.key == "changed" {
+			changed = append(changed, f.value)
+		}
+	}
+
+	return changed, nil
+}
+
+func (c *Client) Status() (Status, error) {
+	fields, err := c.command("status")
+	if err != nil {
+		return Status{}, err
+	}
+
+	var s Status
+	for _, f := range fields {
+		switch f.key {
+		case "state":
+			s.State = f.value
+		case "songid":
+			s.SongID, err = strconv.Atoi(f.value)
+		case "song":
+			s.Pos, err = strconv.Atoi(f.value)
+		case "elapsed":
+			s.Elapsed, err = strconv.ParseFloat(f.value, 64)
+		case "duration":
+			s.Duration, err = strconv.ParseFloat(f.value, 64)
+		case "time":
+			// Whole seconds, "elapsed:duration", from before elapsed and
+			// duration were added
+			if parts := strings.SplitN(f.value, ":", 2); len(parts) == 2 &&
+				s.Duration == 0 {
+				s.Duration, err = strconv.ParseFloat(parts[1], 64)
+			}
+		}
+		if err != nil {
+			return Status{}, fmt.Errorf("Invalid %s in status: %q", f.key, f.value)
+		}
+	}
+
+	return s, nil
+}
+
+// CurrentSong returns the song playing or paused, or a zero Song if
+// there isn't one
+func (c *Client) CurrentSong() (Song, error) {
+	fields, err := c.command("currentsong")
+	if err != nil {
+		return Song{}, err
+	}
+
+	songs, err := parseSongs(fields)
+	if err != nil || len(songs) == 0 {
+		return Song{}, err
+	}
+
+	return songs[0], nil
+}
+
+// SongAt returns the song at pos in the queue, or false if there isn't
+// one
+func (c *Client) SongAt(pos int) (Song, bool, error) {
+	if pos < 0 {
+		return Song{}, false, nil
+	}
+
+	fields, err := c.command("playlistinfo", strconv.Itoa(pos))
+	if err, ok := err.(*Error); ok && err.Code == ackBadArgument {
+		return Song{}, false, nil
+	}
+	if err != nil {
+		return Song{}, false, err
+	}
+
+	songs, err := parseSongs(fields)
+	if err != nil || len(songs) == 0 {
+		return Song{}, false, err
+	}
+
+	return songs[0], true, nil
+}
+
+// ACK code for e.g. a position past the end of the queue
+const ackBadArgument = 2
+
+type field struct {
+	key   string
+	value string
+}
+
+// Sends a command and reads its response up to the closing OK
+func (c *Client) command(name string, args ...string) ([]field, error) {
+	line := name
+	for _, arg := range args {
+		line += " " + quote(arg)
+	}
+
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		return nil, err
+	}
+
+	var fields []field
+
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "OK":
+			return fields, nil
+		case strings.HasPrefix(line, "ACK "):
+			return nil, parseAck(line)
+		}
+
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Unexpected response to %s: %q", name, line)
+		}
+
+		fields = append(fields, field{key: parts[0], value: parts[1]})
+	}
+}
+
+// Songs start at each "file" key
+func parseSongs(fields []field) ([]Song, error) {
+	var songs []Song
+	var err error
+
+	for _, f := range fields {
+		if f.key == "file" {
+			songs = append(songs, Song{File: f.value})
+			continue
+		}
+		if len(songs) == 0 {
+			continue
+		}
+
+		song := &songs[len(songs)-1]
+
+		switch f.key {
+		case "Id":
+			song.ID, err = strconv.Atoi(f.value)
+		case "Pos":
+			song.Pos, err = strconv.Atoi(f.value)
+		case "duration":
+			song.Duration, err = strconv.ParseFloat(f.value, 64)
+		case "Time":
+			if song.Duration == 0 {
+				song.Duration, err = strconv.ParseFloat(f.value, 64)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s for '%s': %q", f.key, song.File, f.value)
+		}
+	}
+
+	return songs, nil
+}
+
+// ACK [code@list_num] {command} message
+func parseAck(line string) error {
+	e := &Error{Message: strings.TrimPrefix(line, "ACK ")}
+
+	var code, listNum int
+	if n, _ := fmt.Sscanf(e.Message, "[%d@%d]", &code, &listNum); n == 2 {
+		e.Code = code
+	}
+
+	if start := strings.Index(e.Message, "{"); start >= 0 {
+		if end := strings.Index(e.Message[start:], "} "); end >= 0 {
+			e.Command = e.Message[start+1 : start+end]
+			e.Message = e.Message[start+end+2:]
+		}
+	}
+
+	return e
+}
+
+func quote(arg string) string {
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+
+	return `"` + arg + `"`
+}
@@ -0,0 +1,300 @@
+package mpd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMPD answers the commands the watcher sends from a queue and player
+// state the test controls. Each idle waits for the test to call change.
+type fakeMPD struct {
+	t        *testing.T
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	queue   []Song
+	state   string
+	pos     int
+	elapsed float64
+	clock   time.Time
+
+	idling  chan struct{} // Sent to when a client starts idling
+	changed chan struct{}
+}
+
+func newFakeMPD(t *testing.T, password string, queue []Song) *fakeMPD {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range queue {
+		queue[i].ID = 100 + i
+		queue[i].Pos = i
+	}
+
+	f := &fakeMPD{
+		t:        t,
+		listener: listener,
+		password: password,
+		queue:    queue,
+		state:    "stop",
+		clock:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		idling:   make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+
+	go f.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return f
+}
+
+func (f *fakeMPD) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeMPD) now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.clock
+}
+
+// Waits for the client to idle, moves the clock on by seconds, applies
+// the change and wakes the client
+func (f *fakeMPD) change(seconds float64, apply func()) {
+	f.t.Helper()
+
+	select {
+	case <-f.idling:
+	case <-time.After(5 * time.Second):
+		f.t.Fatal("Client never went idle")
+	}
+
+	f.mu.Lock()
+	f.clock = f.clock.Add(time.Duration(seconds * float64(time.Second)))
+	if f.state == "play" {
+		f.elapsed += seconds
+	}
+	apply()
+	f.mu.Unlock()
+
+	f.changed <- struct{}{}
+}
+
+func (f *fakeMPD) serve() {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "OK MPD 0.23.5\n")
+
+	authed := f.password == ""
+	r := bufio.NewReader(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(strings.TrimSpace(line))
+		command := fields[0]
+		args := []string{}
+		for _, arg := range fields[1:] {
+			args = append(args, strings.Trim(arg, `"`))
+		}
+
+		if command == "password" {
+			if len(args) == 1 && args[0] == f.password {
+				authed = true
+				fmt.Fprint(conn, "OK\n")
+			} else {
+				fmt.Fprint(conn, "ACK [3@0] {password} incorrect password\n")
+			}
+			continue
+		}
+		if !authed {
+			fmt.Fprintf(conn, "ACK [4@0] {%s} you don't have permission for \"%s\"\n", command, command)
+			continue
+		}
+
+		if command == "idle" {
+			f.idling <- struct{}{}
+			<-f.changed
+			fmt.Fprint(conn, "changed: player\nOK\n")
+			continue
+		}
+
+		f.mu.Lock()
+		response := f.respond(command, args)
+		f.mu.Unlock()
+
+		fmt.Fprint(conn, response)
+	}
+}
+
+func (f *fakeMPD) respond(command string, args []string) string {
+	switch command {
+	case "status":
+		response := "volume: 100\nrepeat: 0\nstate: " + f.state + "\n"
+		if f.state != "stop" {
+			song := f.queue[f.pos]
+			response += fmt.Sprintf(
+				"song: %d\nsongid: %d\ntime: %d:%d\nelapsed: %.3f\nduration: %.3f\n",
+				f.pos, song.ID, int(f.elapsed), int(song.Duration),
+				f.elapsed, song.Duration,
+			)
+		}
+		return response + "OK\n"
+	case "currentsong":
+		if f.state == "stop" {
+			return "OK\n"
+		}
+		return songResponse(f.queue[f.pos]) + "OK\n"
+	case "playlistinfo":
+		pos, err := strconv.Atoi(args[0])
+		if err != nil || pos < 0 || pos >= len(f.queue) {
+			return "ACK [2@0] {playlistinfo} Bad song index\n"
+		}
+		return songResponse(f.queue[pos]) + "OK\n"
+	default:
+		return fmt.Sprintf("ACK [5@0] {} unknown command \"%s\"\n", command)
+	}
+}
+
+func songResponse(song Song) string {
+	return fmt.Sprintf(
+		"file: %s\nLast-Modified: 2020-05-01T10:00:00Z\nTitle: %s\n"+
+			"Time: %d\nduration: %.3f\nPos: %d\nId: %d\n",
+		song.File, song.File, int(song.Duration), song.Duration, song.Pos, song.ID,
+	)
+}
+
+func TestWatch(t *testing.T) {
+	f := newFakeMPD(t, "secret", []Song{
+		{File: "a.flac", Duration: 200},
+		{File: "b.flac", Duration: 200},
+		{File: "c.flac", Duration: 200},
+		{File: "d.flac", Duration: 200},
+	})
+	f.state = "play"
+
+	t.Log("Wrong password")
+
+	if _, err := Dial(f.addr(), "wrong"); err == nil {
+		t.Fatal("Expected error for wrong password")
+	}
+
+	// The fake only serves one connection
+	f = newFakeMPD(t, "secret", f.queue)
+	f.state = "play"
+
+	client, err := Dial(f.addr(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if client.Version != "0.23.5" {
+		t.Errorf("Expected version 0.23.5, got '%s'", client.Version)
+	}
+
+	w, err := NewWatcher(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = f.now
+
+	plays := make(chan Play)
+	done := make(chan error)
+
+	go func() {
+		done <- w.Watch(client, func(p Play) error {
+			plays <- p
+			return nil
+		})
+	}()
+
+	expectPlay := func(expected Play) {
+		t.Helper()
+
+		select {
+		case got := <-plays:
+			if !reflect.DeepEqual(expected, got) {
+				t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+			}
+		case err := <-done:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %+v", expected)
+		}
+	}
+
+	t.Log("Played through into the next track")
+
+	f.change(200, func() { f.pos, f.elapsed = 1, 0 })
+	expectPlay(Play{File: "a.flac", Neighbours: []string{"b.flac"}})
+
+	t.Log("Skipped early")
+
+	f.change(10, func() { f.pos, f.elapsed = 2, 0 })
+	expectPlay(Play{
+		File: "b.flac", Neighbours: []string{"a.flac", "c.flac"}, Skipped: true,
+	})
+
+	t.Log("Time paused doesn't count")
+
+	f.change(10, func() { f.state = "pause" })
+	f.change(1000, func() { f.state = "play" })
+	f.change(20, func() { f.pos, f.elapsed = 3, 0 })
+	expectPlay(Play{
+		File: "c.flac", Neighbours: []string{"b.flac", "d.flac"}, Skipped: true,
+	})
+
+	t.Log("Stopped half way, then seeking from the start to near the end")
+
+	f.change(100, func() { f.state = "stop" })
+	f.change(0, func() { f.state, f.pos, f.elapsed = "play", 0, 0 })
+	f.change(5, func() { f.elapsed = 185 })
+	f.change(15, func() { f.pos, f.elapsed = 1, 0 })
+	expectPlay(Play{File: "a.flac", Neighbours: []string{"b.flac"}})
+
+	select {
+	case p := <-plays:
+		t.Errorf("Expected no more plays, got %+v", p)
+	default:
+	}
+}
+
+func TestParseAck(t *testing.T) {
+	err := parseAck("ACK [50@0] {playlistinfo} Bad song index")
+
+	expected := &Error{Code: 50, Command: "playlistinfo", Message: "Bad song index"}
+	if !reflect.DeepEqual(expected, err) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, err)
+	}
+}
+
+func TestNewWatcherOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{SkipBefore: -0.1, PlayedAfter: 0.9},
+		{SkipBefore: 0.25, PlayedAfter: 1.5},
+		{SkipBefore: 0.95, PlayedAfter: 0.9},
+	} {
+		if _, err := NewWatcher(opts); err == nil {
+			t.Errorf("Expected error for %+v", opts)
+		}
+	}
+}
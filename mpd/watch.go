@@ -0,0 +1,176 @@
+package mpd
+
+import (
+	"fmt"
+	"time"
+)
+
+// Source for comparisons inferred from what MPD played
+const Source = "mpd"
+
+// Play is a track that was skipped early or played through
+type Play struct {
+	File string // Relative to MPD's music directory
+
+	// Files before and after it in the queue when it started playing
+	Neighbours []string
+
+	Skipped bool // Otherwise played through
+}
+
+// Options say how much of a track must play for it to count
+type Options struct {
+	// Moving on to another track before this fraction of one has played
+	// is a skip
+	SkipBefore float64
+
+	// Playing at least this fraction of a track is playing it through
+	PlayedAfter float64
+}
+
+func DefaultOptions() Options {
+	return Options{SkipBefore: 0.25, PlayedAfter: 0.9}
+}
+
+// Watcher follows the player and reports each track skipped or played
+// through. Tracks stopped, or moved on from part way through, are not
+// reported.
+type Watcher struct {
+	client *Client
+	opts   Options
+	now    func() time.Time
+
+	current *nowPlaying
+}
+
+type nowPlaying struct {
+	song       Song
+	neighbours []string
+
+	playing bool
+	elapsed float64   // As of seen
+	seen    time.Time // When the state was last read
+}
+
+func NewWatcher(opts Options) (*Watcher, error) {
+	if opts.SkipBefore < 0 || opts.PlayedAfter > 1 ||
+		opts.SkipBefore > opts.PlayedAfter {
+		return nil, fmt.Errorf(
+			"Need 0 <= skip fraction (%v) <= played fraction (%v) <= 1",
+			opts.SkipBefore, opts.PlayedAfter,
+		)
+	}
+
+	return &Watcher{opts: opts, now: time.Now}, nil
+}
+
+// Watch calls played for each track skipped or played through, waiting
+// for player changes between times. It only returns on error, from the
+// connection or from played. A track playing when it starts watching
+// is only reported if it finishes on the same connection.
+func (w *Watcher) Watch(client *Client, played func(Play) error) error {
+	w.client = client
+	w.current = nil
+
+	for {
+		play, err := w.poll()
+		if err != nil {
+			return err
+		}
+
+		if play != nil {
+			if err = played(*play); err != nil {
+				return err
+			}
+		}
+
+		if _, err = w.client.Idle("player"); err != nil {
+			return err
+		}
+	}
+}
+
+// Reads the player state. Returns the track that was playing if it has
+// just been skipped or played through.
+func (w *Watcher) poll() (*Play, error) {
+	status, err := w.client.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	now := w.now()
+
+	if w.current != nil && status.State != "stop" &&
+		status.SongID == w.current.song.ID {
+		w.current.playing = status.State == "play"
+		w.current.elapsed = status.Elapsed
+		w.current.seen = now
+
+		return nil, nil
+	}
+
+	play := w.finished(now, status.State != "stop")
+
+	w.current = nil
+	if status.State == "stop" || status.SongID == 0 {
+		return play, nil
+	}
+
+	song, err := w.client.CurrentSong()
+	if err != nil {
+		return nil, err
+	}
+	if song.Duration == 0 {
+		song.Duration = status.Duration
+	}
+
+	// Neighbours are read now, as in consume mode tracks leave the
+	// queue once played
+	var neighbours []string
+	for _, pos := range []int{status.Pos - 1, status.Pos + 1} {
+		neighbour, ok, err := w.client.SongAt(pos)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			neighbours = append(neighbours, neighbour.File)
+		}
+	}
+
+	w.current = &nowPlaying{
+		song:       song,
+		neighbours: neighbours,
+		playing:    status.State == "play",
+		elapsed:    status.Elapsed,
+		seen:       now,
+	}
+
+	return play, nil
+}
+
+// The outcome for the current track, now that it has stopped playing.
+// Only a track moved on from counts as skipped, not one stopped.
+func (w *Watcher) finished(now time.Time, movedOn bool) *Play {
+	if w.current == nil || w.current.song.Duration <= 0 {
+		return nil
+	}
+
+	elapsed := w.current.elapsed
+	if w.current.playing {
+		elapsed += now.Sub(w.current.seen).Seconds()
+	}
+
+	fraction := elapsed / w.current.song.Duration
+
+	play := &Play{File: w.current.song.File, Neighbours: w.current.neighbours}
+
+	switch {
+	case fraction >= w.opts.PlayedAfter:
+		return play
+	case fraction < w.opts.SkipBefore && movedOn:
+		play.Skipped = true
+		return play
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,163 @@
+// Package musicbrainz looks up recordings in a local copy of (part of)
+// the MusicBrainz database, for tracks whose tags have no MusicBrainz ID
+package musicbrainz
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recording is a MusicBrainz recording as it appears on one release.
+// Recordings on several releases are read once per release.
+type Recording struct {
+	ID        string
+	Title     string
+	Artist    string // The full artist credit, e.g. "A feat. B"
+	ArtistID  string // The first credited artist
+	Release   string
+	ReleaseID string
+	Length    time.Duration // 0 if unknown
+}
+
+// Recording objects as served by the web service and in the JSON dumps
+type jsonRecording struct {
+	ID           string `json:"id"`
+	Title        string `json:"title"`
+	Length       int64  `json:"length"` // Milliseconds
+	ArtistCredit []struct {
+		Name       string `json:"name"`
+		JoinPhrase string `json:"joinphrase"`
+		Artist     struct {
+			ID string `json:"id"`
+		} `json:"artist"`
+	} `json:"artist-credit"`
+	Releases []struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+	} `json:"releases"`
+}
+
+// ReadJSON reads recordings from JSON Lines, one web service recording
+// object per line, calling f for each
+func ReadJSON(r io.Reader, f func(Recording) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		raw := strings.TrimSpace(scanner.Text())
+		if raw == "" {
+			continue
+		}
+
+		var jr jsonRecording
+		if err := json.Unmarshal([]byte(raw), &jr); err != nil {
+			return fmt.Errorf("Line %d: %w", line, err)
+		}
+		if jr.ID == "" || jr.Title == "" {
+			return fmt.Errorf("Line %d: recording without ID or title", line)
+		}
+
+		rec := Recording{
+			ID:     jr.ID,
+			Title:  jr.Title,
+			Length: time.Duration(jr.Length) * time.Millisecond,
+		}
+
+		for i, credit := range jr.ArtistCredit {
+			rec.Artist += credit.Name + credit.JoinPhrase
+			if i == 0 {
+				rec.ArtistID = credit.Artist.ID
+			}
+		}
+
+		if len(jr.Releases) == 0 {
+			if err := f(rec); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, release := range jr.Releases {
+			rec.Release, rec.ReleaseID = release.Title, release.ID
+			if err := f(rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ReadTSV reads recordings from tab separated values with a header row
+// naming the columns. id, title and artist are required; length (in
+// milliseconds), release, artist_id and release_id are optional, as are
+// columns we don't use. Values aren't quoted, as in the database dumps.
+func ReadTSV(r io.Reader, f func(Recording) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("No header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range strings.Split(scanner.Text(), "\t") {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"id", "title", "artist"} {
+		if _, ok := columns[required]; !ok {
+			return fmt.Errorf("No '%s' column", required)
+		}
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		fields := strings.Split(scanner.Text(), "\t")
+
+		get := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(fields) || fields[i] == `\N` {
+				return ""
+			}
+			return strings.TrimSpace(fields[i])
+		}
+
+		rec := Recording{
+			ID:        get("id"),
+			Title:     get("title"),
+			Artist:    get("artist"),
+			ArtistID:  get("artist_id"),
+			Release:   get("release"),
+			ReleaseID: get("release_id"),
+		}
+		if rec.ID == "" || rec.Title == "" {
+			return fmt.Errorf("Line %d: recording without ID or title", line)
+		}
+
+		if length := get("length"); length != "" {
+			ms, err := strconv.ParseInt(length, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Line %d: invalid length '%s'", line, length)
+			}
+			rec.Length = time.Duration(ms) * time.Millisecond
+		}
+
+		if err := f(rec); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,89 @@
+package musicbrainz
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSON(t *testing.T) {
+	input := `{"id":"rec1","title":"Song 1","length":245000,"artist-credit":[{"name":"Artist 1","joinphrase":" feat. ","artist":{"id":"ar1"}},{"name":"Artist 2","joinphrase":"","artist":{"id":"ar2"}}],"releases":[{"id":"rel1","title":"Album 1"},{"id":"rel2","title":"Best Of"}]}
+
+{"id":"rec2","title":"Song 2","artist-credit":[{"name":"Artist 3","artist":{"id":"ar3"}}]}
+`
+
+	expected := []Recording{
+		{
+			ID: "rec1", Title: "Song 1", Artist: "Artist 1 feat. Artist 2",
+			ArtistID: "ar1", Release: "Album 1", ReleaseID: "rel1",
+			Length: 245 * time.Second,
+		},
+		{
+			ID: "rec1", Title: "Song 1", Artist: "Artist 1 feat. Artist 2",
+			ArtistID: "ar1", Release: "Best Of", ReleaseID: "rel2",
+			Length: 245 * time.Second,
+		},
+		{ID: "rec2", Title: "Song 2", Artist: "Artist 3", ArtistID: "ar3"},
+	}
+
+	got := []Recording{}
+	if err := ReadJSON(strings.NewReader(input), func(rec Recording) error {
+		got = append(got, rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
+
+	t.Log("Invalid")
+
+	for _, input := range []string{`{"id":"rec1"`, `{"id":"rec1"}`} {
+		err := ReadJSON(strings.NewReader(input), func(Recording) error { return nil })
+		if err == nil {
+			t.Errorf("Expected error for %s", input)
+		}
+	}
+}
+
+func TestReadTSV(t *testing.T) {
+	input := "id\tlength\ttitle\tartist\trelease\tcomment\n" +
+		"rec1\t245000\tSong \"1\"\tArtist 1\tAlbum 1\tx\n" +
+		"rec2\t\\N\tSong 2\tArtist 2\n"
+
+	expected := []Recording{
+		{
+			ID: "rec1", Title: `Song "1"`, Artist: "Artist 1",
+			Release: "Album 1", Length: 245 * time.Second,
+		},
+		{ID: "rec2", Title: "Song 2", Artist: "Artist 2"},
+	}
+
+	got := []Recording{}
+	if err := ReadTSV(strings.NewReader(input), func(rec Recording) error {
+		got = append(got, rec)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
+
+	t.Log("Invalid")
+
+	for _, input := range []string{
+		"",
+		"id\ttitle\nrec1\tSong 1\n",
+		"id\ttitle\tartist\tlength\nrec1\tSong 1\tArtist 1\t4:05\n",
+	} {
+		err := ReadTSV(strings.NewReader(input), func(Recording) error { return nil })
+		if err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
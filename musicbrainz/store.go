@@ -0,0 +1,324 @@
+package musicbrainz
+
+import (
+	"database/sql"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// How much each part of a match counts towards its score, out of 1
+const (
+	titleWeight  = 0.3
+	artistWeight = 0.35
+	albumWeight  = 0.15
+	lengthWeight = 0.2
+)
+
+// Lengths this close count as the same; this far apart, as unrelated
+const (
+	sameLength      = 2 * time.Second
+	unrelatedLength = 20 * time.Second
+)
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS recordings (
+    id         TEXT NOT NULL,
+    title      TEXT NOT NULL,
+    artist     TEXT NOT NULL,
+    artist_id  TEXT NOT NULL DEFAULT '',
+    release    TEXT NOT NULL DEFAULT '',
+    release_id TEXT NOT NULL DEFAULT '',
+    length_ms  INTEGER NOT NULL DEFAULT 0,
+    title_key  TEXT NOT NULL,
+    UNIQUE (id, release_id, release)
+);
+
+CREATE INDEX IF NOT EXISTS recordings_title_key ON recordings (title_key);
+`
+
+// Store is a lookup table of recordings in its own SQLite file, kept
+// apart from the rankings as it can be far bigger
+type Store struct {
+	db *sql.DB
+}
+
+// Reader reads recordings in some format, e.g. ReadJSON or ReadTSV
+type Reader func(r io.Reader, f func(Recording) error) error
+
+// Query is what we know about a track
+type Query struct {
+	Title  string
+	Artist string
+	Album  string        // "" if unknown
+	Length time.Duration // 0 if unknown
+}
+
+// Match is a recording that may be the queried track. Score is from 0
+// to 1, where 1 is an exact match on everything.
+type Match struct {
+	Recording
+	Score float64
+}
+
+// Open opens the store at path, creating it if need be
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// One connection, so in-memory stores are shared
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Load adds the recordings read from r, returning how many were new.
+// Recordings already in the store on the same release are ignored.
+func (s *Store) Load(r io.Reader, read Reader) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(
+		`INSERT OR IGNORE INTO recordings
+		    (id, title, artist, artist_id, release, release_id, length_ms,
+		     title_key)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	added := 0
+
+	err = read(r, func(rec Recording) error {
+		result, err := stmt.Exec(
+			rec.ID,
+			rec.Title,
+			rec.Artist,
+			rec.ArtistID,
+			rec.Release,
+			rec.ReleaseID,
+			rec.Length.Milliseconds(),
+			titleKey(rec.Title),
+		)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		added += int(n)
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return added, tx.Commit()
+}
+
+// Recordings counts the recordings in the store, once per release
+func (s *Store) Recordings() (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM recordings`).Scan(&n)
+
+	return n, err
+}
+
+// Match returns up to limit recordings with the same title as q, ignoring
+// anything in brackets, best match first. Each recording is given on the
+// release that best matches q's album.
+func (s *Store) Match(q Query, limit int) ([]Match, error) {
+	key := titleKey(q.Title)
+	if key == "" {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id,
+		        title,
+		        artist,
+		        artist_id,
+		        release,
+		        release_id,
+		        length_ms
+		   FROM recordings
+		  WHERE title_key = ?
+		  ORDER BY id, release_id`,
+		key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	best := map[string]Match{}
+
+	for rows.Next() {
+		var rec Recording
+		var lengthMS int64
+
+		if err = rows.Scan(
+			&rec.ID,
+			&rec.Title,
+			&rec.Artist,
+			&rec.ArtistID,
+			&rec.Release,
+			&rec.ReleaseID,
+			&lengthMS,
+		); err != nil {
+			return nil, err
+		}
+
+		rec.Length = time.Duration(lengthMS) * time.Millisecond
+
+		score := score(q, rec)
+		if m, ok := best[rec.ID]; !ok || score > m.Score {
+			best[rec.ID] = Match{Recording: rec, Score: score}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	return matches, nil
+}
+
+// How well rec matches q. Unknown albums and lengths count for half.
+func score(q Query, rec Recording) float64 {
+	// Candidates already share a title key, so differ at most in brackets
+	title := 0.8
+	if normalise(q.Title) == normalise(rec.Title) {
+		title = 1
+	}
+
+	artist := 0.5
+	if q.Artist != "" {
+		artist = wordOverlap(normalise(q.Artist), normalise(rec.Artist))
+	}
+
+	album := 0.5
+	if q.Album != "" && rec.Release != "" {
+		switch {
+		case normalise(q.Album) == normalise(rec.Release):
+			album = 1
+		case titleKey(q.Album) == titleKey(rec.Release):
+			album = 0.8 // e.g. a "(Deluxe Edition)"
+		default:
+			album = 0
+		}
+	}
+
+	length := 0.5
+	if q.Length > 0 && rec.Length > 0 {
+		diff := q.Length - rec.Length
+		if diff < 0 {
+			diff = -diff
+		}
+
+		length = 1 - float64(diff-sameLength)/float64(unrelatedLength-sameLength)
+		length = math.Max(0, math.Min(1, length))
+	}
+
+	return titleWeight*title +
+		artistWeight*artist +
+		albumWeight*album +
+		lengthWeight*length
+}
+
+func normalise(s string) string {
+	return track.NormaliseName(track.WithoutFeatured(s))
+}
+
+// The normalised name without anything in brackets, e.g. "(Remastered)",
+// unless that's all there is
+func titleKey(s string) string {
+	s = track.WithoutFeatured(s)
+
+	var b strings.Builder
+	depth := 0
+
+	for _, r := range s {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth > 0 {
+				depth--
+			}
+		default:
+			if depth == 0 {
+				b.WriteRune(r)
+			}
+		}
+	}
+
+	if key := track.NormaliseName(b.String()); key != "" {
+		return key
+	}
+
+	return track.NormaliseName(s)
+}
+
+// 1 for the same words, down to 0 for none in common
+func wordOverlap(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	words := map[string]int{}
+	for _, w := range strings.Fields(a) {
+		words[w] |= 1
+	}
+	for _, w := range strings.Fields(b) {
+		words[w] |= 2
+	}
+
+	both := 0
+	for _, in := range words {
+		if in == 3 {
+			both++
+		}
+	}
+
+	if len(words) == 0 {
+		return 0
+	}
+
+	return float64(both) / float64(len(words))
+}
@@ -0,0 +1,91 @@
+package musicbrainz
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestMatch(t *testing.T) {
+	store, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	dump := "id\ttitle\tartist\trelease\tlength\n" +
+		"rec1\tSong 1\tArtist 1\tAlbum 1\t245000\n" +
+		"rec1\tSong 1\tArtist 1\tGreatest Hits\t245000\n" +
+		"rec2\tSong 1 (live)\tArtist 1\tLive at Somewhere\t300000\n" +
+		"rec3\tSong 1\tSomeone Else\tOther Album\t180000\n" +
+		"rec4\tSong 2\tArtist 1\tAlbum 1\t200000\n"
+
+	added, err := store.Load(strings.NewReader(dump), ReadTSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 5 {
+		t.Errorf("Expected 5 recordings added, got %d", added)
+	}
+
+	t.Log("Loading again adds nothing")
+
+	if added, err = store.Load(strings.NewReader(dump), ReadTSV); err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 {
+		t.Errorf("Expected nothing added, got %d", added)
+	}
+
+	t.Log("Exact match first, on the matching release")
+
+	matches, err := store.Match(Query{
+		Title:  "Song 1 (Remastered)",
+		Artist: "The Artist 1",
+		Album:  "Greatest Hits",
+		Length: 246 * time.Second,
+	}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []string{}
+	for _, m := range matches {
+		ids = append(ids, m.ID)
+	}
+	if strings.Join(ids, ",") != "rec1,rec2,rec3" {
+		t.Fatalf("Expected rec1,rec2,rec3, got %v", ids)
+	}
+
+	if matches[0].Release != "Greatest Hits" {
+		t.Errorf("Expected match on Greatest Hits, got %+v", matches[0])
+	}
+	if matches[0].Score < 0.9 || matches[0].Score > 1 {
+		t.Errorf("Expected score of about 0.94, got %v", matches[0].Score)
+	}
+	if matches[2].Score > 0.5 {
+		t.Errorf("Expected other artist to score low, got %v", matches[2].Score)
+	}
+
+	t.Log("Unknown album and length count for half")
+
+	if matches, err = store.Match(Query{Title: "Song 2", Artist: "Artist 1"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].ID != "rec4" ||
+		math.Abs(matches[0].Score-0.825) > 1e-9 {
+		t.Errorf("Expected rec4 scoring 0.825, got %+v", matches)
+	}
+
+	t.Log("No match")
+
+	if matches, err = store.Match(Query{Title: "Song 3"}, 5); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Expected no matches, got %+v", matches)
+	}
+}
@@ -0,0 +1,213 @@
+// Package playlist writes ranked tracks out as M3U8 or XSPF playlists
+package playlist
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+type Format string
+
+const (
+	FormatM3U8 Format = "m3u8"
+	FormatXSPF Format = "xspf"
+)
+
+// Entry is one track in a playlist, with the audio file to play
+type Entry struct {
+	Track track.Track
+	Path  string // Absolute
+}
+
+type Options struct {
+	Title string
+
+	// Write paths relative to this directory, normally the one the
+	// playlist is saved in. "" = absolute paths.
+	RelativeTo string
+}
+
+// Write writes entries as a playlist in the given format, in order
+func Write(w io.Writer, format Format, entries []Entry, opts Options) error {
+	switch format {
+	case FormatM3U8:
+		return writeM3U8(w, entries, opts)
+	case FormatXSPF:
+		return writeXSPF(w, entries, opts)
+	default:
+		return fmt.Errorf("Unknown format '%s'", format)
+	}
+}
+
+func writeM3U8(w io.Writer, entries []Entry, opts Options) error {
+	lines := []string{"#EXTM3U"}
+
+	if opts.Title != "" {
+		lines = append(lines, "#PLAYLIST:"+oneLine(opts.Title))
+	}
+
+	for _, entry := range entries {
+		path, err := location(entry.Path, opts.RelativeTo)
+		if err != nil {
+			return err
+		}
+
+		// No durations are stored, and -1 is "unknown"
+		lines = append(lines, fmt.Sprintf(
+			"#EXTINF:-1,%s - %s",
+			oneLine(entry.Track.PrimaryArtist.Name),
+			oneLine(entry.Track.Title),
+		))
+
+		if len(entry.Track.Albums) > 0 && entry.Track.Albums[0].Title != "" {
+			lines = append(lines, "#EXTALB:"+oneLine(entry.Track.Albums[0].Title))
+		}
+
+		lines = append(lines, path)
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version   int         `xml:"version,attr"`
+	Title     string      `xml:"title,omitempty"`
+	TrackList []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier,omitempty"`
+	Title      string `xml:"title,omitempty"`
+	Creator    string `xml:"creator,omitempty"`
+	Album      string `xml:"album,omitempty"`
+}
+
+func writeXSPF(w io.Writer, entries []Entry, opts Options) error {
+	playlist := xspfPlaylist{
+		Version:   1,
+		Title:     opts.Title,
+		TrackList: []xspfTrack{},
+	}
+
+	for _, entry := range entries {
+		path, err := location(entry.Path, opts.RelativeTo)
+		if err != nil {
+			return err
+		}
+
+		// XSPF locations are URIs
+		uri := &url.URL{Path: filepath.ToSlash(path)}
+		if filepath.IsAbs(path) {
+			uri.Scheme = "file"
+		}
+
+		t := xspfTrack{
+			Location: uri.String(),
+			Title:    entry.Track.Title,
+			Creator:  entry.Track.PrimaryArtist.Name,
+		}
+
+		if entry.Track.MusicBrainzID != "" {
+			t.Identifier = "https://musicbrainz.org/recording/" +
+				entry.Track.MusicBrainzID
+		}
+
+		if len(entry.Track.Albums) > 0 {
+			t.Album = entry.Track.Albums[0].Title
+		}
+
+		playlist.TrackList = append(playlist.TrackList, t)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Mix picks up to n tracks at random, without repeats, favouring higher
+// rated ones. A track's chance of being picked before another matches
+// its Elo odds of beating it, so a track rated 400 points higher is ten
+// times as likely to come first. Unranked tracks count as rated
+// unranked. n <= 0 shuffles all the tracks.
+func Mix(
+	tracks []track.Track, n int, unranked float64, rng *rand.Rand,
+) []track.Track {
+	rating := func(t track.Track) float64 {
+		if t.Ranking == 0 {
+			return unranked
+		}
+		return t.Ranking
+	}
+
+	top := math.Inf(-1)
+	for _, t := range tracks {
+		top = math.Max(top, rating(t))
+	}
+
+	// Weighted sampling without replacement (Efraimidis & Spirakis):
+	// take the tracks with the highest log(u) / weight
+	type keyed struct {
+		track track.Track
+		key   float64
+	}
+
+	keys := []keyed{}
+	for _, t := range tracks {
+		weight := math.Pow(10, (rating(t)-top)/400)
+
+		keys = append(keys, keyed{
+			track: t,
+			key:   math.Log(1-rng.Float64()) / weight,
+		})
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].key > keys[j].key
+	})
+
+	if n <= 0 || n > len(keys) {
+		n = len(keys)
+	}
+
+	mixed := []track.Track{}
+	for _, k := range keys[:n] {
+		mixed = append(mixed, k.track)
+	}
+
+	return mixed
+}
+
+func location(path string, relativeTo string) (string, error) {
+	if relativeTo == "" {
+		return path, nil
+	}
+
+	return filepath.Rel(relativeTo, path)
+}
+
+// M3U is line-based
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
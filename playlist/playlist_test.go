@@ -0,0 +1,168 @@
+package playlist
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+var entries = []Entry{
+	{
+		Track: track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album & 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		},
+		Path: "/music/Artist 1/01 Title 1.flac",
+	},
+	{
+		Track: track.Track{
+			Title:         "Title\n2",
+			Albums:        []track.Album{},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		},
+		Path: "/music/Artist 2/Título #2.mp3",
+	},
+}
+
+func TestM3U8(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(&buf, FormatM3U8, entries, Options{Title: "Top 2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `#EXTM3U
+#PLAYLIST:Top 2
+#EXTINF:-1,Artist 1 - Title 1
+#EXTALB:Album & 1
+/music/Artist 1/01 Title 1.flac
+#EXTINF:-1,Artist 2 - Title 2
+/music/Artist 2/Título #2.mp3
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	t.Log("Relative paths")
+
+	buf.Reset()
+
+	if err := Write(
+		&buf, FormatM3U8, entries[:1], Options{RelativeTo: "/music/Artist 2"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	expected = `#EXTM3U
+#EXTINF:-1,Artist 1 - Title 1
+#EXTALB:Album & 1
+../Artist 1/01 Title 1.flac
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestXSPF(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(&buf, FormatXSPF, entries, Options{Title: "Top 2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<playlist xmlns="http://xspf.org/ns/0/" version="1">
+  <title>Top 2</title>
+  <trackList>
+    <track>
+      <location>file:///music/Artist%201/01%20Title%201.flac</location>
+      <identifier>https://musicbrainz.org/recording/MB1</identifier>
+      <title>Title 1</title>
+      <creator>Artist 1</creator>
+      <album>Album &amp; 1</album>
+    </track>
+    <track>
+      <location>file:///music/Artist%202/T%C3%ADtulo%20%232.mp3</location>
+      <title>Title&#xA;2</title>
+      <creator>Artist 2</creator>
+    </track>
+  </trackList>
+</playlist>
+`
+	if buf.String() != expected {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	t.Log("Relative paths")
+
+	buf.Reset()
+
+	if err := Write(
+		&buf, FormatXSPF, entries[1:], Options{RelativeTo: "/music"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(
+		buf.Bytes(),
+		[]byte("<location>Artist%202/T%C3%ADtulo%20%232.mp3</location>"),
+	) {
+		t.Errorf("Expected relative location, got:\n%s", buf.String())
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Write(&buf, "pls", entries, Options{}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestMix(t *testing.T) {
+	tracks := []track.Track{
+		{InternalID: 1, Ranking: 1400},
+		{InternalID: 2, Ranking: 1000},
+		{InternalID: 3}, // Unranked, so 1000
+	}
+
+	t.Log("No repeats, and n is respected")
+
+	rng := rand.New(rand.NewSource(1))
+
+	all := Mix(tracks, 0, 1000, rng)
+	if len(all) != 3 {
+		t.Fatalf("Expected all 3 tracks, got %+v", all)
+	}
+
+	seen := map[int]bool{}
+	for _, tr := range all {
+		seen[tr.InternalID] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected no repeats, got %+v", all)
+	}
+
+	if got := Mix(tracks, 2, 1000, rng); len(got) != 2 {
+		t.Errorf("Expected 2 tracks, got %+v", got)
+	}
+
+	t.Log("Higher rated tracks come first more often")
+
+	// 400 points = 10:1 odds, so track 1 should come first 10 times in 12
+	first := map[int]int{}
+	for i := 0; i < 12000; i++ {
+		first[Mix(tracks, 1, 1000, rng)[0].InternalID]++
+	}
+
+	if first[1] < 9500 || first[1] > 10500 {
+		t.Errorf("Expected track 1 first about 10000 times, got %v", first)
+	}
+	if first[2] < 700 || first[3] < 700 {
+		t.Errorf("Expected tracks 2 and 3 first about 1000 times, got %v", first)
+	}
+}
@@ -0,0 +1,313 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+)
+
+// Prior sources for ratings and stars set by hand. They beat anything
+// read from tags or play counts, and are kept with the annotation rather
+// than on the track. Albums and artists only get priors this way.
+const (
+	PriorSourceUserRating = "user_rating"
+	PriorSourceStarred    = "starred"
+)
+
+// A starred track, album or artist with no rating starts as if it had
+// this many stars
+const StarredStars = 4
+
+// Annotation is a rating or star set by hand on a track, album or artist
+// in a profile
+type Annotation struct {
+	Rating  int       // 1–5; 0 = not rated
+	Starred time.Time // Zero if not starred
+}
+
+// Annotations returns every track, album or artist rated or starred in
+// the profile, by ID
+func Annotations(
+	db *sql.DB, profile int, entity Entity,
+) (map[int]Annotation, error) {
+	annotations := map[int]Annotation{}
+
+	err := queryEach(db,
+		`SELECT entity_id, IFNULL(rating, 0), IFNULL(starred_at, '')
+		   FROM annotations
+		  WHERE profile_id = ?
+		    AND entity     = ?
+		    AND (rating IS NOT NULL OR starred_at IS NOT NULL)`,
+		func(rows *sql.Rows) error {
+			var id int
+			var a Annotation
+			var starred string
+
+			if err := rows.Scan(&id, &a.Rating, &starred); err != nil {
+				return err
+			}
+
+			var err error
+			if a.Starred, err = parseStarred(starred); err != nil {
+				return err
+			}
+
+			annotations[id] = a
+			return nil
+		},
+		profile,
+		entity,
+	)
+
+	return annotations, err
+}
+
+// GetAnnotation returns the rating and star of one track, album or
+// artist in the profile. Ones never annotated have a zero Annotation.
+func GetAnnotation(
+	db querier, profile int, entity Entity, id int,
+) (Annotation, error) {
+	var a Annotation
+	var starred string
+
+	err := db.QueryRow(
+		`SELECT IFNULL(rating, 0), IFNULL(starred_at, '')
+		   FROM annotations
+		  WHERE profile_id = ?
+		    AND entity     = ?
+		    AND entity_id  = ?`,
+		profile,
+		entity,
+		id,
+	).Scan(&a.Rating, &starred)
+	if err == sql.ErrNoRows {
+		return Annotation{}, nil
+	}
+	if err != nil {
+		return Annotation{}, err
+	}
+
+	a.Starred, err = parseStarred(starred)
+	return a, err
+}
+
+// SetRating rates a track, album or artist from 1 to 5 stars in the
+// profile, or clears its rating with 0. The rating becomes its prior in
+// the profile, and the profile's comparisons of the entity are replayed
+// so its ranking starts from there. Once cleared, it goes back to its
+// star, or for a track the prior from its tags.
+func SetRating(
+	db *sql.DB, profile int, entity Entity, id int, rating int,
+) error {
+	if rating < 0 || rating > 5 {
+		return fmt.Errorf("Rating must be 0-5, got %d", rating)
+	}
+
+	return annotate(db, profile, entity, id,
+		`INSERT INTO annotations
+		             (profile_id, entity, entity_id, rating)
+		      VALUES (?,?,?,NULLIF(?, 0))
+		 ON CONFLICT (profile_id, entity, entity_id) DO UPDATE
+		         SET rating = excluded.rating`,
+		rating,
+	)
+}
+
+// SetStarred stars or unstars a track, album or artist in the profile.
+// One starred without a rating starts from StarredStars.
+func SetStarred(
+	db *sql.DB, profile int, entity Entity, id int, starred bool,
+) error {
+	starredAt := sql.NullString{
+		String: time.Now().UTC().Format(time.RFC3339),
+		Valid:  starred,
+	}
+
+	return annotate(db, profile, entity, id,
+		`INSERT INTO annotations
+		             (profile_id, entity, entity_id, starred_at)
+		      VALUES (?,?,?,?)
+		 ON CONFLICT (profile_id, entity, entity_id) DO UPDATE
+		         SET starred_at = CASE WHEN excluded.starred_at IS NULL THEN NULL
+		                               ELSE IFNULL(starred_at, excluded.starred_at)
+		                          END`,
+		starredAt,
+	)
+}
+
+// Runs an upsert into annotations taking (profile_id, entity, entity_id,
+// value), then brings the entity's prior in the profile into line. The
+// profile's comparisons are only replayed if it has compared the entity
+// and where it starts from changed.
+func annotate(
+	db *sql.DB,
+	profile int,
+	entity Entity,
+	id int,
+	upsert string,
+	value interface{},
+) error {
+	queries, ok := entities[entity]
+	if !ok {
+		return fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err = tx.QueryRow(
+		"SELECT COUNT(*) > 0 FROM "+queries.table+" WHERE id = ?",
+		id,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	before, err := startingRanking(tx, profile, entity, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(upsert, profile, entity, id, value); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(
+		`DELETE FROM annotations
+		  WHERE rating       IS NULL
+		    AND starred_at   IS NULL
+		    AND listen_prior IS NULL`,
+	); err != nil {
+		return err
+	}
+
+	if err = annotationPrior(tx, profile, entity, id); err != nil {
+		return err
+	}
+
+	after, err := startingRanking(tx, profile, entity, id)
+	if err != nil {
+		return err
+	}
+
+	if after != before {
+		if err = replayAnnotatedComparisons(tx, profile, entity, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Sets the prior on an annotation in the profile from its rating, or its
+// star if it has none. Without an annotation a track's prior from its
+// tags is used.
+func annotationPrior(tx *sql.Tx, profile int, entity Entity, id int) error {
+	config, err := LoadEloConfig(tx)
+	if err != nil {
+		return err
+	}
+
+	a, err := GetAnnotation(tx, profile, entity, id)
+	if err != nil {
+		return err
+	}
+
+	var prior sql.NullFloat64
+
+	switch {
+	case a.Rating > 0:
+		prior = sql.NullFloat64{
+			Float64: elo.PriorRating(config.StartingRating, float64(a.Rating), 0),
+			Valid:   true,
+		}
+	case !a.Starred.IsZero():
+		prior = sql.NullFloat64{
+			Float64: elo.PriorRating(config.StartingRating, StarredStars, 0),
+			Valid:   true,
+		}
+	}
+
+	_, err = tx.Exec(
+		`UPDATE annotations
+		    SET prior = ?
+		  WHERE profile_id = ?
+		    AND entity     = ?
+		    AND entity_id  = ?`,
+		prior,
+		profile,
+		entity,
+		id,
+	)
+
+	return err
+}
+
+// The ranking a track, album or artist starts from in the profile before
+// its first comparison
+func startingRanking(
+	tx *sql.Tx, profile int, entity Entity, id int,
+) (float64, error) {
+	config, err := LoadEloConfig(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	queries := entities[entity]
+
+	var ranking float64
+	err = tx.QueryRow(
+		`SELECT `+queries.startingRanking+`
+		   FROM `+queries.table+` e
+		   `+rankingJoin(entity, "e")+`
+		  WHERE e.id = ?`,
+		config.StartingRating,
+		profile,
+		id,
+	).Scan(&ranking)
+
+	return ranking, err
+}
+
+// Replays the profile's comparisons of the entity if it has compared the
+// one with the ID
+func replayAnnotatedComparisons(
+	tx *sql.Tx, profile int, entity Entity, id int,
+) error {
+	var compared bool
+	if err := tx.QueryRow(
+		`SELECT EXISTS (SELECT 1
+		                  FROM comparisons        c
+		                  JOIN comparison_results cr ON cr.comparison_id = c.id
+		                 WHERE c.profile_id  = ?
+		                   AND c.entity      = ?
+		                   AND cr.entity_id  = ?)`,
+		profile,
+		entity,
+		id,
+	).Scan(&compared); err != nil {
+		return err
+	}
+
+	if !compared {
+		return nil
+	}
+
+	return replayProfileComparisons(tx, entity, profile)
+}
+
+func parseStarred(starred string) (time.Time, error) {
+	if starred == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, starred)
+}
@@ -0,0 +1,340 @@
+package repo
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestAnnotations(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Prior:         track.Prior{Plays: 3, Source: "popm_plays"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	})
+
+	if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The prior set by hand, and the one from the tags
+	priors := func(id int) (sql.NullFloat64, sql.NullFloat64) {
+		t.Helper()
+
+		var hand, tags sql.NullFloat64
+		if err := db.QueryRow(
+			`SELECT a.prior, t.prior
+			   FROM tracks t
+			   LEFT JOIN annotations a ON a.profile_id = ?
+			                          AND a.entity     = 'track'
+			                          AND a.entity_id  = t.id
+			  WHERE t.id = ?`,
+			DefaultProfile,
+			id,
+		).Scan(&hand, &tags); err != nil {
+			t.Fatal(err)
+		}
+
+		return hand, tags
+	}
+
+	// Sets a ranking by hand, to see whether it is replayed
+	setRanking := func(profile int, id int, ranking float64) {
+		t.Helper()
+
+		if _, err := db.Exec(
+			`UPDATE rankings
+			    SET ranking = ?
+			  WHERE profile_id = ?
+			    AND entity     = 'track'
+			    AND entity_id  = ?`,
+			ranking, profile, id,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Log("Rating a track replaces its prior and replays")
+
+	before, err := GetContender(db, DefaultProfile, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = SetRating(db, DefaultProfile, EntityTrack, 2, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if hand, _ := priors(2); hand.Float64 != 1200 {
+		t.Errorf("Expected prior 1200, got %v", hand)
+	}
+
+	after, err := GetContender(db, DefaultProfile, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Ranking-before.Ranking < 150 {
+		t.Errorf(
+			"Expected ranking to start from the rating, went from %v to %v",
+			before.Ranking, after.Ranking,
+		)
+	}
+
+	a, err := GetAnnotation(db, DefaultProfile, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Rating != 5 || !a.Starred.IsZero() {
+		t.Errorf("Expected 5 stars, not starred, got %+v", a)
+	}
+
+	t.Log("Stars count for less than a rating")
+
+	// Starting from the same rating, so not replayed
+	setRanking(DefaultProfile, 2, 1234)
+
+	if err = SetStarred(db, DefaultProfile, EntityTrack, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if hand, _ := priors(2); hand.Float64 != 1200 {
+		t.Errorf("Expected rating to beat star, got prior %v", hand)
+	}
+	if after, err = GetContender(db, DefaultProfile, EntityTrack, 2); err != nil {
+		t.Fatal(err)
+	}
+	if after.Ranking != 1234 {
+		t.Errorf("Expected no replay, got ranking %v", after.Ranking)
+	}
+
+	if err = SetRating(db, DefaultProfile, EntityTrack, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if hand, _ := priors(2); hand.Float64 != 1100 {
+		t.Errorf("Expected prior 1100 from the star, got %v", hand)
+	}
+
+	t.Log("Unstarring drops the prior")
+
+	if err = SetStarred(db, DefaultProfile, EntityTrack, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if hand, tags := priors(2); hand.Valid || tags.Valid {
+		t.Errorf("Expected no prior, got %v and %v from tags", hand, tags)
+	}
+
+	annotations, err := Annotations(db, DefaultProfile, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 0 {
+		t.Errorf("Expected no annotations left, got %+v", annotations)
+	}
+
+	t.Log("Rescans don't replace ratings set by hand")
+
+	_, tagPrior := priors(1)
+
+	if err = SetRating(db, DefaultProfile, EntityTrack, 1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Prior:         track.Prior{Plays: 30, Source: "popm_plays"},
+		}),
+	})
+
+	hand, tags := priors(1)
+	if hand.Float64 != 900 || tags.Float64 <= tagPrior.Float64 {
+		t.Errorf(
+			"Expected prior 900 and more plays in the tags, got %v and %v",
+			hand, tags,
+		)
+	}
+
+	t.Log("Clearing a rating goes back to the prior from the tags")
+
+	if err = SetRating(db, DefaultProfile, EntityTrack, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var source string
+	if err = db.QueryRow(
+		"SELECT prior_source FROM tracks WHERE id = 1",
+	).Scan(&source); err != nil {
+		t.Fatal(err)
+	}
+
+	rescanned := tags
+	if hand, tags = priors(1); hand.Valid || tags != rescanned || source != "popm_plays" {
+		t.Errorf(
+			"Expected the prior from popm_plays, got %v and %v from %s",
+			hand, tags, source,
+		)
+	}
+
+	cleared, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleared.Ranking <= elo.DefaultStartingRating {
+		t.Errorf("Expected the plays to lift the ranking, got %v", cleared.Ranking)
+	}
+
+	sources, err := PriorSources(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(map[string]int{"popm_plays": 1}, sources) {
+		t.Errorf("Expected only the tag prior, got %v", sources)
+	}
+
+	t.Log("Ratings are the profile's own")
+
+	sam, err := CreateProfile(db, "Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RecordComparison(db, sam.ID, EntityTrack, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	setRanking(sam.ID, 1, 1234)
+	setRanking(DefaultProfile, 1, 1234)
+
+	if err = SetRating(db, sam.ID, EntityTrack, 1, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	samsTrack, err := GetContender(db, sam.ID, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samsTrack.Ranking == 1234 || samsTrack.Ranking < 1200 {
+		t.Errorf("Expected Sam's rankings replayed from 1200, got %v", samsTrack.Ranking)
+	}
+
+	ownTrack, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ownTrack.Ranking != 1234 {
+		t.Errorf("Expected the default profile left alone, got %v", ownTrack.Ranking)
+	}
+
+	if hand, _ := priors(1); hand.Valid {
+		t.Errorf("Expected no prior in the default profile, got %v", hand)
+	}
+	if a, err = GetAnnotation(db, DefaultProfile, EntityTrack, 1); err != nil {
+		t.Fatal(err)
+	}
+	if a.Rating != 0 {
+		t.Errorf("Expected no rating in the default profile, got %+v", a)
+	}
+
+	t.Log("Profiles that haven't compared the track aren't replayed")
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{MusicBrainzID: "MB3", Title: "Title 3"}),
+	})
+	if err = SetRating(db, sam.ID, EntityTrack, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+	setRanking(sam.ID, 1, 1234)
+	if err = SetRating(db, sam.ID, EntityTrack, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if samsTrack, err = GetContender(db, sam.ID, EntityTrack, 1); err != nil {
+		t.Fatal(err)
+	}
+	if samsTrack.Ranking != 1234 {
+		t.Errorf("Expected no replay, got ranking %v", samsTrack.Ranking)
+	}
+
+	if err = DeleteProfile(db, sam.ID); err != nil {
+		t.Fatal(err)
+	}
+	if annotations, err = Annotations(db, sam.ID, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 0 {
+		t.Errorf("Expected Sam's ratings deleted with the profile, got %+v", annotations)
+	}
+
+	t.Log("Albums and artists can be starred too")
+
+	if err = SetStarred(db, DefaultProfile, EntityAlbum, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetStarred(db, DefaultProfile, EntityAlbum, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, err = Annotations(db, DefaultProfile, EntityAlbum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 1 || annotations[1].Starred.IsZero() {
+		t.Errorf("Expected album 1 starred, got %+v", annotations)
+	}
+
+	t.Log("Their ratings and stars are their priors")
+
+	config := elo.DefaultConfig()
+
+	album, err := GetContender(db, DefaultProfile, EntityAlbum, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := elo.PriorRating(config.StartingRating, StarredStars, 0); album.Ranking != expected {
+		t.Errorf("Expected starred album at %v, got %v", expected, album.Ranking)
+	}
+
+	if err = SetRating(db, DefaultProfile, EntityArtist, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RecordComparison(db, DefaultProfile, EntityArtist, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetRating(db, DefaultProfile, EntityArtist, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	artist, err := GetContender(db, DefaultProfile, EntityArtist, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artist.Ranking <= config.StartingRating || artist.Comparisons != 1 {
+		t.Errorf("Expected replay from the starting rating, got %+v", artist)
+	}
+
+	t.Log("Invalid")
+
+	if err = SetRating(db, DefaultProfile, EntityTrack, 1, 6); err == nil {
+		t.Error("Expected error for 6 stars")
+	}
+	if err = SetStarred(db, DefaultProfile, EntityArtist, 99, true); err != sql.ErrNoRows {
+		t.Errorf("Expected no rows for missing artist, got %v", err)
+	}
+}
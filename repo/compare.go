@@ -0,0 +1,905 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+)
+
+// Entity is the kind of thing being compared
+type Entity string
+
+const (
+	EntityTrack  Entity = "track"
+	EntityAlbum  Entity = "album"
+	EntityArtist Entity = "artist"
+)
+
+// How many of the closest-ranked candidates to pick opponents from, at
+// minimum
+const matchmakingPoolSize = 5
+
+// A track with a prior from its tags or set by hand already has a rough
+// ranking, so counts as this many comparisons when picking what to
+// compare next, as do albums and artists rated or starred by hand.
+// Unrated ones are picked first.
+const PriorComparisons = 2
+
+// Most contenders that can be put in order in one comparison
+const MaxRankedContenders = 5
+
+// Source of comparisons voted on. Comparisons inferred from listening
+// are recorded with the name of what inferred them instead.
+const ComparisonSourceVote = "vote"
+
+var (
+	ErrNotEnoughContenders = errors.New("Need at least two to compare")
+	ErrNothingToUndo       = errors.New("No comparisons to undo")
+)
+
+type entityQueries struct {
+	table string
+
+	// Full-text index, with rowid = id in table
+	search string
+
+	// Selects (id, name, detail, ranking, comparisons) from table as 'e',
+	// with its ranking in a profile as 'r'. Takes the configured starting
+	// rating, then the profile ID.
+	contenders string
+
+	// e's ranking in r; NULL if unranked
+	ranking string
+
+	// Ranking before the first comparison, given the configured starting
+	// rating as its one parameter
+	startingRanking string
+
+	// Comparisons a contender counts as having had when picking the least
+	// compared, from e
+	effectiveComparisons string
+
+	// Whether e has imported plays in the profile 'p'. Of the least
+	// compared, contenders that have been listened to are picked first.
+	listenedTo string
+}
+
+var entities = map[Entity]entityQueries{
+	EntityTrack: {
+		table:  "tracks",
+		search: "tracks_search",
+		contenders: `SELECT e.id,
+		                    IFNULL(e.title, ''),
+		                    IFNULL((SELECT ar.name
+		                              FROM track_artist tar
+		                              JOIN artists      ar  ON ar.id = tar.artist_id
+		                             WHERE tar.track_id = e.id
+		                               AND tar.is_primary_artist = 1), ''),
+		                    IFNULL(r.ranking, IFNULL(` + trackPrior("e") + `, ?)),
+		                    IFNULL(r.comparisons, 0)
+		               FROM tracks e
+		               ` + rankingJoin(EntityTrack, "e"),
+		ranking:         "IFNULL(r.ranking, " + trackPrior("e") + ")",
+		startingRanking: "IFNULL(" + trackPrior("e") + ", ?)",
+		effectiveComparisons: "IFNULL(r.comparisons, 0) + " +
+			"(" + trackPrior("e") + " IS NOT NULL) * " +
+			strconv.Itoa(PriorComparisons),
+		listenedTo: `EXISTS (SELECT 1
+		                       FROM listens l
+		                      WHERE l.profile_id = p.id
+		                        AND l.track_id   = e.id)`,
+	},
+	EntityAlbum: {
+		table:  "albums",
+		search: "albums_search",
+		contenders: `SELECT e.id,
+		                    IFNULL(e.title, ''),
+		                    IFNULL((SELECT GROUP_CONCAT(DISTINCT ar.name)
+		                              FROM track_album  tal
+		                              JOIN track_artist tar ON tar.track_id = tal.track_id
+		                              JOIN artists      ar  ON ar.id = tar.artist_id
+		                             WHERE tal.album_id = e.id
+		                               AND tar.is_primary_artist = 1), ''),
+		                    IFNULL(r.ranking, IFNULL(a.prior, ?)),
+		                    IFNULL(r.comparisons, 0)
+		               FROM albums e
+		               ` + rankingJoin(EntityAlbum, "e"),
+		ranking:              "IFNULL(r.ranking, a.prior)",
+		startingRanking:      "IFNULL(a.prior, ?)",
+		effectiveComparisons: annotatedComparisons,
+		listenedTo: `EXISTS (SELECT 1
+		                       FROM track_album tal
+		                       JOIN listens     l   ON l.track_id = tal.track_id
+		                      WHERE l.profile_id = p.id
+		                        AND tal.album_id = e.id)`,
+	},
+	EntityArtist: {
+		table:  "artists",
+		search: "artists_search",
+		contenders: `SELECT e.id,
+		                    IFNULL(e.name, ''),
+		                    '',
+		                    IFNULL(r.ranking, IFNULL(a.prior, ?)),
+		                    IFNULL(r.comparisons, 0)
+		               FROM artists e
+		               ` + rankingJoin(EntityArtist, "e"),
+		ranking:              "IFNULL(r.ranking, a.prior)",
+		startingRanking:      "IFNULL(a.prior, ?)",
+		effectiveComparisons: annotatedComparisons,
+		listenedTo: `EXISTS (SELECT 1
+		                       FROM track_artist tar
+		                       JOIN listens      l   ON l.track_id = tar.track_id
+		                      WHERE l.profile_id  = p.id
+		                        AND tar.artist_id = e.id)`,
+	},
+}
+
+// A track's prior in a profile, from its annotation 'a' and the track
+// alias: set by hand, then from the profile's plays unless the tags have
+// a star rating, then from the tags. NULL if it has none.
+func trackPrior(alias string) string {
+	return `COALESCE(a.prior,
+	                 CASE WHEN IFNULL(` + alias + `.prior_source, '') IN ('', ` +
+		playCountPriorSourceList + `)
+	                      THEN a.listen_prior
+	                 END,
+	                 ` + alias + `.prior)`
+}
+
+// Comparisons an album or artist counts as having had, from its ranking
+// 'r' and its annotation 'a'
+var annotatedComparisons = "IFNULL(r.comparisons, 0) + " +
+	"(a.prior IS NOT NULL) * " + strconv.Itoa(PriorComparisons)
+
+// Joins the rankings of the entity in table alias in a profile, given as
+// a parameter, as 'r', with the profile ID as 'p', and its annotation in
+// the profile as 'a', for the prior set by hand
+func rankingJoin(entity Entity, alias string) string {
+	return `CROSS JOIN (SELECT ? AS id) p
+	          LEFT JOIN rankings r ON r.profile_id = p.id
+	                              AND r.entity     = '` + string(entity) + `'
+	                              AND r.entity_id  = ` + alias + `.id
+	          LEFT JOIN annotations a ON a.profile_id = p.id
+	                                 AND a.entity     = '` + string(entity) + `'
+	                                 AND a.entity_id  = ` + alias + `.id`
+}
+
+// Contender is a track, album or artist that can be compared against
+// another of the same kind
+type Contender struct {
+	InternalID int
+	Name       string // Track/album title or artist name
+	Detail     string // Primary artist(s), if any
+
+	Ranking     float64
+	Comparisons int
+}
+
+// NextPair picks two contenders to compare. See NextGroup.
+func NextPair(db *sql.DB, profile int, entity Entity) (
+	Contender, Contender, error,
+) {
+	group, err := NextGroup(db, profile, entity, 2)
+	if err != nil {
+		return Contender{}, Contender{}, err
+	}
+
+	return group[0], group[1], nil
+}
+
+// NextGroup picks size contenders to compare. The first is one of the
+// least compared, so everything gets a look in, preferring ones with
+// imported plays; the rest are picked from those ranked closest to the
+// first, as they are the most informative comparisons. Rankings and
+// comparisons are those of the profile.
+func NextGroup(
+	db *sql.DB, profile int, entity Entity, size int,
+) ([]Contender, error) {
+	queries, ok := entities[entity]
+	if !ok {
+		return nil, fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	if size < 2 || size > MaxRankedContenders {
+		return nil, fmt.Errorf(
+			"Can compare 2-%d %ss at once, not %d",
+			MaxRankedContenders, entity, size,
+		)
+	}
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := scanContender(db.QueryRow(
+		queries.contenders+`
+		 ORDER BY `+queries.effectiveComparisons+`,
+		          `+queries.listenedTo+` DESC,
+		          RANDOM()
+		 LIMIT 1`,
+		config.StartingRating,
+		profile,
+	))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotEnoughContenders
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	poolSize := matchmakingPoolSize
+	if 2*(size-1) > poolSize {
+		poolSize = 2 * (size - 1)
+	}
+
+	// Pick at random from the closest few, otherwise the same groups
+	// would come up again and again
+	rows, err := db.Query(
+		`SELECT * FROM (
+		     `+queries.contenders+`
+		      WHERE e.id != ?
+		      ORDER BY ABS(IFNULL(`+queries.ranking+`, ?) - ?)
+		      LIMIT ?
+		 )
+		 ORDER BY RANDOM()
+		 LIMIT ?`,
+		config.StartingRating,
+		profile,
+		first.InternalID,
+		config.StartingRating,
+		first.Ranking,
+		poolSize,
+		size-1,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	group := []Contender{first}
+	for rows.Next() {
+		c, err := scanContender(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		group = append(group, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(group) < size {
+		return nil, ErrNotEnoughContenders
+	}
+
+	return group, nil
+}
+
+// GetContender fetches a single track, album or artist by internal ID,
+// with its ranking in the profile
+func GetContender(
+	db *sql.DB, profile int, entity Entity, id int,
+) (Contender, error) {
+	queries, ok := entities[entity]
+	if !ok {
+		return Contender{}, fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return Contender{}, err
+	}
+
+	return scanContender(db.QueryRow(
+		queries.contenders+" WHERE e.id = ?",
+		config.StartingRating,
+		profile,
+		id,
+	))
+}
+
+// ListContenders returns every track, album or artist, ordered by name,
+// with their rankings in the profile
+func ListContenders(
+	db *sql.DB, profile int, entity Entity,
+) ([]Contender, error) {
+	queries, ok := entities[entity]
+	if !ok {
+		return nil, fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return nil, err
+	}
+
+	contenders := []Contender{}
+
+	err = queryEach(db,
+		queries.contenders+" ORDER BY 2 COLLATE NOCASE, e.id",
+		func(rows *sql.Rows) error {
+			c, err := scanContender(rows)
+			if err != nil {
+				return err
+			}
+
+			contenders = append(contenders, c)
+			return nil
+		},
+		config.StartingRating,
+		profile,
+	)
+
+	return contenders, err
+}
+
+// RecordComparison updates the rankings of a and b given a's score
+// (0 = loss, 0.5 = draw, 1 = win) and adds the comparison to the
+// profile's match history. Returns the ID of the new comparison.
+func RecordComparison(
+	db *sql.DB, profile int, entity Entity, aID int, bID int, scoreA float64,
+) (int64, error) {
+	return recordComparison(
+		db, profile, entity, aID, bID,
+		elo.Outcome{ScoreA: scoreA, Multiplier: 1}, nil, ComparisonSourceVote,
+	)
+}
+
+// RecordGradedComparison is RecordComparison for a graded preference
+// between 0 (strongest preference for b) and 1 (strongest preference for
+// a), mapped to an outcome by the stored grade scale. An n-point scale's
+// grade i is preference i/(n-1).
+func RecordGradedComparison(
+	db *sql.DB,
+	profile int,
+	entity Entity,
+	aID int,
+	bID int,
+	preference float64,
+) (int64, error) {
+	scale, err := LoadGradeScale(db)
+	if err != nil {
+		return 0, err
+	}
+
+	outcome, err := scale.Preference(preference)
+	if err != nil {
+		return 0, err
+	}
+
+	return recordComparison(
+		db, profile, entity, aID, bID, outcome, &preference,
+		ComparisonSourceVote,
+	)
+}
+
+// RecordRankedComparison records several contenders put in order at
+// once, best first, as a single comparison. See
+// elo.Config.CalculatePlacedRankings for how rankings are updated.
+func RecordRankedComparison(
+	db *sql.DB, profile int, entity Entity, orderedIDs []int,
+) (int64, error) {
+	if _, ok := entities[entity]; !ok {
+		return 0, fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	if len(orderedIDs) < 2 || len(orderedIDs) > MaxRankedContenders {
+		return 0, fmt.Errorf(
+			"Can rank 2-%d %ss at once, got %d",
+			MaxRankedContenders, entity, len(orderedIDs),
+		)
+	}
+
+	seen := map[int]bool{}
+	for _, id := range orderedIDs {
+		if seen[id] {
+			return 0, fmt.Errorf("%s %d ranked more than once", entity, id)
+		}
+		seen[id] = true
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	config, err := LoadEloConfig(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO comparisons
+		            (profile_id, entity)
+		     VALUES (?,?)`,
+		profile,
+		entity,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	comparisonID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range orderedIDs {
+		// Stored score is the share of pairwise comparisons won
+		score := float64(len(orderedIDs)-1-i) / float64(len(orderedIDs)-1)
+
+		if _, err = tx.Exec(
+			`INSERT INTO comparison_results
+			            (comparison_id, entity_id, score, place)
+			     VALUES (?,?,?,?)`,
+			comparisonID,
+			id,
+			score,
+			i+1,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = rateComparison(tx, config, entity, comparisonID); err != nil {
+		return 0, err
+	}
+
+	return comparisonID, tx.Commit()
+}
+
+func recordComparison(
+	db *sql.DB,
+	profile int,
+	entity Entity,
+	aID int,
+	bID int,
+	outcome elo.Outcome,
+	preference *float64,
+	source string,
+) (int64, error) {
+	if _, ok := entities[entity]; !ok {
+		return 0, fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	if aID == bID {
+		return 0, fmt.Errorf("Cannot compare %s %d against itself", entity, aID)
+	}
+
+	if outcome.ScoreA < 0 || outcome.ScoreA > 1 {
+		return 0, fmt.Errorf("Score %v out of range", outcome.ScoreA)
+	}
+
+	if outcome.Multiplier <= 0 {
+		return 0, fmt.Errorf("Multiplier %v must be positive", outcome.Multiplier)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	config, err := LoadEloConfig(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO comparisons
+		            (profile_id, entity, preference, multiplier, source)
+		     VALUES (?,?,?,?,?)`,
+		profile,
+		entity,
+		preference,
+		outcome.Multiplier,
+		source,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	comparisonID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, result := range []struct {
+		id    int
+		score float64
+	}{
+		{aID, outcome.ScoreA},
+		{bID, 1 - outcome.ScoreA},
+	} {
+		if _, err = tx.Exec(
+			`INSERT INTO comparison_results
+			            (comparison_id, entity_id, score)
+			     VALUES (?,?,?)`,
+			comparisonID,
+			result.id,
+			result.score,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = rateComparison(tx, config, entity, comparisonID); err != nil {
+		return 0, err
+	}
+
+	return comparisonID, tx.Commit()
+}
+
+// ReplayComparisons resets every profile's rankings for the entity to
+// the starting rating and replays the match history, oldest first, using
+// the stored Elo config. Use after changing the config.
+func ReplayComparisons(db *sql.DB, entity Entity) error {
+	if _, ok := entities[entity]; !ok {
+		return fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = replayComparisons(tx, entity); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Each comparison is rated within its own profile, so replaying them all
+// in order replays every profile at once
+func replayComparisons(tx *sql.Tx, entity Entity) error {
+	return replay(tx, entity, "entity = ?", entity)
+}
+
+// Replays one profile's comparisons, leaving the others' rankings alone
+func replayProfileComparisons(tx *sql.Tx, entity Entity, profile int) error {
+	return replay(
+		tx, entity, "entity = ? AND profile_id = ?", entity, profile,
+	)
+}
+
+// Resets the rankings matching where, over rankings and comparisons, and
+// replays the matching comparisons. Comparisons merged from another DB
+// are added after the existing ones, so the order is by time first.
+func replay(
+	tx *sql.Tx, entity Entity, where string, args ...interface{},
+) error {
+	config, err := LoadEloConfig(tx)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(
+		"DELETE FROM rankings WHERE "+where,
+		args...,
+	); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		`SELECT id
+		   FROM comparisons
+		  WHERE `+where+`
+		  ORDER BY created_at, id`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+
+	var comparisonIDs []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		comparisonIDs = append(comparisonIDs, id)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range comparisonIDs {
+		if err = rateComparison(tx, config, entity, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UndoLastComparison reverts the profile's most recent vote for the
+// entity: its contenders go back to their rankings from before it and it
+// is removed from the match history. Only the latest vote can be undone.
+// Implicit comparisons recorded since were rated from the rankings it
+// produced, so if there are any the history is replayed without it.
+// Latest means by time, as replay orders it: merged comparisons can have
+// higher IDs than later ones, and having been rated after it they also
+// mean a replay.
+// Returns the ID of the removed comparison.
+func UndoLastComparison(db *sql.DB, profile int, entity Entity) (int64, error) {
+	if _, ok := entities[entity]; !ok {
+		return 0, fmt.Errorf("Unknown entity '%s'", entity)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var comparisonID int64
+	var later bool
+	err = tx.QueryRow(
+		`SELECT c.id,
+		        EXISTS (SELECT 1
+		                  FROM comparisons l
+		                 WHERE l.profile_id = c.profile_id
+		                   AND l.entity     = c.entity
+		                   AND (l.created_at > c.created_at
+		                        OR l.id > c.id))
+		   FROM comparisons c
+		  WHERE c.profile_id = ?
+		    AND c.entity     = ?
+		    AND c.source     = ?
+		  ORDER BY c.created_at DESC, c.id DESC
+		  LIMIT 1`,
+		profile,
+		entity,
+		ComparisonSourceVote,
+	).Scan(&comparisonID, &later)
+	if err == sql.ErrNoRows {
+		return 0, ErrNothingToUndo
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if later {
+		if err = deleteComparison(tx, comparisonID); err != nil {
+			return 0, err
+		}
+		if err = replayProfileComparisons(tx, entity, profile); err != nil {
+			return 0, err
+		}
+
+		return comparisonID, tx.Commit()
+	}
+
+	if _, err = tx.Exec(
+		`UPDATE rankings
+		    SET ranking     = (SELECT cr.ranking_before
+		                         FROM comparison_results cr
+		                        WHERE cr.comparison_id = ?
+		                          AND cr.entity_id     = rankings.entity_id),
+		        comparisons = comparisons - 1
+		  WHERE profile_id = ?
+		    AND entity     = ?
+		    AND entity_id IN (SELECT entity_id
+		                        FROM comparison_results
+		                       WHERE comparison_id = ?)`,
+		comparisonID,
+		profile,
+		entity,
+		comparisonID,
+	); err != nil {
+		return 0, err
+	}
+
+	// Back to never compared
+	if _, err = tx.Exec(
+		`DELETE FROM rankings
+		  WHERE profile_id  = ?
+		    AND entity      = ?
+		    AND comparisons = 0`,
+		profile,
+		entity,
+	); err != nil {
+		return 0, err
+	}
+
+	if err = deleteComparison(tx, comparisonID); err != nil {
+		return 0, err
+	}
+
+	return comparisonID, tx.Commit()
+}
+
+func deleteComparison(tx *sql.Tx, comparisonID int64) error {
+	// Don't rely on foreign keys being enabled for the cascade
+	if _, err := tx.Exec(
+		"DELETE FROM comparison_results WHERE comparison_id = ?",
+		comparisonID,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		"DELETE FROM comparisons WHERE id = ?",
+		comparisonID,
+	)
+	return err
+}
+
+type contenderResult struct {
+	entityID    int
+	score       float64
+	place       sql.NullInt64
+	ranking     float64
+	comparisons int
+}
+
+// Applies the scores or places already stored in comparison_results for a
+// comparison: updates the rankings and comparison counts of the
+// contenders, and fills in the K and before/after rankings of each result
+func rateComparison(
+	tx *sql.Tx, config elo.Config, entity Entity, comparisonID int64,
+) error {
+	queries := entities[entity]
+
+	var profile int
+	var multiplier float64
+	if err := tx.QueryRow(
+		"SELECT profile_id, multiplier FROM comparisons WHERE id = ?",
+		comparisonID,
+	).Scan(&profile, &multiplier); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		`SELECT cr.entity_id,
+		        cr.score,
+		        cr.place,
+		        IFNULL(r.ranking, `+queries.startingRanking+`),
+		        IFNULL(r.comparisons, 0)
+		   FROM comparison_results cr
+		   JOIN `+queries.table+` e ON e.id = cr.entity_id
+		   `+rankingJoin(entity, "e")+`
+		  WHERE cr.comparison_id = ?
+		  ORDER BY cr.rowid`,
+		config.StartingRating,
+		profile,
+		comparisonID,
+	)
+	if err != nil {
+		return err
+	}
+
+	var results []contenderResult
+	for rows.Next() {
+		var r contenderResult
+		if err = rows.Scan(
+			&r.entityID, &r.score, &r.place, &r.ranking, &r.comparisons,
+		); err != nil {
+			rows.Close()
+			return err
+		}
+
+		results = append(results, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	// Contenders missing from the entity table are dropped by the join
+	var expected int
+	if err = tx.QueryRow(
+		"SELECT COUNT(*) FROM comparison_results WHERE comparison_id = ?",
+		comparisonID,
+	).Scan(&expected); err != nil {
+		return err
+	}
+	if len(results) != expected {
+		return fmt.Errorf(
+			"Comparison %d: %d of its %ss do not exist",
+			comparisonID, expected-len(results), entity,
+		)
+	}
+
+	players := make([]elo.Elo, len(results))
+	places := make([]int, len(results))
+	placed := true
+
+	for i, r := range results {
+		players[i] = elo.Elo{
+			CurrentRanking: r.ranking,
+			Score:          r.score,
+			Comparisons:    r.comparisons,
+		}
+
+		places[i] = int(r.place.Int64)
+		placed = placed && r.place.Valid
+	}
+
+	var newRankings []float64
+
+	switch {
+	case placed:
+		newRankings, err = config.CalculatePlacedRankings(
+			players, places, multiplier,
+		)
+		if err != nil {
+			return fmt.Errorf("Comparison %d: %s", comparisonID, err)
+		}
+	case len(results) == 2:
+		newRankA, newRankB := config.CalculateNewRankingsWithMultiplier(
+			players[0], players[1], multiplier,
+		)
+		newRankings = []float64{newRankA, newRankB}
+	default:
+		return fmt.Errorf(
+			"Comparison %d has %d %ss but no places",
+			comparisonID, len(results), entity,
+		)
+	}
+
+	// K is divided between opponents in multi-way comparisons
+	opponents := float64(len(results) - 1)
+
+	for i, r := range results {
+		if _, err = tx.Exec(
+			`INSERT INTO rankings
+			             (profile_id, entity, entity_id, ranking, comparisons)
+			      VALUES (?,?,?,?,1)
+			 ON CONFLICT (profile_id, entity, entity_id)
+			   DO UPDATE SET ranking     = excluded.ranking,
+			                 comparisons = comparisons + 1`,
+			profile,
+			entity,
+			r.entityID,
+			newRankings[i],
+		); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(
+			`UPDATE comparison_results
+			    SET k              = ?,
+			        ranking_before = ?,
+			        ranking_after  = ?
+			  WHERE comparison_id = ?
+			    AND entity_id     = ?`,
+			config.KFactor(r.comparisons, r.ranking)/opponents,
+			r.ranking,
+			newRankings[i],
+			comparisonID,
+			r.entityID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanContender(row scanner) (Contender, error) {
+	var c Contender
+
+	err := row.Scan(
+		&c.InternalID,
+		&c.Name,
+		&c.Detail,
+		&c.Ranking,
+		&c.Comparisons,
+	)
+
+	return c, err
+}
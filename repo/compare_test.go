@@ -0,0 +1,645 @@
+package repo
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestRecordComparison(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	for _, entity := range []Entity{EntityTrack, EntityAlbum, EntityArtist} {
+		t.Logf("%s: win for A", entity)
+
+		comparisonID, err := RecordComparison(db, DefaultProfile, entity, 1, 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, err := GetContender(db, DefaultProfile, entity, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := GetContender(db, DefaultProfile, entity, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Equal rankings, so expected score is 0.5 and K/2 changes hands
+		if a.Ranking != 1016 || a.Comparisons != 1 {
+			t.Errorf("Expected A ranking 1016 after 1 comparison, got %+v", a)
+		}
+		if b.Ranking != 984 || b.Comparisons != 1 {
+			t.Errorf("Expected B ranking 984 after 1 comparison, got %+v", b)
+		}
+
+		var gotEntity string
+		if err = db.QueryRow(
+			"SELECT entity FROM comparisons WHERE id = ?",
+			comparisonID,
+		).Scan(&gotEntity); err != nil {
+			t.Fatal(err)
+		}
+		if gotEntity != string(entity) {
+			t.Errorf("Expected entity '%s', got '%s'", entity, gotEntity)
+		}
+
+		expected := [][]float64{
+			{1, 1, 1000, 1016},
+			{2, 0, 1000, 984},
+		}
+		if got := readResults(t, db, comparisonID); !reflect.DeepEqual(expected, got) {
+			t.Errorf("\nExpected:\n%v\ngot:\n%v", expected, got)
+		}
+	}
+
+	t.Log("Album details list the primary artists")
+
+	album, err := GetContender(db, DefaultProfile, EntityAlbum, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if album.Name != "Album 2" || album.Detail != "Artist 2" {
+		t.Errorf("Expected Album 2 by Artist 2, got %+v", album)
+	}
+
+	t.Log("Invalid comparisons")
+
+	if _, err = RecordComparison(db, DefaultProfile, EntityAlbum, 1, 1, 1); err == nil {
+		t.Error("Expected error comparing album with itself")
+	}
+	if _, err = RecordComparison(db, DefaultProfile, EntityAlbum, 1, 3, 1); err == nil {
+		t.Error("Expected error comparing with missing album")
+	}
+	if _, err = RecordComparison(db, DefaultProfile, EntityAlbum, 1, 2, 2); err == nil {
+		t.Error("Expected error for out of range score")
+	}
+	if _, err = RecordComparison(db, DefaultProfile, "genre", 1, 2, 1); err == nil {
+		t.Error("Expected error for unknown entity")
+	}
+}
+
+func TestNextPair(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	t.Log("Nothing to compare")
+
+	if _, _, err := NextPair(db, DefaultProfile, EntityArtist); err != ErrNotEnoughContenders {
+		t.Errorf("Expected ErrNotEnoughContenders, got %v", err)
+	}
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	})
+
+	t.Log("Only one artist")
+
+	if _, _, err := NextPair(db, DefaultProfile, EntityArtist); err != ErrNotEnoughContenders {
+		t.Errorf("Expected ErrNotEnoughContenders, got %v", err)
+	}
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+	})
+
+	if _, err := RecordComparison(db, DefaultProfile, EntityArtist, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Least compared artist goes first")
+
+	for i := 0; i < 10; i++ {
+		a, b, err := NextPair(db, DefaultProfile, EntityArtist)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.InternalID != 3 {
+			t.Errorf("Expected artist 3 first, got %+v", a)
+		}
+		if b.InternalID == a.InternalID {
+			t.Errorf("Artist %d paired with itself", a.InternalID)
+		}
+	}
+}
+
+func TestReplayComparisons(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	t.Log("Default config until one is saved")
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(elo.DefaultConfig(), config) {
+		t.Errorf("Expected default config, got %+v", config)
+	}
+
+	comparisonID, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Invalid config is rejected")
+
+	config.Floor = 2000
+	if err = SaveEloConfig(db, config); err == nil {
+		t.Error("Expected error saving invalid config")
+	}
+
+	t.Log("Replay with a new config")
+
+	config = elo.DefaultConfig()
+	config.StartingRating = 1500
+	config.ProvisionalK = 64
+	config.ProvisionalComparisons = 1
+
+	if err = SaveEloConfig(db, config); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadEloConfig(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, got) {
+		t.Errorf("\nExpected:\n%+v\ngot:\n%+v", config, got)
+	}
+
+	if err = ReplayComparisons(db, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]float64{
+		{1, 1, 1500, 1532},
+		{2, 0, 1500, 1468},
+	}
+	if got := readResults(t, db, comparisonID); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	var k float64
+	if err = db.QueryRow(
+		"SELECT k FROM comparison_results WHERE comparison_id = ? LIMIT 1",
+		comparisonID,
+	).Scan(&k); err != nil {
+		t.Fatal(err)
+	}
+	if k != 64 {
+		t.Errorf("Expected provisional K 64 to be recorded, got %v", k)
+	}
+
+	a, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Ranking != 1532 || a.Comparisons != 1 {
+		t.Errorf("Expected track 1 at 1532 after 1 comparison, got %+v", a)
+	}
+
+	t.Log("New tracks start at the configured rating")
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+	})
+
+	c, err := GetContender(db, DefaultProfile, EntityTrack, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Ranking != 1500 {
+		t.Errorf("Expected track 3 to start at 1500, got %v", c.Ranking)
+	}
+}
+
+// Returns (entity ID, score, ranking before, ranking after) per result
+func readResults(t *testing.T, db *sql.DB, comparisonID int64) [][]float64 {
+	rows, err := db.Query(
+		`SELECT entity_id, score, ranking_before, ranking_after
+		   FROM comparison_results
+		  WHERE comparison_id = ?
+		  ORDER BY entity_id`,
+		comparisonID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	results := [][]float64{}
+	for rows.Next() {
+		result := make([]float64, 4)
+
+		if err = rows.Scan(
+			&result[0], &result[1], &result[2], &result[3],
+		); err != nil {
+			t.Fatal(err)
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func TestRecordGradedComparison(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	t.Log("Strong preference with the default scale")
+
+	comparisonID, err := RecordGradedComparison(db, DefaultProfile, EntityTrack, 1, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// K of 32 multiplied by 1.5
+	expected := [][]float64{
+		{1, 1, 1000, 1024},
+		{2, 0, 1000, 976},
+	}
+	if got := readResults(t, db, comparisonID); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	var preference, multiplier float64
+	if err = db.QueryRow(
+		"SELECT preference, multiplier FROM comparisons WHERE id = ?",
+		comparisonID,
+	).Scan(&preference, &multiplier); err != nil {
+		t.Fatal(err)
+	}
+	if preference != 1 || multiplier != 1.5 {
+		t.Errorf(
+			"Expected preference 1 and multiplier 1.5 stored, got %v and %v",
+			preference, multiplier,
+		)
+	}
+
+	t.Log("Fractional scores with a custom scale")
+
+	if err = SaveGradeScale(db, elo.GradeScale{
+		Points: []elo.Outcome{
+			{ScoreA: 0, Multiplier: 1},
+			{ScoreA: 1, Multiplier: 1},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// On a two-point scale the preference is the score
+	comparisonID, err = RecordGradedComparison(db, DefaultProfile, EntityTrack, 2, 1, 0.75)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := readResults(t, db, comparisonID)
+	if results[0][1] != 0.25 || results[1][1] != 0.75 {
+		t.Errorf("Expected scores 0.25 and 0.75, got %v", results)
+	}
+
+	t.Log("Replay uses the recorded multiplier, not the current scale")
+
+	if err = ReplayComparisons(db, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readResults(t, db, 1); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	t.Log("Invalid input")
+
+	if _, err = RecordGradedComparison(db, DefaultProfile, EntityTrack, 1, 2, 1.5); err == nil {
+		t.Error("Expected error for preference out of range")
+	}
+	if err = SaveGradeScale(db, elo.GradeScale{}); err == nil {
+		t.Error("Expected error saving empty scale")
+	}
+}
+
+func TestRecordRankedComparison(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	for _, mbid := range []string{"MB1", "MB2", "MB3", "MB4"} {
+		SaveTracks(db, []track.Track{
+			track.New(track.Track{
+				MusicBrainzID: mbid,
+				Title:         "Title " + mbid,
+				PrimaryArtist: track.Artist{Name: "Artist"},
+			}),
+		})
+	}
+
+	t.Log("Group of three")
+
+	group, err := NextGroup(db, DefaultProfile, EntityTrack, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[int]bool{}
+	for _, c := range group {
+		seen[c.InternalID] = true
+	}
+	if len(group) != 3 || len(seen) != 3 {
+		t.Errorf("Expected 3 different tracks, got %+v", group)
+	}
+
+	if _, err = NextGroup(db, DefaultProfile, EntityTrack, 5); err != ErrNotEnoughContenders {
+		t.Errorf("Expected ErrNotEnoughContenders for 5 of 4, got %v", err)
+	}
+
+	t.Log("Ordering stored as one comparison")
+
+	comparisonID, err := RecordRankedComparison(
+		db, DefaultProfile, EntityTrack, []int{3, 1, 2},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scores are share of the others beaten; all started equal, so the
+	// winner gains K * (0.5 + 0.5) / 2
+	expected := [][]float64{
+		{1, 0.5, 1000, 1000},
+		{2, 0, 1000, 984},
+		{3, 1, 1000, 1016},
+	}
+	if got := readResults(t, db, comparisonID); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	var comparisons int
+	if err = db.QueryRow(
+		"SELECT COUNT(*) FROM comparisons",
+	).Scan(&comparisons); err != nil {
+		t.Fatal(err)
+	}
+	if comparisons != 1 {
+		t.Errorf("Expected 1 comparison, got %d", comparisons)
+	}
+
+	c, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Comparisons != 1 {
+		t.Errorf("Expected 1 comparison for track 1, got %d", c.Comparisons)
+	}
+
+	t.Log("Replay gives the same result")
+
+	if err = ReplayComparisons(db, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	if got := readResults(t, db, comparisonID); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	t.Log("Invalid orderings")
+
+	if _, err = RecordRankedComparison(db, DefaultProfile, EntityTrack, []int{1}); err == nil {
+		t.Error("Expected error for a single track")
+	}
+	if _, err = RecordRankedComparison(
+		db, DefaultProfile, EntityTrack, []int{1, 2, 1},
+	); err == nil {
+		t.Error("Expected error for repeated track")
+	}
+	if _, err = RecordRankedComparison(
+		db, DefaultProfile, EntityTrack, []int{1, 2, 3, 4, 5, 6},
+	); err == nil {
+		t.Error("Expected error for too many tracks")
+	}
+	if _, err = RecordRankedComparison(
+		db, DefaultProfile, EntityTrack, []int{1, 2, 99},
+	); err == nil {
+		t.Error("Expected error for missing track")
+	}
+}
+
+func TestUndoLastComparison(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+	})
+
+	if _, err := UndoLastComparison(db, DefaultProfile, EntityTrack); err != ErrNothingToUndo {
+		t.Errorf("Expected ErrNothingToUndo, got %v", err)
+	}
+
+	if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	last, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other entities' history is separate
+	if _, err = RecordComparison(db, DefaultProfile, EntityArtist, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Latest comparison is undone")
+
+	undone, err := UndoLastComparison(db, DefaultProfile, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undone != last {
+		t.Errorf("Expected comparison %d to be undone, got %d", last, undone)
+	}
+
+	expected := map[int]Contender{
+		1: {InternalID: 1, Name: "Title 1", Detail: "Artist 1", Ranking: 1016, Comparisons: 1},
+		2: {InternalID: 2, Name: "Title 2", Detail: "Artist 2", Ranking: 984, Comparisons: 1},
+		3: {InternalID: 3, Name: "Title 3", Detail: "Artist 3", Ranking: 1000, Comparisons: 0},
+	}
+	for id, c := range expected {
+		got, err := GetContender(db, DefaultProfile, EntityTrack, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c {
+			t.Errorf("\nExpected:\n%+v\ngot:\n%+v", c, got)
+		}
+	}
+
+	if got := readResults(t, db, last); len(got) != 0 {
+		t.Errorf("Expected results of undone comparison to be removed, got %v", got)
+	}
+
+	t.Log("Undo again")
+
+	if _, err = UndoLastComparison(db, DefaultProfile, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = UndoLastComparison(db, DefaultProfile, EntityTrack); err != ErrNothingToUndo {
+		t.Errorf("Expected ErrNothingToUndo, got %v", err)
+	}
+
+	artist, err := GetContender(db, DefaultProfile, EntityArtist, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if artist.Comparisons != 1 {
+		t.Errorf("Expected artist comparison to be kept, got %+v", artist)
+	}
+
+	t.Log("Latest by time is undone, even if merged in with a higher ID")
+
+	latest, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := RecordComparison(db, DefaultProfile, EntityTrack, 2, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(
+		`UPDATE comparisons SET created_at = '2000-01-01 00:00:00' WHERE id = ?`,
+		merged,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	undone, err = UndoLastComparison(db, DefaultProfile, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if undone != latest {
+		t.Errorf("Expected comparison %d to be undone, got %d", latest, undone)
+	}
+
+	expected = map[int]Contender{
+		1: {InternalID: 1, Name: "Title 1", Detail: "Artist 1", Ranking: 1000, Comparisons: 0},
+		2: {InternalID: 2, Name: "Title 2", Detail: "Artist 2", Ranking: 1016, Comparisons: 1},
+		3: {InternalID: 3, Name: "Title 3", Detail: "Artist 3", Ranking: 984, Comparisons: 1},
+	}
+	for id, c := range expected {
+		got, err := GetContender(db, DefaultProfile, EntityTrack, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c {
+			t.Errorf("\nExpected:\n%+v\ngot:\n%+v", c, got)
+		}
+	}
+}
+
+func TestListContenders(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			Title:         "b",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			Title:         "A",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	})
+
+	for entity, expected := range map[Entity][]string{
+		EntityTrack:  {"A", "b"},
+		EntityAlbum:  {"Album 1", "Album 2"},
+		EntityArtist: {"Artist 1", "Artist 2"},
+	} {
+		contenders, err := ListContenders(db, DefaultProfile, entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		for _, c := range contenders {
+			names = append(names, c.Name)
+		}
+
+		if !reflect.DeepEqual(expected, names) {
+			t.Errorf("%s: expected %v, got %v", entity, expected, names)
+		}
+	}
+}
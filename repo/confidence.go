@@ -0,0 +1,206 @@
+package repo
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// A track's results in a profile's comparison log
+type trackRecord struct {
+	wins   int
+	losses int
+	draws  int
+
+	// Expected score of each result, from the ratings going in
+	expected []float64
+}
+
+func (r *trackRecord) deviation() float64 {
+	if r == nil {
+		return elo.InitialDeviation
+	}
+
+	return elo.Deviation(r.expected)
+}
+
+// trackRecords tallies the given tracks' results in the profile, taking
+// each comparison as a result against each other track in it
+func trackRecords(
+	db querier, profile int, ids []int,
+) (map[int]*trackRecord, error) {
+	records := map[int]*trackRecord{}
+	if len(ids) == 0 {
+		return records, nil
+	}
+
+	// IDs are inlined, as there can be more than SQLite takes parameters
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.Itoa(id)
+	}
+
+	err := queryEach(db,
+		`SELECT cr.entity_id,
+		        cr.score,
+		        cr.place,
+		        cr.ranking_before,
+		        o.place,
+		        o.ranking_before
+		   FROM comparisons        c
+		   JOIN comparison_results cr ON cr.comparison_id = c.id
+		   JOIN comparison_results o  ON o.comparison_id  = c.id
+		                             AND o.entity_id     != cr.entity_id
+		  WHERE c.profile_id = ?
+		    AND c.entity     = ?
+		    AND cr.entity_id IN (`+strings.Join(list, ",")+`)`,
+		func(rows *sql.Rows) error {
+			var id int
+			var score sql.NullFloat64
+			var place, otherPlace sql.NullInt64
+			var before, otherBefore sql.NullFloat64
+
+			if err := rows.Scan(
+				&id, &score, &place, &before, &otherPlace, &otherBefore,
+			); err != nil {
+				return err
+			}
+
+			r, ok := records[id]
+			if !ok {
+				r = &trackRecord{}
+				records[id] = r
+			}
+
+			// Orderings' scores are shares of everyone below, so only
+			// places give the result against one other track
+			if place.Valid && otherPlace.Valid {
+				score = sql.NullFloat64{
+					Float64: elo.PairwiseScore(
+						int(place.Int64), int(otherPlace.Int64),
+					),
+					Valid: true,
+				}
+			}
+
+			switch {
+			case !score.Valid:
+			case score.Float64 > 0.5:
+				r.wins++
+			case score.Float64 < 0.5:
+				r.losses++
+			default:
+				r.draws++
+			}
+
+			if before.Valid && otherBefore.Valid {
+				r.expected = append(
+					r.expected,
+					elo.ExpectedScore(before.Float64, otherBefore.Float64),
+				)
+			}
+
+			return nil
+		},
+		profile,
+		EntityTrack,
+	)
+
+	return records, err
+}
+
+// settledTracks returns the tracks whose confidence intervals overlap
+// neither the next track up's nor the next one down's
+func settledTracks(
+	rankings map[int]float64, deviations map[int]float64,
+) map[int]bool {
+	ids := rankingOrder(rankings)
+
+	lows := make([]float64, len(ids))
+	highs := make([]float64, len(ids))
+	for i, id := range ids {
+		lows[i], highs[i] = elo.Interval(rankings[id], deviations[id])
+	}
+
+	settled := map[int]bool{}
+	for i, id := range ids {
+		if (i == 0 || lows[i-1] > highs[i]) &&
+			(i == len(ids)-1 || highs[i+1] < lows[i]) {
+			settled[id] = true
+		}
+	}
+
+	return settled
+}
+
+// Compared track IDs, highest ranked first
+func rankingOrder(rankings map[int]float64) []int {
+	ids := make([]int, 0, len(rankings))
+	for id := range rankings {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if rankings[ids[i]] != rankings[ids[j]] {
+			return rankings[ids[i]] > rankings[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
+
+// loadConfidence fills in the tracks' results and deviations in the
+// profile, and whether their positions among its compared tracks are
+// settled. Only the records of the tracks and their neighbours in the
+// ranking are read.
+func loadConfidence(db querier, profile int, tracks []track.Track) error {
+	rankings, err := profileTrackRankings(db, profile, 1)
+	if err != nil {
+		return err
+	}
+
+	wanted := map[int]bool{}
+	for _, t := range tracks {
+		wanted[t.InternalID] = true
+	}
+
+	order := rankingOrder(rankings)
+	ids := []int{}
+	for i, id := range order {
+		if wanted[id] ||
+			(i > 0 && wanted[order[i-1]]) ||
+			(i < len(order)-1 && wanted[order[i+1]]) {
+			ids = append(ids, id)
+		}
+	}
+
+	records, err := trackRecords(db, profile, ids)
+	if err != nil {
+		return err
+	}
+
+	// Others' don't matter to whether the tracks are settled
+	deviations := map[int]float64{}
+	for _, id := range ids {
+		deviations[id] = records[id].deviation()
+	}
+
+	settled := settledTracks(rankings, deviations)
+
+	for i := range tracks {
+		t := &tracks[i]
+		r := records[t.InternalID]
+
+		if r != nil {
+			t.Wins, t.Losses, t.Draws = r.wins, r.losses, r.draws
+		}
+		t.Deviation = r.deviation()
+		t.Settled = settled[t.InternalID]
+	}
+
+	return nil
+}
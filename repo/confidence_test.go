@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestConfidence(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{MusicBrainzID: "MB1", Title: "Title 1"}),
+		track.New(track.Track{MusicBrainzID: "MB2", Title: "Title 2"}),
+		track.New(track.Track{MusicBrainzID: "MB3", Title: "Title 3"}),
+		track.New(track.Track{MusicBrainzID: "MB4", Title: "Title 4"}),
+	})
+
+	if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 2, 3, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecordRankedComparison(
+		db, DefaultProfile, EntityTrack, []int{3, 1, 2},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Results")
+
+	page, err := QueryRankings(db, RankingQuery{OrderBy: OrderByTitle, Ascending: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wins, losses, draws
+	expected := [][3]int{{2, 1, 0}, {0, 3, 1}, {2, 0, 1}, {0, 0, 0}}
+	got := [][3]int{}
+	for _, tr := range page.Tracks {
+		got = append(got, [3]int{tr.Wins, tr.Losses, tr.Draws})
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
+
+	for _, tr := range page.Tracks {
+		switch {
+		case tr.InternalID == 4 && tr.Deviation != elo.InitialDeviation:
+			t.Errorf("Expected initial deviation for uncompared track, got %v", tr.Deviation)
+		case tr.InternalID != 4 && tr.Deviation >= elo.InitialDeviation:
+			t.Errorf("Track %d: expected deviation to shrink, got %v", tr.InternalID, tr.Deviation)
+		}
+		if tr.Settled {
+			t.Errorf("Track %d: not settled after a few comparisons", tr.InternalID)
+		}
+	}
+
+	got1, err := GetTrack(db, DefaultProfile, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got1.Wins != 2 || got1.Losses != 1 || got1.Deviation != page.Tracks[0].Deviation {
+		t.Errorf("Expected GetTrack to match QueryRankings, got %+v", got1)
+	}
+
+	t.Log("Other profiles' comparisons don't count")
+
+	sam, err := CreateProfile(db, "Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got1, err = GetTrack(db, sam.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got1.Wins != 0 || got1.Deviation != elo.InitialDeviation {
+		t.Errorf("Expected no results for Sam, got %+v", got1)
+	}
+
+	t.Log("Settled")
+
+	// Intervals of 900±98, 1150±98 and 1200±98; track 4 is too uncertain
+	// to settle
+	settled := settledTracks(
+		map[int]float64{1: 900, 2: 1200, 3: 1150, 4: 1500},
+		map[int]float64{1: 50, 2: 50, 3: 50, 4: elo.InitialDeviation},
+	)
+	if !reflect.DeepEqual(map[int]bool{1: true}, settled) {
+		t.Errorf("Expected only track 1 settled, got %v", settled)
+	}
+}
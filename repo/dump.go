@@ -0,0 +1,1811 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Version of the dump format written by Dump. Version 1 is from before
+// profiles, with rankings on the tracks, albums and artists; version 2
+// is from before audio files were included; version 3 is from before
+// listens were per profile, with the priors from them on the tracks.
+const dumpVersion = 4
+
+// A dump is JSON Lines: one record per line, each
+// {"type": "<record type>", "data": {...}}, starting with a header.
+// Records only refer to records before them. The library folder is
+// specific to one machine, so is left out, and audio files are given
+// relative to it.
+type dumpLine struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type dumpHeader struct {
+	Version int `json:"version"`
+}
+
+type dumpSetting struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+type dumpProfile struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Pointers are NULL in the DB. Rankings on tracks, albums and artists
+// are only in version 1 dumps, and belong to the default profile.
+
+type dumpArtist struct {
+	ID            int64    `json:"id"`
+	MusicBrainzID *string  `json:"musicbrainz_id"`
+	Name          *string  `json:"name"`
+	Ranking       *float64 `json:"ranking,omitempty"`
+	Comparisons   int      `json:"comparisons,omitempty"`
+}
+
+type dumpAlbum struct {
+	ID            int64    `json:"id"`
+	MusicBrainzID *string  `json:"musicbrainz_id"`
+	Title         *string  `json:"title"`
+	Ranking       *float64 `json:"ranking,omitempty"`
+	Comparisons   int      `json:"comparisons,omitempty"`
+}
+
+type dumpTrack struct {
+	ID            int64    `json:"id"`
+	MusicBrainzID *string  `json:"musicbrainz_id"`
+	Title         *string  `json:"title"`
+	Genre         *string  `json:"genre"`
+	Year          *int64   `json:"year"`
+	Ranking       *float64 `json:"ranking,omitempty"`
+	Comparisons   int      `json:"comparisons,omitempty"`
+	Prior         *float64 `json:"prior"`
+	PriorSource   *string  `json:"prior_source"`
+}
+
+type dumpTrackArtist struct {
+	TrackID  int64 `json:"track_id"`
+	ArtistID int64 `json:"artist_id"`
+	Primary  bool  `json:"primary"`
+}
+
+type dumpTrackAlbum struct {
+	TrackID int64 `json:"track_id"`
+	AlbumID int64 `json:"album_id"`
+}
+
+// Path is relative to the library folder, with forward slashes
+type dumpTrackFile struct {
+	TrackID int64  `json:"track_id"`
+	Path    string `json:"path"`
+}
+
+// A profile of 0, as in version 1 dumps, is the default profile
+
+type dumpRanking struct {
+	Profile     int64   `json:"profile_id"`
+	Entity      Entity  `json:"entity"`
+	EntityID    int64   `json:"entity_id"`
+	Ranking     float64 `json:"ranking"`
+	Comparisons int     `json:"comparisons"`
+}
+
+type dumpComparison struct {
+	ID         int64        `json:"id"`
+	Profile    int64        `json:"profile_id,omitempty"`
+	Entity     Entity       `json:"entity"`
+	Preference *float64     `json:"preference"`
+	Multiplier float64      `json:"multiplier"`
+	Source     string       `json:"source,omitempty"` // "" = vote
+	CreatedAt  string       `json:"created_at"`
+	Results    []dumpResult `json:"results"` // In the order recorded
+}
+
+type dumpResult struct {
+	EntityID      int64    `json:"entity_id"`
+	Score         *float64 `json:"score"`
+	Place         *int64   `json:"place"`
+	K             *float64 `json:"k"`
+	RankingBefore *float64 `json:"ranking_before"`
+	RankingAfter  *float64 `json:"ranking_after"`
+}
+
+type dumpSnapshot struct {
+	ID       int64                 `json:"id"`
+	Profile  int64                 `json:"profile_id,omitempty"`
+	TakenAt  string                `json:"taken_at"`
+	Label    *string               `json:"label"`
+	Rankings []dumpSnapshotRanking `json:"rankings"`
+}
+
+type dumpSnapshotRanking struct {
+	TrackID  int64    `json:"track_id"`
+	Ranking  *float64 `json:"ranking"`
+	Position *int64   `json:"position"`
+}
+
+type dumpListen struct {
+	Profile    int64  `json:"profile_id,omitempty"`
+	TrackID    int64  `json:"track_id"`
+	ListenedAt int64  `json:"listened_at"`
+	Source     string `json:"source"`
+}
+
+type dumpAnnotation struct {
+	Profile   int64   `json:"profile_id,omitempty"`
+	Entity    Entity  `json:"entity"`
+	EntityID  int64   `json:"entity_id"`
+	Rating    *int64  `json:"rating"`
+	StarredAt *string `json:"starred_at"`
+
+	ListenPrior *float64 `json:"listen_prior,omitempty"`
+}
+
+// Everything in a dump, in the order written
+type dump struct {
+	settings     []dumpSetting
+	profiles     []dumpProfile
+	artists      []dumpArtist
+	albums       []dumpAlbum
+	tracks       []dumpTrack
+	trackArtists []dumpTrackArtist
+	trackAlbums  []dumpTrackAlbum
+	trackFiles   []dumpTrackFile
+	rankings     []dumpRanking
+	comparisons  []dumpComparison
+	snapshots    []dumpSnapshot
+	listens      []dumpListen
+	annotations  []dumpAnnotation
+}
+
+// Dump writes the whole DB (settings, profiles, tracks, albums, artists,
+// the links between them, the tracks' audio files under the library
+// folder, each profile's rankings, match history, snapshots, listens,
+// and ratings and stars set by hand) as JSON Lines. Output is ordered by
+// ID, so dumps of the same data are identical. Profiles' Subsonic
+// passwords are left out.
+func Dump(db *sql.DB, w io.Writer) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	d, err := readDump(tx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	write := func(recordType string, data interface{}) error {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		return encoder.Encode(dumpLine{Type: recordType, Data: encoded})
+	}
+
+	if err = write("header", dumpHeader{Version: dumpVersion}); err != nil {
+		return err
+	}
+
+	for _, s := range d.settings {
+		if err = write("setting", s); err != nil {
+			return err
+		}
+	}
+	for _, p := range d.profiles {
+		if err = write("profile", p); err != nil {
+			return err
+		}
+	}
+	for _, a := range d.artists {
+		if err = write("artist", a); err != nil {
+			return err
+		}
+	}
+	for _, a := range d.albums {
+		if err = write("album", a); err != nil {
+			return err
+		}
+	}
+	for _, t := range d.tracks {
+		if err = write("track", t); err != nil {
+			return err
+		}
+	}
+	for _, ta := range d.trackArtists {
+		if err = write("track_artist", ta); err != nil {
+			return err
+		}
+	}
+	for _, ta := range d.trackAlbums {
+		if err = write("track_album", ta); err != nil {
+			return err
+		}
+	}
+	for _, f := range d.trackFiles {
+		if err = write("track_file", f); err != nil {
+			return err
+		}
+	}
+	for _, r := range d.rankings {
+		if err = write("ranking", r); err != nil {
+			return err
+		}
+	}
+	for _, c := range d.comparisons {
+		if err = write("comparison", c); err != nil {
+			return err
+		}
+	}
+	for _, s := range d.snapshots {
+		if err = write("snapshot", s); err != nil {
+			return err
+		}
+	}
+	for _, l := range d.listens {
+		if err = write("listen", l); err != nil {
+			return err
+		}
+	}
+	for _, a := range d.annotations {
+		if err = write("annotation", a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readDump(tx *sql.Tx) (dump, error) {
+	var d dump
+
+	err := queryEach(tx,
+		"SELECT key, value FROM settings WHERE key != ? ORDER BY key",
+		func(rows *sql.Rows) error {
+			var s dumpSetting
+			var value string
+
+			if err := rows.Scan(&s.Key, &value); err != nil {
+				return err
+			}
+			s.Value = json.RawMessage(value)
+
+			d.settings = append(d.settings, s)
+			return nil
+		},
+		libraryRootKey,
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		"SELECT id, name FROM profiles ORDER BY id",
+		func(rows *sql.Rows) error {
+			var p dumpProfile
+			if err := rows.Scan(&p.ID, &p.Name); err != nil {
+				return err
+			}
+
+			d.profiles = append(d.profiles, p)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT id, musicbrainz_id, name
+		   FROM artists
+		  ORDER BY id`,
+		func(rows *sql.Rows) error {
+			var a dumpArtist
+			if err := rows.Scan(&a.ID, &a.MusicBrainzID, &a.Name); err != nil {
+				return err
+			}
+
+			d.artists = append(d.artists, a)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT id, musicbrainz_id, title
+		   FROM albums
+		  ORDER BY id`,
+		func(rows *sql.Rows) error {
+			var a dumpAlbum
+			if err := rows.Scan(&a.ID, &a.MusicBrainzID, &a.Title); err != nil {
+				return err
+			}
+
+			d.albums = append(d.albums, a)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT id, musicbrainz_id, title, genre, year, prior, prior_source
+		   FROM tracks
+		  ORDER BY id`,
+		func(rows *sql.Rows) error {
+			var t dumpTrack
+			if err := rows.Scan(
+				&t.ID,
+				&t.MusicBrainzID,
+				&t.Title,
+				&t.Genre,
+				&t.Year,
+				&t.Prior,
+				&t.PriorSource,
+			); err != nil {
+				return err
+			}
+
+			d.tracks = append(d.tracks, t)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT track_id, artist_id, IFNULL(is_primary_artist, 0)
+		   FROM track_artist
+		  ORDER BY track_id, artist_id`,
+		func(rows *sql.Rows) error {
+			var ta dumpTrackArtist
+			if err := rows.Scan(&ta.TrackID, &ta.ArtistID, &ta.Primary); err != nil {
+				return err
+			}
+
+			d.trackArtists = append(d.trackArtists, ta)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT track_id, album_id
+		   FROM track_album
+		  ORDER BY track_id, album_id`,
+		func(rows *sql.Rows) error {
+			var ta dumpTrackAlbum
+			if err := rows.Scan(&ta.TrackID, &ta.AlbumID); err != nil {
+				return err
+			}
+
+			d.trackAlbums = append(d.trackAlbums, ta)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	root, err := LoadLibraryRoot(tx)
+	if err != nil {
+		return dump{}, err
+	}
+
+	// Files outside the library folder can't be found on another machine
+	err = queryEach(tx,
+		`SELECT track_id, path
+		   FROM track_files
+		  ORDER BY track_id, path`,
+		func(rows *sql.Rows) error {
+			var f dumpTrackFile
+			var path string
+			if err := rows.Scan(&f.TrackID, &path); err != nil {
+				return err
+			}
+
+			if rel, ok := libraryRelative(root, path); ok {
+				f.Path = rel
+				d.trackFiles = append(d.trackFiles, f)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT profile_id, entity, entity_id, ranking, comparisons
+		   FROM rankings
+		  ORDER BY profile_id, entity, entity_id`,
+		func(rows *sql.Rows) error {
+			var r dumpRanking
+			if err := rows.Scan(
+				&r.Profile, &r.Entity, &r.EntityID, &r.Ranking, &r.Comparisons,
+			); err != nil {
+				return err
+			}
+
+			d.rankings = append(d.rankings, r)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	comparisonIndex := map[int64]int{}
+
+	err = queryEach(tx,
+		`SELECT id, profile_id, entity, preference, multiplier,
+		        IFNULL(NULLIF(source, ?), ''), created_at
+		   FROM comparisons
+		  ORDER BY id`,
+		func(rows *sql.Rows) error {
+			c := dumpComparison{Results: []dumpResult{}}
+			if err := rows.Scan(
+				&c.ID, &c.Profile, &c.Entity, &c.Preference, &c.Multiplier,
+				&c.Source, &c.CreatedAt,
+			); err != nil {
+				return err
+			}
+
+			comparisonIndex[c.ID] = len(d.comparisons)
+			d.comparisons = append(d.comparisons, c)
+			return nil
+		},
+		ComparisonSourceVote,
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	// Results are rated in the order recorded, so keep it
+	err = queryEach(tx,
+		`SELECT comparison_id, entity_id, score, place, k,
+		        ranking_before, ranking_after
+		   FROM comparison_results
+		  ORDER BY comparison_id, rowid`,
+		func(rows *sql.Rows) error {
+			var comparisonID int64
+			var r dumpResult
+
+			if err := rows.Scan(
+				&comparisonID,
+				&r.EntityID,
+				&r.Score,
+				&r.Place,
+				&r.K,
+				&r.RankingBefore,
+				&r.RankingAfter,
+			); err != nil {
+				return err
+			}
+
+			i, ok := comparisonIndex[comparisonID]
+			if !ok {
+				return fmt.Errorf("Results for missing comparison %d", comparisonID)
+			}
+
+			d.comparisons[i].Results = append(d.comparisons[i].Results, r)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	snapshotIndex := map[int64]int{}
+
+	err = queryEach(tx,
+		"SELECT id, profile_id, taken_at, label FROM snapshots ORDER BY id",
+		func(rows *sql.Rows) error {
+			s := dumpSnapshot{Rankings: []dumpSnapshotRanking{}}
+			if err := rows.Scan(
+				&s.ID, &s.Profile, &s.TakenAt, &s.Label,
+			); err != nil {
+				return err
+			}
+
+			snapshotIndex[s.ID] = len(d.snapshots)
+			d.snapshots = append(d.snapshots, s)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT snapshot_id, track_id, ranking, position
+		   FROM snapshot_rankings
+		  ORDER BY snapshot_id, track_id`,
+		func(rows *sql.Rows) error {
+			var snapshotID int64
+			var r dumpSnapshotRanking
+
+			if err := rows.Scan(
+				&snapshotID, &r.TrackID, &r.Ranking, &r.Position,
+			); err != nil {
+				return err
+			}
+
+			i, ok := snapshotIndex[snapshotID]
+			if !ok {
+				return fmt.Errorf("Rankings for missing snapshot %d", snapshotID)
+			}
+
+			d.snapshots[i].Rankings = append(d.snapshots[i].Rankings, r)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT profile_id, track_id, listened_at, source
+		   FROM listens
+		  ORDER BY profile_id, track_id, listened_at`,
+		func(rows *sql.Rows) error {
+			var l dumpListen
+			if err := rows.Scan(
+				&l.Profile, &l.TrackID, &l.ListenedAt, &l.Source,
+			); err != nil {
+				return err
+			}
+
+			d.listens = append(d.listens, l)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	err = queryEach(tx,
+		`SELECT profile_id, entity, entity_id, rating, starred_at,
+		        listen_prior
+		   FROM annotations
+		  ORDER BY profile_id, entity, entity_id`,
+		func(rows *sql.Rows) error {
+			var a dumpAnnotation
+			if err := rows.Scan(
+				&a.Profile, &a.Entity, &a.EntityID, &a.Rating, &a.StarredAt,
+				&a.ListenPrior,
+			); err != nil {
+				return err
+			}
+
+			d.annotations = append(d.annotations, a)
+			return nil
+		},
+	)
+	if err != nil {
+		return dump{}, err
+	}
+
+	return d, nil
+}
+
+type ImportMode string
+
+const (
+	// Into an empty DB, keeping IDs
+	ImportRestore ImportMode = "restore"
+
+	// Into a DB that may already have data. Tracks, albums and artists
+	// are matched up by MusicBrainz ID, or by name where there is none,
+	// and profiles by name (see Import). Comparisons are added to the
+	// existing history and rankings replayed.
+	ImportMerge ImportMode = "merge"
+)
+
+// ImportStats counts what an import did
+type ImportStats struct {
+	// Per entity; in a restore everything is added
+	Added   map[Entity]int
+	Matched map[Entity]int
+
+	// Profiles with no namesake in the DB
+	ProfilesAdded int
+
+	// Audio files linked under the DB's library folder. They are skipped
+	// if it has none, or if the file is already linked.
+	FilesAdded   int
+	FilesSkipped int
+
+	ComparisonsAdded int
+
+	// Already in the DB, from an earlier merge of the same data
+	ComparisonsSkipped int
+
+	SnapshotsAdded   int
+	SnapshotsSkipped int
+
+	ListensAdded   int
+	ListensSkipped int
+
+	// Ratings and stars on something that already had them are skipped
+	AnnotationsAdded   int
+	AnnotationsSkipped int
+}
+
+// Import reads a dump written by Dump. The whole import happens in one
+// transaction, so nothing is changed if it fails.
+//
+// When merging, tracks, albums and artists with a MusicBrainz ID match
+// existing ones with the same ID. Without one, artists match by name,
+// tracks by title and primary artist, and albums by title and the
+// primary artists of their tracks. Profiles match by name; version 1
+// dumps go in the default profile. Existing settings are kept.
+// Merging the same dump twice adds nothing the second time.
+//
+// Audio files are linked under the DB's own library folder, so it should
+// be set (see SaveLibraryRoot) before importing.
+func Import(db *sql.DB, r io.Reader, mode ImportMode) (ImportStats, error) {
+	if mode != ImportRestore && mode != ImportMerge {
+		return ImportStats{}, fmt.Errorf("Unknown import mode '%s'", mode)
+	}
+
+	d, err := decodeDump(r)
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ImportStats{}, err
+	}
+	defer tx.Rollback()
+
+	stats := ImportStats{
+		Added:   map[Entity]int{},
+		Matched: map[Entity]int{},
+	}
+
+	if mode == ImportRestore {
+		err = restoreDump(tx, d, &stats)
+	} else {
+		err = mergeDump(tx, d, &stats)
+	}
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	return stats, tx.Commit()
+}
+
+func decodeDump(r io.Reader) (dump, error) {
+	var d dump
+
+	decoder := json.NewDecoder(r)
+
+	var header dumpHeader
+	first := true
+
+	for {
+		var line dumpLine
+		if err := decoder.Decode(&line); err == io.EOF {
+			break
+		} else if err != nil {
+			return dump{}, err
+		}
+
+		if first != (line.Type == "header") {
+			return dump{}, errors.New("Dump must start with a single header")
+		}
+		first = false
+
+		var err error
+
+		switch line.Type {
+		case "header":
+			err = json.Unmarshal(line.Data, &header)
+			if err == nil && (header.Version < 1 || header.Version > dumpVersion) {
+				err = fmt.Errorf("Unsupported dump version %d", header.Version)
+			}
+		case "setting":
+			var s dumpSetting
+			err = json.Unmarshal(line.Data, &s)
+			d.settings = append(d.settings, s)
+		case "profile":
+			var p dumpProfile
+			err = json.Unmarshal(line.Data, &p)
+			d.profiles = append(d.profiles, p)
+		case "artist":
+			var a dumpArtist
+			err = json.Unmarshal(line.Data, &a)
+			d.artists = append(d.artists, a)
+		case "album":
+			var a dumpAlbum
+			err = json.Unmarshal(line.Data, &a)
+			d.albums = append(d.albums, a)
+		case "track":
+			var t dumpTrack
+			err = json.Unmarshal(line.Data, &t)
+			d.tracks = append(d.tracks, t)
+		case "track_artist":
+			var ta dumpTrackArtist
+			err = json.Unmarshal(line.Data, &ta)
+			d.trackArtists = append(d.trackArtists, ta)
+		case "track_album":
+			var ta dumpTrackAlbum
+			err = json.Unmarshal(line.Data, &ta)
+			d.trackAlbums = append(d.trackAlbums, ta)
+		case "track_file":
+			var f dumpTrackFile
+			err = json.Unmarshal(line.Data, &f)
+			d.trackFiles = append(d.trackFiles, f)
+		case "ranking":
+			var r dumpRanking
+			err = json.Unmarshal(line.Data, &r)
+			if _, ok := entities[r.Entity]; err == nil && !ok {
+				err = fmt.Errorf("Unknown entity '%s'", r.Entity)
+			}
+			d.rankings = append(d.rankings, r)
+		case "comparison":
+			var c dumpComparison
+			err = json.Unmarshal(line.Data, &c)
+			if _, ok := entities[c.Entity]; err == nil && !ok {
+				err = fmt.Errorf("Unknown entity '%s'", c.Entity)
+			}
+			d.comparisons = append(d.comparisons, c)
+		case "snapshot":
+			var s dumpSnapshot
+			err = json.Unmarshal(line.Data, &s)
+			d.snapshots = append(d.snapshots, s)
+		case "listen":
+			var l dumpListen
+			err = json.Unmarshal(line.Data, &l)
+			d.listens = append(d.listens, l)
+		case "annotation":
+			var a dumpAnnotation
+			err = json.Unmarshal(line.Data, &a)
+			if _, ok := entities[a.Entity]; err == nil && !ok {
+				err = fmt.Errorf("Unknown entity '%s'", a.Entity)
+			}
+			d.annotations = append(d.annotations, a)
+		default:
+			err = fmt.Errorf("Unknown record type '%s'", line.Type)
+		}
+		if err != nil {
+			return dump{}, fmt.Errorf("Dump %s record: %s", line.Type, err)
+		}
+	}
+
+	if first {
+		return dump{}, errors.New("Dump is empty")
+	}
+
+	if header.Version == 1 {
+		d.rankings = entityRankings(d)
+	}
+	if header.Version < 4 {
+		d.annotations = listenPriorAnnotations(d)
+	}
+
+	return d, nil
+}
+
+// The default profile's rankings in a version 1 dump, from the tracks,
+// albums and artists compared
+func entityRankings(d dump) []dumpRanking {
+	rankings := []dumpRanking{}
+
+	add := func(entity Entity, id int64, ranking *float64, comparisons int) {
+		if ranking != nil && comparisons > 0 {
+			rankings = append(rankings, dumpRanking{
+				Profile:     DefaultProfile,
+				Entity:      entity,
+				EntityID:    id,
+				Ranking:     *ranking,
+				Comparisons: comparisons,
+			})
+		}
+	}
+
+	for _, t := range d.tracks {
+		add(EntityTrack, t.ID, t.Ranking, t.Comparisons)
+	}
+	for _, a := range d.albums {
+		add(EntityAlbum, a.ID, a.Ranking, a.Comparisons)
+	}
+	for _, a := range d.artists {
+		add(EntityArtist, a.ID, a.Ranking, a.Comparisons)
+	}
+
+	return rankings
+}
+
+// The annotations in a dump from before listens were per profile, with
+// the priors from listens on the tracks moved onto the default profile's.
+// The tracks lose them.
+func listenPriorAnnotations(d dump) []dumpAnnotation {
+	annotations := d.annotations
+
+	indexes := map[int64]int{}
+	for i, a := range annotations {
+		if dumpProfileID(a.Profile) == DefaultProfile &&
+			a.Entity == EntityTrack {
+			indexes[a.EntityID] = i
+		}
+	}
+
+	for i, t := range d.tracks {
+		if t.PriorSource == nil || *t.PriorSource != priorSourceListens {
+			continue
+		}
+
+		if j, ok := indexes[t.ID]; ok {
+			annotations[j].ListenPrior = t.Prior
+		} else {
+			annotations = append(annotations, dumpAnnotation{
+				Entity:      EntityTrack,
+				EntityID:    t.ID,
+				ListenPrior: t.Prior,
+			})
+		}
+
+		d.tracks[i].Prior, d.tracks[i].PriorSource = nil, nil
+	}
+
+	return annotations
+}
+
+func restoreDump(tx *sql.Tx, d dump, stats *ImportStats) error {
+	var existing int
+	if err := tx.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM tracks)
+		      + (SELECT COUNT(*) FROM albums)
+		      + (SELECT COUNT(*) FROM artists)
+		      + (SELECT COUNT(*) FROM comparisons)
+		      + (SELECT COUNT(*) FROM snapshots)
+		      + (SELECT COUNT(*) FROM profiles WHERE id != ?)`,
+		DefaultProfile,
+	).Scan(&existing); err != nil {
+		return err
+	}
+	if existing > 0 {
+		return errors.New("Can only restore into an empty database; merge instead")
+	}
+
+	for _, s := range d.settings {
+		if s.Key == libraryRootKey {
+			continue
+		}
+
+		if err := saveSetting(tx, s.Key, s.Value); err != nil {
+			return err
+		}
+	}
+
+	// The default profile is in every DB, but may have been renamed
+	for _, p := range d.profiles {
+		if _, err := tx.Exec(
+			`INSERT INTO profiles
+			             (id, name)
+			      VALUES (?,?)
+			 ON CONFLICT (id) DO UPDATE SET name = excluded.name`,
+			p.ID, p.Name,
+		); err != nil {
+			return err
+		}
+
+		if p.ID != DefaultProfile {
+			stats.ProfilesAdded++
+		}
+	}
+
+	for _, a := range d.artists {
+		if _, err := tx.Exec(
+			`INSERT INTO artists
+			            (id, musicbrainz_id, name)
+			     VALUES (?,?,?)`,
+			a.ID, a.MusicBrainzID, a.Name,
+		); err != nil {
+			return err
+		}
+	}
+	stats.Added[EntityArtist] = len(d.artists)
+
+	for _, a := range d.albums {
+		if _, err := tx.Exec(
+			`INSERT INTO albums
+			            (id, musicbrainz_id, title)
+			     VALUES (?,?,?)`,
+			a.ID, a.MusicBrainzID, a.Title,
+		); err != nil {
+			return err
+		}
+	}
+	stats.Added[EntityAlbum] = len(d.albums)
+
+	for _, t := range d.tracks {
+		if _, err := insertDumpTrack(tx, t, t.ID); err != nil {
+			return err
+		}
+	}
+	stats.Added[EntityTrack] = len(d.tracks)
+
+	for _, ta := range d.trackArtists {
+		if _, err := tx.Exec(
+			`INSERT INTO track_artist
+			            (track_id, artist_id, is_primary_artist)
+			     VALUES (?,?,?)`,
+			ta.TrackID, ta.ArtistID, ta.Primary,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, ta := range d.trackAlbums {
+		if _, err := tx.Exec(
+			`INSERT INTO track_album
+			            (track_id, album_id)
+			     VALUES (?,?)`,
+			ta.TrackID, ta.AlbumID,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range d.trackFiles {
+		if err := insertDumpTrackFile(tx, f, stats); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range d.rankings {
+		if _, err := tx.Exec(
+			`INSERT INTO rankings
+			            (profile_id, entity, entity_id, ranking, comparisons)
+			     VALUES (?,?,?,?,?)`,
+			dumpProfileID(r.Profile), r.Entity, r.EntityID, r.Ranking,
+			r.Comparisons,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range d.comparisons {
+		if _, err := insertDumpComparison(tx, c, c.ID); err != nil {
+			return err
+		}
+	}
+	stats.ComparisonsAdded = len(d.comparisons)
+
+	for _, s := range d.snapshots {
+		if _, err := insertDumpSnapshot(tx, s, s.ID); err != nil {
+			return err
+		}
+	}
+	stats.SnapshotsAdded = len(d.snapshots)
+
+	for _, l := range d.listens {
+		if err := insertDumpListen(tx, l); err != nil {
+			return err
+		}
+	}
+	stats.ListensAdded = len(d.listens)
+
+	for _, a := range d.annotations {
+		if _, err := insertDumpAnnotation(tx, a); err != nil {
+			return err
+		}
+	}
+	stats.AnnotationsAdded = len(d.annotations)
+
+	return nil
+}
+
+func mergeDump(tx *sql.Tx, d dump, stats *ImportStats) error {
+	for _, s := range d.settings {
+		if s.Key == libraryRootKey {
+			continue
+		}
+
+		if _, err := tx.Exec(
+			`INSERT INTO settings
+			             (key, value)
+			      VALUES (?,?)
+			 ON CONFLICT (key) DO NOTHING`,
+			s.Key,
+			string(s.Value),
+		); err != nil {
+			return err
+		}
+	}
+
+	// Dump ID -> DB ID
+	profileIDs := map[int64]int64{0: DefaultProfile}
+	artistIDs := map[int64]int64{}
+	albumIDs := map[int64]int64{}
+	trackIDs := map[int64]int64{}
+
+	for _, p := range d.profiles {
+		existing, err := GetProfile(tx, p.Name)
+		if err == nil {
+			profileIDs[p.ID] = int64(existing.ID)
+			continue
+		}
+
+		res, err := tx.Exec("INSERT INTO profiles (name) VALUES (?)", p.Name)
+		if err != nil {
+			return err
+		}
+		if profileIDs[p.ID], err = res.LastInsertId(); err != nil {
+			return err
+		}
+
+		stats.ProfilesAdded++
+	}
+
+	artistNames := map[int64]string{}
+
+	for _, a := range d.artists {
+		artistNames[a.ID] = stringValue(a.Name)
+
+		var id int64
+		var err error
+
+		if stringValue(a.MusicBrainzID) != "" {
+			err = tx.QueryRow(
+				"SELECT id FROM artists WHERE musicbrainz_id = ? ORDER BY id",
+				*a.MusicBrainzID,
+			).Scan(&id)
+		} else {
+			err = tx.QueryRow(
+				"SELECT id FROM artists WHERE name = ? ORDER BY id",
+				a.Name,
+			).Scan(&id)
+		}
+
+		if err == sql.ErrNoRows {
+			res, err := tx.Exec(
+				`INSERT INTO artists
+				            (musicbrainz_id, name)
+				     VALUES (?,?)`,
+				a.MusicBrainzID, a.Name,
+			)
+			if err != nil {
+				return err
+			}
+			if id, err = res.LastInsertId(); err != nil {
+				return err
+			}
+
+			stats.Added[EntityArtist]++
+		} else if err != nil {
+			return err
+		} else {
+			stats.Matched[EntityArtist]++
+		}
+
+		artistIDs[a.ID] = id
+	}
+
+	// For matching albums without MusicBrainz IDs
+	albumArtists := map[int64]map[string]bool{}
+	primaryArtists := map[int64]int64{}
+
+	for _, ta := range d.trackArtists {
+		if ta.Primary {
+			primaryArtists[ta.TrackID] = ta.ArtistID
+		}
+	}
+	for _, ta := range d.trackAlbums {
+		if albumArtists[ta.AlbumID] == nil {
+			albumArtists[ta.AlbumID] = map[string]bool{}
+		}
+		if artistID, ok := primaryArtists[ta.TrackID]; ok {
+			albumArtists[ta.AlbumID][artistNames[artistID]] = true
+		}
+	}
+
+	for _, a := range d.albums {
+		var id int64
+		var err error
+
+		if stringValue(a.MusicBrainzID) != "" {
+			err = tx.QueryRow(
+				"SELECT id FROM albums WHERE musicbrainz_id = ? ORDER BY id",
+				*a.MusicBrainzID,
+			).Scan(&id)
+		} else {
+			id, err = matchAlbum(tx, a.Title, albumArtists[a.ID])
+		}
+
+		if err == sql.ErrNoRows {
+			res, err := tx.Exec(
+				`INSERT INTO albums
+				            (musicbrainz_id, title)
+				     VALUES (?,?)`,
+				a.MusicBrainzID, a.Title,
+			)
+			if err != nil {
+				return err
+			}
+			if id, err = res.LastInsertId(); err != nil {
+				return err
+			}
+
+			stats.Added[EntityAlbum]++
+		} else if err != nil {
+			return err
+		} else {
+			stats.Matched[EntityAlbum]++
+		}
+
+		albumIDs[a.ID] = id
+	}
+
+	for _, t := range d.tracks {
+		var id int64
+		var err error
+
+		if stringValue(t.MusicBrainzID) != "" {
+			err = tx.QueryRow(
+				"SELECT id FROM tracks WHERE musicbrainz_id = ? ORDER BY id",
+				*t.MusicBrainzID,
+			).Scan(&id)
+		} else {
+			err = tx.QueryRow(
+				`SELECT t.id
+				   FROM tracks       t
+				   JOIN track_artist tar ON tar.track_id = t.id
+				  WHERE t.title               = ?
+				    AND tar.artist_id         = ?
+				    AND tar.is_primary_artist = 1
+				  ORDER BY t.id`,
+				t.Title,
+				artistIDs[primaryArtists[t.ID]],
+			).Scan(&id)
+		}
+
+		if err == sql.ErrNoRows {
+			if id, err = insertDumpTrack(tx, t, 0); err != nil {
+				return err
+			}
+
+			stats.Added[EntityTrack]++
+		} else if err != nil {
+			return err
+		} else {
+			stats.Matched[EntityTrack]++
+		}
+
+		trackIDs[t.ID] = id
+	}
+
+	for _, ta := range d.trackArtists {
+		trackID, artistID, err := mapLink(
+			trackIDs, ta.TrackID, artistIDs, ta.ArtistID, "artist",
+		)
+		if err != nil {
+			return err
+		}
+
+		// A matched track keeps its existing primary artist
+		if _, err = tx.Exec(
+			`INSERT OR IGNORE INTO track_artist
+			                      (track_id, artist_id, is_primary_artist)
+			               VALUES (?,?,?)`,
+			trackID,
+			artistID,
+			ta.Primary,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, ta := range d.trackAlbums {
+		trackID, albumID, err := mapLink(
+			trackIDs, ta.TrackID, albumIDs, ta.AlbumID, "album",
+		)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(
+			`INSERT OR IGNORE INTO track_album
+			                      (track_id, album_id)
+			               VALUES (?,?)`,
+			trackID,
+			albumID,
+		); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range d.trackFiles {
+		id, ok := trackIDs[f.TrackID]
+		if !ok {
+			return fmt.Errorf("Audio file refers to missing track %d", f.TrackID)
+		}
+		f.TrackID = id
+
+		if err := insertDumpTrackFile(tx, f, stats); err != nil {
+			return err
+		}
+	}
+
+	idMaps := map[Entity]map[int64]int64{
+		EntityTrack:  trackIDs,
+		EntityAlbum:  albumIDs,
+		EntityArtist: artistIDs,
+	}
+
+	existingComparisons, err := comparisonKeys(tx)
+	if err != nil {
+		return err
+	}
+
+	replay := map[Entity]bool{}
+
+	for _, c := range d.comparisons {
+		ids := idMaps[c.Entity]
+
+		profile, ok := profileIDs[c.Profile]
+		if !ok {
+			return fmt.Errorf(
+				"Comparison %d refers to missing profile %d", c.ID, c.Profile,
+			)
+		}
+		c.Profile = profile
+
+		for i, r := range c.Results {
+			id, ok := ids[r.EntityID]
+			if !ok {
+				return fmt.Errorf(
+					"Comparison %d refers to missing %s %d",
+					c.ID, c.Entity, r.EntityID,
+				)
+			}
+
+			c.Results[i].EntityID = id
+		}
+
+		// Identical comparisons can be made in the same second, so
+		// count them off rather than just checking for one
+		key := comparisonKey(c)
+		if existingComparisons[key] > 0 {
+			existingComparisons[key]--
+			stats.ComparisonsSkipped++
+			continue
+		}
+
+		if _, err = insertDumpComparison(tx, c, 0); err != nil {
+			return err
+		}
+
+		stats.ComparisonsAdded++
+		replay[c.Entity] = true
+	}
+
+	for _, s := range d.snapshots {
+		profile, ok := profileIDs[s.Profile]
+		if !ok {
+			return fmt.Errorf(
+				"Snapshot %d refers to missing profile %d", s.ID, s.Profile,
+			)
+		}
+		s.Profile = profile
+
+		var exists bool
+		if err = tx.QueryRow(
+			`SELECT COUNT(*) > 0
+			   FROM snapshots
+			  WHERE profile_id        = ?
+			    AND taken_at          = ?
+			    AND IFNULL(label, '') = ?`,
+			s.Profile,
+			s.TakenAt,
+			stringValue(s.Label),
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			stats.SnapshotsSkipped++
+			continue
+		}
+
+		for i, r := range s.Rankings {
+			id, ok := trackIDs[r.TrackID]
+			if !ok {
+				return fmt.Errorf(
+					"Snapshot %d refers to missing track %d", s.ID, r.TrackID,
+				)
+			}
+
+			s.Rankings[i].TrackID = id
+		}
+
+		if _, err = insertDumpSnapshot(tx, s, 0); err != nil {
+			return err
+		}
+
+		stats.SnapshotsAdded++
+	}
+
+	for _, l := range d.listens {
+		id, ok := trackIDs[l.TrackID]
+		if !ok {
+			return fmt.Errorf("Listen refers to missing track %d", l.TrackID)
+		}
+
+		profile, ok := profileIDs[l.Profile]
+		if !ok {
+			return fmt.Errorf("Listen refers to missing profile %d", l.Profile)
+		}
+
+		res, err := tx.Exec(
+			`INSERT OR IGNORE INTO listens
+			             (profile_id, track_id, listened_at, source)
+			      VALUES (?,?,?,?)`,
+			profile, id, l.ListenedAt, l.Source,
+		)
+		if err != nil {
+			return err
+		}
+
+		added, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		stats.ListensAdded += int(added)
+		stats.ListensSkipped += 1 - int(added)
+	}
+
+	for _, a := range d.annotations {
+		id, ok := idMaps[a.Entity][a.EntityID]
+		if !ok {
+			return fmt.Errorf(
+				"Annotation refers to missing %s %d", a.Entity, a.EntityID,
+			)
+		}
+		a.EntityID = id
+
+		profile, ok := profileIDs[a.Profile]
+		if !ok {
+			return fmt.Errorf(
+				"Annotation refers to missing profile %d", a.Profile,
+			)
+		}
+		a.Profile = profile
+
+		before, err := startingRanking(tx, int(profile), a.Entity, int(id))
+		if err != nil {
+			return err
+		}
+
+		added, err := insertDumpAnnotation(tx, a)
+		if err != nil {
+			return err
+		}
+		if !added {
+			stats.AnnotationsSkipped++
+			continue
+		}
+
+		stats.AnnotationsAdded++
+
+		after, err := startingRanking(tx, int(profile), a.Entity, int(id))
+		if err != nil {
+			return err
+		}
+		replay[a.Entity] = replay[a.Entity] || after != before
+	}
+
+	// New comparisons were rated against the other DB's rankings
+	for _, entity := range []Entity{EntityTrack, EntityAlbum, EntityArtist} {
+		if replay[entity] {
+			if err = replayComparisons(tx, entity); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// The insertDump functions keep the dumped ID if keepID is set, otherwise
+// let SQLite pick one. They return the ID used.
+
+func insertDumpTrack(tx *sql.Tx, t dumpTrack, keepID int64) (int64, error) {
+	// Older dumps have the priors of ratings and stars set by hand on the
+	// track. They come back with its annotation.
+	if t.PriorSource != nil && (*t.PriorSource == PriorSourceUserRating ||
+		*t.PriorSource == PriorSourceStarred) {
+		t.Prior, t.PriorSource = nil, nil
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO tracks
+		            (id, musicbrainz_id, title, genre, year, prior,
+		             prior_source)
+		     VALUES (?,?,?,?,?,?,?)`,
+		nullID(keepID), t.MusicBrainzID, t.Title, t.Genre, t.Year,
+		t.Prior, t.PriorSource,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+func insertDumpComparison(
+	tx *sql.Tx, c dumpComparison, keepID int64,
+) (int64, error) {
+	res, err := tx.Exec(
+		`INSERT INTO comparisons
+		            (id, profile_id, entity, preference, multiplier, source,
+		             created_at)
+		     VALUES (?,?,?,?,?,IFNULL(NULLIF(?, ''), ?),?)`,
+		nullID(keepID), dumpProfileID(c.Profile), c.Entity, c.Preference,
+		c.Multiplier, c.Source, ComparisonSourceVote, c.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	comparisonID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range c.Results {
+		if _, err = tx.Exec(
+			`INSERT INTO comparison_results
+			            (comparison_id, entity_id, score, place, k,
+			             ranking_before, ranking_after)
+			     VALUES (?,?,?,?,?,?,?)`,
+			comparisonID,
+			r.EntityID,
+			r.Score,
+			r.Place,
+			r.K,
+			r.RankingBefore,
+			r.RankingAfter,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return comparisonID, nil
+}
+
+func insertDumpSnapshot(
+	tx *sql.Tx, s dumpSnapshot, keepID int64,
+) (int64, error) {
+	res, err := tx.Exec(
+		`INSERT INTO snapshots
+		            (id, profile_id, taken_at, label)
+		     VALUES (?,?,?,?)`,
+		nullID(keepID), dumpProfileID(s.Profile), s.TakenAt, s.Label,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshotID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, r := range s.Rankings {
+		if _, err = tx.Exec(
+			`INSERT INTO snapshot_rankings
+			            (snapshot_id, track_id, ranking, position)
+			     VALUES (?,?,?,?)`,
+			snapshotID,
+			r.TrackID,
+			r.Ranking,
+			r.Position,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	return snapshotID, nil
+}
+
+// Finds an album without a MusicBrainz ID with the given title whose
+// tracks' primary artists are the same as artistNames
+func matchAlbum(
+	tx *sql.Tx, title *string, artistNames map[string]bool,
+) (int64, error) {
+	candidates := map[int64]map[string]bool{}
+	order := []int64{}
+
+	err := queryEach(tx,
+		`SELECT al.id, ar.name
+		   FROM albums            al
+		   LEFT JOIN track_album  tal ON tal.album_id = al.id
+		   LEFT JOIN track_artist tar ON tar.track_id = tal.track_id
+		                             AND tar.is_primary_artist = 1
+		   LEFT JOIN artists      ar  ON ar.id = tar.artist_id
+		  WHERE al.title = ?
+		    AND IFNULL(al.musicbrainz_id, '') = ''
+		  ORDER BY al.id`,
+		func(rows *sql.Rows) error {
+			var id int64
+			var name sql.NullString
+
+			if err := rows.Scan(&id, &name); err != nil {
+				return err
+			}
+
+			if candidates[id] == nil {
+				candidates[id] = map[string]bool{}
+				order = append(order, id)
+			}
+			if name.Valid {
+				candidates[id][name.String] = true
+			}
+
+			return nil
+		},
+		title,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range order {
+		if sameKeys(candidates[id], artistNames) {
+			return id, nil
+		}
+	}
+
+	return 0, sql.ErrNoRows
+}
+
+// Identifies a comparison across DBs, once its entity IDs are mapped
+func comparisonKey(c dumpComparison) string {
+	results := []string{}
+	for _, r := range c.Results {
+		score := "null"
+		if r.Score != nil {
+			score = fmt.Sprint(*r.Score)
+		}
+
+		results = append(results, fmt.Sprintf("%d=%s", r.EntityID, score))
+	}
+	sort.Strings(results)
+
+	return fmt.Sprintf(
+		"%d|%s|%s|%s",
+		dumpProfileID(c.Profile), c.Entity, c.CreatedAt, strings.Join(results, ","),
+	)
+}
+
+// Number of existing comparisons with each key
+func comparisonKeys(tx *sql.Tx) (map[string]int, error) {
+	comparisons := map[int64]*dumpComparison{}
+
+	err := queryEach(tx,
+		`SELECT c.id, c.profile_id, c.entity, c.created_at, cr.entity_id,
+		        cr.score
+		   FROM comparisons        c
+		   JOIN comparison_results cr ON cr.comparison_id = c.id`,
+		func(rows *sql.Rows) error {
+			var c dumpComparison
+			var r dumpResult
+
+			if err := rows.Scan(
+				&c.ID, &c.Profile, &c.Entity, &c.CreatedAt, &r.EntityID,
+				&r.Score,
+			); err != nil {
+				return err
+			}
+
+			if comparisons[c.ID] == nil {
+				comparisons[c.ID] = &c
+			}
+			comparisons[c.ID].Results = append(comparisons[c.ID].Results, r)
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]int{}
+	for _, c := range comparisons {
+		keys[comparisonKey(*c)]++
+	}
+
+	return keys, nil
+}
+
+func mapLink(
+	trackIDs map[int64]int64,
+	trackID int64,
+	otherIDs map[int64]int64,
+	otherID int64,
+	other string,
+) (int64, int64, error) {
+	newTrackID, ok := trackIDs[trackID]
+	if !ok {
+		return 0, 0, fmt.Errorf("Link to missing track %d", trackID)
+	}
+
+	newOtherID, ok := otherIDs[otherID]
+	if !ok {
+		return 0, 0, fmt.Errorf("Link to missing %s %d", other, otherID)
+	}
+
+	return newTrackID, newOtherID, nil
+}
+
+// Runs query and calls f for each row
+func queryEach(
+	db querier, query string, f func(rows *sql.Rows) error, args ...interface{},
+) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = f(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
+
+// Profile 0 in a dump is the default
+func dumpProfileID(id int64) int64 {
+	if id == 0 {
+		return DefaultProfile
+	}
+
+	return id
+}
+
+// 0 = let SQLite pick
+func nullID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+
+	return id
+}
+
+func sameKeys(a map[string]bool, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Links the file under the DB's library folder. A file already linked,
+// perhaps to another track by a scan, keeps its link.
+func insertDumpTrackFile(tx *sql.Tx, f dumpTrackFile, stats *ImportStats) error {
+	root, err := LoadLibraryRoot(tx)
+	if err != nil {
+		return err
+	}
+	if root == "" {
+		stats.FilesSkipped++
+		return nil
+	}
+
+	path := filepath.Join(root, filepath.FromSlash(f.Path))
+	if _, ok := libraryRelative(root, path); !ok {
+		return fmt.Errorf("Audio file '%s' is outside the library folder", f.Path)
+	}
+
+	res, err := tx.Exec(
+		`INSERT OR IGNORE INTO track_files
+		             (path, track_id)
+		      VALUES (?,?)`,
+		path,
+		f.TrackID,
+	)
+	if err != nil {
+		return err
+	}
+
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	stats.FilesAdded += int(added)
+	stats.FilesSkipped += 1 - int(added)
+	return nil
+}
+
+// Path relative to root, with forward slashes, if it is under root
+func libraryRelative(root string, path string) (string, bool) {
+	rel, ok := track.InFolder(root, path)
+	if !ok {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+func insertDumpListen(tx *sql.Tx, l dumpListen) error {
+	_, err := tx.Exec(
+		`INSERT INTO listens
+		            (profile_id, track_id, listened_at, source)
+		     VALUES (?,?,?,?)`,
+		dumpProfileID(l.Profile), l.TrackID, l.ListenedAt, l.Source,
+	)
+
+	return err
+}
+
+// Existing annotations are kept. The prior is set from an added one.
+// Returns whether a was added.
+func insertDumpAnnotation(tx *sql.Tx, a dumpAnnotation) (bool, error) {
+	res, err := tx.Exec(
+		`INSERT OR IGNORE INTO annotations
+		             (profile_id, entity, entity_id, rating, starred_at,
+		              listen_prior)
+		      VALUES (?,?,?,?,?,?)`,
+		dumpProfileID(a.Profile), a.Entity, a.EntityID, a.Rating, a.StarredAt,
+		a.ListenPrior,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	added, err := res.RowsAffected()
+	if err != nil || added == 0 {
+		return false, err
+	}
+
+	if err = annotationPrior(
+		tx, int(dumpProfileID(a.Profile)), a.Entity, int(a.EntityID),
+	); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,607 @@
+package repo
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	db := dumpTestDB(t)
+
+	var first bytes.Buffer
+	if err := Dump(db, &first); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Restore into empty DB")
+
+	restored := test_utils.DBSetup()
+
+	// The library is somewhere else on this machine
+	if err := SaveLibraryRoot(restored, "/home/sam/Music"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Import(restored, bytes.NewReader(first.Bytes()), ImportRestore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Added[EntityTrack] != 3 || stats.ComparisonsAdded != 5 ||
+		stats.SnapshotsAdded != 1 || stats.ListensAdded != 2 ||
+		stats.AnnotationsAdded != 2 || stats.FilesAdded != 1 {
+		t.Errorf(
+			"Expected 3 tracks, 5 comparisons, 1 snapshot, 2 listens, "+
+				"2 annotations, 1 file, got %+v",
+			stats,
+		)
+	}
+
+	t.Log("Audio files are under this machine's library folder")
+
+	files, err := TrackFiles(restored, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "/home/sam/Music/Artist 1/01 Title 1.flac" {
+		t.Errorf("Expected file under new library folder, got %v", files)
+	}
+
+	root, err := LoadLibraryRoot(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != "/home/sam/Music" {
+		t.Errorf("Expected library folder to be kept, got '%s'", root)
+	}
+
+	var second bytes.Buffer
+	if err = Dump(restored, &second); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.String() != second.String() {
+		t.Errorf("\nExpected:\n%s\ngot:\n%s", first.String(), second.String())
+	}
+
+	t.Log("Restored DB works as before")
+
+	results, err := Search(restored, DefaultProfile, "artist 2", 0, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected search index to be restored, got %+v", results)
+	}
+
+	before, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ReplayComparisons(restored, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	after, err := GetContender(restored, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Errorf("Expected replay to give %+v, got %+v", before, after)
+	}
+
+	t.Log("Restore only into empty DB")
+
+	if _, err = Import(
+		restored, bytes.NewReader(first.Bytes()), ImportRestore,
+	); err == nil {
+		t.Error("Expected error restoring into non-empty DB")
+	}
+}
+
+func TestDumpMerge(t *testing.T) {
+	var dumped bytes.Buffer
+	if err := Dump(dumpTestDB(t), &dumped); err != nil {
+		t.Fatal(err)
+	}
+
+	db := test_utils.DBSetup()
+
+	config := elo.DefaultConfig()
+	config.K = 40
+	if err := SaveEloConfig(db, config); err != nil {
+		t.Fatal(err)
+	}
+
+	SaveTracks(db, []track.Track{
+		// Same as track 1 by MBID
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1 (retitled)",
+			Albums:        []track.Album{{MusicBrainzID: "AL1", Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		// Not in dump
+		track.New(track.Track{
+			MusicBrainzID: "MB9",
+			Title:         "Title 9",
+			Albums:        []track.Album{{Title: "Album 9"}},
+			PrimaryArtist: track.Artist{Name: "Artist 9"},
+		}),
+		// Same as track 2 by title and artist
+		track.New(track.Track{
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := Import(db, bytes.NewReader(dumped.Bytes()), ImportMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ImportStats{
+		Added: map[Entity]int{
+			EntityTrack:  1, // Title 3
+			EntityArtist: 1, // Artist 3
+		},
+		Matched: map[Entity]int{
+			EntityTrack:  2,
+			EntityAlbum:  2,
+			EntityArtist: 2,
+		},
+		ComparisonsAdded: 5,
+		SnapshotsAdded:   1,
+		ListensAdded:     2,
+		AnnotationsAdded: 2,
+		// No library folder to put it in
+		FilesSkipped: 1,
+	}
+	if !importStatsEqual(expected, stats) {
+		t.Errorf("\nExpected:\n%+v\ngot:\n%+v", expected, stats)
+	}
+
+	t.Log("Merged track keeps its title and gains its history")
+
+	merged, err := GetTrack(db, DefaultProfile, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.Title != "Title 1 (retitled)" || merged.Comparisons != 4 {
+		t.Errorf("Expected retitled track with 4 comparisons, got %+v", merged)
+	}
+
+	var prior sql.NullFloat64
+	if err = db.QueryRow(
+		"SELECT prior FROM annotations WHERE entity = 'track' AND entity_id = 1",
+	).Scan(&prior); err != nil {
+		t.Fatal(err)
+	}
+	if !prior.Valid {
+		t.Error("Expected prior from merged rating")
+	}
+
+	var comparisons int
+	if err = db.QueryRow(
+		"SELECT COUNT(*) FROM comparisons",
+	).Scan(&comparisons); err != nil {
+		t.Fatal(err)
+	}
+	if comparisons != 6 {
+		t.Errorf("Expected 6 comparisons, got %d", comparisons)
+	}
+
+	t.Log("Existing settings are kept")
+
+	config, err = LoadEloConfig(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.K != 40 {
+		t.Errorf("Expected K 40, got %v", config.K)
+	}
+
+	t.Log("Merging again adds nothing")
+
+	stats, err = Import(db, bytes.NewReader(dumped.Bytes()), ImportMerge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = ImportStats{
+		Matched: map[Entity]int{
+			EntityTrack:  3,
+			EntityAlbum:  2,
+			EntityArtist: 3,
+		},
+		ComparisonsSkipped: 5,
+		SnapshotsSkipped:   1,
+		ListensSkipped:     2,
+		AnnotationsSkipped: 2,
+		FilesSkipped:       1,
+	}
+	if !importStatsEqual(expected, stats) {
+		t.Errorf("\nExpected:\n%+v\ngot:\n%+v", expected, stats)
+	}
+}
+
+func TestDumpMergeOrder(t *testing.T) {
+	setup := func(createdAt string, winner int, loser int) *sql.DB {
+		db := test_utils.DBSetup()
+
+		SaveTracks(db, []track.Track{
+			track.New(track.Track{MusicBrainzID: "MB1", Title: "Title 1"}),
+			track.New(track.Track{MusicBrainzID: "MB2", Title: "Title 2"}),
+		})
+
+		if _, err := RecordComparison(
+			db, DefaultProfile, EntityTrack, winner, loser, 1,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("UPDATE comparisons SET created_at = ?", createdAt); err != nil {
+			t.Fatal(err)
+		}
+
+		return db
+	}
+
+	db := setup("2021-06-01 12:00:00", 1, 2)
+
+	var dumped bytes.Buffer
+	if err := Dump(setup("2021-01-01 12:00:00", 2, 1), &dumped); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Import(db, &dumped, ImportMerge); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("The other laptop's earlier comparison is replayed first")
+
+	var before float64
+	if err := db.QueryRow(
+		`SELECT cr.ranking_before
+		   FROM comparisons        c
+		   JOIN comparison_results cr ON cr.comparison_id = c.id
+		  WHERE c.created_at  = '2021-01-01 12:00:00'
+		    AND cr.entity_id = 2`,
+	).Scan(&before); err != nil {
+		t.Fatal(err)
+	}
+	if before != elo.DefaultStartingRating {
+		t.Errorf("Expected to be rated from the start, got %v", before)
+	}
+
+	merged, err := GetTrack(db, DefaultProfile, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lost then won, so ends up ahead
+	if merged.Ranking <= elo.DefaultStartingRating {
+		t.Errorf("Expected track 1 to end up ahead, got %v", merged.Ranking)
+	}
+}
+
+func TestDumpProfiles(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	sam, err := CreateProfile(db, "Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RecordComparison(db, sam.ID, EntityTrack, 1, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetRating(db, sam.ID, EntityTrack, 2, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sam's rating, and none in the default profile
+	checkRatings := func(db *sql.DB, sam int) {
+		t.Helper()
+
+		for profile, expected := range map[int]int{sam: 5, DefaultProfile: 0} {
+			a, err := GetAnnotation(db, profile, EntityTrack, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Rating != expected {
+				t.Errorf("Profile %d: expected %d stars, got %d", profile, expected, a.Rating)
+			}
+		}
+	}
+
+	var dumped bytes.Buffer
+	if err = Dump(db, &dumped); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Restore keeps profile IDs")
+
+	restored := test_utils.DBSetup()
+
+	stats, err := Import(restored, bytes.NewReader(dumped.Bytes()), ImportRestore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ProfilesAdded != 1 {
+		t.Errorf("Expected 1 profile added, got %d", stats.ProfilesAdded)
+	}
+
+	before, err := GetContender(db, sam.ID, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := GetContender(restored, sam.ID, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Errorf("Expected %+v, got %+v", before, after)
+	}
+	checkRatings(restored, sam.ID)
+
+	t.Log("Merge matches profiles by name")
+
+	merged := test_utils.DBSetup()
+
+	if _, err = CreateProfile(merged, "Alex"); err != nil {
+		t.Fatal(err)
+	}
+	mergedSam, err := CreateProfile(merged, "SAM")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats, err = Import(
+		merged, bytes.NewReader(dumped.Bytes()), ImportMerge,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ProfilesAdded != 0 {
+		t.Errorf("Expected no profiles added, got %d", stats.ProfilesAdded)
+	}
+
+	if after, err = GetContender(
+		merged, mergedSam.ID, EntityTrack, 2,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Errorf("Expected %+v, got %+v", before, after)
+	}
+	checkRatings(merged, mergedSam.ID)
+
+	t.Log("Version 1 dumps go in the default profile")
+
+	v1 := test_utils.DBSetup()
+
+	if _, err = Import(v1, strings.NewReader(
+		`{"type": "header", "data": {"version": 1}}
+{"type": "track", "data": {"id": 1, "title": "Title 1", "ranking": 1016, "comparisons": 1}}
+{"type": "track", "data": {"id": 2, "title": "Title 2", "ranking": 1000, "comparisons": 0}}`,
+	), ImportRestore); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, expected := range map[int]Contender{
+		1: {InternalID: 1, Name: "Title 1", Ranking: 1016, Comparisons: 1},
+		2: {InternalID: 2, Name: "Title 2", Ranking: elo.DefaultConfig().StartingRating},
+	} {
+		got, err := GetContender(v1, DefaultProfile, EntityTrack, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+		}
+	}
+
+	t.Log("Version 3 dumps' priors from listens go on the default profile")
+
+	v3 := test_utils.DBSetup()
+
+	if _, err = Import(v3, strings.NewReader(
+		`{"type": "header", "data": {"version": 3}}
+{"type": "track", "data": {"id": 1, "title": "Title 1", "prior": 1025, "prior_source": "listens"}}
+{"type": "listen", "data": {"track_id": 1, "listened_at": 1600000000, "source": "lastfm"}}`,
+	), ImportRestore); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GetTrack(v3, DefaultProfile, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Ranking != 1025 || got.Plays != 1 {
+		t.Errorf("Expected ranking 1025 and 1 play, got %v, %d", got.Ranking, got.Plays)
+	}
+
+	sources, err := PriorSources(v3, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[priorSourceListens] != 1 {
+		t.Errorf("Expected 1 prior from listens, got %v", sources)
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	for _, dumped := range []string{
+		"",
+		`{"type": "track", "data": {"id": 1}}`,
+		`{"type": "header", "data": {"version": 99}}`,
+		`{"type": "header", "data": {"version": 1}}
+{"type": "colour", "data": {}}`,
+		`{"type": "header", "data": {"version": 1}}
+{"type": "track_album", "data": {"track_id": 1, "album_id": 1}}`,
+	} {
+		if _, err := Import(
+			db, strings.NewReader(dumped), ImportMerge,
+		); err == nil {
+			t.Errorf("Expected error importing %s", dumped)
+		}
+	}
+
+	var tracks int
+	if err := db.QueryRow("SELECT COUNT(*) FROM tracks").Scan(&tracks); err != nil {
+		t.Fatal(err)
+	}
+	if tracks != 0 {
+		t.Errorf("Expected failed imports to change nothing, got %d tracks", tracks)
+	}
+}
+
+// Three tracks, compared as tracks, albums and artists, with audio files,
+// a snapshot, some listens, a rating, a star and a custom config
+func dumpTestDB(t *testing.T) *sql.DB {
+	db := test_utils.DBSetup()
+
+	config := elo.DefaultConfig()
+	config.K = 24
+	if err := SaveEloConfig(db, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveLibraryRoot(db, "/music"); err != nil {
+		t.Fatal(err)
+	}
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Genre:         "Rock",
+			Year:          1991,
+			Albums:        []track.Album{{MusicBrainzID: "AL1", Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Files:         []string{"/music/Artist 1/01 Title 1.flac"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+			OtherArtists:  []track.Artist{{Name: "Artist 1"}},
+			// Outside the library, so not dumped
+			Files: []string{"/downloads/Title 2.mp3"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 3",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+			OtherArtists:  []track.Artist{{Name: "Artist 2"}},
+		}),
+	})
+
+	// Albums without MBIDs are never reused by SaveTracks
+	if _, err := db.Exec("DELETE FROM albums WHERE id = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE track_album SET album_id = 2 WHERE track_id = 3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Identical comparisons in the same second are both kept
+	for i := 0; i < 2; i++ {
+		if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := RecordGradedComparison(db, DefaultProfile, EntityTrack, 3, 1, 0.25); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecordComparison(db, DefaultProfile, EntityAlbum, 1, 2, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecordRankedComparison(
+		db, DefaultProfile, EntityArtist, []int{3, 1, 2},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := TakeSnapshot(db, DefaultProfile, "Before move"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(
+		`INSERT INTO listens
+		             (track_id, listened_at, source)
+		      VALUES (1, 1600000000, 'lastfm'), (1, 1600000300, 'lastfm')`,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetRating(db, DefaultProfile, EntityTrack, 1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetStarred(db, DefaultProfile, EntityAlbum, 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func importStatsEqual(a ImportStats, b ImportStats) bool {
+	counts := func(m map[Entity]int) map[Entity]int {
+		nonZero := map[Entity]int{}
+		for entity, n := range m {
+			if n != 0 {
+				nonZero[entity] = n
+			}
+		}
+		return nonZero
+	}
+
+	a.Added, b.Added = counts(a.Added), counts(b.Added)
+	a.Matched, b.Matched = counts(a.Matched), counts(b.Matched)
+
+	return len(a.Added) == len(b.Added) &&
+		len(a.Matched) == len(b.Matched) &&
+		sameCounts(a.Added, b.Added) &&
+		sameCounts(a.Matched, b.Matched) &&
+		a.ComparisonsAdded == b.ComparisonsAdded &&
+		a.ComparisonsSkipped == b.ComparisonsSkipped &&
+		a.SnapshotsAdded == b.SnapshotsAdded &&
+		a.SnapshotsSkipped == b.SnapshotsSkipped &&
+		a.ListensAdded == b.ListensAdded &&
+		a.ListensSkipped == b.ListensSkipped &&
+		a.AnnotationsAdded == b.AnnotationsAdded &&
+		a.AnnotationsSkipped == b.AnnotationsSkipped &&
+		a.ProfilesAdded == b.ProfilesAdded &&
+		a.FilesAdded == b.FilesAdded &&
+		a.FilesSkipped == b.FilesSkipped
+}
+
+func sameCounts(a map[Entity]int, b map[Entity]int) bool {
+	for entity, n := range a {
+		if b[entity] != n {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,348 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const timestampFormat = "2006-01-02 15:04:05"
+
+// Snapshot is a copy of one profile's track rankings at a point in time
+type Snapshot struct {
+	ID      int
+	Profile int
+	TakenAt time.Time
+	Label   string
+}
+
+// HistoryPoint is a track's ranking as of a snapshot
+type HistoryPoint struct {
+	Snapshot Snapshot
+	Ranking  float64
+	Position int
+}
+
+// Movement is the change in a track's ranking between two snapshots
+type Movement struct {
+	TrackID       int
+	Title         string
+	PrimaryArtist string
+
+	RankingBefore float64
+	RankingAfter  float64
+
+	PositionBefore int
+	PositionAfter  int
+}
+
+func (m Movement) Change() float64 {
+	return m.RankingAfter - m.RankingBefore
+}
+
+// TakeSnapshot records the current ranking of every track the profile
+// has compared
+func TakeSnapshot(db *sql.DB, profile int, label string) (Snapshot, error) {
+	return takeSnapshot(db, profile, label, time.Now())
+}
+
+// TakeDailySnapshot takes a snapshot for the profile unless one has
+// already been taken today (UTC). Returns whether a snapshot was taken.
+func TakeDailySnapshot(db *sql.DB, profile int) (Snapshot, bool, error) {
+	return takeDailySnapshot(db, profile, time.Now())
+}
+
+func takeDailySnapshot(db *sql.DB, profile int, now time.Time) (
+	Snapshot, bool, error,
+) {
+	var count int
+
+	row := db.QueryRow(
+		`SELECT COUNT(*)
+		   FROM snapshots
+		  WHERE profile_id     = ?
+		    AND DATE(taken_at) = ?`,
+		profile,
+		now.UTC().Format("2006-01-02"),
+	)
+	if err := row.Scan(&count); err != nil {
+		return Snapshot{}, false, err
+	}
+
+	if count > 0 {
+		return Snapshot{}, false, nil
+	}
+
+	snapshot, err := takeSnapshot(db, profile, "daily", now)
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+
+	return snapshot, true, nil
+}
+
+func takeSnapshot(
+	db *sql.DB, profile int, label string, takenAt time.Time,
+) (Snapshot, error) {
+	snapshot := Snapshot{
+		Profile: profile,
+		TakenAt: takenAt.UTC().Truncate(time.Second),
+		Label:   label,
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO snapshots
+		             (profile_id, taken_at, label)
+		      VALUES (?,?,?)`,
+		profile,
+		snapshot.TakenAt.Format(timestampFormat),
+		label,
+	)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.ID = int(id)
+
+	if _, err = tx.Exec(
+		`INSERT INTO snapshot_rankings
+		            (snapshot_id, track_id, ranking, position)
+		     SELECT ?,
+		            entity_id,
+		            ranking,
+		            ROW_NUMBER() OVER (ORDER BY ranking DESC, entity_id)
+		       FROM rankings
+		      WHERE profile_id  = ?
+		        AND entity      = ?
+		        AND comparisons > 0`,
+		snapshot.ID,
+		profile,
+		EntityTrack,
+	); err != nil {
+		return Snapshot{}, err
+	}
+
+	return snapshot, tx.Commit()
+}
+
+// Snapshots returns all the profile's snapshots, oldest first
+func Snapshots(db *sql.DB, profile int) ([]Snapshot, error) {
+	rows, err := db.Query(
+		`SELECT id, profile_id, taken_at, IFNULL(label, '')
+		   FROM snapshots
+		  WHERE profile_id = ?
+		  ORDER BY taken_at, id`,
+		profile,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, rows.Err()
+}
+
+// SnapshotAt returns the profile's latest snapshot taken at or before t
+func SnapshotAt(db *sql.DB, profile int, t time.Time) (Snapshot, error) {
+	row := db.QueryRow(
+		`SELECT id, profile_id, taken_at, IFNULL(label, '')
+		   FROM snapshots
+		  WHERE profile_id = ?
+		    AND taken_at  <= ?
+		  ORDER BY taken_at DESC, id DESC
+		  LIMIT 1`,
+		profile,
+		t.UTC().Format(timestampFormat),
+	)
+
+	snapshot, err := scanSnapshot(row)
+	if err == sql.ErrNoRows {
+		return Snapshot{}, fmt.Errorf("No snapshot at or before %s", t)
+	}
+
+	return snapshot, err
+}
+
+// TrackHistory returns a track's ranking in the profile over time,
+// oldest first
+func TrackHistory(db *sql.DB, profile int, trackID int) ([]HistoryPoint, error) {
+	rows, err := db.Query(
+		`SELECT s.id, s.profile_id, s.taken_at, IFNULL(s.label, ''),
+		        sr.ranking, sr.position
+		   FROM snapshot_rankings sr
+		   JOIN snapshots         s  ON s.id = sr.snapshot_id
+		  WHERE s.profile_id = ?
+		    AND sr.track_id  = ?
+		  ORDER BY s.taken_at, s.id`,
+		profile,
+		trackID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []HistoryPoint{}
+	for rows.Next() {
+		var point HistoryPoint
+		var takenAt string
+
+		if err = rows.Scan(
+			&point.Snapshot.ID,
+			&point.Snapshot.Profile,
+			&takenAt,
+			&point.Snapshot.Label,
+			&point.Ranking,
+			&point.Position,
+		); err != nil {
+			return nil, err
+		}
+
+		if point.Snapshot.TakenAt, err = time.Parse(
+			timestampFormat, takenAt,
+		); err != nil {
+			return nil, err
+		}
+
+		points = append(points, point)
+	}
+
+	return points, rows.Err()
+}
+
+// Movers returns up to n tracks whose ranking rose the most and up to n
+// whose ranking fell the most between two of the profile's snapshots.
+// Only tracks in both snapshots are considered.
+func Movers(
+	db *sql.DB, profile int, fromSnapshotID int, toSnapshotID int, n int,
+) (risers []Movement, fallers []Movement, err error) {
+	for _, id := range []int{fromSnapshotID, toSnapshotID} {
+		var owner int
+
+		err = db.QueryRow(
+			`SELECT profile_id FROM snapshots WHERE id = ?`, id,
+		).Scan(&owner)
+		if err == sql.ErrNoRows || (err == nil && owner != profile) {
+			return nil, nil, fmt.Errorf("No snapshot %d for this profile", id)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rows, err := db.Query(
+		`SELECT t.id,
+		        IFNULL(t.title, ''),
+		        IFNULL((SELECT ar.name
+		                  FROM track_artist tar
+		                  JOIN artists      ar  ON ar.id = tar.artist_id
+		                 WHERE tar.track_id = t.id
+		                   AND tar.is_primary_artist = 1), ''),
+		        sr_from.ranking,
+		        sr_to.ranking,
+		        sr_from.position,
+		        sr_to.position
+		   FROM snapshot_rankings sr_from
+		   JOIN snapshot_rankings sr_to ON sr_to.track_id = sr_from.track_id
+		   JOIN tracks            t     ON t.id           = sr_from.track_id
+		  WHERE sr_from.snapshot_id = ?
+		    AND sr_to.snapshot_id   = ?`,
+		fromSnapshotID,
+		toSnapshotID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	movements := []Movement{}
+	for rows.Next() {
+		var m Movement
+
+		if err = rows.Scan(
+			&m.TrackID,
+			&m.Title,
+			&m.PrimaryArtist,
+			&m.RankingBefore,
+			&m.RankingAfter,
+			&m.PositionBefore,
+			&m.PositionAfter,
+		); err != nil {
+			return nil, nil, err
+		}
+
+		movements = append(movements, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(movements, func(i, j int) bool {
+		if movements[i].Change() != movements[j].Change() {
+			return movements[i].Change() > movements[j].Change()
+		}
+		return movements[i].TrackID < movements[j].TrackID
+	})
+
+	risers = []Movement{}
+	for _, m := range movements {
+		if m.Change() <= 0 || len(risers) == n {
+			break
+		}
+		risers = append(risers, m)
+	}
+
+	fallers = []Movement{}
+	for i := len(movements) - 1; i >= 0; i-- {
+		if movements[i].Change() >= 0 || len(fallers) == n {
+			break
+		}
+		fallers = append(fallers, movements[i])
+	}
+
+	return risers, fallers, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSnapshot(row scanner) (Snapshot, error) {
+	var snapshot Snapshot
+	var takenAt string
+
+	if err := row.Scan(
+		&snapshot.ID,
+		&snapshot.Profile,
+		&takenAt,
+		&snapshot.Label,
+	); err != nil {
+		return Snapshot{}, err
+	}
+
+	var err error
+	snapshot.TakenAt, err = time.Parse(timestampFormat, takenAt)
+
+	return snapshot, err
+}
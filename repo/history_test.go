@@ -0,0 +1,155 @@
+package repo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestSnapshots(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+		// Never compared, so never in a snapshot
+		track.New(track.Track{
+			MusicBrainzID: "MB4",
+			Title:         "Title 4",
+			PrimaryArtist: track.Artist{Name: "Artist 4"},
+		}),
+	})
+
+	day1 := time.Date(2021, 3, 1, 20, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	setRanking(t, db, 1, 1100, 1)
+	setRanking(t, db, 2, 1000, 1)
+	setRanking(t, db, 3, 900, 1)
+
+	first, err := takeSnapshot(db, DefaultProfile, "session", day1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setRanking(t, db, 1, 1050, 2)
+	setRanking(t, db, 2, 1000, 2)
+	setRanking(t, db, 3, 1120, 2)
+
+	second, taken, err := takeDailySnapshot(db, DefaultProfile, day2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !taken {
+		t.Fatal("Expected daily snapshot to be taken")
+	}
+
+	t.Log("Only one daily snapshot per day")
+
+	if _, taken, err = takeDailySnapshot(
+		db, DefaultProfile, day2.Add(time.Hour),
+	); err != nil || taken {
+		t.Errorf("Expected no second snapshot for day 2 (err: %v)", err)
+	}
+
+	snapshots, err := Snapshots(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Snapshot{
+		{ID: 1, TakenAt: day1, Label: "session"},
+		{ID: 2, TakenAt: day2, Label: "daily"},
+	}
+	if len(snapshots) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, snapshots)
+	}
+	for i := range expected {
+		if snapshots[i].ID != expected[i].ID ||
+			!snapshots[i].TakenAt.Equal(expected[i].TakenAt) ||
+			snapshots[i].Label != expected[i].Label {
+			t.Errorf("Expected %v, got %v", expected[i], snapshots[i])
+		}
+	}
+
+	t.Log("Snapshot lookup by date")
+
+	if got, err := SnapshotAt(db, DefaultProfile, day3); err != nil || got.ID != second.ID {
+		t.Errorf("Expected snapshot %d for day 3, got %v (err: %v)",
+			second.ID, got, err)
+	}
+	if got, err := SnapshotAt(db, DefaultProfile, day2.Add(-time.Minute)); err != nil ||
+		got.ID != first.ID {
+		t.Errorf("Expected snapshot %d before day 2, got %v (err: %v)",
+			first.ID, got, err)
+	}
+	if _, err := SnapshotAt(db, DefaultProfile, day1.Add(-time.Minute)); err == nil {
+		t.Error("Expected error for date before first snapshot")
+	}
+
+	t.Log("Track history")
+
+	history, err := TrackHistory(db, DefaultProfile, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 2 ||
+		history[0].Ranking != 900 || history[0].Position != 3 ||
+		history[1].Ranking != 1120 || history[1].Position != 1 {
+		t.Errorf("Unexpected history for track 3: %+v", history)
+	}
+
+	if history, err = TrackHistory(db, DefaultProfile, 4); err != nil || len(history) != 0 {
+		t.Errorf("Expected no history for track 4, got %v (err: %v)",
+			history, err)
+	}
+
+	t.Log("Risers and fallers")
+
+	risers, fallers, err := Movers(db, DefaultProfile, first.ID, second.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(risers) != 1 || risers[0].TrackID != 3 ||
+		risers[0].Change() != 220 || risers[0].PositionBefore != 3 ||
+		risers[0].PositionAfter != 1 || risers[0].PrimaryArtist != "Artist 3" {
+		t.Errorf("Expected track 3 to be the only riser, got %+v", risers)
+	}
+
+	// Track 2 didn't move so is neither
+	if len(fallers) != 1 || fallers[0].TrackID != 1 ||
+		fallers[0].Change() != -50 {
+		t.Errorf("Expected track 1 to be the only faller, got %+v", fallers)
+	}
+
+	t.Log("Snapshots must be the profile's")
+
+	sam, err := CreateProfile(db, "Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = Movers(db, sam.ID, first.ID, second.ID, 5); err == nil {
+		t.Error("Expected error for another profile's snapshots")
+	}
+	if _, _, err = Movers(db, DefaultProfile, first.ID, 99, 5); err == nil {
+		t.Error("Expected error for unknown snapshot")
+	}
+}
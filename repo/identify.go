@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SetTrackMBID gives a track with no MusicBrainz ID the one it was
+// identified as, so later rescans and imports update it in place rather
+// than adding it again. Fails if another track already has that ID.
+func SetTrackMBID(db *sql.DB, trackID int, mbid string) error {
+	if mbid == "" {
+		return fmt.Errorf("No MusicBrainz ID given")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err = tx.QueryRow(
+		`SELECT IFNULL(musicbrainz_id, '')
+		   FROM tracks
+		  WHERE id = ?`,
+		trackID,
+	).Scan(&current); err != nil {
+		return err
+	}
+
+	if current == mbid {
+		return nil
+	}
+	if current != "" {
+		return fmt.Errorf(
+			"Track %d already has MusicBrainz ID %s", trackID, current,
+		)
+	}
+
+	var other int
+	err = tx.QueryRow(
+		`SELECT id
+		   FROM tracks
+		  WHERE musicbrainz_id = ?`,
+		mbid,
+	).Scan(&other)
+	if err == nil {
+		return fmt.Errorf(
+			"Track %d already has MusicBrainz ID %s", other, mbid,
+		)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	if _, err = tx.Exec(
+		`UPDATE tracks
+		    SET musicbrainz_id = ?
+		  WHERE id = ?`,
+		mbid,
+		trackID,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
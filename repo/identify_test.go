@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestSetTrackMBID(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	})
+
+	unidentified := func() []int {
+		t.Helper()
+
+		page, err := QueryRankings(db, RankingQuery{
+			Unidentified: true,
+			OrderBy:      OrderByTitle,
+			Ascending:    true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int{}
+		for _, tr := range page.Tracks {
+			ids = append(ids, tr.InternalID)
+		}
+		return ids
+	}
+
+	if ids := unidentified(); len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("Expected tracks 2 and 3 unidentified, got %v", ids)
+	}
+
+	if err := SetTrackMBID(db, 2, "MB2"); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := GetTrack(db, DefaultProfile, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.MusicBrainzID != "MB2" {
+		t.Errorf("Expected MB2, got '%s'", tr.MusicBrainzID)
+	}
+
+	if ids := unidentified(); len(ids) != 1 || ids[0] != 3 {
+		t.Errorf("Expected track 3 unidentified, got %v", ids)
+	}
+
+	t.Log("Same ID again is fine")
+
+	if err = SetTrackMBID(db, 2, "MB2"); err != nil {
+		t.Error(err)
+	}
+
+	t.Log("IDs already in use, or tracks already identified")
+
+	for _, test := range []struct {
+		trackID int
+		mbid    string
+	}{
+		{3, "MB1"},
+		{2, "MB3"},
+		{3, ""},
+	} {
+		if err = SetTrackMBID(db, test.trackID, test.mbid); err == nil {
+			t.Errorf("Expected error setting track %d to '%s'", test.trackID, test.mbid)
+		}
+	}
+
+	if err = SetTrackMBID(db, 99, "MB3"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+)
+
+// RecordImplicitComparison records a comparison between two tracks that
+// was inferred from listening rather than voted on, e.g. a track skipped
+// while its neighbour was played through. weight is the K multiplier,
+// so it can count for less than a vote. source says what inferred it.
+func RecordImplicitComparison(
+	db *sql.DB,
+	profile int,
+	aID int,
+	bID int,
+	scoreA float64,
+	weight float64,
+	source string,
+) (int64, error) {
+	if source == "" || source == ComparisonSourceVote {
+		return 0, errors.New("Implicit comparisons need their own source")
+	}
+
+	return recordComparison(
+		db, profile, EntityTrack, aID, bID,
+		elo.Outcome{ScoreA: scoreA, Multiplier: weight}, nil, source,
+	)
+}
+
+// TrackIDForFile returns the ID of the track with the audio file at the
+// absolute path, or sql.ErrNoRows if there isn't one
+func TrackIDForFile(db *sql.DB, path string) (int, error) {
+	var id int
+
+	err := db.QueryRow(
+		"SELECT track_id FROM track_files WHERE path = ?",
+		path,
+	).Scan(&id)
+
+	return id, err
+}
+
+// PlayWeights are the K multipliers for comparisons inferred from a track
+// being skipped or played through. 0 records nothing.
+type PlayWeights struct {
+	Skipped       float64 // The track loses to each of its neighbours
+	PlayedThrough float64 // The track beats each of its neighbours
+}
+
+// Skips say more than plays, as most of what is queued gets played
+func DefaultPlayWeights() PlayWeights {
+	return PlayWeights{Skipped: 0.25, PlayedThrough: 0.1}
+}
+
+// RecordPlay records a track that was skipped early, or played through,
+// as losing to, or beating, each of the tracks next to it in the play
+// queue. Tracks are given by the absolute paths of their files; unknown
+// files are ignored. Comparisons go in the profile of whoever was
+// listening. Returns the number of comparisons recorded.
+func RecordPlay(
+	db *sql.DB,
+	profile int,
+	path string,
+	neighbours []string,
+	skipped bool,
+	weights PlayWeights,
+	source string,
+) (int, error) {
+	score, weight := 1.0, weights.PlayedThrough
+	if skipped {
+		score, weight = 0, weights.Skipped
+	}
+	if weight == 0 {
+		return 0, nil
+	}
+
+	trackID, err := TrackIDForFile(db, path)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+
+	for _, neighbour := range neighbours {
+		neighbourID, err := TrackIDForFile(db, neighbour)
+		if err == sql.ErrNoRows || neighbourID == trackID {
+			continue
+		}
+		if err != nil {
+			return recorded, err
+		}
+
+		if _, err = RecordImplicitComparison(
+			db, profile, trackID, neighbourID, score, weight, source,
+		); err != nil {
+			return recorded, err
+		}
+
+		recorded++
+	}
+
+	return recorded, nil
+}
@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestRecordPlay(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	for i, title := range []string{"Title 1", "Title 2", "Title 3"} {
+		SaveTracks(db, []track.Track{
+			track.New(track.Track{
+				Title:         title,
+				PrimaryArtist: track.Artist{Name: "Artist 1"},
+				Files:         []string{"/music/" + string(rune('a'+i)) + ".flac"},
+			}),
+		})
+	}
+
+	weights := PlayWeights{Skipped: 0.5, PlayedThrough: 0}
+
+	t.Log("Skipped track loses to its known neighbours")
+
+	recorded, err := RecordPlay(
+		db, DefaultProfile,
+		"/music/b.flac",
+		[]string{"/music/a.flac", "/music/unknown.flac", "/music/c.flac"},
+		true,
+		weights,
+		"mpd",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("Expected 2 comparisons, got %d", recorded)
+	}
+
+	skipped, err := GetContender(db, DefaultProfile, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped.Ranking >= 1000 || skipped.Comparisons != 2 {
+		t.Errorf("Expected skipped track to lose twice, got %+v", skipped)
+	}
+
+	// Two losses at half the ranking change of a vote (16) each
+	if change := 1000 - skipped.Ranking; change < 15 || change > 17 {
+		t.Errorf("Expected weighted losses of about 16, lost %v", change)
+	}
+
+	t.Log("Zero weight and unknown tracks record nothing")
+
+	for _, path := range []string{"/music/a.flac", "/music/unknown.flac"} {
+		recorded, err = RecordPlay(
+			db, DefaultProfile, path, []string{"/music/b.flac"},
+			path != "/music/a.flac", weights, "mpd",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorded != 0 {
+			t.Errorf("%s: expected nothing recorded, got %d", path, recorded)
+		}
+	}
+
+	if _, err = RecordImplicitComparison(
+		db, DefaultProfile, 1, 2, 1, 1, ComparisonSourceVote,
+	); err == nil {
+		t.Error("Expected error for implicit comparison recorded as a vote")
+	}
+
+	t.Log("Counted apart from votes")
+
+	if _, err = RecordComparison(db, DefaultProfile, EntityTrack, 1, 3, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := GetLibraryStats(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Comparisons[EntityTrack] != 1 || stats.ImplicitComparisons != 2 {
+		t.Errorf("Expected 1 vote and 2 implicit comparisons, got %+v", stats)
+	}
+
+	t.Log("Undo skips over implicit comparisons to the last vote")
+
+	if _, err = RecordPlay(
+		db, DefaultProfile, "/music/c.flac", []string{"/music/b.flac"}, false,
+		PlayWeights{PlayedThrough: 0.1}, "mpd",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = UndoLastComparison(db, DefaultProfile, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = GetLibraryStats(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Comparisons[EntityTrack] != 0 || stats.ImplicitComparisons != 3 {
+		t.Errorf("Expected no votes and 3 implicit comparisons, got %+v", stats)
+	}
+
+	first, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Comparisons != 1 {
+		t.Errorf("Expected vote to be replayed away, got %+v", first)
+	}
+
+	if _, err = UndoLastComparison(db, DefaultProfile, EntityTrack); err != ErrNothingToUndo {
+		t.Errorf("Expected ErrNothingToUndo, got %v", err)
+	}
+}
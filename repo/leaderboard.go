@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+type Aggregate string
+
+const (
+	AggregateMean     Aggregate = "mean"
+	AggregateMedian   Aggregate = "median"
+	AggregateTopKMean Aggregate = "top-k"
+	AggregateBayesian Aggregate = "bayesian"
+)
+
+const (
+	DefaultTopK        = 3
+	DefaultPriorWeight = 5
+)
+
+// LeaderboardEntry is an album or artist scored from the rankings of its
+// tracks
+type LeaderboardEntry struct {
+	InternalID    int
+	MusicBrainzID string
+	Name          string // Album title or artist name
+
+	RankedTracks int
+
+	Mean     float64
+	Median   float64
+	TopKMean float64 // Mean of the best TopK tracks
+
+	// Mean pulled towards the mean of all ranked tracks, by PriorWeight
+	// imaginary average tracks. Albums with only one or two ranked tracks
+	// cannot top the leaderboard on the strength of a single favourite.
+	BayesianScore float64
+}
+
+type LeaderboardOptions struct {
+	Profile int // Whose track rankings to use. Defaults to DefaultProfile.
+
+	// Tracks with fewer comparisons are treated as unranked and ignored.
+	// A track is never counted before its first comparison.
+	MinComparisons int
+
+	TopK        int     // Defaults to DefaultTopK
+	PriorWeight float64 // Defaults to DefaultPriorWeight
+
+	// Only count the primary artist of each track (artist leaderboard
+	// only)
+	PrimaryArtistsOnly bool
+
+	OrderBy Aggregate // Defaults to AggregateBayesian
+	Limit   int       // 0 = no limit
+}
+
+func AlbumLeaderboard(db *sql.DB, opts LeaderboardOptions) (
+	[]LeaderboardEntry, error,
+) {
+	return leaderboard(
+		db,
+		opts,
+		`SELECT al.id,
+		        IFNULL(al.musicbrainz_id, ''),
+		        IFNULL(al.title, ''),
+		        r.ranking
+		   FROM albums      al
+		   JOIN track_album tal ON tal.album_id = al.id
+		   JOIN tracks      t   ON t.id         = tal.track_id
+		   `+rankingJoin(EntityTrack, "t")+`
+		  WHERE r.comparisons >= ?`,
+	)
+}
+
+func ArtistLeaderboard(db *sql.DB, opts LeaderboardOptions) (
+	[]LeaderboardEntry, error,
+) {
+	query := `SELECT ar.id,
+	                 IFNULL(ar.musicbrainz_id, ''),
+	                 IFNULL(ar.name, ''),
+	                 r.ranking
+	            FROM artists      ar
+	            JOIN track_artist tar ON tar.artist_id = ar.id
+	            JOIN tracks       t   ON t.id          = tar.track_id
+	            ` + rankingJoin(EntityTrack, "t") + `
+	           WHERE r.comparisons >= ?`
+
+	if opts.PrimaryArtistsOnly {
+		query += " AND tar.is_primary_artist = 1"
+	}
+
+	return leaderboard(db, opts, query)
+}
+
+// query must select (id, musicbrainz_id, name, track ranking) and take the
+// profile ID, then the minimum comparison count, as its parameters
+func leaderboard(db *sql.DB, opts LeaderboardOptions, query string) (
+	[]LeaderboardEntry, error,
+) {
+	if opts.TopK <= 0 {
+		opts.TopK = DefaultTopK
+	}
+	if opts.PriorWeight <= 0 {
+		opts.PriorWeight = DefaultPriorWeight
+	}
+	if opts.OrderBy == "" {
+		opts.OrderBy = AggregateBayesian
+	}
+	if opts.Profile == 0 {
+		opts.Profile = DefaultProfile
+	}
+
+	minComparisons := opts.MinComparisons
+	if minComparisons < 1 {
+		minComparisons = 1
+	}
+
+	// The prior for the Bayesian score is the mean of all ranked tracks,
+	// not of all album/artist links, so tracks on several albums are
+	// only counted once
+	var priorMean float64
+	row := db.QueryRow(
+		`SELECT IFNULL(AVG(ranking), 0)
+		   FROM rankings
+		  WHERE profile_id   = ?
+		    AND entity       = ?
+		    AND comparisons >= ?`,
+		opts.Profile,
+		EntityTrack,
+		minComparisons,
+	)
+	if err := row.Scan(&priorMean); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, opts.Profile, minComparisons)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LeaderboardEntry{}
+	rankingsByID := map[int][]float64{}
+
+	for rows.Next() {
+		var entry LeaderboardEntry
+		var ranking float64
+
+		if err = rows.Scan(
+			&entry.InternalID,
+			&entry.MusicBrainzID,
+			&entry.Name,
+			&ranking,
+		); err != nil {
+			return nil, err
+		}
+
+		if _, exists := rankingsByID[entry.InternalID]; !exists {
+			entries = append(entries, entry)
+		}
+
+		rankingsByID[entry.InternalID] = append(
+			rankingsByID[entry.InternalID], ranking,
+		)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		rankings := rankingsByID[entries[i].InternalID]
+
+		entries[i].RankedTracks = len(rankings)
+		entries[i].Mean = mean(rankings)
+		entries[i].Median = median(rankings)
+		entries[i].TopKMean = topKMean(rankings, opts.TopK)
+		entries[i].BayesianScore = bayesianMean(
+			rankings, priorMean, opts.PriorWeight,
+		)
+	}
+
+	score, err := aggregateFunc(opts.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if score(entries[i]) != score(entries[j]) {
+			return score(entries[i]) > score(entries[j])
+		}
+		return entries[i].InternalID < entries[j].InternalID
+	})
+
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+	}
+
+	return entries, nil
+}
+
+func aggregateFunc(aggregate Aggregate) (func(LeaderboardEntry) float64, error) {
+	switch aggregate {
+	case AggregateMean:
+		return func(e LeaderboardEntry) float64 { return e.Mean }, nil
+	case AggregateMedian:
+		return func(e LeaderboardEntry) float64 { return e.Median }, nil
+	case AggregateTopKMean:
+		return func(e LeaderboardEntry) float64 { return e.TopKMean }, nil
+	case AggregateBayesian:
+		return func(e LeaderboardEntry) float64 { return e.BayesianScore }, nil
+	}
+
+	return nil, fmt.Errorf("Unknown aggregate '%s'", aggregate)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	return sorted[mid]
+}
+
+func topKMean(values []float64, k int) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+
+	if k > len(sorted) {
+		k = len(sorted)
+	}
+
+	return mean(sorted[:k])
+}
+
+// See https://en.wikipedia.org/wiki/Bayesian_average
+func bayesianMean(values []float64, priorMean float64, priorWeight float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return (priorWeight*priorMean + sum) / (priorWeight + float64(len(values)))
+}
@@ -0,0 +1,156 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestAggregates(t *testing.T) {
+	values := []float64{1000, 1200, 900, 1100}
+
+	if got := mean(values); got != 1050 {
+		t.Errorf("Mean: expected 1050, got %v", got)
+	}
+	if got := median(values); got != 1050 {
+		t.Errorf("Median (even): expected 1050, got %v", got)
+	}
+	if got := median(values[:3]); got != 1000 {
+		t.Errorf("Median (odd): expected 1000, got %v", got)
+	}
+	if got := topKMean(values, 2); got != 1150 {
+		t.Errorf("Top-k mean: expected 1150, got %v", got)
+	}
+	if got := topKMean(values, 10); got != 1050 {
+		t.Errorf("Top-k mean with k > n: expected 1050, got %v", got)
+	}
+	if got := bayesianMean([]float64{1300}, 1000, 2); got != 1100 {
+		t.Errorf("Bayesian mean: expected 1100, got %v", got)
+	}
+}
+
+func TestLeaderboards(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	// Album 1 has one excellent track, album 2 has three very good ones
+	// and album 3 drags the overall mean down
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{MusicBrainzID: "AL1", Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Albums:        []track.Album{{MusicBrainzID: "AL2", Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			Albums:        []track.Album{{MusicBrainzID: "AL2", Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+			OtherArtists:  []track.Artist{{Name: "Artist 1"}},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB4",
+			Title:         "Title 4",
+			Albums:        []track.Album{{MusicBrainzID: "AL2", Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB5",
+			Title:         "Title 5",
+			Albums:        []track.Album{{MusicBrainzID: "AL3", Title: "Album 3"}},
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+		// Never compared, so not ranked
+		track.New(track.Track{
+			MusicBrainzID: "MB6",
+			Title:         "Title 6",
+			Albums:        []track.Album{{MusicBrainzID: "AL4", Title: "Album 4"}},
+			PrimaryArtist: track.Artist{Name: "Artist 4"},
+		}),
+	})
+
+	setRanking(t, db, 1, 1400, 5)
+	setRanking(t, db, 2, 1350, 5)
+	setRanking(t, db, 3, 1350, 5)
+	setRanking(t, db, 4, 1350, 5)
+	setRanking(t, db, 5, 800, 5)
+
+	t.Log("Albums by mean: the single excellent track wins")
+
+	albums, err := AlbumLeaderboard(
+		db, LeaderboardOptions{OrderBy: AggregateMean},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(albums) != 3 {
+		t.Fatalf("Expected 3 ranked albums, got %d", len(albums))
+	}
+	if albums[0].Name != "Album 1" || albums[0].Mean != 1400 {
+		t.Errorf("Expected Album 1 first with mean 1400, got %+v", albums[0])
+	}
+
+	t.Log("Albums by Bayesian score: the album with more ranked tracks wins")
+
+	albums, err = AlbumLeaderboard(db, LeaderboardOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Prior mean = 1250, prior weight = 5
+	// Album 1: (5*1250 + 1400) / 6 = 1275
+	// Album 2: (5*1250 + 3*1350) / 8 = 1287.5
+	if albums[0].Name != "Album 2" || albums[0].BayesianScore != 1287.5 {
+		t.Errorf("Expected Album 2 first with score 1287.5, got %+v", albums[0])
+	}
+	if albums[1].Name != "Album 1" || albums[1].BayesianScore != 1275 {
+		t.Errorf("Expected Album 1 second with score 1275, got %+v", albums[1])
+	}
+
+	t.Log("Artists include secondary artists unless told otherwise")
+
+	artists, err := ArtistLeaderboard(
+		db, LeaderboardOptions{OrderBy: AggregateMedian},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if artists[0].Name != "Artist 1" || artists[0].RankedTracks != 2 {
+		t.Errorf("Expected Artist 1 first with 2 tracks, got %+v", artists[0])
+	}
+	if artists[0].Median != 1375 {
+		t.Errorf("Expected median 1375, got %v", artists[0].Median)
+	}
+
+	artists, err = ArtistLeaderboard(db, LeaderboardOptions{
+		OrderBy:            AggregateTopKMean,
+		TopK:               1,
+		PrimaryArtistsOnly: true,
+		Limit:              1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(artists) != 1 || artists[0].RankedTracks != 1 ||
+		artists[0].TopKMean != 1400 {
+		t.Errorf("Expected only Artist 1 with 1 track, got %+v", artists)
+	}
+
+	t.Log("Unknown aggregate is an error")
+
+	if _, err = AlbumLeaderboard(
+		db, LeaderboardOptions{OrderBy: "bogus"},
+	); err == nil {
+		t.Error("Expected error for unknown aggregate")
+	}
+}
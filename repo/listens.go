@@ -0,0 +1,292 @@
+package repo
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/scrobble"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// Prior source for priors from imported play counts, kept with the
+// profile's annotations
+const priorSourceListens = "listens"
+
+// Tags with play counts, whose priors a profile's listens replace. Star
+// ratings say more than plays, so are kept.
+var playCountPriorSources = []string{
+	"popm_plays", "fmps_playcount", "itunes_plays", "beets_plays",
+}
+
+// playCountPriorSources as SQL string literals
+var playCountPriorSourceList = "'" +
+	strings.Join(playCountPriorSources, "', '") + "'"
+
+// ListenStats counts what an import of listens did
+type ListenStats struct {
+	Listens int
+
+	MatchedByMBID int
+	MatchedByName int
+	Unmatched     int
+
+	// Matched plays not already imported
+	Added int
+
+	// Tracks given a prior from their play count
+	Priors int
+}
+
+// Candidate tracks for a normalised artist and title
+type listenCandidate struct {
+	id     int64
+	albums map[string]bool // Normalised titles
+}
+
+// ImportListens stores plays of tracks in the library by the profile.
+// Listens are matched by MusicBrainz ID, then by primary artist and
+// title ignoring case, punctuation and featured artists. When several
+// tracks match, the one on the listen's album is preferred.
+//
+// With priors, every track the profile has played and not rated gets a
+// prior in the profile from its total play count, and the profile's
+// track comparisons are replayed so the rankings start from it.
+func ImportListens(
+	db *sql.DB,
+	profile int,
+	listens []scrobble.Listen,
+	source string,
+	priors bool,
+) (ListenStats, error) {
+	stats := ListenStats{Listens: len(listens)}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ListenStats{}, err
+	}
+	defer tx.Rollback()
+
+	byMBID, byName, err := listenIndex(tx)
+	if err != nil {
+		return ListenStats{}, err
+	}
+
+	for _, listen := range listens {
+		trackID, ok := byMBID[listen.RecordingMBID]
+		if ok && listen.RecordingMBID != "" {
+			stats.MatchedByMBID++
+		} else if trackID, ok = matchListen(byName, listen); ok {
+			stats.MatchedByName++
+		} else {
+			stats.Unmatched++
+			continue
+		}
+
+		res, err := tx.Exec(
+			`INSERT OR IGNORE INTO listens
+			             (profile_id, track_id, listened_at, source)
+			      VALUES (?,?,?,?)`,
+			profile,
+			trackID,
+			listen.ListenedAt.Unix(),
+			source,
+		)
+		if err != nil {
+			return ListenStats{}, err
+		}
+
+		added, err := res.RowsAffected()
+		if err != nil {
+			return ListenStats{}, err
+		}
+
+		stats.Added += int(added)
+	}
+
+	if priors {
+		if stats.Priors, err = listenPriors(tx, profile); err != nil {
+			return ListenStats{}, err
+		}
+
+		if stats.Priors > 0 {
+			err = replayProfileComparisons(tx, EntityTrack, profile)
+			if err != nil {
+				return ListenStats{}, err
+			}
+		}
+	}
+
+	return stats, tx.Commit()
+}
+
+// Tracks by MusicBrainz ID, and by normalised primary artist and title
+func listenIndex(
+	tx *sql.Tx,
+) (map[string]int64, map[string][]listenCandidate, error) {
+	byMBID := map[string]int64{}
+	byName := map[string][]listenCandidate{}
+	candidates := map[int64]listenCandidate{}
+
+	err := queryEach(tx,
+		`SELECT t.id,
+		        IFNULL(t.musicbrainz_id, ''),
+		        IFNULL(t.title, ''),
+		        IFNULL(ar.name, '')
+		   FROM tracks            t
+		   LEFT JOIN track_artist tar ON tar.track_id = t.id
+		                             AND tar.is_primary_artist = 1
+		   LEFT JOIN artists      ar  ON ar.id = tar.artist_id
+		  ORDER BY t.id`,
+		func(rows *sql.Rows) error {
+			var id int64
+			var mbid, title, artist string
+
+			if err := rows.Scan(&id, &mbid, &title, &artist); err != nil {
+				return err
+			}
+
+			if mbid != "" {
+				byMBID[mbid] = id
+			}
+
+			c := listenCandidate{id: id, albums: map[string]bool{}}
+			candidates[id] = c
+
+			key := listenKey(artist, title)
+			byName[key] = append(byName[key], c)
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = queryEach(tx,
+		`SELECT tal.track_id, IFNULL(al.title, '')
+		   FROM track_album tal
+		   JOIN albums      al  ON al.id = tal.album_id`,
+		func(rows *sql.Rows) error {
+			var id int64
+			var album string
+
+			if err := rows.Scan(&id, &album); err != nil {
+				return err
+			}
+
+			if c, ok := candidates[id]; ok {
+				c.albums[track.NormaliseName(album)] = true
+			}
+
+			return nil
+		},
+	)
+
+	return byMBID, byName, err
+}
+
+func matchListen(
+	byName map[string][]listenCandidate, listen scrobble.Listen,
+) (int64, bool) {
+	candidates := byName[listenKey(listen.Artist, listen.Title)]
+	if len(candidates) == 0 {
+		return 0, false
+	}
+
+	album := track.NormaliseName(listen.Album)
+	for _, c := range candidates {
+		if album != "" && c.albums[album] {
+			return c.id, true
+		}
+	}
+
+	return candidates[0].id, true
+}
+
+func listenKey(artist string, title string) string {
+	return track.NormaliseName(track.WithoutFeatured(artist)) + "\x00" +
+		track.NormaliseName(track.WithoutFeatured(title))
+}
+
+// Sets the priors from play counts in the profile of the tracks it has
+// played. Tracks rated by hand or with a star rating in their tags keep
+// starting from that. Returns the number of tracks whose starting prior
+// changed.
+func listenPriors(tx *sql.Tx, profile int) (int, error) {
+	config, err := LoadEloConfig(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	type playCount struct {
+		id    int64
+		plays int
+		prior sql.NullFloat64
+
+		// Whether the prior is the one the track starts from
+		replaces bool
+	}
+
+	counts := []playCount{}
+
+	err = queryEach(tx,
+		`SELECT t.id,
+		        COUNT(*),
+		        a.listen_prior,
+		        a.prior IS NULL
+		        AND IFNULL(t.prior_source, '') IN ('', `+playCountPriorSourceList+`)
+		   FROM tracks           t
+		   JOIN listens          l ON l.track_id   = t.id
+		                          AND l.profile_id = ?
+		   LEFT JOIN annotations a ON a.profile_id = l.profile_id
+		                          AND a.entity     = ?
+		                          AND a.entity_id  = t.id
+		  GROUP BY t.id`,
+		func(rows *sql.Rows) error {
+			var c playCount
+			if err := rows.Scan(
+				&c.id, &c.plays, &c.prior, &c.replaces,
+			); err != nil {
+				return err
+			}
+
+			counts = append(counts, c)
+
+			return nil
+		},
+		profile,
+		EntityTrack,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, c := range counts {
+		prior := elo.PriorRating(config.StartingRating, 0, c.plays)
+		if c.prior.Valid && c.prior.Float64 == prior {
+			continue
+		}
+
+		if _, err = tx.Exec(
+			`INSERT INTO annotations
+			             (profile_id, entity, entity_id, listen_prior)
+			      VALUES (?,?,?,?)
+			 ON CONFLICT (profile_id, entity, entity_id) DO UPDATE
+			         SET listen_prior = excluded.listen_prior`,
+			profile,
+			EntityTrack,
+			c.id,
+			prior,
+		); err != nil {
+			return 0, err
+		}
+
+		if c.replaces {
+			changed++
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,219 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/scrobble"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestImportListens(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "The Artist"},
+			Prior:         track.Prior{Stars: 5, Source: "popm"},
+		}),
+		track.New(track.Track{
+			Title:         "Don't Stop (feat. Artist 3)",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist & Friends"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 3",
+			Albums:        []track.Album{{Title: "Album A"}},
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 3",
+			Albums:        []track.Album{{Title: "Album B"}},
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+	})
+
+	start := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	listens := []scrobble.Listen{
+		{
+			Artist:        "Somebody else",
+			Title:         "Something else",
+			RecordingMBID: "MB1",
+			ListenedAt:    start,
+		},
+		{
+			Artist:     "artist",
+			Title:      "TITLE 1",
+			ListenedAt: start.Add(time.Hour),
+		},
+		{
+			Artist:     "Artist and Friends feat. Artist 3",
+			Title:      "Dont Stop",
+			ListenedAt: start,
+		},
+		{
+			Artist:     "Artist 3",
+			Album:      "Album B",
+			Title:      "Title 3",
+			ListenedAt: start,
+		},
+		{
+			Artist:     "Artist 4",
+			Title:      "Title 4",
+			ListenedAt: start,
+		},
+	}
+
+	stats, err := ImportListens(db, DefaultProfile, listens, "lastfm", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ListenStats{
+		Listens:       5,
+		MatchedByMBID: 1,
+		MatchedByName: 3,
+		Unmatched:     1,
+		Added:         4,
+		Priors:        2, // Track 1 keeps its star rating
+	}
+	if stats != expected {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, stats)
+	}
+
+	t.Log("Tracks have their plays")
+
+	for id, want := range map[int]struct {
+		plays      int
+		lastPlayed time.Time
+		ranking    float64
+	}{
+		1: {2, start.Add(time.Hour), 1200},
+		2: {1, start, 1025},
+		3: {0, time.Time{}, 1000},
+		4: {1, start, 1025},
+	} {
+		got, err := GetTrack(db, DefaultProfile, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Plays != want.plays || !got.LastPlayed.Equal(want.lastPlayed) ||
+			got.Ranking != want.ranking {
+			t.Errorf(
+				"Track %d: expected %d plays, last %v, ranking %v, got %d, %v, %v",
+				id, want.plays, want.lastPlayed, want.ranking,
+				got.Plays, got.LastPlayed, got.Ranking,
+			)
+		}
+	}
+
+	t.Log("Importing again adds nothing")
+
+	stats, err = ImportListens(db, DefaultProfile, listens, "lastfm", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Added != 0 || stats.Priors != 0 {
+		t.Errorf("Expected nothing added, got %+v", stats)
+	}
+
+	t.Log("Other profiles have their own plays and priors")
+
+	other, err := CreateProfile(db, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ImportListens(db, other.ID, listens[3:4], "lastfm", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct {
+		profile int
+		id      int
+		plays   int
+		ranking float64
+	}{
+		{other.ID, 2, 0, 1000},
+		{other.ID, 4, 1, 1025},
+		{DefaultProfile, 4, 1, 1025},
+	} {
+		got, err := GetTrack(db, want.profile, want.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Plays != want.plays || got.Ranking != want.ranking {
+			t.Errorf(
+				"Profile %d track %d: expected %d plays, ranking %v, got %d, %v",
+				want.profile, want.id, want.plays, want.ranking,
+				got.Plays, got.Ranking,
+			)
+		}
+	}
+
+	t.Log("Clearing priors from plays only clears the profile's")
+
+	cleared, err := ClearPriors(db, other.ID, priorSourceListens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleared != 1 {
+		t.Errorf("Expected 1 prior cleared, got %d", cleared)
+	}
+
+	for profile, want := range map[int]map[string]int{
+		DefaultProfile: {"popm": 1, priorSourceListens: 2},
+		other.ID:       {"popm": 1},
+	} {
+		sources, err := PriorSources(db, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, sources) {
+			t.Errorf("\nExpected:\n%#v\ngot:\n%#v", want, sources)
+		}
+	}
+}
+
+func TestListenedTracksPickedFirst(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	})
+
+	if _, err := ImportListens(db, DefaultProfile, []scrobble.Listen{{
+		Artist:     "Artist 1",
+		Title:      "Title 2",
+		ListenedAt: time.Now(),
+	}}, "listenbrainz", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		first, _, err := NextPair(db, DefaultProfile, EntityTrack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.InternalID != 2 {
+			t.Errorf("Expected track 2 first, got %d", first.InternalID)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Schema changes for DBs created before them, oldest first. A DB's
+// user_version is the number of these it has had; sql/schemas.sql
+// already includes them all.
+var migrations = []string{
+	// 1: everything added to the first schema before it had a version:
+	// genres and years, priors, audio files, listens, ratings and stars,
+	// settings, search, album and artist rankings, match history and
+	// snapshots. The first schema kept no count of comparisons, so a
+	// ranking moved off the starting 1000 counts as one.
+	`ALTER TABLE tracks ADD COLUMN genre TEXT;
+	 ALTER TABLE tracks ADD COLUMN year INTEGER;
+	 ALTER TABLE tracks ADD COLUMN comparisons INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE tracks ADD COLUMN prior REAL;
+	 ALTER TABLE tracks ADD COLUMN prior_source TEXT;
+
+	 UPDATE tracks
+	    SET comparisons = 1
+	  WHERE ranking IS NOT NULL
+	    AND ranking != 1000;
+
+	 ALTER TABLE artists ADD COLUMN ranking;
+	 ALTER TABLE artists ADD COLUMN comparisons INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE albums ADD COLUMN ranking;
+	 ALTER TABLE albums ADD COLUMN comparisons INTEGER NOT NULL DEFAULT 0;
+
+	 CREATE TABLE track_files (
+	     path TEXT PRIMARY KEY,
+	     track_id NOT NULL,
+	     FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	 );
+
+	 CREATE TABLE listens (
+	     track_id NOT NULL,
+	     listened_at INTEGER NOT NULL,
+	     source TEXT NOT NULL,
+	     PRIMARY KEY (track_id, listened_at),
+	     FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	 );
+
+	 CREATE TABLE annotations (
+	     entity TEXT NOT NULL,
+	     entity_id INTEGER NOT NULL,
+	     rating INTEGER,
+	     starred_at TEXT,
+	     PRIMARY KEY (entity, entity_id)
+	 );
+
+	 CREATE TABLE comparisons (
+	     id INTEGER PRIMARY KEY,
+	     entity TEXT NOT NULL,
+	     preference REAL,
+	     multiplier REAL NOT NULL DEFAULT 1,
+	     source TEXT NOT NULL DEFAULT 'vote',
+	     created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+	 );
+
+	 CREATE TABLE comparison_results (
+	     comparison_id,
+	     entity_id,
+	     score REAL,
+	     place INTEGER,
+	     k REAL,
+	     ranking_before REAL,
+	     ranking_after REAL,
+	     PRIMARY KEY (comparison_id, entity_id),
+	     FOREIGN KEY(comparison_id) REFERENCES comparisons(id) ON DELETE CASCADE
+	 );
+
+	 CREATE TABLE snapshots (
+	     id INTEGER PRIMARY KEY,
+	     taken_at TEXT NOT NULL,
+	     label TEXT
+	 );
+
+	 CREATE TABLE snapshot_rankings (
+	     snapshot_id,
+	     track_id,
+	     ranking REAL,
+	     position INTEGER,
+	     PRIMARY KEY (snapshot_id, track_id),
+	     FOREIGN KEY(snapshot_id) REFERENCES snapshots(id) ON DELETE CASCADE,
+	     FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	 );
+
+	 CREATE TABLE settings (
+	     key TEXT PRIMARY KEY,
+	     value TEXT
+	 );
+
+	 CREATE VIRTUAL TABLE tracks_search USING fts5 (
+	     title,
+	     artists,
+	     albums,
+	     tokenize = "unicode61 remove_diacritics 2",
+	     prefix = '2 3'
+	 );
+
+	 CREATE VIRTUAL TABLE albums_search USING fts5 (
+	     title,
+	     tokenize = "unicode61 remove_diacritics 2",
+	     prefix = '2 3'
+	 );
+
+	 CREATE VIRTUAL TABLE artists_search USING fts5 (
+	     name,
+	     tokenize = "unicode61 remove_diacritics 2",
+	     prefix = '2 3'
+	 );
+
+	 -- What is indexed for each track
+	 CREATE VIEW tracks_search_source AS
+	 SELECT t.id,
+	        t.title,
+	        (SELECT GROUP_CONCAT(ar.name, ' ')
+	           FROM track_artist tar
+	           JOIN artists      ar  ON ar.id = tar.artist_id
+	          WHERE tar.track_id = t.id) AS artists,
+	        (SELECT GROUP_CONCAT(al.title, ' ')
+	           FROM track_album tal
+	           JOIN albums      al  ON al.id = tal.album_id
+	          WHERE tal.track_id = t.id) AS albums
+	   FROM tracks t;
+
+	 CREATE TRIGGER tracks_search_insert AFTER INSERT ON tracks BEGIN
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id = NEW.id;
+	 END;
+
+	 CREATE TRIGGER tracks_search_update AFTER UPDATE OF title ON tracks BEGIN
+	     DELETE FROM tracks_search WHERE rowid = OLD.id;
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id = NEW.id;
+	 END;
+
+	 CREATE TRIGGER tracks_search_delete AFTER DELETE ON tracks BEGIN
+	     DELETE FROM tracks_search WHERE rowid = OLD.id;
+	 END;
+
+	 -- A track's artists and albums are part of its entry
+	 CREATE TRIGGER track_artist_search_insert AFTER INSERT ON track_artist BEGIN
+	     DELETE FROM tracks_search WHERE rowid = NEW.track_id;
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id = NEW.track_id;
+	 END;
+
+	 CREATE TRIGGER track_artist_search_delete AFTER DELETE ON track_artist BEGIN
+	     DELETE FROM tracks_search WHERE rowid = OLD.track_id;
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id = OLD.track_id;
+	 END;
+
+	 CREATE TRIGGER track_album_search_insert AFTER INSERT ON track_album BEGIN
+	     DELETE FROM tracks_search WHERE rowid = NEW.track_id;
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id = NEW.track_id;
+	 END;
+
+	 CREATE TRIGGER track_album_search_delete AFTER DELETE ON track_album BEGIN
+	     DELETE FROM tracks_search WHERE rowid = OLD.track_id;
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id = OLD.track_id;
+	 END;
+
+	 CREATE TRIGGER albums_search_insert AFTER INSERT ON albums BEGIN
+	     INSERT INTO albums_search (rowid, title) VALUES (NEW.id, NEW.title);
+	 END;
+
+	 CREATE TRIGGER albums_search_update AFTER UPDATE OF title ON albums BEGIN
+	     DELETE FROM albums_search WHERE rowid = OLD.id;
+	     INSERT INTO albums_search (rowid, title) VALUES (NEW.id, NEW.title);
+
+	     DELETE FROM tracks_search
+	      WHERE rowid IN (SELECT track_id FROM track_album WHERE album_id = NEW.id);
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id IN (SELECT track_id FROM track_album WHERE album_id = NEW.id);
+	 END;
+
+	 CREATE TRIGGER albums_search_delete AFTER DELETE ON albums BEGIN
+	     DELETE FROM albums_search WHERE rowid = OLD.id;
+	 END;
+
+	 CREATE TRIGGER artists_search_insert AFTER INSERT ON artists BEGIN
+	     INSERT INTO artists_search (rowid, name) VALUES (NEW.id, NEW.name);
+	 END;
+
+	 CREATE TRIGGER artists_search_update AFTER UPDATE OF name ON artists BEGIN
+	     DELETE FROM artists_search WHERE rowid = OLD.id;
+	     INSERT INTO artists_search (rowid, name) VALUES (NEW.id, NEW.name);
+
+	     DELETE FROM tracks_search
+	      WHERE rowid IN (SELECT track_id FROM track_artist WHERE artist_id = NEW.id);
+	     INSERT INTO tracks_search (rowid, title, artists, albums)
+	     SELECT id, title, artists, albums
+	       FROM tracks_search_source
+	      WHERE id IN (SELECT track_id FROM track_artist WHERE artist_id = NEW.id);
+	 END;
+
+	 CREATE TRIGGER artists_search_delete AFTER DELETE ON artists BEGIN
+	     DELETE FROM artists_search WHERE rowid = OLD.id;
+	 END;
+
+	 INSERT INTO tracks_search
+	             (rowid, title, artists, albums)
+	      SELECT id, title, artists, albums
+	        FROM tracks_search_source;
+
+	 INSERT INTO albums_search
+	             (rowid, title)
+	      SELECT id, title
+	        FROM albums;
+
+	 INSERT INTO artists_search
+	             (rowid, name)
+	      SELECT id, name
+	        FROM artists;`,
+
+	// 2: rankings and match history per profile, with the existing ones
+	// moved into the default profile
+	`CREATE TABLE profiles (
+	     id INTEGER PRIMARY KEY,
+	     name TEXT NOT NULL UNIQUE COLLATE NOCASE
+	 );
+
+	 INSERT INTO profiles (id, name) VALUES (1, 'default');
+
+	 CREATE TABLE rankings (
+	     profile_id INTEGER NOT NULL,
+	     entity TEXT NOT NULL,
+	     entity_id INTEGER NOT NULL,
+	     ranking REAL NOT NULL,
+	     comparisons INTEGER NOT NULL,
+	     PRIMARY KEY (profile_id, entity, entity_id)
+	 );
+
+	 INSERT INTO rankings
+	             (profile_id, entity, entity_id, ranking, comparisons)
+	      SELECT 1, 'track', id, ranking, comparisons
+	        FROM tracks
+	       WHERE comparisons > 0
+	         AND ranking IS NOT NULL
+	       UNION ALL
+	      SELECT 1, 'album', id, ranking, comparisons
+	        FROM albums
+	       WHERE comparisons > 0
+	         AND ranking IS NOT NULL
+	       UNION ALL
+	      SELECT 1, 'artist', id, ranking, comparisons
+	        FROM artists
+	       WHERE comparisons > 0
+	         AND ranking IS NOT NULL;
+
+	 ALTER TABLE tracks DROP COLUMN ranking;
+	 ALTER TABLE tracks DROP COLUMN comparisons;
+	 ALTER TABLE albums DROP COLUMN ranking;
+	 ALTER TABLE albums DROP COLUMN comparisons;
+	 ALTER TABLE artists DROP COLUMN ranking;
+	 ALTER TABLE artists DROP COLUMN comparisons;
+
+	 ALTER TABLE comparisons ADD COLUMN profile_id INTEGER NOT NULL DEFAULT 1;
+	 ALTER TABLE snapshots ADD COLUMN profile_id INTEGER NOT NULL DEFAULT 1;`,
+
+	// 3: priors from ratings and stars set by hand kept with them, so the
+	// prior from the tags is still there when they are cleared. Tags
+	// overwritten by hand-set priors come back on the next scan.
+	`ALTER TABLE annotations ADD COLUMN prior REAL;
+
+	 UPDATE annotations
+	    SET prior = (SELECT t.prior
+	                   FROM tracks t
+	                  WHERE t.id = annotations.entity_id
+	                    AND t.prior_source IN ('user_rating', 'starred'))
+	  WHERE entity = 'track';
+
+	 UPDATE tracks
+	    SET prior        = NULL,
+	        prior_source = NULL
+	  WHERE prior_source IN ('user_rating', 'starred');`,
+
+	// 4: ratings and stars per profile, with the existing ones the
+	// default profile's. SQLite can't change a primary key in place.
+	`CREATE TABLE profile_annotations (
+	     profile_id INTEGER NOT NULL DEFAULT 1,
+	     entity TEXT NOT NULL,
+	     entity_id INTEGER NOT NULL,
+	     rating INTEGER,
+	     starred_at TEXT,
+	     prior REAL,
+	     PRIMARY KEY (profile_id, entity, entity_id)
+	 );
+
+	 INSERT INTO profile_annotations
+	             (profile_id, entity, entity_id, rating, starred_at, prior)
+	      SELECT 1, entity, entity_id, rating, starred_at, prior
+	        FROM annotations;
+
+	 DROP TABLE annotations;
+	 ALTER TABLE profile_annotations RENAME TO annotations;`,
+
+	// 5: listens per profile, with the existing ones the default
+	// profile's, and the priors from them kept on its annotations. Play
+	// counts in the tags they replaced come back on the next scan.
+	`CREATE TABLE profile_listens (
+	     profile_id INTEGER NOT NULL DEFAULT 1,
+	     track_id NOT NULL,
+	     listened_at INTEGER NOT NULL,
+	     source TEXT NOT NULL,
+	     PRIMARY KEY (profile_id, track_id, listened_at),
+	     FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+	 );
+
+	 INSERT INTO profile_listens
+	             (profile_id, track_id, listened_at, source)
+	      SELECT 1, track_id, listened_at, source
+	        FROM listens;
+
+	 DROP TABLE listens;
+	 ALTER TABLE profile_listens RENAME TO listens;
+
+	 ALTER TABLE annotations ADD COLUMN listen_prior REAL;
+
+	 INSERT INTO annotations
+	             (profile_id, entity, entity_id, listen_prior)
+	      SELECT 1, 'track', id, prior
+	        FROM tracks
+	       WHERE prior_source = 'listens'
+	 ON CONFLICT (profile_id, entity, entity_id) DO UPDATE
+	         SET listen_prior = excluded.listen_prior;
+
+	 UPDATE tracks
+	    SET prior        = NULL,
+	        prior_source = NULL
+	  WHERE prior_source = 'listens';`,
+
+	// 6: a Subsonic password for each profile
+	`ALTER TABLE profiles ADD COLUMN subsonic_password TEXT;`,
+}
+
+// Migrate applies the migrations the DB hasn't had yet, each in its own
+// transaction. Returns how many were applied.
+func Migrate(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	if version > len(migrations) {
+		return 0, fmt.Errorf(
+			"Database is at version %d, newer than this program's %d",
+			version, len(migrations),
+		)
+	}
+
+	applied := 0
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return applied, err
+		}
+
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("Migration %d: %w", i+1, err)
+		}
+
+		// Pragmas can't take parameters
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return applied, err
+		}
+
+		applied++
+	}
+
+	return applied, nil
+}
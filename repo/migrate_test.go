@@ -0,0 +1,271 @@
+package repo
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	_ "modernc.org/sqlite"
+)
+
+// Opens a DB with the first schema, from before it had a version, with
+// two tracks ranked and two not
+func baselineDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+
+	schema, err := ioutil.ReadFile("testdata/schema_baseline.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Exec(
+		`INSERT INTO tracks (id, title, ranking)
+		 VALUES (1, 'Title 1', 1016),
+		        (2, 'Title 2', 984),
+		        (3, 'Title 3', 1000),
+		        (4, 'Title 4', 1000);
+
+		 INSERT INTO artists (id, name) VALUES (1, 'Artist 1');
+		 INSERT INTO albums (id, title) VALUES (1, 'Album 1');
+
+		 INSERT INTO track_artist (track_id, artist_id, is_primary_artist)
+		 VALUES (1, 1, 1);
+		 INSERT INTO track_album (track_id, album_id) VALUES (1, 1);`,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// Columns of every table and view, and the names of the triggers
+func schemaOf(t *testing.T, db *sql.DB) map[string][]string {
+	t.Helper()
+
+	schema := map[string][]string{}
+
+	err := queryEach(db,
+		`SELECT m.type || ' ' || m.name, IFNULL(c.name, '')
+		   FROM sqlite_master m
+		   LEFT JOIN pragma_table_info(m.name) c
+		  WHERE m.type IN ('table', 'view', 'trigger')
+		  ORDER BY m.name, c.name`,
+		func(rows *sql.Rows) error {
+			var name, column string
+			if err := rows.Scan(&name, &column); err != nil {
+				return err
+			}
+
+			schema[name] = append(schema[name], column)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+func TestMigrate(t *testing.T) {
+	t.Log("The first schema becomes the current one")
+
+	db := baselineDB(t)
+
+	applied, err := Migrate(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("Expected %d migrations, got %d", len(migrations), applied)
+	}
+
+	expectedSchema := schemaOf(t, test_utils.DBSetup())
+	if schema := schemaOf(t, db); !reflect.DeepEqual(expectedSchema, schema) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedSchema, schema)
+	}
+
+	tracks, err := QueryRankings(db, RankingQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTracks := [][2]float64{{1, 1016}, {3, 1000}, {4, 1000}, {2, 984}}
+	gotTracks := [][2]float64{}
+	for _, tr := range tracks.Tracks {
+		gotTracks = append(gotTracks, [2]float64{float64(tr.InternalID), tr.Ranking})
+	}
+	if !reflect.DeepEqual(expectedTracks, gotTracks) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedTracks, gotTracks)
+	}
+
+	results, err := Search(db, DefaultProfile, "album 1", 0, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].InternalID != 1 {
+		t.Errorf("Expected existing tracks to be searchable, got %+v", results)
+	}
+
+	t.Log("Data from before profiles")
+
+	db = baselineDB(t)
+
+	// As far as the first migration, as the programs before profiles did
+	if _, err = db.Exec(migrations[0] + "; PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.Exec(
+		`UPDATE tracks SET prior = 1100, prior_source = 'popm_rating' WHERE id = 3;
+		 UPDATE tracks SET prior = 900, prior_source = 'user_rating' WHERE id = 4;
+		 UPDATE tracks SET prior = 1025, prior_source = 'listens' WHERE id = 2;
+
+		 INSERT INTO listens (track_id, listened_at, source)
+		 VALUES (2, 1614628800, 'lastfm');
+
+		 INSERT INTO annotations (entity, entity_id, rating)
+		 VALUES ('track', 4, 2);
+
+		 UPDATE artists SET ranking = 1030, comparisons = 2 WHERE id = 1;
+
+		 INSERT INTO comparisons (id, entity) VALUES (1, 'track');
+		 INSERT INTO comparison_results (comparison_id, entity_id, score)
+		 VALUES (1, 1, 1), (1, 2, 0);
+
+		 INSERT INTO snapshots (id, taken_at) VALUES (1, '2021-03-01 20:00:00');`,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied, err = Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations)-1 {
+		t.Errorf("Expected %d migrations, got %d", len(migrations)-1, applied)
+	}
+
+	t.Log("Rankings of what was compared are the default profile's")
+
+	rankings := map[Entity]map[int]float64{}
+
+	err = queryEach(db,
+		"SELECT profile_id, entity, entity_id, ranking FROM rankings",
+		func(rows *sql.Rows) error {
+			var profile, id int
+			var entity Entity
+			var ranking float64
+
+			if err := rows.Scan(&profile, &entity, &id, &ranking); err != nil {
+				return err
+			}
+			if profile != DefaultProfile {
+				t.Errorf("Expected profile %d, got %d", DefaultProfile, profile)
+			}
+
+			if rankings[entity] == nil {
+				rankings[entity] = map[int]float64{}
+			}
+			rankings[entity][id] = ranking
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[Entity]map[int]float64{
+		EntityTrack:  {1: 1016, 2: 984},
+		EntityArtist: {1: 1030},
+	}
+	if !reflect.DeepEqual(rankings, expected) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, rankings)
+	}
+
+	t.Log("History is the default profile's")
+
+	var profile int
+	if err = db.QueryRow(
+		"SELECT profile_id FROM comparisons WHERE id = 1",
+	).Scan(&profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile != DefaultProfile {
+		t.Errorf("Expected profile %d, got %d", DefaultProfile, profile)
+	}
+
+	snapshots, err := Snapshots(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Profile != DefaultProfile {
+		t.Errorf("Expected 1 default profile snapshot, got %#v", snapshots)
+	}
+
+	t.Log("Uncompared tracks rank by their prior")
+
+	tr, err := GetTrack(db, DefaultProfile, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Ranking != 1100 {
+		t.Errorf("Expected 1100, got %v", tr.Ranking)
+	}
+
+	t.Log("Ratings set by hand keep their prior")
+
+	if tr, err = GetTrack(db, DefaultProfile, 4); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Ranking != 900 {
+		t.Errorf("Expected 900, got %v", tr.Ranking)
+	}
+
+	sources, err := PriorSources(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSources := map[string]int{
+		"popm_rating":         1,
+		PriorSourceUserRating: 1,
+		priorSourceListens:    1,
+	}
+	if !reflect.DeepEqual(expectedSources, sources) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedSources, sources)
+	}
+
+	t.Log("Listens are the default profile's")
+
+	if tr, err = GetTrack(db, DefaultProfile, 2); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Plays != 1 {
+		t.Errorf("Expected 1 play, got %d", tr.Plays)
+	}
+
+	t.Log("Nothing to do the second time, or for a new DB")
+
+	for _, db := range []*sql.DB{db, test_utils.DBSetup()} {
+		if applied, err = Migrate(db); err != nil || applied != 0 {
+			t.Errorf("Expected no migrations, got %d, %v", applied, err)
+		}
+	}
+
+	if _, err = db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Migrate(db); err == nil {
+		t.Error("Expected error for a newer DB")
+	}
+}
@@ -0,0 +1,140 @@
+package repo
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// PriorSources counts the tracks with a prior from each kind of tag, and
+// those with one in the profile from its plays or rated or starred by
+// hand. Plays in the profile take the place of play counts in the tags.
+func PriorSources(db *sql.DB, profile int) (map[string]int, error) {
+	rows, err := db.Query(
+		`SELECT CASE WHEN a.listen_prior IS NOT NULL
+		              AND IFNULL(t.prior_source, '') IN ('', `+playCountPriorSourceList+`)
+		             THEN ?
+		             ELSE t.prior_source
+		        END AS source,
+		        COUNT(*)
+		   FROM tracks           t
+		   LEFT JOIN annotations a ON a.profile_id = ?
+		                          AND a.entity     = ?
+		                          AND a.entity_id  = t.id
+		  GROUP BY source
+		 HAVING source IS NOT NULL
+		  UNION ALL
+		 SELECT CASE WHEN rating IS NOT NULL THEN ? ELSE ? END, COUNT(*)
+		   FROM annotations
+		  WHERE profile_id = ?
+		    AND entity     = ?
+		    AND prior IS NOT NULL
+		  GROUP BY rating IS NOT NULL`,
+		priorSourceListens,
+		profile,
+		EntityTrack,
+		PriorSourceUserRating,
+		PriorSourceStarred,
+		profile,
+		EntityTrack,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := map[string]int{}
+	for rows.Next() {
+		var source string
+		var count int
+
+		if err = rows.Scan(&source, &count); err != nil {
+			return nil, err
+		}
+
+		sources[source] = count
+	}
+
+	return sources, rows.Err()
+}
+
+// ClearPriors drops the priors taken from the given sources, or all of
+// them if none are given, and replays the track comparisons so rankings
+// no longer start from them. Priors from tags are shared by every
+// profile, so go from all of them; priors from plays only go from the
+// profile. Priors from ratings and stars set by hand go when those are
+// cleared. Returns the number of priors dropped.
+func ClearPriors(db *sql.DB, profile int, sources ...string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where := "prior_source IS NOT NULL"
+	args := []interface{}{}
+	listens := len(sources) == 0
+
+	if len(sources) > 0 {
+		where = "prior_source IN (?" + strings.Repeat(",?", len(sources)-1) + ")"
+		for _, source := range sources {
+			args = append(args, source)
+			listens = listens || source == priorSourceListens
+		}
+	}
+
+	res, err := tx.Exec(
+		`UPDATE tracks
+		    SET prior        = NULL,
+		        prior_source = NULL
+		  WHERE `+where,
+		args...,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	tags, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	cleared := tags
+
+	if listens {
+		if res, err = tx.Exec(
+			`UPDATE annotations
+			    SET listen_prior = NULL
+			  WHERE profile_id   = ?
+			    AND listen_prior IS NOT NULL`,
+			profile,
+		); err != nil {
+			return 0, err
+		}
+
+		played, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		cleared += played
+
+		if _, err = tx.Exec(
+			`DELETE FROM annotations
+			  WHERE rating       IS NULL
+			    AND starred_at   IS NULL
+			    AND listen_prior IS NULL`,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	if tags > 0 {
+		err = replayComparisons(tx, EntityTrack)
+	} else {
+		err = replayProfileComparisons(tx, EntityTrack, profile)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return cleared, tx.Commit()
+}
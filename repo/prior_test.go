@@ -0,0 +1,134 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestPriors(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Prior:         track.Prior{Stars: 5, Source: "popm"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Prior:         track.Prior{Plays: 3, Source: "popm_plays"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	})
+
+	t.Log("Priors seed the starting ranking")
+
+	for id, expected := range map[int]float64{1: 1200, 2: 1050, 3: 1000} {
+		c, err := GetContender(db, DefaultProfile, EntityTrack, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Ranking != expected {
+			t.Errorf("Track %d: expected %v, got %v", id, expected, c.Ranking)
+		}
+	}
+
+	t.Log("Tracks without a prior are picked first")
+
+	for i := 0; i < 10; i++ {
+		first, _, err := NextPair(db, DefaultProfile, EntityTrack)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.InternalID != 3 {
+			t.Errorf("Expected track 3 first, got %d", first.InternalID)
+		}
+	}
+
+	t.Log("Replays start from the prior")
+
+	if _, err := RecordComparison(db, DefaultProfile, EntityTrack, 3, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ReplayComparisons(db, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	after, err := GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Errorf("Expected replay to give %+v, got %+v", before, after)
+	}
+
+	t.Log("Rescanning a rated track updates its prior")
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Prior:         track.Prior{Stars: 4, Source: "rating"},
+		}),
+	})
+
+	sources, err := PriorSources(db, DefaultProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 || sources["popm"] != 1 || sources["rating"] != 1 {
+		t.Errorf("Expected popm and rating, got %v", sources)
+	}
+
+	c, err := GetContender(db, DefaultProfile, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Ranking != 1100 {
+		t.Errorf("Expected uncompared track to move to 1100, got %v", c.Ranking)
+	}
+
+	t.Log("Cleared priors are replayed from the starting rating")
+
+	cleared, err := ClearPriors(db, DefaultProfile, "popm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleared != 1 {
+		t.Errorf("Expected 1 cleared, got %d", cleared)
+	}
+
+	c, err = GetContender(db, DefaultProfile, EntityTrack, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Ranking != 984 {
+		t.Errorf("Expected 984 after losing from 1000, got %v", c.Ranking)
+	}
+
+	c, err = GetContender(db, DefaultProfile, EntityTrack, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Ranking != 1100 {
+		t.Errorf("Expected other priors to be kept, got %v", c.Ranking)
+	}
+}
@@ -0,0 +1,173 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultProfile is the ID of the profile used when no user is given,
+// which rankings from before profiles were moved into
+const DefaultProfile = 1
+
+// Profile is one listener's rankings, comparison history and snapshots.
+// Tracks, albums and artists, and everything about them, are shared.
+type Profile struct {
+	ID   int
+	Name string
+}
+
+var (
+	ErrNoProfile      = errors.New("No such profile")
+	ErrDefaultProfile = errors.New("The default profile can't be deleted")
+)
+
+// Profiles returns every profile, default first
+func Profiles(db *sql.DB) ([]Profile, error) {
+	profiles := []Profile{}
+
+	err := queryEach(db,
+		"SELECT id, name FROM profiles ORDER BY id",
+		func(rows *sql.Rows) error {
+			var p Profile
+			if err := rows.Scan(&p.ID, &p.Name); err != nil {
+				return err
+			}
+
+			profiles = append(profiles, p)
+			return nil
+		},
+	)
+
+	return profiles, err
+}
+
+// GetProfile finds a profile by name, ignoring case. An empty name is
+// the default profile.
+func GetProfile(db querier, name string) (Profile, error) {
+	var p Profile
+	var err error
+
+	if name == "" {
+		err = db.QueryRow(
+			"SELECT id, name FROM profiles WHERE id = ?",
+			DefaultProfile,
+		).Scan(&p.ID, &p.Name)
+	} else {
+		err = db.QueryRow(
+			"SELECT id, name FROM profiles WHERE name = ?",
+			name,
+		).Scan(&p.ID, &p.Name)
+	}
+	if err == sql.ErrNoRows {
+		return Profile{}, fmt.Errorf("%w: '%s'", ErrNoProfile, name)
+	}
+
+	return p, err
+}
+
+// CreateProfile adds a profile with nothing ranked
+func CreateProfile(db *sql.DB, name string) (Profile, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Profile{}, errors.New("Profiles need a name")
+	}
+
+	if _, err := GetProfile(db, name); err == nil {
+		return Profile{}, fmt.Errorf("Profile '%s' already exists", name)
+	}
+
+	res, err := db.Exec("INSERT INTO profiles (name) VALUES (?)", name)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	return Profile{ID: int(id), Name: name}, nil
+}
+
+// SubsonicPassword returns the password Subsonic clients log in to the
+// profile with, or "" if they can't
+func SubsonicPassword(db querier, id int) (string, error) {
+	var password string
+	err := db.QueryRow(
+		"SELECT IFNULL(subsonic_password, '') FROM profiles WHERE id = ?",
+		id,
+	).Scan(&password)
+
+	return password, err
+}
+
+// SetSubsonicPassword sets the password Subsonic clients log in to the
+// profile with. "" stops them logging in.
+func SetSubsonicPassword(db *sql.DB, id int, password string) error {
+	res, err := db.Exec(
+		"UPDATE profiles SET subsonic_password = NULLIF(?, '') WHERE id = ?",
+		password,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteProfile removes a profile with its rankings, match history,
+// snapshots, ratings, stars and listens
+func DeleteProfile(db *sql.DB, id int) error {
+	if id == DefaultProfile {
+		return ErrDefaultProfile
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM profiles WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	// Don't rely on foreign keys being enabled for the cascade
+	for _, query := range []string{
+		"DELETE FROM rankings WHERE profile_id = ?",
+		`DELETE FROM comparison_results
+		  WHERE comparison_id IN (SELECT id
+		                            FROM comparisons
+		                           WHERE profile_id = ?)`,
+		"DELETE FROM comparisons WHERE profile_id = ?",
+		`DELETE FROM snapshot_rankings
+		  WHERE snapshot_id IN (SELECT id
+		                          FROM snapshots
+		                         WHERE profile_id = ?)`,
+		"DELETE FROM snapshots WHERE profile_id = ?",
+		"DELETE FROM annotations WHERE profile_id = ?",
+		"DELETE FROM listens WHERE profile_id = ?",
+	} {
+		if _, err = tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,148 @@
+package repo
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestProfiles(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	profile, err := CreateProfile(db, " Sam ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "Sam" {
+		t.Errorf("Expected 'Sam', got '%s'", profile.Name)
+	}
+
+	for _, name := range []string{"sam", "Default", " "} {
+		if _, err = CreateProfile(db, name); err == nil {
+			t.Errorf("Expected error creating '%s'", name)
+		}
+	}
+
+	profiles, err := Profiles(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 ||
+		profiles[0].ID != DefaultProfile ||
+		profiles[1] != profile {
+		t.Errorf("Expected default and %#v, got %#v", profile, profiles)
+	}
+
+	for name, expected := range map[string]int{
+		"":        DefaultProfile,
+		"default": DefaultProfile,
+		"SAM":     profile.ID,
+	} {
+		got, err := GetProfile(db, name)
+		if err != nil || got.ID != expected {
+			t.Errorf("'%s': expected %d, got %d, %v", name, expected, got.ID, err)
+		}
+	}
+	if _, err = GetProfile(db, "nobody"); err == nil {
+		t.Error("Expected error for unknown profile")
+	}
+
+	t.Log("Each profile has its own rankings")
+
+	if _, err = RecordComparison(db, DefaultProfile, EntityTrack, 1, 2, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RecordComparison(db, profile.ID, EntityTrack, 1, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RecordComparison(db, profile.ID, EntityTrack, 1, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ranked := func(profile int) (Contender, Contender) {
+		t.Helper()
+
+		a, err := GetContender(db, profile, EntityTrack, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := GetContender(db, profile, EntityTrack, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a, b
+	}
+
+	a, b := ranked(DefaultProfile)
+	if a.Ranking <= b.Ranking || a.Comparisons != 1 {
+		t.Errorf("Default profile: expected track 1 ahead after 1 match, got %#v, %#v", a, b)
+	}
+
+	a, b = ranked(profile.ID)
+	if a.Ranking >= b.Ranking || a.Comparisons != 2 {
+		t.Errorf("Sam: expected track 2 ahead after 2 matches, got %#v, %#v", a, b)
+	}
+
+	t.Log("Replaying keeps them apart")
+
+	if err = ReplayComparisons(db, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	if a, b = ranked(DefaultProfile); a.Ranking <= b.Ranking || a.Comparisons != 1 {
+		t.Errorf("Default profile: got %#v, %#v", a, b)
+	}
+	if a, b = ranked(profile.ID); a.Ranking >= b.Ranking || a.Comparisons != 2 {
+		t.Errorf("Sam: got %#v, %#v", a, b)
+	}
+
+	t.Log("Undo only touches the profile's own history")
+
+	if _, err = UndoLastComparison(db, DefaultProfile, EntityTrack); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ = ranked(DefaultProfile); a.Comparisons != 0 {
+		t.Errorf("Default profile: expected no matches, got %d", a.Comparisons)
+	}
+	if a, _ = ranked(profile.ID); a.Comparisons != 2 {
+		t.Errorf("Sam: expected 2 matches, got %d", a.Comparisons)
+	}
+
+	t.Log("Deleting")
+
+	if err = DeleteProfile(db, DefaultProfile); err != ErrDefaultProfile {
+		t.Errorf("Expected ErrDefaultProfile, got %v", err)
+	}
+	if err = DeleteProfile(db, profile.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = DeleteProfile(db, profile.ID); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+
+	var left int
+	if err = db.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM rankings)
+		      + (SELECT COUNT(*) FROM comparisons)
+		      + (SELECT COUNT(*) FROM comparison_results)`,
+	).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("Expected nothing left, got %d rows", left)
+	}
+}
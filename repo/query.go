@@ -0,0 +1,490 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+type OrderBy string
+
+const (
+	OrderByRanking     OrderBy = "ranking"
+	OrderByTitle       OrderBy = "title"
+	OrderByYear        OrderBy = "year"
+	OrderByComparisons OrderBy = "comparisons"
+)
+
+// A track's ranking and comparison count in a profile, from
+// trackRankingJoins. Tracks not yet compared are ranked by their prior
+// in the profile, or failing that the starting rating.
+var (
+	trackRanking     = "IFNULL(r.ranking, IFNULL(" + trackPrior("t") + ", s.rating))"
+	trackComparisons = "IFNULL(r.comparisons, 0)"
+)
+
+// Joins tracks 't' to their rankings in a profile as 'r', their
+// annotations in it as 'a', the profile ID as 'p' and the starting
+// rating as 's'. Takes the starting rating then the profile as
+// parameters.
+func trackRankingJoins() string {
+	return "CROSS JOIN (SELECT ? AS rating) s\n" + rankingJoin(EntityTrack, "t")
+}
+
+var orderByColumns = map[OrderBy]string{
+	OrderByRanking:     trackRanking,
+	OrderByTitle:       "t.title",
+	OrderByYear:        "t.year",
+	OrderByComparisons: trackComparisons,
+}
+
+// RankingQuery describes which ranked tracks to fetch. Zero values mean
+// "no filter", so an empty RankingQuery returns every track, highest
+// ranked first.
+type RankingQuery struct {
+	Profile int // Defaults to DefaultProfile
+
+	// Artist and Album match names case-insensitively. Artist matches
+	// secondary artists as well as the primary artist.
+	Artist string
+	Album  string
+	Genre  string
+
+	ArtistID int
+	AlbumID  int
+
+	// Inclusive
+	YearFrom int
+	YearTo   int
+
+	MinComparisons int
+
+	// Only tracks with no MusicBrainz ID
+	Unidentified bool
+
+	// Inclusive; 0 = no bound. Tracks not yet compared are banded by
+	// their prior or the starting rating, as they are ranked.
+	MinRating float64
+	MaxRating float64
+
+	OrderBy   OrderBy // Defaults to OrderByRanking
+	Ascending bool
+
+	Limit  int // 0 = no limit
+	Offset int
+}
+
+// RankingPage is one page of a ranking query. Total is the number of
+// tracks matching the filters, ignoring Limit and Offset.
+type RankingPage struct {
+	Tracks []track.Track
+	Total  int
+}
+
+// TopTracks returns the n highest ranked tracks
+func TopTracks(db *sql.DB, profile int, n int) ([]track.Track, error) {
+	page, err := QueryRankings(db, RankingQuery{Profile: profile, Limit: n})
+	if err != nil {
+		return nil, err
+	}
+
+	return page.Tracks, nil
+}
+
+// QueryRankings returns the tracks matching q, with their albums,
+// artists and comparison records populated
+func QueryRankings(db *sql.DB, q RankingQuery) (RankingPage, error) {
+	where, args := q.whereClause()
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return RankingPage{}, err
+	}
+
+	profile := q.Profile
+	if profile == 0 {
+		profile = DefaultProfile
+	}
+	args = append([]interface{}{config.StartingRating, profile}, args...)
+
+	var page RankingPage
+
+	row := db.QueryRow(
+		`SELECT COUNT(*)
+		   FROM tracks t
+		   `+trackRankingJoins()+`
+		  WHERE `+where,
+		args...,
+	)
+	if err := row.Scan(&page.Total); err != nil {
+		return RankingPage{}, err
+	}
+
+	orderBy := q.OrderBy
+	if orderBy == "" {
+		orderBy = OrderByRanking
+	}
+
+	column, ok := orderByColumns[orderBy]
+	if !ok {
+		return RankingPage{}, fmt.Errorf("Unknown order '%s'", orderBy)
+	}
+
+	direction := "DESC"
+	if q.Ascending {
+		direction = "ASC"
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1 // SQLite for "no limit"
+	}
+
+	rows, err := db.Query(
+		`SELECT t.id,
+		        IFNULL(t.musicbrainz_id, ''),
+		        IFNULL(t.title, ''),
+		        IFNULL(t.genre, ''),
+		        IFNULL(t.year, 0),
+		        `+trackRanking+`,
+		        `+trackComparisons+`,
+		        (SELECT COUNT(*)
+		           FROM listens l
+		          WHERE l.profile_id = p.id
+		            AND l.track_id   = t.id),
+		        (SELECT IFNULL(MAX(l.listened_at), 0)
+		           FROM listens l
+		          WHERE l.profile_id = p.id
+		            AND l.track_id   = t.id)
+		   FROM tracks t
+		   `+trackRankingJoins()+`
+		  WHERE `+where+`
+		  ORDER BY `+column+` `+direction+`, t.id
+		  LIMIT ? OFFSET ?`,
+		append(args, limit, q.Offset)...,
+	)
+	if err != nil {
+		return RankingPage{}, err
+	}
+	defer rows.Close()
+
+	page.Tracks = []track.Track{}
+
+	for rows.Next() {
+		var t track.Track
+		var lastPlayed int64
+
+		if err = rows.Scan(
+			&t.InternalID,
+			&t.MusicBrainzID,
+			&t.Title,
+			&t.Genre,
+			&t.Year,
+			&t.Ranking,
+			&t.Comparisons,
+			&t.Plays,
+			&lastPlayed,
+		); err != nil {
+			return RankingPage{}, err
+		}
+
+		t.LastPlayed = unixTime(lastPlayed)
+
+		page.Tracks = append(page.Tracks, t)
+	}
+	if err = rows.Err(); err != nil {
+		return RankingPage{}, err
+	}
+
+	for i := range page.Tracks {
+		if err = loadTrackLinks(db, &page.Tracks[i]); err != nil {
+			return RankingPage{}, err
+		}
+	}
+
+	if err = loadConfidence(db, profile, page.Tracks); err != nil {
+		return RankingPage{}, err
+	}
+
+	return page, nil
+}
+
+// GetTrack fetches a single track by internal ID, with its albums and
+// artists populated and its ranking and comparison record in the profile
+func GetTrack(db *sql.DB, profile int, id int) (track.Track, error) {
+	var t track.Track
+	var lastPlayed int64
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return track.Track{}, err
+	}
+
+	if err = db.QueryRow(
+		`SELECT t.id,
+		        IFNULL(t.musicbrainz_id, ''),
+		        IFNULL(t.title, ''),
+		        IFNULL(t.genre, ''),
+		        IFNULL(t.year, 0),
+		        `+trackRanking+`,
+		        `+trackComparisons+`,
+		        (SELECT COUNT(*)
+		           FROM listens l
+		          WHERE l.profile_id = p.id
+		            AND l.track_id   = t.id),
+		        (SELECT IFNULL(MAX(l.listened_at), 0)
+		           FROM listens l
+		          WHERE l.profile_id = p.id
+		            AND l.track_id   = t.id)
+		   FROM tracks t
+		   `+trackRankingJoins()+`
+		  WHERE t.id = ?`,
+		config.StartingRating,
+		profile,
+		id,
+	).Scan(
+		&t.InternalID,
+		&t.MusicBrainzID,
+		&t.Title,
+		&t.Genre,
+		&t.Year,
+		&t.Ranking,
+		&t.Comparisons,
+		&t.Plays,
+		&lastPlayed,
+	); err != nil {
+		return track.Track{}, err
+	}
+
+	t.LastPlayed = unixTime(lastPlayed)
+
+	if err = loadTrackLinks(db, &t); err != nil {
+		return track.Track{}, err
+	}
+
+	tracks := []track.Track{t}
+	if err = loadConfidence(db, profile, tracks); err != nil {
+		return track.Track{}, err
+	}
+
+	return tracks[0], nil
+}
+
+// TrackFiles returns the paths of a track's audio files
+func TrackFiles(db *sql.DB, trackID int) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT path
+		   FROM track_files
+		  WHERE track_id = ?
+		  ORDER BY path`,
+		trackID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		if err = rows.Scan(&path); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, rows.Err()
+}
+
+// KnownFiles returns the paths of every audio file in the library
+func KnownFiles(db *sql.DB) (map[string]bool, error) {
+	paths := map[string]bool{}
+
+	err := queryEach(db,
+		"SELECT path FROM track_files",
+		func(rows *sql.Rows) error {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				return err
+			}
+
+			paths[path] = true
+			return nil
+		},
+	)
+
+	return paths, err
+}
+
+func (q RankingQuery) whereClause() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+
+	if q.Artist != "" {
+		conditions = append(conditions,
+			`EXISTS (SELECT 1
+			           FROM track_artist tar
+			           JOIN artists      ar  ON ar.id = tar.artist_id
+			          WHERE tar.track_id = t.id
+			            AND ar.name = ? COLLATE NOCASE)`,
+		)
+		args = append(args, q.Artist)
+	}
+
+	if q.ArtistID > 0 {
+		conditions = append(conditions,
+			`EXISTS (SELECT 1
+			           FROM track_artist tar
+			          WHERE tar.track_id  = t.id
+			            AND tar.artist_id = ?)`,
+		)
+		args = append(args, q.ArtistID)
+	}
+
+	if q.Album != "" {
+		conditions = append(conditions,
+			`EXISTS (SELECT 1
+			           FROM track_album tal
+			           JOIN albums      al  ON al.id = tal.album_id
+			          WHERE tal.track_id = t.id
+			            AND al.title = ? COLLATE NOCASE)`,
+		)
+		args = append(args, q.Album)
+	}
+
+	if q.AlbumID > 0 {
+		conditions = append(conditions,
+			`EXISTS (SELECT 1
+			           FROM track_album tal
+			          WHERE tal.track_id = t.id
+			            AND tal.album_id = ?)`,
+		)
+		args = append(args, q.AlbumID)
+	}
+
+	if q.Genre != "" {
+		conditions = append(conditions, "t.genre = ? COLLATE NOCASE")
+		args = append(args, q.Genre)
+	}
+
+	if q.YearFrom > 0 {
+		conditions = append(conditions, "t.year >= ?")
+		args = append(args, q.YearFrom)
+	}
+
+	if q.YearTo > 0 {
+		conditions = append(conditions, "t.year <= ?")
+		args = append(args, q.YearTo)
+	}
+
+	if q.MinComparisons > 0 {
+		conditions = append(conditions, trackComparisons+" >= ?")
+		args = append(args, q.MinComparisons)
+	}
+
+	if q.Unidentified {
+		conditions = append(conditions, "IFNULL(t.musicbrainz_id, '') = ''")
+	}
+
+	if q.MinRating > 0 {
+		conditions = append(conditions, trackRanking+" >= ?")
+		args = append(args, q.MinRating)
+	}
+
+	if q.MaxRating > 0 {
+		conditions = append(conditions, trackRanking+" <= ?")
+		args = append(args, q.MaxRating)
+	}
+
+	return strings.Join(conditions, "\n		    AND "), args
+}
+
+// Populate albums and artists of an already-loaded track
+func loadTrackLinks(db *sql.DB, t *track.Track) error {
+	rows, err := db.Query(
+		`SELECT ar.id,
+		        IFNULL(ar.musicbrainz_id, ''),
+		        ar.name,
+		        tar.is_primary_artist
+		   FROM track_artist tar
+		   JOIN artists      ar  ON ar.id = tar.artist_id
+		  WHERE tar.track_id = ?
+		  ORDER BY ar.id`,
+		t.InternalID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	t.OtherArtists = []track.Artist{}
+
+	for rows.Next() {
+		var artist track.Artist
+		var isPrimary bool
+
+		if err = rows.Scan(
+			&artist.InternalID,
+			&artist.MusicBrainzID,
+			&artist.Name,
+			&isPrimary,
+		); err != nil {
+			return err
+		}
+
+		if isPrimary {
+			t.PrimaryArtist = artist
+		} else {
+			t.OtherArtists = append(t.OtherArtists, artist)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(
+		`SELECT al.id,
+		        IFNULL(al.musicbrainz_id, ''),
+		        al.title
+		   FROM track_album tal
+		   JOIN albums      al  ON al.id = tal.album_id
+		  WHERE tal.track_id = ?
+		  ORDER BY al.id`,
+		t.InternalID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	t.Albums = []track.Album{}
+
+	for rows.Next() {
+		var album track.Album
+
+		if err = rows.Scan(
+			&album.InternalID,
+			&album.MusicBrainzID,
+			&album.Title,
+		); err != nil {
+			return err
+		}
+
+		t.Albums = append(t.Albums, album)
+	}
+
+	return rows.Err()
+}
+
+// Zero time for 0, i.e. never
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(seconds, 0).UTC()
+}
@@ -0,0 +1,242 @@
+package repo
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestQueryRankings(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Genre:         "Rock",
+			Year:          1991,
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Genre:         "Pop",
+			Year:          1994,
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+			OtherArtists:  []track.Artist{{Name: "Artist 1"}},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			Genre:         "rock",
+			Year:          1998,
+			Albums:        []track.Album{{Title: "Album 3"}},
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+	})
+
+	setRanking(t, db, 1, 1100, 4)
+	setRanking(t, db, 2, 900, 2)
+	setRanking(t, db, 3, 1000, 6)
+
+	tests := []struct {
+		name          string
+		query         RankingQuery
+		expectedIDs   []int
+		expectedTotal int
+	}{
+		{"No filters", RankingQuery{}, []int{1, 3, 2}, 3},
+		{"Top N", RankingQuery{Limit: 2}, []int{1, 3}, 3},
+		{"Paginated", RankingQuery{Limit: 2, Offset: 2}, []int{2}, 3},
+		{"Ascending", RankingQuery{Ascending: true}, []int{2, 3, 1}, 3},
+		{
+			"Order by year",
+			RankingQuery{OrderBy: OrderByYear},
+			[]int{3, 2, 1},
+			3,
+		},
+		{
+			"Artist includes secondary artists",
+			RankingQuery{Artist: "artist 1"},
+			[]int{1, 2},
+			2,
+		},
+		{"Album", RankingQuery{Album: "Album 3"}, []int{3}, 1},
+		{"Album ID", RankingQuery{AlbumID: 2}, []int{2}, 1},
+		{"Genre", RankingQuery{Genre: "ROCK"}, []int{1, 3}, 2},
+		{
+			"Year range",
+			RankingQuery{YearFrom: 1992, YearTo: 1998},
+			[]int{3, 2},
+			2,
+		},
+		{"Min comparisons", RankingQuery{MinComparisons: 4}, []int{1, 3}, 2},
+		{
+			"Rating band",
+			RankingQuery{MinRating: 950, MaxRating: 1100},
+			[]int{1, 3},
+			2,
+		},
+		{"No matches", RankingQuery{Genre: "Jazz"}, []int{}, 0},
+	}
+
+	for _, test := range tests {
+		t.Log(test.name)
+
+		page, err := QueryRankings(db, test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotIDs := []int{}
+		for _, tr := range page.Tracks {
+			gotIDs = append(gotIDs, tr.InternalID)
+		}
+
+		if !reflect.DeepEqual(test.expectedIDs, gotIDs) {
+			t.Errorf("Expected IDs %v, got %v", test.expectedIDs, gotIDs)
+		}
+		if test.expectedTotal != page.Total {
+			t.Errorf("Expected total %d, got %d", test.expectedTotal, page.Total)
+		}
+	}
+
+	t.Log("Albums and artists are populated")
+
+	page, err := QueryRankings(db, RankingQuery{Album: "Album 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := track.Track{
+		InternalID:    2,
+		MusicBrainzID: "MB2",
+		Title:         "Title 2",
+		Genre:         "Pop",
+		Year:          1994,
+		Albums:        []track.Album{{InternalID: 2, Title: "Album 2"}},
+		PrimaryArtist: track.Artist{InternalID: 2, Name: "Artist 2"},
+		OtherArtists:  []track.Artist{{InternalID: 1, Name: "Artist 1"}},
+		Ranking:       900,
+		Comparisons:   2,
+		// Ranked by hand, so there are no results behind it
+		Deviation: elo.InitialDeviation,
+	}
+
+	if !reflect.DeepEqual(expected, page.Tracks[0]) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, page.Tracks[0])
+	}
+
+	t.Log("Unknown order is an error")
+
+	if _, err = QueryRankings(db, RankingQuery{OrderBy: "bogus"}); err == nil {
+		t.Error("Expected error for unknown order")
+	}
+}
+
+func TestTrackFiles(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	t.Log("Tracks without MusicBrainz IDs are kept apart")
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Files:         []string{"/music/1.mp3", "/music/1.flac"},
+		}),
+		track.New(track.Track{
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Files:         []string{"/music/2.mp3"},
+		}),
+	})
+
+	for id, expected := range map[int][]string{
+		1: {"/music/1.flac", "/music/1.mp3"},
+		2: {"/music/2.mp3"},
+		3: {},
+	} {
+		got, err := TrackFiles(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Track %d: expected %v, got %v", id, expected, got)
+		}
+	}
+
+	known, err := KnownFiles(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		"/music/1.mp3":  true,
+		"/music/1.flac": true,
+		"/music/2.mp3":  true,
+	}
+	if !reflect.DeepEqual(expected, known) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, known)
+	}
+
+	t.Log("Retagged file moves track")
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			Files:         []string{"/music/2.mp3"},
+		}),
+	})
+
+	got, err := TrackFiles(db, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"/music/2.mp3"}, got) {
+		t.Errorf("Expected file to move to track 3, got %v", got)
+	}
+
+	if got, err = TrackFiles(db, 2); err != nil || len(got) != 0 {
+		t.Errorf("Expected no files for track 2, got %v, %v", got, err)
+	}
+
+	t.Log("Library root")
+
+	if root, err := LoadLibraryRoot(db); err != nil || root != "" {
+		t.Errorf("Expected no root, got '%s', %v", root, err)
+	}
+	if err = SaveLibraryRoot(db, "/music"); err != nil {
+		t.Fatal(err)
+	}
+	if root, err := LoadLibraryRoot(db); err != nil || root != "/music" {
+		t.Errorf("Expected /music, got '%s', %v", root, err)
+	}
+}
+
+func setRanking(
+	t *testing.T, db *sql.DB, trackID int, ranking float64, comparisons int,
+) {
+	if _, err := db.Exec(
+		`INSERT INTO rankings
+		             (profile_id, entity, entity_id, ranking, comparisons)
+		      VALUES (?,?,?,?,?)
+		 ON CONFLICT (profile_id, entity, entity_id)
+		          DO UPDATE SET ranking     = excluded.ranking,
+		                        comparisons = excluded.comparisons`,
+		DefaultProfile,
+		EntityTrack,
+		trackID,
+		ranking,
+		comparisons,
+	); err != nil {
+		t.Fatal(err)
+	}
+}
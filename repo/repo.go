@@ -2,9 +2,11 @@ package repo
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"strconv"
 
+	"github.com/nephila-nacrea/rank-my-music/elo"
 	"github.com/nephila-nacrea/rank-my-music/track"
 )
 
@@ -28,18 +30,59 @@ type trackResult struct {
 	otherArtists  []artistResult
 }
 
-func SaveTracks(db *sql.DB, inputTracks []track.Track) {
+// SaveTracks adds tracks to the library, or updates the ones already in
+// it: by MusicBrainz ID, or failing that by audio file, so rescanning a
+// folder doesn't add its tracks again. Tracks that can't be saved are
+// logged and skipped, and the error says how many there were.
+func SaveTracks(db *sql.DB, inputTracks []track.Track) error {
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
 	for _, inputTrack := range inputTracks {
+		if inputTrack.Ranking == 0 && inputTrack.Prior.Source != "" {
+			inputTrack.Ranking = elo.PriorRating(
+				config.StartingRating,
+				inputTrack.Prior.Stars,
+				inputTrack.Prior.Plays,
+			)
+		}
+		if inputTrack.Ranking == 0 {
+			inputTrack.Ranking = config.StartingRating
+		}
+
 		err := saveTrack(db, inputTrack)
 		if err != nil {
 			log.Printf("%s\n\n", err)
+			failed++
 		}
 	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tracks could not be saved", failed, len(inputTracks))
+	}
+
+	return nil
 }
 
 // Save individual track, wrapped in a transaction
 func saveTrack(db *sql.DB, inputTrack track.Track) error {
-	existingTrack := getExistingDataForTrackMBID(db, inputTrack.MusicBrainzID)
+	// Tracks without a MusicBrainz ID can only be matched by a file
+	// already scanned, otherwise they are inserted
+	var existingTrack track.Track
+	if inputTrack.MusicBrainzID != "" {
+		existingTrack = getExistingDataForTrackMBID(db, inputTrack.MusicBrainzID)
+	} else {
+		for _, path := range inputTrack.Files {
+			existingTrack = getExistingDataForTrackFile(db, path)
+			if existingTrack.InternalID > 0 {
+				break
+			}
+		}
+	}
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -47,10 +90,21 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 	}
 	defer tx.Rollback()
 
+	trackID := int64(existingTrack.InternalID)
+
+	prior := sql.NullFloat64{
+		Float64: inputTrack.Ranking,
+		Valid:   inputTrack.Prior.Source != "",
+	}
+	priorSource := sql.NullString{
+		String: inputTrack.Prior.Source,
+		Valid:  inputTrack.Prior.Source != "",
+	}
+
 	if existingTrack.InternalID > 0 {
-		log.Printf("Track '%s' exists for MBID '%s'",
+		log.Printf("Track '%s' exists with ID %d",
 			existingTrack.Title,
-			existingTrack.MusicBrainzID,
+			existingTrack.InternalID,
 		)
 
 		// TODO:
@@ -60,7 +114,7 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 
 		// Album from inputTrack may not already be associated with track in
 		// DB
-		var existingAlbumsForTrack map[string]bool
+		existingAlbumsForTrack := map[string]bool{}
 		for _, album := range existingTrack.Albums {
 			existingAlbumsForTrack[album.Title] = true
 		}
@@ -69,19 +123,23 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 		// TODO What if album list empty?
 		inputAlbum := inputTrack.Albums[0]
 		if _, exists := existingAlbumsForTrack[inputAlbum.Title]; !exists {
-			// Does album exist already?
+			// Does album exist already? Albums without a MusicBrainz ID
+			// cannot be matched, so are always inserted.
 			var albumInternalID int64
 
-			row := tx.QueryRow(
-				`SELECT id
-				   FROM albums
-				  WHERE musicbrainz_id = ?`,
-				inputAlbum.MusicBrainzID,
-			)
+			err := sql.ErrNoRows
+			if inputAlbum.MusicBrainzID != "" {
+				row := tx.QueryRow(
+					`SELECT id
+					   FROM albums
+					  WHERE musicbrainz_id = ?`,
+					inputAlbum.MusicBrainzID,
+				)
 
-			err := row.Scan(&albumInternalID)
-			if err != nil && err != sql.ErrNoRows {
-				return err
+				err = row.Scan(&albumInternalID)
+				if err != nil && err != sql.ErrNoRows {
+					return err
+				}
 			}
 			if err == sql.ErrNoRows {
 				log.Printf(
@@ -127,8 +185,12 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 
 		// Secondary artists from input track may not already be associated
 		// with track in DB
+		existingOtherArtistsForTrack := map[string]bool{}
+		for _, artist := range existingTrack.OtherArtists {
+			existingOtherArtistsForTrack[artist.Name] = true
+		}
+
 		for _, inputOtherArtist := range inputTrack.OtherArtists {
-			var existingOtherArtistsForTrack map[string]bool
 			if _, exists := existingOtherArtistsForTrack[inputOtherArtist.Name]; !exists {
 				// Check if artist exists in DB.
 				// Secondary artists do not have a MusicBrainz ID so we have
@@ -159,7 +221,7 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 						`INSERT INTO artists
 						             (name)
 						      VALUES (?)`,
-						inputOtherArtist,
+						inputOtherArtist.Name,
 					)
 					if err != nil {
 						return err
@@ -191,6 +253,41 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 				}
 			}
 		}
+
+		// The tags may have been corrected since the last scan. Blank
+		// values leave what we have.
+		_, err = tx.Exec(
+			`UPDATE tracks
+			    SET title = IFNULL(NULLIF(?, ''), title),
+			        genre = IFNULL(NULLIF(?, ''), genre),
+			        year  = IFNULL(NULLIF(?, 0), year)
+			  WHERE id = ?`,
+			inputTrack.Title,
+			inputTrack.Genre,
+			inputTrack.Year,
+			existingTrack.InternalID,
+		)
+		if err != nil {
+			return err
+		}
+
+		// The tags may have been rated since the last scan. Tracks
+		// already compared keep their ranking until the next replay, and
+		// ratings set by hand, kept with their annotations, beat the tags.
+		if prior.Valid {
+			_, err = tx.Exec(
+				`UPDATE tracks
+				    SET prior        = ?,
+				        prior_source = ?
+				  WHERE id = ?`,
+				prior,
+				priorSource,
+				existingTrack.InternalID,
+			)
+			if err != nil {
+				return err
+			}
+		}
 	} else {
 		// Brand new track
 
@@ -203,32 +300,34 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 
 		res, err := tx.Exec(
 			`INSERT INTO tracks
-			             (title, musicbrainz_id, ranking)
-			      VALUES (?,?,?)`,
+			             (title, musicbrainz_id, genre, year, prior,
+			              prior_source)
+			      VALUES (?,?,?,?,?,?)`,
 			inputTrack.Title,
 			inputTrack.MusicBrainzID,
-			inputTrack.Ranking,
+			inputTrack.Genre,
+			inputTrack.Year,
+			prior,
+			priorSource,
 		)
 		if err != nil {
 			log.Fatalln(err)
 		}
 
-		trackID, err := res.LastInsertId()
+		trackID, err = res.LastInsertId()
 		if err != nil {
 			log.Fatalln(err)
 		}
 
-		var artistIDs []int64
-
 		// Insert artist if not a duplicate
 		for idx, artist := range append(
-			[]string{inputTrack.PrimaryArtist},
+			[]track.Artist{inputTrack.PrimaryArtist},
 			inputTrack.OtherArtists...,
 		) {
 			// Does artist already exist?
 			row := tx.QueryRow(
 				"SELECT id FROM artists WHERE name = ?",
-				artist,
+				artist.Name,
 			)
 
 			var artistID int64
@@ -236,13 +335,14 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 				log.Fatalln(err)
 			}
 			if err == sql.ErrNoRows {
-				log.Println("    Inserting artist: " + artist)
+				log.Println("    Inserting artist: " + artist.Name)
 
 				res, err = tx.Exec(
 					`INSERT INTO artists
-					            (name)
-					     VALUES (?)`,
-					artist,
+					            (musicbrainz_id, name)
+					     VALUES (?,?)`,
+					artist.MusicBrainzID,
+					artist.Name,
 				)
 				if err != nil {
 					log.Fatalln(err)
@@ -262,75 +362,79 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 				     VALUES (?,?,?)`,
 				trackID,
 				artistID,
-				idx == 0, // Assume primary artist is first one in list
+				idx == 0, // Primary artist is first one in list
 			)
 			if err != nil {
 				log.Fatalln(err)
 			}
-
-			artistIDs = append(artistIDs, artistID)
 		}
 
-		// Insert album if not a duplicate. It is possible for
-		// different albums to have the same name, but we assume
-		// album names to be unique per primary artist.
-		row := tx.QueryRow(
-			`SELECT al.id
-			   FROM albums al
-			   JOIN album_artist aa ON aa.album_id = al.id
-			  WHERE al.title = ?
-			    AND aa.artist_id  = ?`,
-			inputTrack.Album,
-			artistIDs[0], // Primary artist
-		)
+		// Albums can share titles, so only reuse an existing album if
+		// the MusicBrainz IDs match
+		for _, album := range inputTrack.Albums {
+			var albumID int64
 
-		var albumID int64
-		if err = row.Scan(&albumID); err != nil && err != sql.ErrNoRows {
-			log.Fatalln(err)
-		}
-		if err == sql.ErrNoRows {
-			log.Println("    Inserting album: " + inputTrack.Album)
-
-			res, err = tx.Exec(
-				`INSERT INTO albums
-				            (title)
-				     VALUES (?)`,
-				inputTrack.Album,
-			)
-			if err != nil {
-				log.Fatalln(err)
+			err = sql.ErrNoRows
+			if album.MusicBrainzID != "" {
+				row := tx.QueryRow(
+					`SELECT id
+					   FROM albums
+					  WHERE musicbrainz_id = ?`,
+					album.MusicBrainzID,
+				)
+
+				err = row.Scan(&albumID)
+				if err != nil && err != sql.ErrNoRows {
+					log.Fatalln(err)
+				}
 			}
+			if err == sql.ErrNoRows {
+				log.Println("    Inserting album: " + album.Title)
 
-			albumID, err = res.LastInsertId()
-			if err != nil {
-				log.Fatalln(err)
+				res, err = tx.Exec(
+					`INSERT INTO albums
+					            (musicbrainz_id, title)
+					     VALUES (?,?)`,
+					album.MusicBrainzID,
+					album.Title,
+				)
+				if err != nil {
+					log.Fatalln(err)
+				}
+
+				albumID, err = res.LastInsertId()
+				if err != nil {
+					log.Fatalln(err)
+				}
 			}
 
-			// Populate album_artist
+			log.Println("    Album ID: " + strconv.Itoa(int(albumID)))
+
+			// Populate track_album
 			_, err = tx.Exec(
-				`INSERT INTO album_artist
-				            (album_id, artist_id)
+				`INSERT INTO track_album
+				            (track_id, album_id)
 				     VALUES (?,?)`,
+				trackID,
 				albumID,
-				artistIDs[0], // Primary artist
 			)
 			if err != nil {
 				log.Fatalln(err)
 			}
 		}
+	}
 
-		log.Println("    Album ID: " + strconv.Itoa(int(albumID)))
-
-		// Populate track_album
-		_, err = tx.Exec(
-			`INSERT INTO track_album
-			            (track_id, album_id)
-			     VALUES (?,?)`,
+	// A file that was retagged moves to the track it now describes
+	for _, path := range inputTrack.Files {
+		if _, err = tx.Exec(
+			`INSERT INTO track_files
+			            (path, track_id)
+			     VALUES (?,?)
+			         ON CONFLICT (path) DO UPDATE SET track_id = excluded.track_id`,
+			path,
 			trackID,
-			albumID,
-		)
-		if err != nil {
-			log.Fatalln(err)
+		); err != nil {
+			return err
 		}
 	}
 
@@ -338,7 +442,21 @@ func saveTrack(db *sql.DB, inputTrack track.Track) error {
 	return tx.Commit()
 }
 
-func getExistingDataForTrackMBID(db *sql.DB, trackMBID string) (
+func getExistingDataForTrackMBID(db *sql.DB, trackMBID string) track.Track {
+	return getExistingTrack(db, "t.musicbrainz_id = ?", trackMBID)
+}
+
+func getExistingDataForTrackFile(db *sql.DB, path string) track.Track {
+	return getExistingTrack(
+		db,
+		"t.id = (SELECT track_id FROM track_files WHERE path = ?)",
+		path,
+	)
+}
+
+// The track matching condition on tracks 't', which takes arg, with its
+// artists and albums. Zero if there isn't one.
+func getExistingTrack(db *sql.DB, condition string, arg string) (
 	existingTrack track.Track,
 ) {
 	row := db.QueryRow(
@@ -346,23 +464,23 @@ func getExistingDataForTrackMBID(db *sql.DB, trackMBID string) (
 		        t.musicbrainz_id,
 		        t.title,
 		        ar.id,
-		        ar.musicbrainz_id,
+		        IFNULL(ar.musicbrainz_id, ''),
 		        ar.name
 		   FROM tracks       t
 		   JOIN track_artist tar ON tar.track_id = t.id
 		   JOIN artists      ar  ON ar.id = tar.artist_id
-		  WHERE t.musicbrainz_id      = ?
+		  WHERE `+condition+`
 		    AND tar.is_primary_artist = 1`,
-		trackMBID,
+		arg,
 	)
 
 	err := row.Scan(
-		existingTrack.InternalID,
-		existingTrack.MusicBrainzID,
-		existingTrack.Title,
-		existingTrack.PrimaryArtist.InternalID,
-		existingTrack.PrimaryArtist.MusicBrainzID,
-		existingTrack.PrimaryArtist.Name,
+		&existingTrack.InternalID,
+		&existingTrack.MusicBrainzID,
+		&existingTrack.Title,
+		&existingTrack.PrimaryArtist.InternalID,
+		&existingTrack.PrimaryArtist.MusicBrainzID,
+		&existingTrack.PrimaryArtist.Name,
 	)
 	if err != nil && err != sql.ErrNoRows {
 		log.Fatalln(err)
@@ -373,14 +491,14 @@ func getExistingDataForTrackMBID(db *sql.DB, trackMBID string) (
 	// Get secondary artists
 	rows, err := db.Query(
 		`SELECT ar.id,
-		        ar.musicbrainz_id,
+		        IFNULL(ar.musicbrainz_id, ''),
 		        ar.name
 		   FROM tracks       t
 		   JOIN track_artist tar ON tar.track_id = t.id
 		   JOIN artists      ar  ON ar.id = tar.artist_id
-		  WHERE t.musicbrainz_id      = ?
+		  WHERE `+condition+`
 		    AND tar.is_primary_artist = 0`,
-		trackMBID,
+		arg,
 	)
 	if err != nil {
 		log.Fatalln(err)
@@ -391,9 +509,9 @@ func getExistingDataForTrackMBID(db *sql.DB, trackMBID string) (
 		var artist track.Artist
 
 		if err = rows.Scan(
-			artist.InternalID,
-			artist.MusicBrainzID,
-			artist.Name,
+			&artist.InternalID,
+			&artist.MusicBrainzID,
+			&artist.Name,
 		); err != nil {
 			log.Fatalln(err)
 		}
@@ -406,13 +524,13 @@ func getExistingDataForTrackMBID(db *sql.DB, trackMBID string) (
 	// Get albums
 	rows, err = db.Query(
 		`SELECT al.id,
-		        al.musicbrainz_id,
+		        IFNULL(al.musicbrainz_id, ''),
 		        al.title
 		   FROM tracks      t
 		   JOIN track_album tal ON tal.track_id = t.id
 		   JOIN albums      al  ON al.id        = tal.album_id
-		  WHERE t.musicbrainz_id = ?`,
-		trackMBID,
+		  WHERE `+condition,
+		arg,
 	)
 	if err != nil {
 		log.Fatalln(err)
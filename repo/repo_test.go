@@ -20,21 +20,19 @@ func TestSaveTracks(t *testing.T) {
 	t.Log("Brand new track with MusicBrainz ID plus a complete duplicate")
 
 	input := []track.Track{
-		track.New(
-			"Title 1",
-			"Album 1",
-			"Artist 1",
-			[]string{},
-			"MB1",
-		),
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
 		// Complete duplicate
-		track.New(
-			"Title 1",
-			"Album 1",
-			"Artist 1",
-			[]string{},
-			"MB1",
-		),
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
 	}
 
 	SaveTracks(db, input)
@@ -68,13 +66,12 @@ func TestSaveTracks(t *testing.T) {
 	t.Log("Track with existing MusicBrainz ID but different album")
 
 	input = []track.Track{
-		track.New(
-			"Title 1",
-			"Album 2",
-			"Artist 1",
-			[]string{},
-			"MB1",
-		),
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 2"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
 	}
 
 	SaveTracks(db, input)
@@ -112,13 +109,16 @@ func TestSaveTracks(t *testing.T) {
 	t.Log("Track with existing MusicBrainz ID, adding secondary artists")
 
 	input = []track.Track{
-		track.New(
-			"Title 1",
-			"Album 1",
-			"Artist 1",
-			[]string{"Artist 2", "Artist 3"},
-			"MB1",
-		),
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			OtherArtists: []track.Artist{
+				{Name: "Artist 2"},
+				{Name: "Artist 3"},
+			},
+		}),
 	}
 
 	SaveTracks(db, input)
@@ -166,13 +166,15 @@ func TestSaveTracks(t *testing.T) {
 	t.Log("New artists should be added to existing list")
 
 	input = []track.Track{
-		track.New(
-			"Title 1",
-			"Album 1",
-			"Artist 1",
-			[]string{"Artist 4"},
-			"MB1",
-		),
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+			OtherArtists: []track.Artist{
+				{Name: "Artist 4"},
+			},
+		}),
 	}
 
 	SaveTracks(db, input)
@@ -224,34 +226,31 @@ func TestSaveTracks(t *testing.T) {
 	t.Log("Should be an entirely new track")
 
 	input = []track.Track{
-		track.New(
-			"Title 1",
-			"Album 1",
-			"Artist 1",
-			[]string{},
-			"MB2",
-		),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 1",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
 	}
 
 	SaveTracks(db, input)
 
-	expected = map[int]trackResult{
-		2: {
-			id:            2,
-			title:         "Title 1",
-			musicBrainzID: "MB2",
-			albums: []albumResult{
-				{
-					id:    3,
-					title: "Album 1",
-				},
-			},
-			primaryArtist: artistResult{
-				id:   1,
-				name: "Artist 1",
+	expected[2] = trackResult{
+		id:            2,
+		title:         "Title 1",
+		musicBrainzID: "MB2",
+		albums: []albumResult{
+			{
+				id:    3,
+				title: "Album 1",
 			},
-			otherArtists: []artistResult{},
 		},
+		primaryArtist: artistResult{
+			id:   1,
+			name: "Artist 1",
+		},
+		otherArtists: []artistResult{},
 	}
 
 	got = readDB(t, db)
@@ -271,12 +270,29 @@ func TestSaveTracks(t *testing.T) {
 	t.Log("Track with existing MusicBrainz ID but different title")
 	t.Log("Title should be overwritten")
 
-	t.Log("TODO")
+	input = []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			Albums:        []track.Album{{Title: "Album 1"}},
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+	}
+
+	SaveTracks(db, input)
+
+	retitled := expected[2]
+	retitled.title = "Title 2"
+	expected[2] = retitled
+
+	got = readDB(t, db)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
 
 	//////////////////////////////////////////////////////////////////////////
 
 	// TODO
-	// Existing MBID, new title
 	// Existing MBID, new primary artist
 	// New MBID, duplicate (title + primary artist)
 	// No MBID, completely new data
@@ -651,6 +667,62 @@ func TestSaveTracks(t *testing.T) {
 	// Update track that has a musicbrainz ID
 }
 
+func TestSaveTracksRescan(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	scan := func(title string) {
+		err := SaveTracks(db, []track.Track{
+			track.New(track.Track{
+				Title:         title,
+				Albums:        []track.Album{{Title: "Album 1"}},
+				PrimaryArtist: track.Artist{Name: "Artist 1"},
+				Files:         []string{"/music/one.mp3"},
+			}),
+			track.New(track.Track{
+				Title:         "Title 2",
+				Albums:        []track.Album{{Title: "Album 1"}},
+				PrimaryArtist: track.Artist{Name: "Artist 1"},
+				Files:         []string{"/music/two.mp3"},
+			}),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Log("Rescanning tracks without a MusicBrainz ID matches them by file")
+
+	scan("Title 1")
+	scan("Title 1 (Retagged)")
+
+	var tracks int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tracks`).Scan(&tracks); err != nil {
+		t.Fatal(err)
+	}
+	if tracks != 2 {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", 2, tracks)
+	}
+
+	var trackID int
+	var title string
+	err := db.QueryRow(
+		`SELECT t.id, t.title
+		   FROM track_files tf
+		   JOIN tracks      t ON t.id = tf.track_id
+		  WHERE tf.path = '/music/one.mp3'`,
+	).Scan(&trackID, &title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trackID != 1 || title != "Title 1 (Retagged)" {
+		t.Errorf(
+			"\nExpected:\n%#v\ngot:\n%#v",
+			[]interface{}{1, "Title 1 (Retagged)"},
+			[]interface{}{trackID, title},
+		)
+	}
+}
+
 func readDB(t *testing.T, db *sql.DB) map[int]trackResult {
 	rows, err := db.Query(
 		`SELECT t.id,
@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a track, album or artist matching a search
+type SearchResult struct {
+	Entity Entity
+	Contender
+
+	// bm25 score; lower is a better match
+	Score float64
+}
+
+// Search finds tracks, albums and artists whose names contain words
+// starting with each of the words in query, best matches first. Case and
+// diacritics are ignored, so "bjo" finds "Björk". Tracks also match on
+// their artists and albums. With no entities given, all are searched.
+// Rankings are those of the profile.
+func Search(
+	db *sql.DB, profile int, query string, limit int, only ...Entity,
+) ([]SearchResult, error) {
+	match := searchExpression(query)
+	if match == "" {
+		return []SearchResult{}, nil
+	}
+
+	if len(only) == 0 {
+		only = []Entity{EntityTrack, EntityAlbum, EntityArtist}
+	}
+
+	if limit <= 0 {
+		limit = -1 // SQLite for "no limit"
+	}
+
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []SearchResult{}
+
+	for _, entity := range only {
+		queries, ok := entities[entity]
+		if !ok {
+			return nil, fmt.Errorf("Unknown entity '%s'", entity)
+		}
+
+		rows, err := db.Query(
+			`SELECT c.*,
+			        bm25(`+queries.search+`)
+			   FROM (`+queries.contenders+`) c
+			   JOIN `+queries.search+` ON `+queries.search+`.rowid = c.id
+			  WHERE `+queries.search+` MATCH ?
+			  ORDER BY bm25(`+queries.search+`)
+			  LIMIT ?`,
+			config.StartingRating,
+			profile,
+			match,
+			limit,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			r := SearchResult{Entity: entity}
+
+			if err = rows.Scan(
+				&r.InternalID,
+				&r.Name,
+				&r.Detail,
+				&r.Ranking,
+				&r.Comparisons,
+				&r.Score,
+			); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			results = append(results, r)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score < results[j].Score
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+// RebuildSearchIndex repopulates the search indexes from scratch. They
+// are normally kept up to date by triggers.
+func RebuildSearchIndex(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"DELETE FROM tracks_search",
+		`INSERT INTO tracks_search
+		             (rowid, title, artists, albums)
+		      SELECT id, title, artists, albums
+		        FROM tracks_search_source`,
+		"DELETE FROM albums_search",
+		`INSERT INTO albums_search
+		             (rowid, title)
+		      SELECT id, title
+		        FROM albums`,
+		"DELETE FROM artists_search",
+		`INSERT INTO artists_search
+		             (rowid, name)
+		      SELECT id, name
+		        FROM artists`,
+	} {
+		if _, err = tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Turns free text into an FTS5 query matching every word as a prefix.
+// Words are quoted, so FTS5 syntax in the input is treated as text.
+func searchExpression(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+	})
+
+	terms := []string{}
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+
+	return strings.Join(terms, " ")
+}
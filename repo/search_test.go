@@ -0,0 +1,158 @@
+package repo
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestSearch(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Jóga",
+			Albums:        []track.Album{{Title: "Homogenic"}},
+			PrimaryArtist: track.Artist{Name: "Björk"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Hunter",
+			Albums:        []track.Album{{Title: "Homogenic"}},
+			PrimaryArtist: track.Artist{Name: "Björk"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Homesick",
+			Albums:        []track.Album{{Title: "Disintegration"}},
+			PrimaryArtist: track.Artist{Name: "The Cure"},
+		}),
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		only     []Entity
+		expected []string // entity:id
+	}{
+		{"Diacritics ignored", "joga", []Entity{EntityTrack}, []string{"track:1"}},
+		{"Case ignored", "HUNTER", []Entity{EntityTrack}, []string{"track:2"}},
+		{"Prefix", "bjo", []Entity{EntityArtist}, []string{"artist:1"}},
+		{"Tracks match on artist", "bjork", []Entity{EntityTrack}, []string{"track:1", "track:2"}},
+		{"Every word must match", "homo hun", []Entity{EntityTrack}, []string{"track:2"}},
+		{"Albums", "homo", []Entity{EntityAlbum}, []string{"album:1", "album:2"}},
+		{"Syntax is treated as text", `"cure)*`, []Entity{EntityArtist}, []string{"artist:2"}},
+		{"No words", "  -- ", nil, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Log(test.name)
+
+		results, err := Search(db, DefaultProfile, test.query, 0, test.only...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := []string{}
+		for _, r := range results {
+			got = append(got, fmt.Sprintf("%s:%d", r.Entity, r.InternalID))
+		}
+
+		if !sameElements(test.expected, got) {
+			t.Errorf("\nExpected:\n%v\ngot:\n%v", test.expected, got)
+		}
+	}
+
+	t.Log("All entities, best match first")
+
+	results, err := Search(db, DefaultProfile, "hom", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Errorf("Expected 2 albums and 3 tracks, got %+v", results)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Score < results[i-1].Score {
+			t.Errorf("Expected results in score order, got %+v", results)
+		}
+	}
+
+	if results, err = Search(db, DefaultProfile, "hom", 2); err != nil || len(results) != 2 {
+		t.Errorf("Expected 2 results with limit, got %+v, %v", results, err)
+	}
+
+	t.Log("Index follows renames")
+
+	if _, err = db.Exec("UPDATE artists SET name = 'Bjork Gudmundsdottir' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("UPDATE tracks SET title = 'Bachelorette' WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err = Search(db, DefaultProfile, "gudm", 0, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Detail != "Bjork Gudmundsdottir" {
+		t.Errorf("Expected both tracks under new artist name, got %+v", results)
+	}
+
+	results, err = Search(db, DefaultProfile, "bachelorette", 0, EntityTrack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SearchResult{{
+		Entity: EntityTrack,
+		Contender: Contender{
+			InternalID: 2,
+			Name:       "Bachelorette",
+			Detail:     "Bjork Gudmundsdottir",
+			Ranking:    1000,
+		},
+	}}
+	if len(results) == 1 {
+		expected[0].Score = results[0].Score
+	}
+	if !reflect.DeepEqual(expected, results) {
+		t.Errorf("\nExpected:\n%+v\ngot:\n%+v", expected, results)
+	}
+
+	if results, err = Search(db, DefaultProfile, "hunter", 0); err != nil || len(results) != 0 {
+		t.Errorf("Expected old title to be gone, got %+v, %v", results, err)
+	}
+
+	t.Log("Rebuild")
+
+	if _, err = db.Exec("DELETE FROM tracks_search"); err != nil {
+		t.Fatal(err)
+	}
+	if err = RebuildSearchIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = Search(db, DefaultProfile, "homesick", 0); err != nil || len(results) != 1 {
+		t.Errorf("Expected rebuilt index to find track 3, got %+v, %v", results, err)
+	}
+}
+
+func sameElements(a []string, b []string) bool {
+	counts := map[string]int{}
+	for _, s := range a {
+		counts[s]++
+	}
+	for _, s := range b {
+		counts[s]--
+	}
+	for _, n := range counts {
+		if n != 0 {
+			return false
+		}
+	}
+
+	return true
+}
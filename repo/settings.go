@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+)
+
+const (
+	eloConfigKey   = "elo_config"
+	gradeScaleKey  = "grade_scale"
+	libraryRootKey = "library_root"
+)
+
+// Satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// LoadEloConfig returns the stored Elo config, or elo.DefaultConfig if
+// none has been saved
+func LoadEloConfig(db querier) (elo.Config, error) {
+	config := elo.DefaultConfig()
+
+	found, err := loadSetting(db, eloConfigKey, &config)
+	if err != nil || !found {
+		return elo.DefaultConfig(), err
+	}
+
+	return config, nil
+}
+
+// SaveEloConfig stores the Elo config used for all future comparisons.
+// Existing rankings are not changed; use ReplayComparisons for that.
+func SaveEloConfig(db querier, config elo.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	return saveSetting(db, eloConfigKey, config)
+}
+
+// LoadGradeScale returns the stored grade scale, or
+// elo.DefaultGradeScale if none has been saved
+func LoadGradeScale(db querier) (elo.GradeScale, error) {
+	var scale elo.GradeScale
+
+	found, err := loadSetting(db, gradeScaleKey, &scale)
+	if err != nil || !found {
+		return elo.DefaultGradeScale(), err
+	}
+
+	return scale, nil
+}
+
+// SaveGradeScale stores the mapping from graded preferences to outcomes.
+// Comparisons already made keep the outcome they were recorded with.
+func SaveGradeScale(db querier, scale elo.GradeScale) error {
+	if err := scale.Validate(); err != nil {
+		return err
+	}
+
+	return saveSetting(db, gradeScaleKey, scale)
+}
+
+// LoadLibraryRoot returns the folder the tracks were last scanned from,
+// or "" if none has been scanned
+func LoadLibraryRoot(db querier) (string, error) {
+	var root string
+
+	_, err := loadSetting(db, libraryRootKey, &root)
+
+	return root, err
+}
+
+// SaveLibraryRoot records the folder tracks were scanned from. Only audio
+// files under it are served.
+func SaveLibraryRoot(db querier, root string) error {
+	return saveSetting(db, libraryRootKey, root)
+}
+
+// Decodes JSON setting into value. Returns false if there is no such
+// setting.
+func loadSetting(db querier, key string, value interface{}) (bool, error) {
+	var encoded string
+
+	row := db.QueryRow("SELECT value FROM settings WHERE key = ?", key)
+	if err := row.Scan(&encoded); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal([]byte(encoded), value)
+}
+
+func saveSetting(db querier, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO settings
+		             (key, value)
+		      VALUES (?,?)
+		 ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		key,
+		string(encoded),
+	)
+
+	return err
+}
@@ -0,0 +1,79 @@
+package repo
+
+import "database/sql"
+
+// LibraryStats counts what is in the DB, and what one profile has ranked
+type LibraryStats struct {
+	Tracks  int
+	Albums  int
+	Artists int
+
+	// Votes, per entity
+	Comparisons map[Entity]int
+
+	// Track comparisons inferred from listening
+	ImplicitComparisons int
+
+	// Tracks compared at least once
+	RankedTracks int
+}
+
+func GetLibraryStats(db *sql.DB, profile int) (LibraryStats, error) {
+	var stats LibraryStats
+
+	if err := db.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM tracks),
+		        (SELECT COUNT(*) FROM albums),
+		        (SELECT COUNT(*) FROM artists),
+		        (SELECT COUNT(*)
+		           FROM rankings
+		          WHERE profile_id  = ?
+		            AND entity      = ?
+		            AND comparisons > 0)`,
+		profile,
+		EntityTrack,
+	).Scan(
+		&stats.Tracks,
+		&stats.Albums,
+		&stats.Artists,
+		&stats.RankedTracks,
+	); err != nil {
+		return LibraryStats{}, err
+	}
+
+	stats.Comparisons = map[Entity]int{}
+	for entity := range entities {
+		stats.Comparisons[entity] = 0
+	}
+
+	rows, err := db.Query(
+		`SELECT entity, source = ?, COUNT(*)
+		   FROM comparisons
+		  WHERE profile_id = ?
+		  GROUP BY 1, 2`,
+		ComparisonSourceVote,
+		profile,
+	)
+	if err != nil {
+		return LibraryStats{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entity Entity
+		var vote bool
+		var count int
+
+		if err = rows.Scan(&entity, &vote, &count); err != nil {
+			return LibraryStats{}, err
+		}
+
+		if vote {
+			stats.Comparisons[entity] = count
+		} else {
+			stats.ImplicitComparisons += count
+		}
+	}
+
+	return stats, rows.Err()
+}
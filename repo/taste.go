@@ -0,0 +1,515 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/taste"
+)
+
+// TasteMatch is how alike two profiles' rankings are, over the tracks
+// both have compared. Correlations are from -1 (opposite tastes) to 1
+// (the same), and 0 with fewer than two tracks in common.
+type TasteMatch struct {
+	ProfileA int
+	ProfileB int
+
+	Common int
+
+	Spearman float64
+	Kendall  float64
+}
+
+// Disagreement is a track two profiles rank differently. Positions are
+// among the tracks both have compared, 1 = top.
+type Disagreement struct {
+	TrackID       int
+	Title         string
+	PrimaryArtist string
+
+	RankingA  float64
+	RankingB  float64
+	PositionA int
+	PositionB int
+}
+
+// Gap is how many places apart the profiles put the track
+func (d Disagreement) Gap() int {
+	if d.PositionA > d.PositionB {
+		return d.PositionA - d.PositionB
+	}
+
+	return d.PositionB - d.PositionA
+}
+
+type ConsensusMethod string
+
+const (
+	ConsensusBorda        ConsensusMethod = "borda"
+	ConsensusKemeny       ConsensusMethod = "kemeny"
+	ConsensusBradleyTerry ConsensusMethod = "bradley-terry"
+)
+
+type ConsensusOptions struct {
+	Profiles []int           // Defaults to every profile
+	Method   ConsensusMethod // Defaults to ConsensusBorda
+
+	// Tracks with fewer comparisons in a profile count as unranked by it.
+	// A track is never counted before its first comparison.
+	MinComparisons int
+
+	Limit int // 0 = no limit
+}
+
+// ConsensusEntry is a track's place in the profiles' combined ranking.
+// Score is Borda points for ConsensusBorda and ConsensusKemeny (which
+// may order them differently), or a pooled rating on the Elo scale for
+// ConsensusBradleyTerry.
+type ConsensusEntry struct {
+	TrackID       int
+	Title         string
+	PrimaryArtist string
+
+	Score    float64
+	Profiles int // How many of the profiles have ranked the track
+}
+
+// CompareTaste correlates two profiles' rankings of the tracks both have
+// compared at least minComparisons times
+func CompareTaste(
+	db *sql.DB, profileA int, profileB int, minComparisons int,
+) (TasteMatch, error) {
+	a, b, common, err := commonRankings(db, profileA, profileB, minComparisons)
+	if err != nil {
+		return TasteMatch{}, err
+	}
+
+	return TasteMatch{
+		ProfileA: profileA,
+		ProfileB: profileB,
+		Common:   len(common),
+		Spearman: taste.Spearman(a, b),
+		Kendall:  taste.KendallTau(a, b),
+	}, nil
+}
+
+// Disagreements returns up to n of the tracks both profiles have compared
+// that they place furthest apart, biggest gap first (n = 0 for all)
+func Disagreements(
+	db *sql.DB, profileA int, profileB int, minComparisons int, n int,
+) ([]Disagreement, error) {
+	a, b, common, err := commonRankings(db, profileA, profileB, minComparisons)
+	if err != nil {
+		return nil, err
+	}
+
+	positionsA, positionsB := positions(a), positions(b)
+
+	disagreements := []Disagreement{}
+	for i, id := range common {
+		disagreements = append(disagreements, Disagreement{
+			TrackID:   id,
+			RankingA:  a[i],
+			RankingB:  b[i],
+			PositionA: positionsA[i],
+			PositionB: positionsB[i],
+		})
+	}
+
+	sort.SliceStable(disagreements, func(i, j int) bool {
+		return disagreements[i].Gap() > disagreements[j].Gap()
+	})
+
+	if n > 0 && len(disagreements) > n {
+		disagreements = disagreements[:n]
+	}
+
+	names, err := trackNames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range disagreements {
+		name := names[disagreements[i].TrackID]
+		disagreements[i].Title = name.title
+		disagreements[i].PrimaryArtist = name.primaryArtist
+	}
+
+	return disagreements, nil
+}
+
+// ConsensusRanking combines several profiles' track rankings into one,
+// best first. Borda and Kemeny only consider tracks every profile has
+// ranked; Bradley-Terry pools all the profiles' comparisons, so includes
+// tracks any of them have ranked.
+func ConsensusRanking(
+	db *sql.DB, opts ConsensusOptions,
+) ([]ConsensusEntry, error) {
+	method := opts.Method
+	if method == "" {
+		method = ConsensusBorda
+	}
+
+	switch method {
+	case ConsensusBorda, ConsensusKemeny, ConsensusBradleyTerry:
+	default:
+		return nil, fmt.Errorf("Unknown consensus method '%s'", method)
+	}
+
+	profiles := opts.Profiles
+	if len(profiles) == 0 {
+		all, err := Profiles(db)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range all {
+			profiles = append(profiles, p.ID)
+		}
+	}
+
+	rankings := make([]map[int]float64, len(profiles))
+	for i, profile := range profiles {
+		var err error
+		if rankings[i], err = profileTrackRankings(
+			db, profile, opts.MinComparisons,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []ConsensusEntry
+	var err error
+
+	if method == ConsensusBradleyTerry {
+		entries, err = bradleyTerryConsensus(db, profiles, rankings)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		entries = ballotConsensus(method, rankings)
+	}
+
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+	}
+
+	names, err := trackNames(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		name := names[entries[i].TrackID]
+		entries[i].Title = name.title
+		entries[i].PrimaryArtist = name.primaryArtist
+	}
+
+	return entries, nil
+}
+
+// Borda or Kemeny, with each profile's rankings of the tracks all have
+// ranked as a ballot
+func ballotConsensus(
+	method ConsensusMethod, rankings []map[int]float64,
+) []ConsensusEntry {
+	common := []int{}
+	for id := range rankings[0] {
+		inAll := true
+		for _, r := range rankings[1:] {
+			if _, ok := r[id]; !ok {
+				inAll = false
+				break
+			}
+		}
+
+		if inAll {
+			common = append(common, id)
+		}
+	}
+	sort.Ints(common)
+
+	ballots := make([][]float64, len(rankings))
+	for i, r := range rankings {
+		ballots[i] = make([]float64, len(common))
+		for j, id := range common {
+			ballots[i][j] = r[id]
+		}
+	}
+
+	points := taste.Borda(ballots)
+
+	var order []int
+	if method == ConsensusKemeny {
+		order = taste.Kemeny(ballots)
+	} else {
+		order = make([]int, len(common))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return points[order[i]] > points[order[j]]
+		})
+	}
+
+	entries := []ConsensusEntry{}
+	for _, i := range order {
+		entries = append(entries, ConsensusEntry{
+			TrackID:  common[i],
+			Score:    points[i],
+			Profiles: len(rankings),
+		})
+	}
+
+	return entries
+}
+
+// Fits one Bradley-Terry model to all the profiles' track comparisons.
+// Orderings of several tracks count as every pairwise result in them.
+func bradleyTerryConsensus(
+	db *sql.DB, profiles []int, rankings []map[int]float64,
+) ([]ConsensusEntry, error) {
+	config, err := LoadEloConfig(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Track ID -> index in the model
+	indexes := map[int]int{}
+	index := func(id int) int {
+		i, ok := indexes[id]
+		if !ok {
+			i = len(indexes)
+			indexes[id] = i
+		}
+		return i
+	}
+
+	type result struct {
+		index int
+		score float64
+		place sql.NullInt64
+	}
+
+	var games []taste.Game
+
+	addGames := func(results []result) {
+		placed := true
+		for _, r := range results {
+			placed = placed && r.place.Valid
+		}
+
+		switch {
+		case placed:
+			for i, a := range results {
+				for _, b := range results[i+1:] {
+					games = append(games, taste.Game{
+						A:     a.index,
+						B:     b.index,
+						Score: elo.PairwiseScore(int(a.place.Int64), int(b.place.Int64)),
+					})
+				}
+			}
+		case len(results) == 2:
+			games = append(games, taste.Game{
+				A:     results[0].index,
+				B:     results[1].index,
+				Score: results[0].score,
+			})
+		}
+	}
+
+	args := []interface{}{EntityTrack}
+	for _, profile := range profiles {
+		args = append(args, profile)
+	}
+
+	var comparisonID int64
+	var results []result
+
+	err = queryEach(db,
+		`SELECT cr.comparison_id, cr.entity_id, IFNULL(cr.score, 0.5), cr.place
+		   FROM comparison_results cr
+		   JOIN comparisons        c  ON c.id = cr.comparison_id
+		  WHERE c.entity = ?
+		    AND c.profile_id IN (?`+strings.Repeat(",?", len(profiles)-1)+`)
+		  ORDER BY cr.comparison_id, cr.rowid`,
+		func(rows *sql.Rows) error {
+			var id int64
+			var trackID int
+			var r result
+
+			if err := rows.Scan(&id, &trackID, &r.score, &r.place); err != nil {
+				return err
+			}
+
+			if id != comparisonID {
+				addGames(results)
+				comparisonID, results = id, nil
+			}
+
+			r.index = index(trackID)
+			results = append(results, r)
+			return nil
+		},
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	addGames(results)
+
+	ratings := taste.BradleyTerry(len(indexes), games)
+
+	// Tracks ranked by any of the profiles
+	ranked := map[int]int{}
+	for _, r := range rankings {
+		for id := range r {
+			ranked[id]++
+		}
+	}
+
+	entries := []ConsensusEntry{}
+	for id, n := range ranked {
+		score := config.StartingRating
+		if i, ok := indexes[id]; ok {
+			score += ratings[i]
+		}
+
+		entries = append(entries, ConsensusEntry{
+			TrackID:  id,
+			Score:    score,
+			Profiles: n,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].TrackID < entries[j].TrackID
+	})
+
+	return entries, nil
+}
+
+// The rankings of the tracks both profiles have compared, in track ID
+// order
+func commonRankings(
+	db *sql.DB, profileA int, profileB int, minComparisons int,
+) ([]float64, []float64, []int, error) {
+	rankingsA, err := profileTrackRankings(db, profileA, minComparisons)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	rankingsB, err := profileTrackRankings(db, profileB, minComparisons)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	common := []int{}
+	for id := range rankingsA {
+		if _, ok := rankingsB[id]; ok {
+			common = append(common, id)
+		}
+	}
+	sort.Ints(common)
+
+	a := make([]float64, len(common))
+	b := make([]float64, len(common))
+	for i, id := range common {
+		a[i], b[i] = rankingsA[id], rankingsB[id]
+	}
+
+	return a, b, common, nil
+}
+
+// Track ID -> ranking, for the tracks the profile has compared at least
+// minComparisons times
+func profileTrackRankings(
+	db querier, profile int, minComparisons int,
+) (map[int]float64, error) {
+	if minComparisons < 1 {
+		minComparisons = 1
+	}
+
+	rankings := map[int]float64{}
+
+	err := queryEach(db,
+		`SELECT entity_id, ranking
+		   FROM rankings
+		  WHERE profile_id   = ?
+		    AND entity       = ?
+		    AND comparisons >= ?`,
+		func(rows *sql.Rows) error {
+			var id int
+			var ranking float64
+			if err := rows.Scan(&id, &ranking); err != nil {
+				return err
+			}
+
+			rankings[id] = ranking
+			return nil
+		},
+		profile,
+		EntityTrack,
+		minComparisons,
+	)
+
+	return rankings, err
+}
+
+// Positions of the rankings when sorted, 1 = highest. Ties are broken
+// by order in the slice.
+func positions(rankings []float64) []int {
+	order := make([]int, len(rankings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return rankings[order[i]] > rankings[order[j]]
+	})
+
+	positions := make([]int, len(rankings))
+	for position, i := range order {
+		positions[i] = position + 1
+	}
+
+	return positions
+}
+
+type trackName struct {
+	title         string
+	primaryArtist string
+}
+
+func trackNames(db querier) (map[int]trackName, error) {
+	names := map[int]trackName{}
+
+	err := queryEach(db,
+		`SELECT t.id,
+		        IFNULL(t.title, ''),
+		        IFNULL((SELECT ar.name
+		                  FROM track_artist tar
+		                  JOIN artists      ar  ON ar.id = tar.artist_id
+		                 WHERE tar.track_id = t.id
+		                   AND tar.is_primary_artist = 1), '')
+		   FROM tracks t`,
+		func(rows *sql.Rows) error {
+			var id int
+			var name trackName
+			if err := rows.Scan(&id, &name.title, &name.primaryArtist); err != nil {
+				return err
+			}
+
+			names[id] = name
+			return nil
+		},
+	)
+
+	return names, err
+}
@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nephila-nacrea/rank-my-music/test_utils"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestTaste(t *testing.T) {
+	db := test_utils.DBSetup()
+
+	SaveTracks(db, []track.Track{
+		track.New(track.Track{
+			MusicBrainzID: "MB1",
+			Title:         "Title 1",
+			PrimaryArtist: track.Artist{Name: "Artist 1"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB2",
+			Title:         "Title 2",
+			PrimaryArtist: track.Artist{Name: "Artist 2"},
+		}),
+		track.New(track.Track{
+			MusicBrainzID: "MB3",
+			Title:         "Title 3",
+			PrimaryArtist: track.Artist{Name: "Artist 3"},
+		}),
+		// Only compared by Alex
+		track.New(track.Track{
+			MusicBrainzID: "MB4",
+			Title:         "Title 4",
+			PrimaryArtist: track.Artist{Name: "Artist 4"},
+		}),
+	})
+
+	sam, err := CreateProfile(db, "Sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alex, err := CreateProfile(db, "Alex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for profile, order := range map[int][]int{
+		DefaultProfile: {1, 2, 3},
+		sam.ID:         {3, 2, 1},
+		alex.ID:        {1, 2, 3},
+	} {
+		if _, err = RecordRankedComparison(db, profile, EntityTrack, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = RecordComparison(db, alex.ID, EntityTrack, 1, 4, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Correlation")
+
+	for _, test := range []struct {
+		a        int
+		b        int
+		min      int
+		expected TasteMatch
+	}{
+		{DefaultProfile, alex.ID, 0, TasteMatch{DefaultProfile, alex.ID, 3, 1, 1}},
+		{DefaultProfile, sam.ID, 0, TasteMatch{DefaultProfile, sam.ID, 3, -1, -1}},
+		{DefaultProfile, alex.ID, 2, TasteMatch{DefaultProfile, alex.ID, 0, 0, 0}},
+	} {
+		got, err := CompareTaste(db, test.a, test.b, test.min)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.expected {
+			t.Errorf("\nExpected:\n%#v\ngot:\n%#v", test.expected, got)
+		}
+	}
+
+	t.Log("Disagreements")
+
+	disagreements, err := Disagreements(db, DefaultProfile, sam.ID, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotIDs := []int{}
+	for _, d := range disagreements {
+		gotIDs = append(gotIDs, d.TrackID)
+		if d.Gap() != 2 {
+			t.Errorf("Track %d: expected gap 2, got %+v", d.TrackID, d)
+		}
+	}
+	if !reflect.DeepEqual([]int{1, 3}, gotIDs) {
+		t.Errorf("Expected tracks 1 and 3, got %v", gotIDs)
+	}
+	if disagreements[0].Title != "Title 1" ||
+		disagreements[0].PrimaryArtist != "Artist 1" ||
+		disagreements[0].PositionA != 1 || disagreements[0].PositionB != 3 {
+		t.Errorf("Expected Title 1 at 1 and 3, got %+v", disagreements[0])
+	}
+
+	t.Log("Consensus")
+
+	for _, test := range []struct {
+		opts        ConsensusOptions
+		expectedIDs []int
+	}{
+		{ConsensusOptions{}, []int{1, 2, 3}},
+		{ConsensusOptions{Method: ConsensusKemeny}, []int{1, 2, 3}},
+		{ConsensusOptions{Profiles: []int{sam.ID}}, []int{3, 2, 1}},
+		{ConsensusOptions{Limit: 1}, []int{1}},
+	} {
+		entries, err := ConsensusRanking(db, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gotIDs := []int{}
+		for _, e := range entries {
+			gotIDs = append(gotIDs, e.TrackID)
+		}
+		if !reflect.DeepEqual(test.expectedIDs, gotIDs) {
+			t.Errorf("%+v: expected %v, got %v", test.opts, test.expectedIDs, gotIDs)
+		}
+	}
+
+	entries, err := ConsensusRanking(db, ConsensusOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ConsensusEntry{
+		TrackID:       1,
+		Title:         "Title 1",
+		PrimaryArtist: "Artist 1",
+		Score:         4,
+		Profiles:      3,
+	}
+	if entries[0] != expected {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, entries[0])
+	}
+
+	t.Log("Bradley-Terry includes tracks only some have ranked")
+
+	if entries, err = ConsensusRanking(
+		db, ConsensusOptions{Method: ConsensusBradleyTerry},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[0].TrackID != 1 || entries[0].Profiles != 3 {
+		t.Errorf("Expected 4 tracks, track 1 first, got %+v", entries)
+	}
+	for _, e := range entries {
+		if e.TrackID == 4 && e.Profiles != 1 {
+			t.Errorf("Expected track 4 ranked by 1 profile, got %d", e.Profiles)
+		}
+	}
+
+	if _, err = ConsensusRanking(
+		db, ConsensusOptions{Method: "dictator"},
+	); err == nil {
+		t.Error("Expected error for unknown method")
+	}
+}
@@ -0,0 +1,243 @@
+// Package scrobble reads listening history exported from Last.fm and
+// ListenBrainz
+package scrobble
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listen is one play of a track
+type Listen struct {
+	Artist        string
+	Album         string
+	Title         string
+	RecordingMBID string // MusicBrainz ID of the track, if known
+
+	ListenedAt time.Time
+}
+
+// Date formats used by the various Last.fm export tools
+var lastfmDateLayouts = []string{
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"02 Jan 2006, 15:04",
+	"2 Jan 2006, 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// Column names used by the various Last.fm export tools
+var lastfmColumns = map[string]string{
+	"artist":      "artist",
+	"artist_name": "artist",
+	"album":       "album",
+	"album_name":  "album",
+	"track":       "title",
+	"title":       "title",
+	"track_name":  "title",
+	"name":        "title",
+	"track_mbid":  "mbid",
+	"mbid":        "mbid",
+	"uts":         "uts",
+	"timestamp":   "uts",
+	"utc_time":    "date",
+	"date":        "date",
+}
+
+// ReadLastfmCSV reads a Last.fm scrobble export. With a header row the
+// columns are found by name (artist, album, track, track_mbid, uts or
+// utc_time, ...); without one they are artist, album, title, date.
+// Scrobbles with no date, i.e. tracks playing when the export was made,
+// are skipped.
+func ReadLastfmCSV(r io.Reader) ([]Listen, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{"artist": 0, "album": 1, "title": 2, "date": 3}
+
+	if len(records) > 0 {
+		header := map[string]int{}
+		for i, name := range records[0] {
+			name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+			if column, ok := lastfmColumns[name]; ok {
+				header[column] = i
+			}
+		}
+
+		_, hasArtist := header["artist"]
+		_, hasTitle := header["title"]
+		if hasArtist && hasTitle {
+			columns = header
+			records = records[1:]
+		}
+	}
+
+	field := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	listens := []Listen{}
+
+	for i, record := range records {
+		listen := Listen{
+			Artist:        field(record, "artist"),
+			Album:         field(record, "album"),
+			Title:         field(record, "title"),
+			RecordingMBID: field(record, "mbid"),
+		}
+
+		uts := field(record, "uts")
+		date := field(record, "date")
+
+		switch {
+		case uts != "":
+			seconds, err := strconv.ParseInt(uts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Row %d: Invalid timestamp '%s'", i+1, uts)
+			}
+			listen.ListenedAt = time.Unix(seconds, 0).UTC()
+		case date != "":
+			if listen.ListenedAt, err = parseLastfmDate(date); err != nil {
+				return nil, fmt.Errorf("Row %d: %w", i+1, err)
+			}
+		default:
+			continue
+		}
+
+		if listen.Artist == "" || listen.Title == "" {
+			return nil, fmt.Errorf("Row %d: Missing artist or title", i+1)
+		}
+
+		listens = append(listens, listen)
+	}
+
+	return listens, nil
+}
+
+func parseLastfmDate(date string) (time.Time, error) {
+	for _, layout := range lastfmDateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	if seconds, err := strconv.ParseInt(date, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("Unknown date format '%s'", date)
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64 `json:"listened_at"`
+	TrackMetadata struct {
+		ArtistName     string `json:"artist_name"`
+		ReleaseName    string `json:"release_name"`
+		TrackName      string `json:"track_name"`
+		AdditionalInfo struct {
+			RecordingMBID string `json:"recording_mbid"`
+		} `json:"additional_info"`
+		MBIDMapping *struct {
+			RecordingMBID string `json:"recording_mbid"`
+		} `json:"mbid_mapping"`
+	} `json:"track_metadata"`
+}
+
+// ReadListenBrainz reads a ListenBrainz listens export: either a JSON
+// array of listens or one listen per line. The MBID ListenBrainz mapped
+// a listen to is preferred over the one it was submitted with.
+func ReadListenBrainz(r io.Reader) ([]Listen, error) {
+	buffered := bufio.NewReader(r)
+
+	var raw []listenBrainzListen
+
+	first, err := firstNonSpace(buffered)
+	if err == io.EOF {
+		return []Listen{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first == '[' {
+		if err = json.NewDecoder(buffered).Decode(&raw); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := json.NewDecoder(buffered)
+		for {
+			var l listenBrainzListen
+			err = decoder.Decode(&l)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Listen %d: %w", len(raw)+1, err)
+			}
+
+			raw = append(raw, l)
+		}
+	}
+
+	listens := []Listen{}
+
+	for i, l := range raw {
+		m := l.TrackMetadata
+
+		if l.ListenedAt <= 0 || m.ArtistName == "" || m.TrackName == "" {
+			return nil, fmt.Errorf(
+				"Listen %d: Missing listened_at, artist_name or track_name", i+1,
+			)
+		}
+
+		mbid := m.AdditionalInfo.RecordingMBID
+		if m.MBIDMapping != nil && m.MBIDMapping.RecordingMBID != "" {
+			mbid = m.MBIDMapping.RecordingMBID
+		}
+
+		listens = append(listens, Listen{
+			Artist:        m.ArtistName,
+			Album:         m.ReleaseName,
+			Title:         m.TrackName,
+			RecordingMBID: mbid,
+			ListenedAt:    time.Unix(l.ListenedAt, 0).UTC(),
+		})
+	}
+
+	return listens, nil
+}
+
+// Peeks at the first byte that isn't white space, leaving it unread
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(bytes.TrimSpace(b)) > 0 {
+			return b[0], nil
+		}
+
+		if _, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package scrobble
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLastfmCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		expected []Listen
+	}{
+		{
+			"No header",
+			`Artist 1,Album 1,Title 1,02 Mar 2021 10:15
+"Artist, 2",,Title 2,"3 Mar 2021, 11:00"
+Artist 3,Album 3,Now playing,
+`,
+			[]Listen{
+				{
+					Artist:     "Artist 1",
+					Album:      "Album 1",
+					Title:      "Title 1",
+					ListenedAt: time.Date(2021, 3, 2, 10, 15, 0, 0, time.UTC),
+				},
+				{
+					Artist:     "Artist, 2",
+					Title:      "Title 2",
+					ListenedAt: time.Date(2021, 3, 3, 11, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			"Header with Unix times",
+			"\ufeffuts,utc_time,artist,artist_mbid,album,album_mbid,track,track_mbid\n" +
+				"1614680100,02 Mar 2021 10:15,Artist 1,A1,Album 1,AL1,Title 1,MB1\n",
+			[]Listen{{
+				Artist:        "Artist 1",
+				Album:         "Album 1",
+				Title:         "Title 1",
+				RecordingMBID: "MB1",
+				ListenedAt:    time.Date(2021, 3, 2, 10, 15, 0, 0, time.UTC),
+			}},
+		},
+		{
+			"Header with dates",
+			"Artist,Track,Date\nArtist 1,Title 1,2021-03-02 10:15:00\n",
+			[]Listen{{
+				Artist:     "Artist 1",
+				Title:      "Title 1",
+				ListenedAt: time.Date(2021, 3, 2, 10, 15, 0, 0, time.UTC),
+			}},
+		},
+		{
+			"Empty",
+			"",
+			[]Listen{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Log(test.name)
+
+		listens, err := ReadLastfmCSV(strings.NewReader(test.csv))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(test.expected, listens) {
+			t.Errorf("\nExpected:\n%#v\ngot:\n%#v", test.expected, listens)
+		}
+	}
+
+	t.Log("Invalid rows")
+
+	for _, csv := range []string{
+		"Artist 1,Album 1,Title 1,yesterday\n",
+		"uts,artist,track\nnoon,Artist 1,Title 1\n",
+		",Album 1,Title 1,02 Mar 2021 10:15\n",
+	} {
+		if _, err := ReadLastfmCSV(strings.NewReader(csv)); err == nil {
+			t.Errorf("Expected error reading %q", csv)
+		}
+	}
+}
+
+func TestReadListenBrainz(t *testing.T) {
+	expected := []Listen{
+		{
+			Artist:        "Artist 1",
+			Album:         "Album 1",
+			Title:         "Title 1",
+			RecordingMBID: "MB1-mapped",
+			ListenedAt:    time.Date(2021, 3, 2, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			Artist:        "Artist 2",
+			Title:         "Title 2",
+			RecordingMBID: "MB2",
+			ListenedAt:    time.Date(2021, 3, 3, 11, 0, 0, 0, time.UTC),
+		},
+	}
+
+	first := `{
+		"listened_at": 1614680100,
+		"track_metadata": {
+			"artist_name": "Artist 1",
+			"release_name": "Album 1",
+			"track_name": "Title 1",
+			"additional_info": {"recording_mbid": "MB1"},
+			"mbid_mapping": {"recording_mbid": "MB1-mapped"}
+		}
+	}`
+	second := `{
+		"listened_at": 1614769200,
+		"track_metadata": {
+			"artist_name": "Artist 2",
+			"track_name": "Title 2",
+			"additional_info": {"recording_mbid": "MB2"},
+			"mbid_mapping": null
+		}
+	}`
+
+	for name, input := range map[string]string{
+		"JSON array": "\n  [" + first + "," + second + "]",
+		"JSON Lines": strings.ReplaceAll(first, "\n", "") + "\n" +
+			strings.ReplaceAll(second, "\n", "") + "\n",
+	} {
+		t.Log(name)
+
+		listens, err := ReadListenBrainz(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(expected, listens) {
+			t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, listens)
+		}
+	}
+
+	t.Log("Empty")
+
+	listens, err := ReadListenBrainz(strings.NewReader("  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 0 {
+		t.Errorf("Expected no listens, got %#v", listens)
+	}
+
+	t.Log("Invalid listens")
+
+	for _, input := range []string{
+		`[{"listened_at": 1614680100}]`,
+		`{"listened_at": 1614680100, "track_metadata": {"artist_name": "A"}}`,
+		`{"listened_at": `,
+	} {
+		if _, err := ReadListenBrainz(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error reading %s", input)
+		}
+	}
+}
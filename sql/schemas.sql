@@ -1,12 +1,23 @@
 PRAGMA foreign_keys = ON;
 
+-- Number of migrations in repo/migrate.go this schema already includes
+PRAGMA user_version = 6;
+
 CREATE TABLE tracks (
     id INTEGER PRIMARY KEY,
     musicbrainz_id TEXT,
     title,
-    ranking
+    genre TEXT,
+    year INTEGER,
+    -- Starting ranking from star ratings or play counts already in the
+    -- file's tags, and the tag it came from. Replays start from it unless
+    -- the track has been rated or starred by hand.
+    prior REAL,
+    prior_source TEXT
 );
 
+-- Artists and albums can be ranked head-to-head as well as through their
+-- tracks
 CREATE TABLE artists (
     id INTEGER PRIMARY KEY,
     musicbrainz_id TEXT,
@@ -19,6 +30,32 @@ CREATE TABLE albums (
     title
 );
 
+-- Everyone sharing the library ranks it separately. Profile 1 is the
+-- default, which rankings from before profiles belong to.
+CREATE TABLE profiles (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    -- Password Subsonic clients log in to the profile with; NULL if they
+    -- can't. Kept as is, as clients send salted hashes of it.
+    subsonic_password TEXT
+);
+
+INSERT INTO profiles (id, name) VALUES (1, 'default');
+
+-- Each profile's rankings. 'entity_id' refers to the table given by
+-- 'entity', as for comparisons. There is only a row once the
+-- track/album/artist has been compared; until then it is ranked by its
+-- prior, if any, and albums and artists without one are unranked.
+CREATE TABLE rankings (
+    profile_id INTEGER NOT NULL,
+    entity TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    ranking REAL NOT NULL,
+    -- Number of comparisons taken part in
+    comparisons INTEGER NOT NULL,
+    PRIMARY KEY (profile_id, entity, entity_id)
+);
+
 -- A track may have multiple artists
 CREATE TABLE track_artist (
     track_id,
@@ -37,3 +74,229 @@ CREATE TABLE track_album (
     FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE,
     FOREIGN KEY(album_id) REFERENCES albums(id) ON DELETE CASCADE
 );
+
+-- Audio files for each track. A track can have several, e.g. the same
+-- recording ripped from two albums.
+CREATE TABLE track_files (
+    path TEXT PRIMARY KEY, -- Absolute
+    track_id NOT NULL,
+    FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+-- Plays imported from Last.fm and ListenBrainz exports, per profile. A
+-- track can't be played twice in the same second, so importing the same
+-- plays again adds nothing.
+CREATE TABLE listens (
+    profile_id INTEGER NOT NULL DEFAULT 1,
+    track_id NOT NULL,
+    listened_at INTEGER NOT NULL, -- Unix time
+    source TEXT NOT NULL, -- 'lastfm' or 'listenbrainz'
+    PRIMARY KEY (profile_id, track_id, listened_at),
+    FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+-- Star ratings and stars set by hand in a profile, e.g. from a Subsonic
+-- client. The rating, or the star if there is none, is used as the
+-- prior in that profile, for tracks in place of the one from the tags.
+-- Tracks the profile has listened to can also have a prior from their
+-- play count, which beats play counts in the tags but not star ratings.
+CREATE TABLE annotations (
+    profile_id INTEGER NOT NULL DEFAULT 1,
+    entity TEXT NOT NULL, -- 'track', 'album' or 'artist'
+    entity_id INTEGER NOT NULL,
+    rating INTEGER, -- 1-5 stars; NULL if not rated
+    starred_at TEXT, -- NULL if not starred
+    prior REAL, -- From the rating or star
+    listen_prior REAL, -- Tracks only
+    PRIMARY KEY (profile_id, entity, entity_id)
+);
+
+-- Match history. 'entity' says whether the comparison was between tracks,
+-- albums or artists.
+CREATE TABLE comparisons (
+    id INTEGER PRIMARY KEY,
+    profile_id INTEGER NOT NULL DEFAULT 1,
+    entity TEXT NOT NULL,
+    -- Graded input, 0 = strongest preference for the second entity,
+    -- 1 = strongest for the first. NULL if given as a plain score.
+    preference REAL,
+    -- Margin-of-victory multiplier applied to K
+    multiplier REAL NOT NULL DEFAULT 1,
+    -- 'vote', or for comparisons inferred from listening, what inferred
+    -- them, e.g. 'mpd'
+    source TEXT NOT NULL DEFAULT 'vote',
+    created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per track/album/artist taking part in a comparison. 'entity_id'
+-- refers to the table given by comparisons.entity.
+CREATE TABLE comparison_results (
+    comparison_id,
+    entity_id,
+    -- 0 = loss, 0.5 = draw, 1 = win. For orderings, the share of the
+    -- others placed below this entity.
+    score REAL,
+    -- Place in an ordering of several entities, 1 = best. NULL when the
+    -- score was given directly.
+    place INTEGER,
+    k REAL, -- K-factor applied, before comparisons.multiplier
+    ranking_before REAL,
+    ranking_after REAL,
+    PRIMARY KEY (comparison_id, entity_id),
+    FOREIGN KEY(comparison_id) REFERENCES comparisons(id) ON DELETE CASCADE
+);
+
+-- Point-in-time copies of track rankings, so we can see how they change.
+-- Taken per ranking session or per day.
+CREATE TABLE snapshots (
+    id INTEGER PRIMARY KEY,
+    profile_id INTEGER NOT NULL DEFAULT 1,
+    taken_at TEXT NOT NULL, -- UTC, 'YYYY-MM-DD HH:MM:SS'
+    label TEXT
+);
+
+-- Only tracks that have been compared are included
+CREATE TABLE snapshot_rankings (
+    snapshot_id,
+    track_id,
+    ranking REAL,
+    position INTEGER, -- 1 = top ranked at the time
+    PRIMARY KEY (snapshot_id, track_id),
+    FOREIGN KEY(snapshot_id) REFERENCES snapshots(id) ON DELETE CASCADE,
+    FOREIGN KEY(track_id) REFERENCES tracks(id) ON DELETE CASCADE
+);
+
+-- Key-value settings, e.g. the Elo config as JSON
+CREATE TABLE settings (
+    key TEXT PRIMARY KEY,
+    value TEXT
+);
+
+-- Full-text search. One index per entity, with rowid = the entity's id,
+-- kept in sync by the triggers below. Matching ignores case and
+-- diacritics; the prefix indexes make prefix queries fast.
+CREATE VIRTUAL TABLE tracks_search USING fts5 (
+    title,
+    artists,
+    albums,
+    tokenize = "unicode61 remove_diacritics 2",
+    prefix = '2 3'
+);
+
+CREATE VIRTUAL TABLE albums_search USING fts5 (
+    title,
+    tokenize = "unicode61 remove_diacritics 2",
+    prefix = '2 3'
+);
+
+CREATE VIRTUAL TABLE artists_search USING fts5 (
+    name,
+    tokenize = "unicode61 remove_diacritics 2",
+    prefix = '2 3'
+);
+
+-- What is indexed for each track
+CREATE VIEW tracks_search_source AS
+SELECT t.id,
+       t.title,
+       (SELECT GROUP_CONCAT(ar.name, ' ')
+          FROM track_artist tar
+          JOIN artists      ar  ON ar.id = tar.artist_id
+         WHERE tar.track_id = t.id) AS artists,
+       (SELECT GROUP_CONCAT(al.title, ' ')
+          FROM track_album tal
+          JOIN albums      al  ON al.id = tal.album_id
+         WHERE tal.track_id = t.id) AS albums
+  FROM tracks t;
+
+CREATE TRIGGER tracks_search_insert AFTER INSERT ON tracks BEGIN
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER tracks_search_update AFTER UPDATE OF title ON tracks BEGIN
+    DELETE FROM tracks_search WHERE rowid = OLD.id;
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER tracks_search_delete AFTER DELETE ON tracks BEGIN
+    DELETE FROM tracks_search WHERE rowid = OLD.id;
+END;
+
+-- A track's artists and albums are part of its entry
+CREATE TRIGGER track_artist_search_insert AFTER INSERT ON track_artist BEGIN
+    DELETE FROM tracks_search WHERE rowid = NEW.track_id;
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id = NEW.track_id;
+END;
+
+CREATE TRIGGER track_artist_search_delete AFTER DELETE ON track_artist BEGIN
+    DELETE FROM tracks_search WHERE rowid = OLD.track_id;
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id = OLD.track_id;
+END;
+
+CREATE TRIGGER track_album_search_insert AFTER INSERT ON track_album BEGIN
+    DELETE FROM tracks_search WHERE rowid = NEW.track_id;
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id = NEW.track_id;
+END;
+
+CREATE TRIGGER track_album_search_delete AFTER DELETE ON track_album BEGIN
+    DELETE FROM tracks_search WHERE rowid = OLD.track_id;
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id = OLD.track_id;
+END;
+
+CREATE TRIGGER albums_search_insert AFTER INSERT ON albums BEGIN
+    INSERT INTO albums_search (rowid, title) VALUES (NEW.id, NEW.title);
+END;
+
+CREATE TRIGGER albums_search_update AFTER UPDATE OF title ON albums BEGIN
+    DELETE FROM albums_search WHERE rowid = OLD.id;
+    INSERT INTO albums_search (rowid, title) VALUES (NEW.id, NEW.title);
+
+    DELETE FROM tracks_search
+     WHERE rowid IN (SELECT track_id FROM track_album WHERE album_id = NEW.id);
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id IN (SELECT track_id FROM track_album WHERE album_id = NEW.id);
+END;
+
+CREATE TRIGGER albums_search_delete AFTER DELETE ON albums BEGIN
+    DELETE FROM albums_search WHERE rowid = OLD.id;
+END;
+
+CREATE TRIGGER artists_search_insert AFTER INSERT ON artists BEGIN
+    INSERT INTO artists_search (rowid, name) VALUES (NEW.id, NEW.name);
+END;
+
+CREATE TRIGGER artists_search_update AFTER UPDATE OF name ON artists BEGIN
+    DELETE FROM artists_search WHERE rowid = OLD.id;
+    INSERT INTO artists_search (rowid, name) VALUES (NEW.id, NEW.name);
+
+    DELETE FROM tracks_search
+     WHERE rowid IN (SELECT track_id FROM track_artist WHERE artist_id = NEW.id);
+    INSERT INTO tracks_search (rowid, title, artists, albums)
+    SELECT id, title, artists, albums
+      FROM tracks_search_source
+     WHERE id IN (SELECT track_id FROM track_artist WHERE artist_id = NEW.id);
+END;
+
+CREATE TRIGGER artists_search_delete AFTER DELETE ON artists BEGIN
+    DELETE FROM artists_search WHERE rowid = OLD.id;
+END;
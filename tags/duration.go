@@ -0,0 +1,231 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// How far past the ID3 tag to look for the first MP3 frame
+const mp3SyncSearch = 64 * 1024
+
+// MPEG audio layer III bitrates in kbit/s, by bitrate index, for MPEG 1
+// and for MPEG 2 and 2.5
+var mp3Bitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// Sample rates by MPEG version bits (0 = 2.5, 2 = 2, 3 = 1) and index
+var mp3SampleRates = map[byte][3]int{
+	0: {11025, 12000, 8000},
+	2: {22050, 24000, 16000},
+	3: {44100, 48000, 32000},
+}
+
+// Duration reads how long the audio in path lasts:
+//   - MP3: from the frame count in a Xing, Info or VBRI header, or for
+//     constant bitrate files without one, from the size and bitrate
+//   - FLAC: from the sample count in STREAMINFO
+//   - MP4/M4A: from the movie header
+func Duration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	format, err := sniff(file, path)
+	if err != nil {
+		return 0, err
+	}
+
+	var d time.Duration
+	switch format {
+	case "id3":
+		d, err = mp3Duration(file, info.Size())
+	case "flac":
+		d, err = flacDuration(file)
+	case "mp4":
+		d, err = mp4Duration(file, info.Size())
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return d, nil
+}
+
+func flacDuration(r io.ReaderAt) (time.Duration, error) {
+	// Block header, then min/max block and frame sizes before the rate
+	info := make([]byte, 4+18)
+	if _, err := r.ReadAt(info, 4); err != nil {
+		return 0, fmt.Errorf("Invalid FLAC metadata: %w", err)
+	}
+	if info[0]&0x7f != 0 {
+		return 0, fmt.Errorf("FLAC file does not start with STREAMINFO")
+	}
+
+	// 20 bits of sample rate, 3 of channels, 5 of bits per sample, then
+	// 36 of total samples
+	b := info[4+10:]
+	sampleRate := int64(b[0])<<12 | int64(b[1])<<4 | int64(b[2])>>4
+	samples := int64(b[3]&0x0f)<<32 | int64(binary.BigEndian.Uint32(b[4:8]))
+
+	if sampleRate == 0 || samples == 0 {
+		return 0, fmt.Errorf("FLAC length unknown")
+	}
+
+	return time.Duration(samples * int64(time.Second) / sampleRate), nil
+}
+
+func mp4Duration(r io.ReaderAt, size int64) (time.Duration, error) {
+	for offset := int64(0); offset < size; {
+		kind, length, err := mp4AtomHeader(r, offset, size)
+		if err != nil {
+			return 0, err
+		}
+
+		if kind != "moov" {
+			offset += length
+			continue
+		}
+
+		raw := make([]byte, length)
+		if _, err = r.ReadAt(raw, offset); err != nil {
+			return 0, err
+		}
+
+		moov, err := parseAtom(raw)
+		if err != nil {
+			return 0, err
+		}
+
+		mvhd := moov.find("mvhd")
+		if mvhd == nil {
+			break
+		}
+
+		// Version and flags, then creation and modification times
+		// before the time scale and duration; 64-bit in version 1
+		var timescale, duration uint64
+		switch data := mvhd.data; {
+		case len(data) >= 20 && data[0] == 0:
+			timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+			duration = uint64(binary.BigEndian.Uint32(data[16:]))
+		case len(data) >= 32 && data[0] == 1:
+			timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+			duration = binary.BigEndian.Uint64(data[24:])
+		}
+
+		if timescale == 0 || duration == 0 {
+			return 0, fmt.Errorf("MP4 length unknown")
+		}
+
+		return time.Duration(
+			float64(duration) / float64(timescale) * float64(time.Second),
+		), nil
+	}
+
+	return 0, fmt.Errorf("No MP4 movie header")
+}
+
+func mp3Duration(r io.ReaderAt, size int64) (time.Duration, error) {
+	start, err := id3TagSize(r)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, mp3SyncSearch)
+	n, err := r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+
+		version := buf[i+1] >> 3 & 3
+		layer := buf[i+1] >> 1 & 3
+		bitrateIndex := buf[i+2] >> 4
+		rateIndex := buf[i+2] >> 2 & 3
+		mono := buf[i+3]>>6 == 3
+
+		// Layer III only, and not a reserved or free bitrate or rate
+		if version == 1 || layer != 1 ||
+			bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		mpeg1 := version == 3
+		sampleRate := mp3SampleRates[version][rateIndex]
+
+		table, samplesPerFrame, sideInfo := 1, 576, 17
+		if mpeg1 {
+			table, samplesPerFrame, sideInfo = 0, 1152, 32
+		}
+		if mono {
+			if mpeg1 {
+				sideInfo = 17
+			} else {
+				sideInfo = 9
+			}
+		}
+
+		frame := buf[i:]
+		frames := mp3FrameCount(frame, 4+sideInfo)
+
+		if frames > 0 {
+			return time.Duration(
+				int64(frames) * int64(samplesPerFrame) * int64(time.Second) /
+					int64(sampleRate),
+			), nil
+		}
+
+		// Constant bitrate: everything from the first frame to any ID3v1
+		// tag at the end is audio
+		audioBytes := size - start - int64(i)
+		tag := make([]byte, 3)
+		if _, err := r.ReadAt(tag, size-128); err == nil &&
+			string(tag) == "TAG" {
+			audioBytes -= 128
+		}
+
+		bitrate := int64(mp3Bitrates[table][bitrateIndex]) * 1000
+
+		return time.Duration(audioBytes * 8 * int64(time.Second) / bitrate), nil
+	}
+
+	return 0, fmt.Errorf("No MP3 frames found")
+}
+
+// Frames in the stream from a Xing/Info header after the side info, or
+// a VBRI header 32 bytes into the frame. 0 if there's neither.
+func mp3FrameCount(frame []byte, xingOffset int) int {
+	if len(frame) >= xingOffset+12 {
+		id := frame[xingOffset : xingOffset+4]
+		flags := binary.BigEndian.Uint32(frame[xingOffset+4:])
+
+		if (bytes.Equal(id, []byte("Xing")) || bytes.Equal(id, []byte("Info"))) &&
+			flags&1 != 0 {
+			return int(binary.BigEndian.Uint32(frame[xingOffset+8:]))
+		}
+	}
+
+	if len(frame) >= 36+18 && bytes.Equal(frame[36:40], []byte("VBRI")) {
+		return int(binary.BigEndian.Uint32(frame[36+14:]))
+	}
+
+	return 0
+}
@@ -0,0 +1,77 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	// MPEG 1 layer III, 128 kbit/s, 44.1 kHz, joint stereo
+	frameHeader := []byte{0xff, 0xfb, 0x90, 0x40}
+
+	// Xing header after the 32 bytes of side info, saying there are 383
+	// frames of 1152 samples
+	xing := append(append([]byte{}, frameHeader...), make([]byte, 32)...)
+	xing = append(xing, "Xing\x00\x00\x00\x01\x00\x00\x01\x7f"...)
+
+	// The ID3 tag is skipped
+	id3 := append([]byte("ID3\x03\x00\x00\x00\x00\x00\x0a"), make([]byte, 10)...)
+
+	// 160,000 bytes at 128 kbit/s
+	cbr := append(append([]byte{}, frameHeader...), make([]byte, 160000-4)...)
+
+	// ID3v1 tag at the end isn't audio
+	v1 := append(append([]byte{}, cbr...), append([]byte("TAG"), make([]byte, 125)...)...)
+
+	streamInfo := make([]byte, 34)
+	// 44.1 kHz, 2 channels, 16 bits, 7,938,000 samples
+	streamInfo[10], streamInfo[11], streamInfo[12] = 0x0a, 0xc4, 0x42
+	streamInfo[13] = 0xf0
+	binary.BigEndian.PutUint32(streamInfo[14:], 7938000)
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), streamInfo...)
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 200500)
+	m4a := append(
+		box("ftyp", []byte("M4A \x00\x00\x00\x00M4A ")),
+		box("moov", box("mvhd", mvhd))...,
+	)
+
+	for _, test := range []struct {
+		name     string
+		contents []byte
+		expected time.Duration
+	}{
+		{"vbr.mp3", append(append(id3, xing...), make([]byte, 1000)...), 10004897959},
+		{"cbr.mp3", cbr, 10 * time.Second},
+		{"id3v1.mp3", v1, 10 * time.Second},
+		{"track.flac", flac, 180 * time.Second},
+		{"track.m4a", m4a, 200500 * time.Millisecond},
+	} {
+		path := writeFile(t, test.name, test.contents)
+
+		got, err := Duration(path)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+
+	t.Log("Unknown")
+
+	for name, contents := range map[string][]byte{
+		"silence.mp3": bytes.Repeat([]byte{0}, 1000),
+		"empty.flac":  append([]byte("fLaC\x80\x00\x00\x22"), make([]byte, 34)...),
+		"notes.txt":   []byte("notes"),
+	} {
+		if _, err := Duration(writeFile(t, name, contents)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package tags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	flacPadding       = 1
+	flacVorbisComment = 4
+)
+
+const vorbisVendor = "rank-my-music"
+
+// Replaces the RATING and FMPS_RATING comments in the FLAC Vorbis
+// comment block, adding the block if there is none. Other metadata
+// blocks are copied as they are; everything after them is audio.
+func flacSegments(r io.ReaderAt, size int64, stars int) ([]segment, error) {
+	blocks := [][]byte{}
+	var comment []byte
+
+	offset := int64(4) // "fLaC"
+	for {
+		header := make([]byte, 4)
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return nil, fmt.Errorf("Invalid FLAC metadata: %w", err)
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		if offset+4+length > size {
+			return nil, fmt.Errorf("FLAC metadata runs past the end of the file")
+		}
+
+		block := make([]byte, 4+length)
+		if _, err := r.ReadAt(block, offset); err != nil {
+			return nil, err
+		}
+		offset += 4 + length
+
+		if blockType == flacVorbisComment && comment == nil {
+			comment = block[4:]
+		} else if blockType != flacVorbisComment {
+			blocks = append(blocks, block)
+		}
+
+		if last {
+			break
+		}
+	}
+
+	vendor, comments, err := parseVorbisComments(comment)
+	if err != nil {
+		return nil, err
+	}
+
+	comments = append(
+		withoutComments(comments, "RATING", "FMPS_RATING"),
+		"RATING="+strconv.Itoa(stars),
+		"FMPS_RATING="+strconv.FormatFloat(float64(stars)/5, 'f', -1, 64),
+	)
+
+	commentBlock := vorbisComments(vendor, comments)
+	if len(commentBlock) >= 1<<24 {
+		return nil, fmt.Errorf("FLAC Vorbis comments too large")
+	}
+
+	// STREAMINFO must stay first, and padding is best left last
+	if len(blocks) == 0 || blocks[0][0]&0x7f != 0 {
+		return nil, fmt.Errorf("FLAC file does not start with STREAMINFO")
+	}
+
+	i := 1
+	for i < len(blocks) && blocks[i][0]&0x7f != flacPadding {
+		i++
+	}
+
+	header := []byte{
+		flacVorbisComment,
+		byte(len(commentBlock) >> 16),
+		byte(len(commentBlock) >> 8),
+		byte(len(commentBlock)),
+	}
+	blocks = append(
+		blocks[:i],
+		append([][]byte{append(header, commentBlock...)}, blocks[i:]...)...,
+	)
+
+	metadata := []byte("fLaC")
+	for j, block := range blocks {
+		flag := block[0] & 0x7f
+		if j == len(blocks)-1 {
+			flag |= 0x80
+		}
+
+		metadata = append(metadata, flag)
+		metadata = append(metadata, block[1:]...)
+	}
+
+	return []segment{
+		{data: metadata},
+		original(offset, size-offset, true),
+	}, nil
+}
+
+// Vendor string and "KEY=value" comments of a Vorbis comment block, with
+// no framing bit. A nil block has no comments.
+func parseVorbisComments(block []byte) (string, []string, error) {
+	if block == nil {
+		return vorbisVendor, []string{}, nil
+	}
+
+	invalid := fmt.Errorf("Invalid Vorbis comments")
+
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+
+		n := binary.LittleEndian.Uint32(block)
+		if uint64(n) > uint64(len(block)-4) {
+			return "", false
+		}
+
+		s := string(block[4 : 4+n])
+		block = block[4+n:]
+
+		return s, true
+	}
+
+	vendor, ok := next()
+	if !ok || len(block) < 4 {
+		return "", nil, invalid
+	}
+
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+
+	comments := []string{}
+	for i := uint32(0); i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return "", nil, invalid
+		}
+
+		comments = append(comments, comment)
+	}
+
+	return vendor, comments, nil
+}
+
+func vorbisComments(vendor string, comments []string) []byte {
+	block := appendUint32LE(nil, uint32(len(vendor)))
+	block = append(block, vendor...)
+	block = appendUint32LE(block, uint32(len(comments)))
+
+	for _, comment := range comments {
+		block = appendUint32LE(block, uint32(len(comment)))
+		block = append(block, comment...)
+	}
+
+	return block
+}
+
+func appendUint32LE(b []byte, n uint32) []byte {
+	le := make([]byte, 4)
+	binary.LittleEndian.PutUint32(le, n)
+
+	return append(b, le...)
+}
+
+// Comment keys are case-insensitive
+func withoutComments(comments []string, keys ...string) []string {
+	kept := []string{}
+
+	for _, comment := range comments {
+		key := strings.SplitN(comment, "=", 2)[0]
+
+		remove := false
+		for _, k := range keys {
+			remove = remove || strings.EqualFold(key, k)
+		}
+
+		if !remove {
+			kept = append(kept, comment)
+		}
+	}
+
+	return kept
+}
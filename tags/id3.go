@@ -0,0 +1,187 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Email of the POPM frame we write. Players each read their own; this is
+// the one most of them read.
+const popmEmail = "Windows Media Player 9 Series"
+
+const id3HeaderSize = 10
+
+// Padding added when the tag grows, so the next change can fit
+const id3Padding = 1024
+
+// Size of the ID3v2 tag at the start of r, including its header and any
+// footer
+func id3TagSize(r io.ReaderAt) (int64, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+
+	size := int64(id3HeaderSize) + int64(syncsafe(header[6:10]))
+	if header[3] == 4 && header[5]&0x10 != 0 {
+		size += id3HeaderSize
+	}
+
+	return size, nil
+}
+
+// Replaces our POPM frame in the ID3v2 tag, or adds a tag if there is
+// none. Everything after the tag, including any ID3v1 tag at the end, is
+// audio.
+func id3Segments(r io.ReaderAt, size int64, stars int) ([]segment, error) {
+	version := byte(3)
+	frames := [][]byte{}
+	oldSize := 0
+
+	tagSize, err := id3TagSize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if tagSize > 0 {
+		if tagSize > size {
+			return nil, fmt.Errorf("ID3v2 tag runs past the end of the file")
+		}
+
+		tag := make([]byte, tagSize)
+		if _, err = r.ReadAt(tag, 0); err != nil {
+			return nil, err
+		}
+
+		version = tag[3]
+		flags := tag[5]
+		oldSize = int(syncsafe(tag[6:10]))
+
+		if version != 3 && version != 4 {
+			return nil, fmt.Errorf("%w: ID3v2.%d", ErrUnsupported, version)
+		}
+		if flags&0x80 != 0 {
+			return nil, fmt.Errorf("%w: unsynchronised ID3v2 tag", ErrUnsupported)
+		}
+
+		body := tag[id3HeaderSize : id3HeaderSize+oldSize]
+
+		// The extended header is optional, and any CRC in it would no
+		// longer match, so it is dropped
+		if flags&0x40 != 0 {
+			if len(body) < 4 {
+				return nil, fmt.Errorf("Invalid ID3v2 extended header")
+			}
+
+			extended := int(binary.BigEndian.Uint32(body)) + 4
+			if version == 4 {
+				extended = int(syncsafe(body[:4]))
+			}
+			if extended > len(body) {
+				return nil, fmt.Errorf("Invalid ID3v2 extended header")
+			}
+
+			body = body[extended:]
+		}
+
+		if frames, err = id3Frames(body, version); err != nil {
+			return nil, err
+		}
+	}
+
+	kept := [][]byte{}
+	for _, frame := range frames {
+		if string(frame[:4]) == "POPM" &&
+			bytes.HasPrefix(frame[id3HeaderSize:], []byte(popmEmail+"\x00")) {
+			continue
+		}
+
+		kept = append(kept, frame)
+	}
+
+	popm := append([]byte(popmEmail+"\x00"), POPM(stars))
+	kept = append(kept, id3Frame("POPM", popm, version))
+
+	framesSize := 0
+	for _, frame := range kept {
+		framesSize += len(frame)
+	}
+
+	newSize := oldSize
+	if framesSize > newSize {
+		newSize = framesSize + id3Padding
+	}
+
+	tag := make([]byte, 0, id3HeaderSize+newSize)
+	tag = append(tag, 'I', 'D', '3', version, 0, 0)
+	tag = append(tag, toSyncsafe(uint32(newSize))...)
+	for _, frame := range kept {
+		tag = append(tag, frame...)
+	}
+	tag = append(tag, make([]byte, newSize-framesSize)...)
+
+	return []segment{
+		{data: tag},
+		original(tagSize, size-tagSize, true),
+	}, nil
+}
+
+// Splits a tag body into whole frames, header included, stopping at
+// padding
+func id3Frames(body []byte, version byte) ([][]byte, error) {
+	frames := [][]byte{}
+
+	for len(body) >= id3HeaderSize && body[0] != 0 {
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		if version == 4 {
+			size = int(syncsafe(body[4:8]))
+		}
+
+		end := id3HeaderSize + size
+		if size < 0 || end > len(body) {
+			return nil, fmt.Errorf(
+				"ID3v2 frame '%s' runs past the end of the tag", body[:4],
+			)
+		}
+
+		frames = append(frames, body[:end])
+		body = body[end:]
+	}
+
+	return frames, nil
+}
+
+func id3Frame(id string, data []byte, version byte) []byte {
+	frame := make([]byte, id3HeaderSize, id3HeaderSize+len(data))
+	copy(frame, id)
+
+	if version == 4 {
+		copy(frame[4:8], toSyncsafe(uint32(len(data))))
+	} else {
+		binary.BigEndian.PutUint32(frame[4:8], uint32(len(data)))
+	}
+
+	return append(frame, data...)
+}
+
+// ID3v2 sizes use 7 bits per byte
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 |
+		uint32(b[1]&0x7f)<<14 |
+		uint32(b[2]&0x7f)<<7 |
+		uint32(b[3]&0x7f)
+}
+
+func toSyncsafe(n uint32) []byte {
+	return []byte{
+		byte(n >> 21 & 0x7f),
+		byte(n >> 14 & 0x7f),
+		byte(n >> 7 & 0x7f),
+		byte(n & 0x7f),
+	}
+}
@@ -0,0 +1,344 @@
+package tags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// An MP4 atom (box). Only the atoms on the way to ilst and to the chunk
+// offset tables are split into children; the rest are kept as they are.
+type atom struct {
+	kind string
+
+	// Bytes between the header and the children, e.g. the version and
+	// flags of meta
+	prefix []byte
+
+	children []*atom
+	data     []byte // Leaf atoms only
+}
+
+// Atoms whose children we need, and the size of any prefix before them
+var mp4Containers = map[string]int{
+	"moov": 0,
+	"trak": 0,
+	"mdia": 0,
+	"minf": 0,
+	"stbl": 0,
+	"udta": 0,
+	"meta": 4,
+	"ilst": 0,
+}
+
+// Replaces the rate atom in moov/udta/meta/ilst, creating any atoms on
+// the way that are missing. Only moov is rewritten. If it comes before
+// the media data, the chunk offsets in it are moved by the change in
+// its size so they still point at the same samples.
+func mp4Segments(r io.ReaderAt, size int64, stars int) ([]segment, error) {
+	type topLevel struct {
+		kind           string
+		offset, length int64
+	}
+
+	atoms := []topLevel{}
+	moovIndex := -1
+
+	for offset := int64(0); offset < size; {
+		kind, length, err := mp4AtomHeader(r, offset, size)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case "moov":
+			if moovIndex >= 0 {
+				return nil, fmt.Errorf("MP4 file has more than one moov atom")
+			}
+			moovIndex = len(atoms)
+		case "moof":
+			return nil, fmt.Errorf("%w: fragmented MP4", ErrUnsupported)
+		}
+
+		atoms = append(atoms, topLevel{kind, offset, length})
+		offset += length
+	}
+
+	if moovIndex < 0 {
+		return nil, fmt.Errorf("MP4 file has no moov atom")
+	}
+
+	moovAtom := atoms[moovIndex]
+
+	raw := make([]byte, moovAtom.length)
+	if _, err := r.ReadAt(raw, moovAtom.offset); err != nil {
+		return nil, err
+	}
+
+	moov, err := parseAtom(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	ilst := moov.path("udta", "meta", "ilst")
+
+	items := []*atom{}
+	for _, item := range ilst.children {
+		if item.kind != "rate" {
+			items = append(items, item)
+		}
+	}
+
+	// Text data, as MusicBee and Mp3tag write it
+	value := strconv.Itoa(stars * 20)
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(data, 1) // Version 0, type UTF-8
+	data = append(data, value...)
+
+	ilst.children = append(items, &atom{
+		kind:     "rate",
+		children: []*atom{{kind: "data", data: data}},
+	})
+
+	delta := int64(moov.size()) - moovAtom.length
+	end := moovAtom.offset + moovAtom.length
+
+	if err = moov.moveChunkOffsets(end, delta); err != nil {
+		return nil, err
+	}
+
+	segments := []segment{}
+	for i, a := range atoms {
+		if i == moovIndex {
+			segments = append(segments, segment{data: moov.bytes()})
+			continue
+		}
+
+		segments = append(segments, original(a.offset, a.length, a.kind == "mdat"))
+	}
+
+	return segments, nil
+}
+
+// Reads the type and full size of the atom at offset
+func mp4AtomHeader(r io.ReaderAt, offset int64, size int64) (string, int64, error) {
+	header := make([]byte, 16)
+	n, err := r.ReadAt(header, offset)
+	if n < 8 {
+		return "", 0, fmt.Errorf("Invalid MP4 atom at %d: %v", offset, err)
+	}
+
+	kind := string(header[4:8])
+	length := int64(binary.BigEndian.Uint32(header))
+
+	switch length {
+	case 0: // To the end of the file
+		length = size - offset
+	case 1:
+		if n < 16 {
+			return "", 0, fmt.Errorf("Invalid MP4 atom at %d", offset)
+		}
+		length = int64(binary.BigEndian.Uint64(header[8:]))
+	}
+
+	if length < 8 || offset+length > size {
+		return "", 0, fmt.Errorf("MP4 atom '%s' runs past the end of the file", kind)
+	}
+
+	return kind, length, nil
+}
+
+func parseAtom(b []byte) (*atom, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("Invalid MP4 atom")
+	}
+
+	a := &atom{kind: string(b[4:8])}
+	body := b[8:]
+
+	if binary.BigEndian.Uint32(b) == 1 {
+		if len(b) < 16 {
+			return nil, fmt.Errorf("Invalid MP4 atom '%s'", a.kind)
+		}
+		body = b[16:]
+	}
+
+	prefix, container := mp4Containers[a.kind]
+	if !container {
+		a.data = body
+		return a, nil
+	}
+
+	if len(body) < prefix {
+		return nil, fmt.Errorf("Invalid MP4 atom '%s'", a.kind)
+	}
+	a.prefix = body[:prefix]
+	body = body[prefix:]
+
+	for len(body) > 0 {
+		if len(body) < 8 {
+			return nil, fmt.Errorf("Invalid MP4 atom in '%s'", a.kind)
+		}
+
+		length := uint64(binary.BigEndian.Uint32(body))
+		if length == 1 && len(body) >= 16 {
+			length = binary.BigEndian.Uint64(body[8:])
+		}
+		if length == 0 {
+			length = uint64(len(body))
+		}
+		if length < 8 || length > uint64(len(body)) {
+			return nil, fmt.Errorf("MP4 atom in '%s' runs past its end", a.kind)
+		}
+
+		child, err := parseAtom(body[:length])
+		if err != nil {
+			return nil, err
+		}
+
+		a.children = append(a.children, child)
+		body = body[length:]
+	}
+
+	return a, nil
+}
+
+// Follows a path of child atoms, creating any that are missing
+func (a *atom) path(kinds ...string) *atom {
+	current := a
+
+	for _, kind := range kinds {
+		next := current.find(kind)
+
+		if next == nil {
+			next = &atom{kind: kind}
+
+			if kind == "meta" {
+				next.prefix = make([]byte, 4)
+				next.children = []*atom{mp4MetadataHandler()}
+			}
+
+			current.children = append(current.children, next)
+		}
+
+		current = next
+	}
+
+	return current
+}
+
+// Follows a path of child atoms; nil if any are missing
+func (a *atom) find(kinds ...string) *atom {
+	current := a
+
+	for _, kind := range kinds {
+		var next *atom
+		for _, child := range current.children {
+			if child.kind == kind {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		current = next
+	}
+
+	return current
+}
+
+// The hdlr atom that marks meta as holding iTunes-style metadata
+func mp4MetadataHandler() *atom {
+	data := make([]byte, 25)
+	copy(data[8:], "mdirappl")
+
+	return &atom{kind: "hdlr", data: data}
+}
+
+// Adds delta to every chunk offset at or after from
+func (a *atom) moveChunkOffsets(from int64, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+
+	switch a.kind {
+	case "stco", "co64":
+		width := 4
+		if a.kind == "co64" {
+			width = 8
+		}
+
+		if len(a.data) < 8 {
+			return fmt.Errorf("Invalid MP4 '%s' atom", a.kind)
+		}
+
+		count := int(binary.BigEndian.Uint32(a.data[4:8]))
+		if count < 0 || 8+count*width > len(a.data) {
+			return fmt.Errorf("Invalid MP4 '%s' atom", a.kind)
+		}
+
+		// Don't change the bytes shared with the original file
+		data := append([]byte{}, a.data...)
+
+		for i := 0; i < count; i++ {
+			entry := data[8+i*width:]
+
+			if width == 4 {
+				offset := int64(binary.BigEndian.Uint32(entry))
+				if offset < from {
+					continue
+				}
+
+				moved := offset + delta
+				if moved < 0 || moved > 0xffffffff {
+					return fmt.Errorf("MP4 chunk offset out of range")
+				}
+				binary.BigEndian.PutUint32(entry, uint32(moved))
+			} else {
+				offset := int64(binary.BigEndian.Uint64(entry))
+				if offset < from {
+					continue
+				}
+
+				binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+			}
+		}
+
+		a.data = data
+	}
+
+	for _, child := range a.children {
+		if err := child.moveChunkOffsets(from, delta); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *atom) size() int {
+	size := 8 + len(a.prefix) + len(a.data)
+	for _, child := range a.children {
+		size += child.size()
+	}
+
+	return size
+}
+
+func (a *atom) bytes() []byte {
+	b := make([]byte, 8, a.size())
+	binary.BigEndian.PutUint32(b, uint32(a.size()))
+	copy(b[4:], a.kind)
+
+	b = append(b, a.prefix...)
+	b = append(b, a.data...)
+	for _, child := range a.children {
+		b = append(b, child.bytes()...)
+	}
+
+	return b
+}
@@ -0,0 +1,210 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+// ReadPrior finds a star rating or play count in a file's tags:
+//   - ID3v2 POPM frames, preferring the one WriteRating writes
+//   - Vorbis FMPS_RATING (0–1), RATING (0–5, or 0–100) and FMPS_PLAYCOUNT
+//   - the MP4 rate atom (0–100), read from r as the tag library skips it
+//
+// A star rating found anywhere beats a play count. The Source of the
+// result says which tag was used, and is "" if there was nothing.
+func ReadPrior(meta tag.Metadata, r io.ReaderAt, size int64) track.Prior {
+	raw := meta.Raw()
+
+	var prior track.Prior
+
+	switch meta.Format() {
+	case tag.ID3v2_2, tag.ID3v2_3, tag.ID3v2_4:
+		prior = popmPrior(raw)
+	case tag.VORBIS:
+		prior = vorbisPrior(raw)
+	case tag.MP4:
+		prior = mp4Prior(r, size)
+	}
+
+	if prior.Stars <= 0 && prior.Plays <= 0 {
+		return track.Prior{}
+	}
+
+	return prior
+}
+
+func popmPrior(raw map[string]interface{}) track.Prior {
+	// Frames are keyed POPM, POPM_0, POPM_1, ... in file order
+	keys := []string{}
+	for key := range raw {
+		if key == "POPM" || key == "POP" ||
+			strings.HasPrefix(key, "POPM_") || strings.HasPrefix(key, "POP_") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	prior := track.Prior{}
+	ours := false
+
+	for _, key := range keys {
+		frame, ok := raw[key].([]byte)
+		if !ok {
+			continue
+		}
+
+		end := bytes.IndexByte(frame, 0)
+		if end < 0 || end+1 >= len(frame) {
+			continue
+		}
+
+		email := string(frame[:end])
+		rating := frame[end+1]
+
+		// Counters are at least 4 bytes, but may be longer
+		if counter := frame[end+2:]; len(counter) >= 4 && len(counter) <= 8 {
+			plays := make([]byte, 8)
+			copy(plays[8-len(counter):], counter)
+
+			if n := binary.BigEndian.Uint64(plays); n <= math.MaxInt32 &&
+				int(n) > prior.Plays {
+				prior.Plays = int(n)
+			}
+		}
+
+		if rating > 0 && !ours {
+			prior.Stars = popmStars(rating)
+			ours = email == popmEmail
+		}
+	}
+
+	switch {
+	case prior.Stars > 0:
+		prior.Source = "popm"
+	case prior.Plays > 0:
+		prior.Source = "popm_plays"
+	}
+
+	return prior
+}
+
+// Inverse of POPM, with the boundaries most players use
+func popmStars(rating byte) float64 {
+	switch {
+	case rating == 0:
+		return 0
+	case rating < 32:
+		return 1
+	case rating < 96:
+		return 2
+	case rating < 160:
+		return 3
+	case rating < 224:
+		return 4
+	default:
+		return 5
+	}
+}
+
+func vorbisPrior(raw map[string]interface{}) track.Prior {
+	number := func(key string) (float64, bool) {
+		s, ok := raw[key].(string)
+		if !ok {
+			return 0, false
+		}
+
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return n, err == nil && n > 0
+	}
+
+	prior := track.Prior{}
+
+	if n, ok := number("fmps_playcount"); ok {
+		prior.Plays = int(n)
+		prior.Source = "fmps_playcount"
+	}
+
+	if n, ok := number("fmps_rating"); ok && n <= 1 {
+		prior.Stars = 5 * n
+		prior.Source = "fmps_rating"
+	} else if n, ok := number("rating"); ok && n <= 100 {
+		// 1–5 stars, or a percentage
+		if n > 5 {
+			n /= 20
+		}
+
+		prior.Stars = n
+		prior.Source = "rating"
+	}
+
+	return prior
+}
+
+func mp4Prior(r io.ReaderAt, size int64) track.Prior {
+	for offset := int64(0); offset < size; {
+		kind, length, err := mp4AtomHeader(r, offset, size)
+		if err != nil {
+			return track.Prior{}
+		}
+
+		if kind == "moov" {
+			return mp4MoovPrior(r, offset, length)
+		}
+
+		offset += length
+	}
+
+	return track.Prior{}
+}
+
+func mp4MoovPrior(r io.ReaderAt, offset int64, length int64) track.Prior {
+	raw := make([]byte, length)
+	if _, err := r.ReadAt(raw, offset); err != nil {
+		return track.Prior{}
+	}
+
+	moov, err := parseAtom(raw)
+	if err != nil {
+		return track.Prior{}
+	}
+
+	rate := moov.find("udta", "meta", "ilst", "rate")
+	if rate == nil {
+		return track.Prior{}
+	}
+
+	// The value is in a data atom: size, "data", type, locale, value
+	if len(rate.data) < 16 || string(rate.data[4:8]) != "data" {
+		return track.Prior{}
+	}
+
+	value := rate.data[16:]
+
+	var n float64
+	switch binary.BigEndian.Uint32(rate.data[8:12]) & 0xffffff {
+	case 1: // UTF-8
+		if n, err = strconv.ParseFloat(string(value), 64); err != nil {
+			return track.Prior{}
+		}
+	case 0, 21: // Integer
+		if len(value) != 1 {
+			return track.Prior{}
+		}
+		n = float64(value[0])
+	}
+
+	if n <= 0 || n > 100 {
+		return track.Prior{}
+	}
+
+	return track.Prior{Stars: n / 20, Source: "mp4_rate"}
+}
@@ -0,0 +1,132 @@
+package tags
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/dhowden/tag"
+
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+func TestPOPMPrior(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		raw      map[string]interface{}
+		expected track.Prior
+	}{
+		{
+			"Ours preferred, highest play count",
+			map[string]interface{}{
+				"POPM":   []byte("other@example.com\x00\xff\x00\x00\x00\x09"),
+				"POPM_0": []byte(popmEmail + "\x00\x40\x00\x00\x00\x02"),
+			},
+			track.Prior{Stars: 2, Plays: 9, Source: "popm"},
+		},
+		{
+			"Plays only",
+			map[string]interface{}{
+				"POPM": []byte("other@example.com\x00\x00\x00\x00\x00\x01\x00"),
+			},
+			track.Prior{Plays: 256, Source: "popm_plays"},
+		},
+		{
+			"Nothing",
+			map[string]interface{}{"TIT2": "Title 1"},
+			track.Prior{},
+		},
+	} {
+		if got := popmPrior(test.raw); got != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestVorbisPrior(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		raw      map[string]interface{}
+		expected track.Prior
+	}{
+		{
+			"FMPS preferred",
+			map[string]interface{}{"fmps_rating": "0.8", "rating": "1"},
+			track.Prior{Stars: 4, Source: "fmps_rating"},
+		},
+		{
+			"Stars",
+			map[string]interface{}{"rating": "3", "fmps_playcount": "12"},
+			track.Prior{Stars: 3, Plays: 12, Source: "rating"},
+		},
+		{
+			"Percentage",
+			map[string]interface{}{"rating": "90"},
+			track.Prior{Stars: 4.5, Source: "rating"},
+		},
+		{
+			"Plays only",
+			map[string]interface{}{"fmps_playcount": "4"},
+			track.Prior{Plays: 4, Source: "fmps_playcount"},
+		},
+		{
+			"Out of range",
+			map[string]interface{}{"rating": "500", "fmps_rating": "-1"},
+			track.Prior{},
+		},
+	} {
+		if got := vorbisPrior(test.raw); got != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, got)
+		}
+	}
+}
+
+// What WriteRating writes, ReadPrior reads back
+func TestPriorRoundTrip(t *testing.T) {
+	streamInfo := append([]byte{0, 0, 0, 34}, make([]byte, 34)...)
+	streamInfo[0] |= 0x80
+
+	for name, contents := range map[string][]byte{
+		"track.mp3":  audio,
+		"track.flac": append(append([]byte("fLaC"), streamInfo...), audio...),
+		"track.m4a": append(
+			box("ftyp", []byte("M4A \x00\x00\x00\x00M4A ")),
+			append(box("mdat", audio), box("moov", box("mvhd", make([]byte, 100)))...)...,
+		),
+	} {
+		path := writeFile(t, name, contents)
+
+		if _, err := WriteRating(path, 4, Options{}); err != nil {
+			t.Fatal(err)
+		}
+
+		got := readFile(t, path)
+
+		meta, err := tag.ReadFrom(bytes.NewReader(got))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		prior := ReadPrior(meta, bytes.NewReader(got), int64(len(got)))
+		if prior.Stars != 4 || prior.Source == "" {
+			t.Errorf("%s: expected 4 stars, got %+v", name, prior)
+		}
+	}
+
+	t.Log("Untagged files have no prior")
+
+	path := writeFile(t, "plain.mp3", append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), audio...))
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	meta, err := tag.ReadFrom(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prior := ReadPrior(meta, file, int64(len(audio)+10)); prior != (track.Prior{}) {
+		t.Errorf("Expected no prior, got %+v", prior)
+	}
+}
@@ -0,0 +1,317 @@
+// Package tags writes ratings into the tags of the audio files
+// themselves, so music players can see them
+package tags
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nephila-nacrea/rank-my-music/elo"
+	"github.com/nephila-nacrea/rank-my-music/track"
+)
+
+var ErrUnsupported = errors.New("Unsupported file format")
+
+// Stars converts a rating to 1–5 stars, the reverse of the prior a star
+// rating gives: the starting rating is 3 stars, and each
+// elo.PriorStarPoints above or below it a star more or less
+func Stars(rating float64, start float64) int {
+	stars := int(math.Round(3 + (rating-start)/elo.PriorStarPoints))
+
+	if stars < 1 {
+		return 1
+	}
+	if stars > 5 {
+		return 5
+	}
+	return stars
+}
+
+// POPM converts stars to an ID3 popularimeter value, on the 0–255 scale
+// used by Windows Media Player, foobar2000 and MusicBee
+func POPM(stars int) byte {
+	return [...]byte{0, 1, 64, 128, 196, 255}[clampStars(stars)]
+}
+
+type Options struct {
+	// Check the file can be updated without changing it
+	DryRun bool
+
+	// Copy each file into BackupDir before changing it, unless it was
+	// backed up there before, so the backup is always of the file as it
+	// was before the first change. "" = no backups.
+	BackupDir string
+
+	// Backups of files under LibraryRoot keep their path relative to it;
+	// others keep their full path
+	LibraryRoot string
+}
+
+// Result of writing one file
+type Result struct {
+	Format string // "id3", "flac" or "mp4"
+	Backup string // "" if no backup was made
+
+	// Backup was made by an earlier run, so was left as it was
+	BackedUpBefore bool
+}
+
+// WriteRating writes a 1–5 star rating into path's tags:
+//   - MP3: an ID3v2 POPM frame
+//   - FLAC: Vorbis RATING (1–5) and FMPS_RATING (0–1) comments
+//   - MP4/M4A: a rate atom (0–100)
+//
+// Only the tags are rewritten. The new file is written alongside the old
+// one and only replaces it once the audio data has been checked to be
+// byte for byte the same. Ogg files are not supported.
+func WriteRating(path string, stars int, opts Options) (Result, error) {
+	if stars < 1 || stars > 5 {
+		return Result{}, fmt.Errorf("Invalid rating %d; must be 1–5 stars", stars)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Result{}, err
+	}
+
+	format, err := sniff(file, path)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var segments []segment
+	switch format {
+	case "id3":
+		segments, err = id3Segments(file, info.Size(), stars)
+	case "flac":
+		segments, err = flacSegments(file, info.Size(), stars)
+	case "mp4":
+		segments, err = mp4Segments(file, info.Size(), stars)
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	result := Result{Format: format}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if opts.BackupDir != "" {
+		result.Backup, result.BackedUpBefore, err = backup(file, info, path, opts)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	return result, rewrite(file, info, path, segments)
+}
+
+// Part of a rewritten file: either new tag data, or a range of the
+// original file copied as is
+type segment struct {
+	data []byte
+
+	offset int64
+	length int64
+	audio  bool // Checked to be unchanged before the file is replaced
+}
+
+func original(offset int64, length int64, audio bool) segment {
+	return segment{offset: offset, length: length, audio: audio}
+}
+
+func sniff(file *os.File, path string) (string, error) {
+	header := make([]byte, 12)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		// FLAC with an ID3 tag in front of it
+		if size, err := id3TagSize(file); err == nil {
+			magic := make([]byte, 4)
+			if _, err = file.ReadAt(magic, size); err == nil &&
+				string(magic) == "fLaC" {
+				return "", fmt.Errorf("%w: FLAC with an ID3 tag", ErrUnsupported)
+			}
+		}
+		return "id3", nil
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "flac", nil
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return "mp4", nil
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return "", fmt.Errorf("%w: Ogg", ErrUnsupported)
+	case strings.EqualFold(filepath.Ext(path), ".mp3"):
+		// MP3 with no tag yet
+		return "id3", nil
+	}
+
+	return "", ErrUnsupported
+}
+
+// Copies the original file into the backup folder. An earlier backup is
+// kept instead, as it is of the file before any ratings were written; a
+// copy that fails is removed, so it isn't taken for one. Returns the
+// backup's path and whether it was already there.
+func backup(
+	file *os.File, info os.FileInfo, path string, opts Options,
+) (_ string, _ bool, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	rel, ok := track.InFolder(opts.LibraryRoot, abs)
+	if !ok {
+		rel = strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	}
+
+	dest := filepath.Join(opts.BackupDir, rel)
+
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", false, err
+	}
+
+	out, err := os.OpenFile(
+		dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm(),
+	)
+	if os.IsExist(err) {
+		return dest, true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(dest)
+		}
+	}()
+
+	n, err := io.Copy(out, io.NewSectionReader(file, 0, info.Size()))
+	if err == nil && n != info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if err = out.Sync(); err != nil {
+		return "", false, err
+	}
+	if err = out.Close(); err != nil {
+		return "", false, err
+	}
+
+	return dest, false, nil
+}
+
+// Writes segments to a temporary file next to path, checks the audio
+// came through unchanged, then replaces path with it
+func rewrite(
+	file *os.File, info os.FileInfo, path string, segments []segment,
+) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	type moved struct {
+		from, to, length int64
+	}
+	audio := []moved{}
+
+	var written int64
+	for _, s := range segments {
+		var n int64
+		if s.data != nil {
+			var m int
+			m, err = tmp.Write(s.data)
+			n = int64(m)
+		} else {
+			n, err = io.Copy(tmp, io.NewSectionReader(file, s.offset, s.length))
+			if err == nil && n != s.length {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if s.audio {
+			audio = append(audio, moved{s.offset, written, s.length})
+		}
+
+		written += n
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	for _, a := range audio {
+		var before, after []byte
+		if before, err = hash(file, a.from, a.length); err != nil {
+			return err
+		}
+		if after, err = hash(tmp, a.to, a.length); err != nil {
+			return err
+		}
+		if !bytes.Equal(before, after) {
+			err = fmt.Errorf("%s: Audio data changed; file left as it was", path)
+			return err
+		}
+	}
+
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func hash(r io.ReaderAt, offset int64, length int64) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, offset, length)); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+func clampStars(stars int) int {
+	if stars < 0 {
+		return 0
+	}
+	if stars > 5 {
+		return 5
+	}
+	return stars
+}
@@ -0,0 +1,408 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dhowden/tag"
+)
+
+// Stands in for the encoded audio; any change to it must be caught
+var audio = bytes.Repeat([]byte{0xff, 0xfb, 0x90, 0x00, 'I', 'D', '3', 0}, 64)
+
+func TestStars(t *testing.T) {
+	for _, test := range []struct {
+		rating   float64
+		expected int
+	}{
+		{1000, 3},
+		{1100, 4},
+		{1149, 4},
+		{1150, 5},
+		{1500, 5},
+		{849, 1},
+		{900, 2},
+		{0, 1},
+	} {
+		if got := Stars(test.rating, 1000); got != test.expected {
+			t.Errorf(
+				"Rating %v: expected %d stars, got %d",
+				test.rating, test.expected, got,
+			)
+		}
+	}
+
+	if got := POPM(4); got != 196 {
+		t.Errorf("Expected POPM 196 for 4 stars, got %d", got)
+	}
+}
+
+func TestID3(t *testing.T) {
+	frames := append(
+		id3Frame("TIT2", []byte("\x00Title 1"), 3),
+		id3Frame("POPM", []byte(popmEmail+"\x00\x01\x00\x00\x00\x07"), 3)...,
+	)
+	frames = append(frames, id3Frame("POPM", []byte("other@example.com\x00\x40"), 3)...)
+
+	tagged := []byte{'I', 'D', '3', 3, 0, 0}
+	tagged = append(tagged, toSyncsafe(uint32(len(frames)+16))...)
+	tagged = append(tagged, frames...)
+	tagged = append(tagged, make([]byte, 16)...)
+	tagged = append(tagged, audio...)
+
+	path := writeFile(t, "tagged.mp3", tagged)
+
+	if _, err := WriteRating(path, 4, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readFile(t, path)
+	if !bytes.HasSuffix(got, audio) {
+		t.Error("Expected audio to be unchanged")
+	}
+
+	t.Log("Our POPM replaced, other frames kept")
+
+	size, err := id3TagSize(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(size)+len(audio) != len(got) {
+		t.Errorf("Expected tag of %d bytes, got %d", len(got)-len(audio), size)
+	}
+
+	gotFrames, err := id3Frames(got[id3HeaderSize:size], 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]byte{
+		id3Frame("TIT2", []byte("\x00Title 1"), 3),
+		id3Frame("POPM", []byte("other@example.com\x00\x40"), 3),
+		id3Frame("POPM", []byte(popmEmail+"\x00\xc4"), 3),
+	}
+	if len(gotFrames) != len(expected) {
+		t.Fatalf("\nExpected:\n%q\ngot:\n%q", expected, gotFrames)
+	}
+	for i := range expected {
+		if !bytes.Equal(expected[i], gotFrames[i]) {
+			t.Errorf("\nExpected:\n%q\ngot:\n%q", expected[i], gotFrames[i])
+		}
+	}
+
+	meta, err := tag.ReadFrom(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title() != "Title 1" {
+		t.Errorf("Expected title to still be readable, got '%s'", meta.Title())
+	}
+
+	t.Log("MP3 with no tag")
+
+	path = writeFile(t, "untagged.mp3", audio)
+
+	if _, err = WriteRating(path, 2, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got = readFile(t, path)
+	if !bytes.HasPrefix(got, []byte("ID3\x03")) || !bytes.HasSuffix(got, audio) {
+		t.Errorf("Expected a new ID3v2.3 tag before the audio, got %q", got[:10])
+	}
+	if !bytes.Contains(got, []byte(popmEmail+"\x00\x40")) {
+		t.Error("Expected POPM 64 for 2 stars")
+	}
+
+	t.Log("ID3v2.4 frame sizes are syncsafe")
+
+	frame := id3Frame("TXXX", make([]byte, 200), 4)
+	if !bytes.Equal(frame[4:8], []byte{0, 0, 1, 0x48}) {
+		t.Errorf("Expected syncsafe size, got %v", frame[4:8])
+	}
+}
+
+func TestFLAC(t *testing.T) {
+	streamInfo := append([]byte{0, 0, 0, 34}, make([]byte, 34)...)
+	comments := vorbisComments("reference libFLAC", []string{
+		"TITLE=Title 1", "rating=1", "FMPS_RATING=0.2",
+	})
+	comment := append(
+		[]byte{flacVorbisComment, 0, 0, byte(len(comments))}, comments...,
+	)
+	padding := append([]byte{0x80 | flacPadding, 0, 0, 8}, make([]byte, 8)...)
+
+	flac := []byte("fLaC")
+	for _, block := range [][]byte{streamInfo, comment, padding} {
+		flac = append(flac, block...)
+	}
+	flac = append(flac, audio...)
+
+	path := writeFile(t, "track.flac", flac)
+
+	result, err := WriteRating(path, 3, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Format != "flac" {
+		t.Errorf("Expected flac, got %s", result.Format)
+	}
+
+	got := readFile(t, path)
+	if !bytes.HasSuffix(got, audio) {
+		t.Error("Expected audio to be unchanged")
+	}
+
+	meta, err := tag.ReadFrom(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := meta.Raw()
+	if meta.Title() != "Title 1" || raw["rating"] != "3" || raw["fmps_rating"] != "0.6" {
+		t.Errorf("Expected title and new ratings, got %+v", raw)
+	}
+
+	t.Log("STREAMINFO first, padding last")
+
+	if got[4] != 0 || got[len(got)-len(audio)-12] != 0x80|flacPadding {
+		t.Errorf("Expected blocks in order, got %q", got[:len(got)-len(audio)])
+	}
+}
+
+func TestMP4(t *testing.T) {
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00M4A "))
+
+	title := box("\xa9nam", box("data", []byte("\x00\x00\x00\x01\x00\x00\x00\x00Title 1")))
+	ilst := box("ilst", title, box("rate", box("data", []byte("\x00\x00\x00\x01\x00\x00\x00\x0020"))))
+	meta := box("meta", make([]byte, 4), mp4MetadataHandler().bytes(), ilst)
+
+	// One chunk, starting 8 bytes into the audio
+	moov := func(chunk uint32) []byte {
+		stco := make([]byte, 12)
+		binary.BigEndian.PutUint32(stco[4:], 1)
+		binary.BigEndian.PutUint32(stco[8:], chunk)
+
+		return box("moov",
+			box("mvhd", make([]byte, 100)),
+			box("trak", box("mdia", box("minf", box("stbl", box("stco", stco))))),
+			box("udta", meta),
+		)
+	}
+
+	chunk := uint32(len(ftyp) + len(moov(0)) + 8 + 8)
+	m4a := append(append(ftyp, moov(chunk)...), box("mdat", audio)...)
+
+	path := writeFile(t, "track.m4a", m4a)
+
+	if _, err := WriteRating(path, 5, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readFile(t, path)
+	if !bytes.HasSuffix(got, audio) {
+		t.Error("Expected audio to be unchanged")
+	}
+
+	t.Log("Rate replaced")
+
+	parsed, err := parseAtom(got[len(ftyp) : len(got)-len(audio)-8])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := parsed.path("udta", "meta", "ilst").children
+	if len(items) != 2 || items[1].kind != "rate" ||
+		!bytes.HasSuffix(items[1].data, []byte("\x00100")) {
+		t.Errorf("Expected title then rate 100, got %+v", items)
+	}
+
+	meta4, err := tag.ReadFrom(bytes.NewReader(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta4.Title() != "Title 1" {
+		t.Errorf("Expected title to still be readable, got '%s'", meta4.Title())
+	}
+
+	t.Log("Chunk offsets still point at the same audio")
+
+	stco := parsed.path("trak", "mdia", "minf", "stbl", "stco").data
+	offset := binary.BigEndian.Uint32(stco[8:])
+	if !bytes.Equal(got[offset:offset+8], audio[8:16]) {
+		t.Errorf("Expected chunk offset to move, got %d", offset)
+	}
+
+	t.Log("No metadata yet")
+
+	bare := box("moov", box("mvhd", make([]byte, 100)))
+	path = writeFile(
+		t, "bare.m4a", append(append(append([]byte{}, ftyp...), box("mdat", audio)...), bare...),
+	)
+
+	if _, err = WriteRating(path, 1, Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	got = readFile(t, path)
+	if !bytes.Contains(got, []byte("mdirappl")) || !bytes.HasSuffix(got, []byte("\x0020")) {
+		t.Errorf("Expected new meta with rate 20, got %q", got[len(got)-120:])
+	}
+}
+
+func TestDryRunAndBackup(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "music")
+	path := filepath.Join(root, "Artist", "track.mp3")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, audio, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Dry run changes nothing")
+
+	backups := filepath.Join(dir, "backups")
+
+	result, err := WriteRating(path, 5, Options{DryRun: true, BackupDir: backups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Backup != "" || !bytes.Equal(readFile(t, path), audio) {
+		t.Errorf("Expected nothing to change, got %+v", result)
+	}
+	if _, err = os.Stat(backups); !os.IsNotExist(err) {
+		t.Error("Expected no backup folder")
+	}
+
+	t.Log("Backups keep the path under the library")
+
+	result, err = WriteRating(
+		path, 5, Options{BackupDir: backups, LibraryRoot: root},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := filepath.Join(backups, "Artist", "track.mp3")
+	if result.Backup != expected {
+		t.Errorf("Expected backup %s, got %s", expected, result.Backup)
+	}
+	if !bytes.Equal(readFile(t, expected), audio) {
+		t.Error("Expected backup of the original")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode to be kept, got %v", info.Mode())
+	}
+
+	t.Log("Earlier backups are kept")
+
+	if result, err = WriteRating(
+		path, 4, Options{BackupDir: backups, LibraryRoot: root},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if result.Backup != expected || !result.BackedUpBefore {
+		t.Errorf("Expected earlier backup %s, got %+v", expected, result)
+	}
+	if !bytes.Equal(readFile(t, expected), audio) {
+		t.Error("Expected backup of the original to be kept")
+	}
+
+	t.Log("Failed backups are removed")
+
+	other := filepath.Join(root, "Artist", "other.mp3")
+	if err = os.WriteFile(other, audio, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading from a file opened for writing fails
+	file, err := os.OpenFile(other, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if info, err = file.Stat(); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{BackupDir: backups, LibraryRoot: root}
+	if _, _, err = backup(file, info, other, opts); err == nil {
+		t.Error("Expected backup to fail")
+	}
+
+	expected = filepath.Join(backups, "Artist", "other.mp3")
+	if _, err = os.Stat(expected); !os.IsNotExist(err) {
+		t.Errorf("Expected failed backup to be removed, got %v", err)
+	}
+
+	if result, err = WriteRating(other, 4, opts); err != nil {
+		t.Fatal(err)
+	}
+	if result.Backup != expected || result.BackedUpBefore {
+		t.Errorf("Expected new backup %s, got %+v", expected, result)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	for name, contents := range map[string][]byte{
+		"track.ogg": append([]byte("OggS"), audio...),
+		"track.wav": append([]byte("RIFF"), audio...),
+		"track.mp3": append([]byte{'I', 'D', '3', 3, 0, 0x80, 0, 0, 0, 0}, audio...),
+	} {
+		path := writeFile(t, name, contents)
+
+		if _, err := WriteRating(path, 3, Options{}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: expected unsupported, got %v", name, err)
+		}
+		if !bytes.Equal(readFile(t, path), contents) {
+			t.Errorf("%s: expected file to be unchanged", name)
+		}
+	}
+
+	path := writeFile(t, "track.mp3", audio)
+	if _, err := WriteRating(path, 6, Options{}); err == nil {
+		t.Error("Expected error for 6 stars")
+	}
+}
+
+func box(kind string, parts ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], kind)
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+
+	return b
+}
+
+func writeFile(t *testing.T, name string, contents []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func readFile(t *testing.T, path string) []byte {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return contents
+}
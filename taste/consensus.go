@@ -0,0 +1,137 @@
+package taste
+
+import (
+	"math"
+	"sort"
+)
+
+// Most Bradley-Terry fits converge well within this
+const (
+	bradleyTerryIterations = 1000
+	bradleyTerryTolerance  = 1e-9
+)
+
+// Borda counts, for each item, the items each ballot scores lower than
+// it, with half for each it ties with, summed over the ballots.
+// ballots[v][i] is ballot v's score for item i; every ballot must score
+// every item.
+func Borda(ballots [][]float64) []float64 {
+	if len(ballots) == 0 {
+		return nil
+	}
+
+	n := len(ballots[0])
+	points := make([]float64, n)
+
+	for _, ballot := range ballots {
+		// The item ranked r beats n - r others, ties counting half
+		for i, rank := range Ranks(ballot) {
+			points[i] += float64(n) - rank
+		}
+	}
+
+	return points
+}
+
+// Kemeny approximates the Kemeny ranking of the ballots' items, the order
+// disagreeing with the fewest of the ballots' pairwise preferences.
+// Starting from the Borda order, neighbours are swapped while more
+// ballots prefer the lower one, until no neighbouring pair goes against
+// the majority. Returns item indexes, best first.
+func Kemeny(ballots [][]float64) []int {
+	points := Borda(ballots)
+
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return points[order[i]] > points[order[j]]
+	})
+
+	// Ballots putting a above b
+	prefer := func(a int, b int) int {
+		n := 0
+		for _, ballot := range ballots {
+			if ballot[a] > ballot[b] {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Each swap removes disagreements, so this ends
+	for swapped := true; swapped; {
+		swapped = false
+
+		for i := 0; i+1 < len(order); i++ {
+			a, b := order[i], order[i+1]
+			if prefer(b, a) > prefer(a, b) {
+				order[i], order[i+1] = b, a
+				swapped = true
+			}
+		}
+	}
+
+	return order
+}
+
+// Game is a result between items A and B. Score is A's: 1 for a win, 0.5
+// for a draw, 0 for a loss, or anything between.
+type Game struct {
+	A     int
+	B     int
+	Score float64
+}
+
+// BradleyTerry fits a Bradley-Terry model to games between n items,
+// pooling them as if all played by one person. Returns each item's
+// rating on the Elo scale. Every item also draws one game against a
+// phantom item rated 0, so items that won or lost every game still get a
+// finite rating and items with no games are rated 0.
+func BradleyTerry(n int, games []Game) []float64 {
+	// Strengths, with the phantom at 1
+	strengths := make([]float64, n)
+	wins := make([]float64, n)
+
+	for i := range strengths {
+		strengths[i] = 1
+		wins[i] = 0.5
+	}
+	for _, g := range games {
+		wins[g.A] += g.Score
+		wins[g.B] += 1 - g.Score
+	}
+
+	denominators := make([]float64, n)
+
+	// Minorisation-maximisation (Hunter, 2004)
+	for iteration := 0; iteration < bradleyTerryIterations; iteration++ {
+		for i, s := range strengths {
+			denominators[i] = 1 / (s + 1)
+		}
+		for _, g := range games {
+			d := 1 / (strengths[g.A] + strengths[g.B])
+			denominators[g.A] += d
+			denominators[g.B] += d
+		}
+
+		change := 0.0
+		for i, s := range strengths {
+			updated := wins[i] / denominators[i]
+			change = math.Max(change, math.Abs(updated-s)/s)
+			strengths[i] = updated
+		}
+
+		if change < bradleyTerryTolerance {
+			break
+		}
+	}
+
+	ratings := make([]float64, n)
+	for i, s := range strengths {
+		ratings[i] = 400 * math.Log10(s)
+	}
+
+	return ratings
+}
@@ -0,0 +1,119 @@
+// Package taste compares people's rankings of the same items, and
+// combines them into one
+package taste
+
+import (
+	"math"
+	"sort"
+)
+
+// Spearman is the Spearman rank correlation between two sets of scores
+// for the same items, a[i] and b[i] being item i's: 1 when they put the
+// items in the same order, -1 when in reverse. Tied items share their
+// average rank. 0 if there are fewer than two items, or either scores
+// them all the same.
+func Spearman(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) < 2 {
+		return 0
+	}
+
+	ra, rb := Ranks(a), Ranks(b)
+
+	// Both have the same mean, (n + 1) / 2
+	mean := float64(len(a)+1) / 2
+
+	var cov, varA, varB float64
+	for i := range ra {
+		da, db := ra[i]-mean, rb[i]-mean
+		cov += da * db
+		varA += da * da
+		varB += db * db
+	}
+
+	if varA == 0 || varB == 0 {
+		return 0
+	}
+
+	return cov / math.Sqrt(varA*varB)
+}
+
+// KendallTau is Kendall's tau-b between two sets of scores for the same
+// items: the share of pairs of items they order the same way less the
+// share they order differently, adjusted for ties. 0 if there are fewer
+// than two items, or either scores them all the same.
+func KendallTau(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) < 2 {
+		return 0
+	}
+
+	var concordant, discordant, tiedA, tiedB float64
+
+	for i := range a {
+		for j := i + 1; j < len(a); j++ {
+			da, db := sign(a[i]-a[j]), sign(b[i]-b[j])
+
+			switch {
+			case da == 0 && db == 0:
+			case da == 0:
+				tiedA++
+			case db == 0:
+				tiedB++
+			case da == db:
+				concordant++
+			default:
+				discordant++
+			}
+		}
+	}
+
+	denominator := math.Sqrt(
+		(concordant + discordant + tiedA) * (concordant + discordant + tiedB),
+	)
+	if denominator == 0 {
+		return 0
+	}
+
+	return (concordant - discordant) / denominator
+}
+
+// Ranks gives each score its place, 1 = highest. Tied scores share the
+// average of the places they span, so e.g. two joint first are 1.5.
+func Ranks(scores []float64) []float64 {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	ranks := make([]float64, len(scores))
+
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && scores[order[end]] == scores[order[start]] {
+			end++
+		}
+
+		// Places start+1 to end
+		rank := float64(start+1+end) / 2
+		for _, i := range order[start:end] {
+			ranks[i] = rank
+		}
+
+		start = end
+	}
+
+	return ranks
+}
+
+func sign(x float64) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+
+	return 0
+}
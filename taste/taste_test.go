@@ -0,0 +1,104 @@
+package taste
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCorrelation(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        []float64
+		b        []float64
+		spearman float64
+		kendall  float64
+	}{
+		{"Same order", []float64{1, 2, 3}, []float64{10, 20, 30}, 1, 1},
+		{"Reversed", []float64{1, 2, 3}, []float64{3, 2, 1}, -1, -1},
+		{"Two pairs swapped", []float64{1, 2, 3, 4, 5}, []float64{2, 1, 4, 3, 5}, 0.8, 0.6},
+		{"Ties", []float64{1, 2, 2, 4}, []float64{1, 3, 2, 2}, 0.5, 0.4},
+		{"All tied", []float64{1, 1, 1}, []float64{1, 2, 3}, 0, 0},
+		{"One item", []float64{1}, []float64{1}, 0, 0},
+	}
+
+	for _, test := range tests {
+		if got := Spearman(test.a, test.b); math.Abs(got-test.spearman) > 1e-9 {
+			t.Errorf("%s: expected Spearman %v, got %v", test.name, test.spearman, got)
+		}
+		if got := KendallTau(test.a, test.b); math.Abs(got-test.kendall) > 1e-9 {
+			t.Errorf("%s: expected Kendall %v, got %v", test.name, test.kendall, got)
+		}
+	}
+}
+
+func TestRanks(t *testing.T) {
+	expected := []float64{4, 2.5, 2.5, 1}
+	if got := Ranks([]float64{1, 2, 2, 4}); !reflect.DeepEqual(expected, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expected, got)
+	}
+}
+
+func TestBordaAndKemeny(t *testing.T) {
+	// Three items; three ballots rank them 0, 1, 2 and two rank them
+	// 1, 2, 0
+	ballots := [][]float64{
+		{3, 2, 1},
+		{3, 2, 1},
+		{3, 2, 1},
+		{1, 3, 2},
+		{1, 3, 2},
+	}
+
+	// 1 comes top on points...
+	expectedPoints := []float64{6, 7, 2}
+	if got := Borda(ballots); !reflect.DeepEqual(expectedPoints, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedPoints, got)
+	}
+
+	// ...but most prefer 0 to 1
+	expectedOrder := []int{0, 1, 2}
+	if got := Kemeny(ballots); !reflect.DeepEqual(expectedOrder, got) {
+		t.Errorf("\nExpected:\n%#v\ngot:\n%#v", expectedOrder, got)
+	}
+
+	if got := Borda(nil); len(got) != 0 {
+		t.Errorf("Expected nothing for no ballots, got %v", got)
+	}
+}
+
+func TestBradleyTerry(t *testing.T) {
+	t.Log("Even results")
+
+	ratings := BradleyTerry(3, []Game{{0, 1, 1}, {1, 0, 1}, {0, 1, 0.5}})
+	for i, r := range ratings {
+		if math.Abs(r) > 1e-6 {
+			t.Errorf("Item %d: expected 0, got %v", i, r)
+		}
+	}
+
+	t.Log("Three wins in four")
+
+	var games []Game
+	for i := 0; i < 400; i++ {
+		games = append(games, Game{A: 0, B: 1, Score: float64(i % 4 / 3)})
+	}
+
+	ratings = BradleyTerry(3, games)
+
+	// Odds of 3 to 1, shrunk a little by the phantom draws
+	diff := ratings[1] - ratings[0]
+	if expected := 400 * math.Log10(3); diff > expected || diff < expected-5 {
+		t.Errorf("Expected item 1 about %v ahead, got %v", expected, diff)
+	}
+	if ratings[2] != 0 {
+		t.Errorf("Expected 0 for item without games, got %v", ratings[2])
+	}
+
+	t.Log("Unbeaten")
+
+	ratings = BradleyTerry(2, []Game{{0, 1, 1}, {0, 1, 1}})
+	if math.IsInf(ratings[0], 0) || math.IsNaN(ratings[0]) || ratings[0] <= ratings[1] {
+		t.Errorf("Expected finite ratings with item 0 ahead, got %v", ratings)
+	}
+}
@@ -0,0 +1,23 @@
+package track
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// InFolder returns path relative to the folder root, if it is in it.
+// Paths are compared as given, so should both be absolute and cleaned
+// or resolved the same way. Nothing is in a root of "".
+func InFolder(root string, path string) (string, bool) {
+	if root == "" {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return rel, true
+}
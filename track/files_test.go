@@ -0,0 +1,38 @@
+package track
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestInFolder(t *testing.T) {
+	root := filepath.FromSlash("/music")
+
+	tests := []struct {
+		root     string
+		path     string
+		expected string
+		in       bool
+	}{
+		{root, "/music/Artist/track.mp3", "Artist/track.mp3", true},
+		{root, "/music", ".", true},
+		{root, "/music/..foo/track.mp3", "..foo/track.mp3", true},
+		{root, "/music/../track.mp3", "", false},
+		{root, "/", "", false},
+		{root, "/musicals/track.mp3", "", false},
+		{root, "track.mp3", "", false},
+		{"", "/music/track.mp3", "", false},
+	}
+
+	for _, test := range tests {
+		path := filepath.FromSlash(test.path)
+
+		rel, in := InFolder(test.root, path)
+		if rel != filepath.FromSlash(test.expected) || in != test.in {
+			t.Errorf(
+				"%s in %s: expected %q, %v, got %q, %v",
+				path, test.root, test.expected, test.in, rel, in,
+			)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package track
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormaliseName gives lower case letters and numbers only, with single
+// spaces between words and no leading "the", so names from different
+// sources can be matched
+func NormaliseName(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "&", " and ")
+
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+
+	kept := []string{}
+	for i, word := range words {
+		word = strings.ReplaceAll(word, "'", "")
+		if word == "" || (i == 0 && word == "the" && len(words) > 1) {
+			continue
+		}
+
+		kept = append(kept, word)
+	}
+
+	return strings.Join(kept, " ")
+}
+
+// WithoutFeatured drops "feat. X" and the like, which scrobblers and
+// taggers disagree on
+func WithoutFeatured(s string) string {
+	lower := strings.ToLower(s)
+
+	for _, marker := range []string{
+		" feat. ", " feat ", " ft. ", " featuring ",
+		"(feat. ", "(feat ", "(ft. ", "(featuring ",
+		"[feat. ", "[feat ", "[ft. ", "[featuring ",
+	} {
+		if i := strings.Index(lower, marker); i > 0 {
+			s, lower = s[:i], lower[:i]
+		}
+	}
+
+	return s
+}
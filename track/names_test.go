@@ -0,0 +1,22 @@
+package track
+
+import "testing"
+
+func TestNormaliseName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"The Beatles", "beatles"},
+		{"The", "the"},
+		{"Simon & Garfunkel", "simon and garfunkel"},
+		{"Don't Stop Me Now!", "dont stop me now"},
+		{"  Sigur   Rós ", "sigur rós"},
+	}
+
+	for _, test := range tests {
+		if got := NormaliseName(test.name); got != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 package track
 
-const StartingRanking = 1000
+import "time"
 
 type Album struct {
 	InternalID    int
@@ -14,17 +14,49 @@ type Artist struct {
 	Name          string
 }
 
+// Prior is what a file's tags say about how much a track is liked
+type Prior struct {
+	Stars  float64 // 0–5; 0 = not rated
+	Plays  int
+	Source string // Tag it came from; "" = no prior
+}
+
 type Track struct {
 	InternalID    int
 	MusicBrainzID string
 	Title         string
+	Genre         string
+	Year          int
 
 	Albums []Album
 
 	PrimaryArtist Artist
 	OtherArtists  []Artist
 
-	Ranking float64
+	Ranking     float64 // 0 = use the configured starting rating
+	Comparisons int
+
+	// Head-to-head results, counting an ordering as one against each
+	// other track in it
+	Wins   int
+	Losses int
+	Draws  int
+
+	// Standard error of Ranking, and whether its confidence interval
+	// clears those of the tracks ranked either side, so its position is
+	// unlikely to change
+	Deviation float64
+	Settled   bool
+
+	// Rating found in the file's tags, used as the starting ranking
+	Prior Prior
+
+	// From imported Last.fm/ListenBrainz history
+	Plays      int
+	LastPlayed time.Time // Zero if never played
+
+	// Absolute paths of the audio files for the track
+	Files []string
 }
 
 func New(track Track) Track {
@@ -33,12 +65,17 @@ func New(track Track) Track {
 	return Track{
 		MusicBrainzID: track.MusicBrainzID,
 		Title:         track.Title,
+		Genre:         track.Genre,
+		Year:          track.Year,
 
 		Albums: track.Albums,
 
 		PrimaryArtist: track.PrimaryArtist,
 		OtherArtists:  track.OtherArtists,
 
-		Ranking: StartingRanking,
+		Ranking: track.Ranking,
+		Prior:   track.Prior,
+
+		Files: track.Files,
 	}
 }
@@ -0,0 +1,229 @@
+"use strict";
+
+// Preferences for the default 5-point grade scale: 0.75 = "prefer A"
+const PREFERENCES = { left: 0.75, draw: 0.5, right: 0.25 };
+
+const KEYS = {
+  ArrowLeft: "left",
+  ArrowDown: "draw",
+  ArrowRight: "right",
+  "1": "left",
+  "2": "right",
+  d: "draw",
+  s: "skip",
+  " ": "skip",
+  u: "undo",
+};
+
+const LEADERBOARD_SIZE = 10;
+const LEADERBOARD_REFRESH_MS = 10000;
+
+// Whose rankings these are, from the page's ?user=; the API defaults to
+// the default profile
+const USER = new URLSearchParams(window.location.search).get("user");
+
+let pair = [];
+let busy = false;
+
+async function api(path, options) {
+  let url = "/api/" + path;
+  if (USER) {
+    url += (url.includes("?") ? "&" : "?") + "user=" + encodeURIComponent(USER);
+  }
+
+  const response = await fetch(url, options);
+  const body = await response.json();
+
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+
+  return body;
+}
+
+function setStatus(message, isError) {
+  const status = document.getElementById("status");
+  status.textContent = message;
+  status.classList.toggle("error", !!isError);
+}
+
+function element(tag, className, text) {
+  const el = document.createElement(tag);
+  if (className) {
+    el.className = className;
+  }
+  if (text !== undefined) {
+    el.textContent = text;
+  }
+  return el;
+}
+
+// Seeking uses range requests, so only the part played is downloaded.
+// audio_url starts from the middle if asked for and the server could
+// tell the track's length.
+function showAudio(audio, track) {
+  audio.pause();
+  audio.hidden = !track.audio_url;
+
+  if (!track.audio_url) {
+    audio.removeAttribute("src");
+    return;
+  }
+
+  audio.src = track.audio_url;
+  audio.onloadedmetadata = () => {
+    if (fromMiddle() && !track.audio_start && audio.duration) {
+      audio.currentTime = audio.duration / 2;
+    }
+  };
+}
+
+function fromMiddle() {
+  return document.getElementById("from-middle").checked;
+}
+
+function showTrack(button, track) {
+  button.replaceChildren();
+
+  const artists = [track.primary_artist]
+    .concat(track.other_artists)
+    .map((a) => a.name)
+    .filter((name) => name)
+    .join(", ");
+
+  const albums = track.albums.map((a) => a.title).join(", ");
+  const details = [track.genre, track.year].filter((d) => d).join(", ");
+
+  button.append(element("span", "title", track.title || "(untitled)"));
+  if (artists) {
+    button.append(element("span", "meta", artists));
+  }
+  if (albums) {
+    button.append(element("span", "meta", albums));
+  }
+  if (details) {
+    button.append(element("span", "meta", details));
+  }
+  button.append(
+    element(
+      "span",
+      "meta",
+      `Rating ${Math.round(track.ranking)} ` +
+        `(${Math.round(track.rating_low)}–${Math.round(track.rating_high)}) · ` +
+        `${track.wins} won, ${track.losses} lost, ${track.draws} drawn`,
+    ),
+  );
+}
+
+async function loadPair() {
+  const next = await api("next");
+
+  pair = await Promise.all(
+    next.contenders.map((c) =>
+      api(`tracks/${c.id}?start=${fromMiddle() ? "middle" : "0"}`),
+    ),
+  );
+
+  showTrack(document.getElementById("left"), pair[0]);
+  showTrack(document.getElementById("right"), pair[1]);
+  showAudio(document.getElementById("left-audio"), pair[0]);
+  showAudio(document.getElementById("right-audio"), pair[1]);
+}
+
+async function loadLeaderboard() {
+  const rankings = await api(
+    `rankings?min_comparisons=1&limit=${LEADERBOARD_SIZE}`,
+  );
+
+  const list = document.getElementById("leaderboard");
+  list.replaceChildren();
+
+  for (const track of rankings.tracks) {
+    const item = element("li");
+    const margin = Math.round(track.rating_high - track.ranking);
+    item.append(
+      element("span", "ranking", `${Math.round(track.ranking)} ± ${margin}`),
+    );
+    item.append(
+      document.createTextNode(
+        track.primary_artist.name
+          ? `${track.title} – ${track.primary_artist.name}`
+          : track.title,
+      ),
+    );
+    // Unlikely to move past its neighbours
+    if (track.settled) {
+      const settled = element("span", "settled", " ✓");
+      settled.title = "Settled";
+      item.append(settled);
+    }
+    list.append(item);
+  }
+
+  if (rankings.tracks.length === 0) {
+    list.append(element("li", "meta", "Nothing compared yet"));
+  }
+}
+
+async function choose(choice) {
+  if (busy || (pair.length < 2 && choice !== "undo")) {
+    return;
+  }
+  busy = true;
+
+  try {
+    if (choice === "undo") {
+      await api("undo", {
+        method: "POST",
+        body: JSON.stringify({ entity: "track" }),
+      });
+      setStatus("Undid last comparison");
+    } else if (choice === "skip") {
+      setStatus("Skipped");
+    } else {
+      await api("comparisons", {
+        method: "POST",
+        body: JSON.stringify({
+          entity: "track",
+          ids: [pair[0].id, pair[1].id],
+          preference: PREFERENCES[choice],
+        }),
+      });
+      setStatus(
+        choice === "draw"
+          ? "Draw"
+          : `Picked ${pair[choice === "left" ? 0 : 1].title}`,
+      );
+    }
+
+    await Promise.all([loadPair(), loadLeaderboard()]);
+  } catch (err) {
+    setStatus(err.message, true);
+  } finally {
+    busy = false;
+  }
+}
+
+document.addEventListener("keydown", (event) => {
+  if (event.ctrlKey || event.metaKey || event.altKey) {
+    return;
+  }
+
+  const choice = KEYS[event.key] || KEYS[event.key.toLowerCase()];
+  if (choice) {
+    event.preventDefault();
+    choose(choice);
+  }
+});
+
+document.querySelectorAll("[data-choice]").forEach((button) => {
+  button.addEventListener("click", () => choose(button.dataset.choice));
+});
+
+Promise.all([loadPair(), loadLeaderboard()]).catch((err) =>
+  setStatus(err.message, true),
+);
+
+setInterval(() => {
+  loadLeaderboard().catch((err) => setStatus(err.message, true));
+}, LEADERBOARD_REFRESH_MS);
@@ -0,0 +1,24 @@
+// Package web is the browser front end for voting, served alongside the
+// API
+package web
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the front end. It calls the API under /api/ on the same
+// host, so mount it on the same server.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// Only possible if the embed directive above is wrong
+		panic(err)
+	}
+
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	for _, test := range []struct {
+		path        string
+		contentType string
+	}{
+		{"/", "text/html"},
+		{"/app.js", "javascript"},
+		{"/style.css", "text/css"},
+	} {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", test.path, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.Contains(ct, test.contentType) {
+			t.Errorf("%s: expected %s, got '%s'", test.path, test.contentType, ct)
+		}
+	}
+
+	t.Log("No external dependencies")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if strings.Contains(rec.Body.String(), "//") {
+		t.Error("Expected index.html to only load local files")
+	}
+
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/missing.js", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing file, got %d", rec.Code)
+	}
+}